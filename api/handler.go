@@ -1,28 +1,196 @@
 package api
 
 import (
+	"context"
+	"encoding/hex"
+	"io"
 	"net/http"
+	"strings"
+	"time"
+
+	"mtcloud.com/mtstorage/pkg/auth"
+	"mtcloud.com/mtstorage/pkg/config"
+	"mtcloud.com/mtstorage/pkg/hash"
+	xhttp "mtcloud.com/mtstorage/pkg/http"
+	"mtcloud.com/mtstorage/pkg/logger"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
 )
 
+// 默认允许的请求时间偏差
+const defaultMaxSkew = 15 * time.Minute
+
+// authSys 请求签名认证的配置, 未初始化时不做认证
+type authSys struct {
+	store   auth.Store
+	region  string
+	maxSkew time.Duration
+}
+
+var globalAuth *authSys
+
+const contextCredentialsKey = contextKeyType("mtosscred")
+
+// 不需要认证的路径
+var authExemptPrefixes = []string{"/swagger"}
+
+// 允许匿名读取的路径, 以 / 结尾时匹配前缀. 是否允许由桶策略及ACL决定
+var anonymousReadPaths []string
+
+// AllowAnonymousRead lets the anonymous GET and HEAD requests of the paths pass the authentication,
+// a path ending with "/" matches all the paths under it. The handlers must be wrapped by Authorize.
+func AllowAnonymousRead(paths ...string) {
+	anonymousReadPaths = append(anonymousReadPaths, paths...)
+}
+
+func isAnonymousReadAllowed(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	for _, p := range anonymousReadPaths {
+		if r.URL.Path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(r.URL.Path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// InitAuth enables the signature V4 authentication of the GlobalHandlers, the access keys are looked up in the store.
+func InitAuth(c config.AuthConfig, store auth.Store) {
+	skew := time.Duration(c.MaxSkew) * time.Second
+	if skew <= 0 {
+		skew = defaultMaxSkew
+	}
+	globalAuth = &authSys{
+		store:   store,
+		region:  c.Region,
+		maxSkew: skew,
+	}
+}
+
+// AuthRegion returns the region to sign the requests, us-east-1 if not configured
+func AuthRegion() string {
+	if globalAuth == nil || globalAuth.region == "" {
+		return "us-east-1"
+	}
+	return globalAuth.region
+}
+
+// ReqCredentials returns the credentials which signed the request
+func ReqCredentials(ctx context.Context) (auth.Credentials, bool) {
+	c, ok := ctx.Value(contextCredentialsKey).(auth.Credentials)
+	return c, ok
+}
+
+// IsAdminRequest 未开启认证时或由配置文件中的秘钥签名的请求
+func IsAdminRequest(ctx context.Context) bool {
+	if globalAuth == nil {
+		return true
+	}
+	c, ok := ReqCredentials(ctx)
+	return ok && c.Admin
+}
+
+func isAuthRequired(r *http.Request) bool {
+	if globalAuth == nil {
+		return false
+	}
+	for _, p := range authExemptPrefixes {
+		if strings.HasPrefix(r.URL.Path, p) {
+			return false
+		}
+	}
+	return true
+}
+
 // ServeHTTP fails if the request contains at least one reserved header which
 // would be treated as metadata.
+// 保留的元数据头部只在内部使用, 直接从请求中去除
 func filterReservedMetadata(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		//todo : do something
+		for k := range r.Header {
+			if strings.HasPrefix(k, xhttp.AmzMetaInternal) {
+				logger.Warnf("strip reserved header %s of request %s", k, r.URL.Path)
+				r.Header.Del(k)
+			}
+		}
 		h.ServeHTTP(w, r)
 	})
 }
 
 func setAuthHandler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		//todo : do something
-		h.ServeHTTP(w, r)
+		if !isAuthRequired(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if auth.IsRequestAnonymous(r) && isAnonymousReadAllowed(r) {
+			reqInfo := NewReqInfo(r.RemoteAddr, r.UserAgent(), "", "", "", "", "")
+			reqInfo.Host = r.Host
+			h.ServeHTTP(w, r.WithContext(SetReqInfo(r.Context(), reqInfo)))
+			return
+		}
+		cred, code := auth.VerifyRequest(r.Context(), r, globalAuth.store, globalAuth.region, time.Now().UTC())
+		// 预签名上传的数据长度限制
+		if code == error2.ErrNone && auth.IsRequestPresignedV4(r) {
+			code = auth.CheckContentLength(r)
+		}
+		if code != error2.ErrNone {
+			apiErr := error2.ErrorCodes.ToAPIErr(code)
+			logger.Errorf("authenticate request %s %s from %s failed: %s", r.Method, r.URL.Path, r.RemoteAddr, apiErr.Description)
+			WriteErrorResponseJSON(w, apiErr, r.URL)
+			return
+		}
+
+		// 签名中包含数据的sha256时读取时校验
+		if sum := r.Header.Get(xhttp.AmzContentSha256); auth.IsRequestSignedV4(r) && isSHA256(sum) && r.Body != nil {
+			hr, err := hash.NewReader(r.Body, r.ContentLength, "", sum, r.ContentLength)
+			if err != nil {
+				WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrContentSHA256Mismatch), r.URL)
+				return
+			}
+			r.Body = verifiedBody{Reader: hr, Closer: r.Body}
+		}
+
+		reqInfo := NewReqInfo(r.RemoteAddr, r.UserAgent(), "", "", "", "", "")
+		reqInfo.Host = r.Host
+		reqInfo.AccessKey = cred.AccessKey
+		ctx := context.WithValue(SetReqInfo(r.Context(), reqInfo), contextCredentialsKey, cred)
+		h.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
 func setTimeValidityHandler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		//todo : do something
+		// 预签名请求的有效期在认证时检查
+		if !isAuthRequired(r) || !auth.IsRequestSignedV4(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		t, code := auth.RequestTime(r)
+		if code == error2.ErrNone {
+			if d := time.Now().UTC().Sub(t); d > globalAuth.maxSkew || d < -globalAuth.maxSkew {
+				code = error2.ErrRequestTimeTooSkewed
+			}
+		}
+		if code != error2.ErrNone {
+			WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(code), r.URL)
+			return
+		}
 		h.ServeHTTP(w, r)
 	})
 }
+
+// verifiedBody 读取完成时校验sha256的请求体, 只暴露Read和Close,
+// 避免处理函数再次创建hash.Reader时合并掉sha256
+type verifiedBody struct {
+	io.Reader
+	io.Closer
+}
+
+func isSHA256(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
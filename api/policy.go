@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/pkg/logger"
+	"mtcloud.com/mtstorage/pkg/policy"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+)
+
+// globalPolicy 查询桶的所有者及访问策略, 未初始化或未开启认证时不检查
+var globalPolicy policy.Getter
+
+// InitPolicy enables the bucket policy evaluation of the handlers wrapped by Authorize
+func InitPolicy(g policy.Getter) {
+	globalPolicy = g
+}
+
+// Authorize evaluates the bucket policy before calling the handler, the request is rejected
+// if the policy does not allow the action. The bucket and object are taken from the query or the route.
+func Authorize(action string, f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bucket, object := requestBucketObject(r)
+		if err := CheckPolicy(r, action, bucket, object); err != nil {
+			WriteErrorResponseJSON(w, error2.ToAPIError(r.Context(), err), r.URL)
+			return
+		}
+		f(w, r)
+	}
+}
+
+// CheckPolicy returns error2.AccessDenied if neither the bucket policy nor the acl allows the action on the object.
+// 没有指定桶的请求只允许管理员, 桶不存在时除创建桶外都拒绝
+func CheckPolicy(r *http.Request, action, bucket, object string) error {
+	if globalAuth == nil || globalPolicy == nil || IsAdminRequest(r.Context()) {
+		return nil
+	}
+	if bucket == "" {
+		logger.Warnf("%s without bucket is denied", action)
+		return error2.AccessDenied{}
+	}
+	ctx, span := trace.StartSpan(r.Context(), "CheckPolicy")
+	defer span.End()
+
+	access, err := globalPolicy.GetBucketAccess(ctx, bucket)
+	if _, ok := err.(error2.BucketNotFound); ok {
+		if action == policy.CreateBucketAction {
+			return nil
+		}
+		logger.Warnf("%s on bucket %s not found is denied", action, bucket)
+		return err
+	}
+	if err != nil {
+		logger.Errorf("get access of bucket %s failed: %s", bucket, err)
+		return err
+	}
+	p, err := policy.Parse(access.Policy, "")
+	if err != nil {
+		// 保存时已校验, 不能解析时只允许所有者
+		logger.Errorf("parse policy of bucket %s failed: %s", bucket, err)
+	}
+	args := PolicyArgs(r, action, bucket, object)
+	args.IsOwner = isBucketOwner(ctx, access)
+	args.BucketACL = access.Acl
+	// 只有读对象使用对象的ACL
+	if action == policy.GetObjectAction && object != "" && !args.IsOwner {
+		if args.ObjectACL, err = globalPolicy.GetObjectAcl(ctx, bucket, object); err != nil {
+			logger.Errorf("get acl of %s/%s failed: %s", bucket, object, err)
+			return err
+		}
+	}
+	if res := p.Evaluate(args); !res.Allowed {
+		logger.Warnf("%s %s/%s by %q is denied: %s", action, bucket, object, args.AccessKey, res.Reason)
+		return error2.AccessDenied{Bucket: bucket, Object: object}
+	}
+	return nil
+}
+
+// PolicyArgs returns the arguments to evaluate the bucket policy for the request,
+// the owner of the bucket is left to the caller.
+func PolicyArgs(r *http.Request, action, bucket, object string) policy.Args {
+	args := policy.Args{
+		Action: action,
+		Bucket: bucket,
+		Object: object,
+		Conditions: map[string]string{
+			policy.KeyCurrentTime: time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	if cred, ok := ReqCredentials(r.Context()); ok {
+		args.AccessKey, args.User, args.Admin = cred.AccessKey, cred.User, cred.Admin
+		if cred.User != "" {
+			args.Conditions[policy.KeyUsername] = cred.User
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		args.Conditions[policy.KeySourceIp] = host
+	}
+	if ua := r.UserAgent(); ua != "" {
+		args.Conditions[policy.KeyUserAgent] = ua
+	}
+	if ref := r.Referer(); ref != "" {
+		args.Conditions[policy.KeyReferer] = ref
+	}
+	if prefix, ok := r.URL.Query()["prefix"]; ok {
+		args.Conditions[policy.KeyPrefix] = prefix[0]
+	}
+	return args
+}
+
+func isBucketOwner(ctx context.Context, access policy.BucketAccess) bool {
+	return IsOwnerRequest(ctx, access.Owner)
+}
+
+// IsOwnerRequest 请求的秘钥属于ID为owner的用户
+func IsOwnerRequest(ctx context.Context, owner uint32) bool {
+	cred, ok := ReqCredentials(ctx)
+	return ok && cred.AccessKey != "" && cred.Owner != 0 && cred.Owner == owner
+}
+
+func requestBucketObject(r *http.Request) (string, string) {
+	vars := r.URL.Query()
+	bucket, object := vars.Get("bucket"), vars.Get("object")
+	if bucket == "" {
+		bucket = mux.Vars(r)["bucket"]
+	}
+	if object == "" {
+		object = mux.Vars(r)["object"]
+	}
+	// 资源中的对象名不以 / 开头
+	return bucket, strings.TrimPrefix(object, "/")
+}
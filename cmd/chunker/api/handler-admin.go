@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/api"
+	"mtcloud.com/mtstorage/cmd/chunker/engine"
+	"mtcloud.com/mtstorage/pkg/logger"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+	"mtcloud.com/mtstorage/util"
+)
+
+// checkAdmin 管理接口只允许管理秘钥访问
+func checkAdmin(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	if api.IsAdminRequest(ctx) {
+		return true
+	}
+	api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrAccessDenied), r.URL)
+	return false
+}
+
+// GetRebalance reports the progress of the rebalance job and the usage of endpoints
+func (h *chunkerAPIHandlers) GetRebalance(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "GetRebalance")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	h.rebalance(w, "", 0, 0)
+}
+
+// PostRebalance starts, resumes or pauses the rebalance job.
+// action: start | pause, bandwidth: 限速(MB/s), 为空时使用配置的默认值,
+// threshold: 最满与最空节点的使用率之差(百分比)不超过该值时结束, 为空时为10
+func (h *chunkerAPIHandlers) PostRebalance(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "PostRebalance")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	action := r.URL.Query().Get("action")
+	if action != "start" && action != "pause" {
+		util.WriteJsonQuiet(w, http.StatusBadRequest, "action should be start or pause")
+		return
+	}
+	bandwidth := 0
+	if v := r.URL.Query().Get("bandwidth"); v != "" {
+		var err error
+		if bandwidth, err = strconv.Atoi(v); err != nil || bandwidth < 0 {
+			util.WriteJsonQuiet(w, http.StatusBadRequest, "invalid bandwidth")
+			return
+		}
+	}
+	threshold := 0
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		var err error
+		if threshold, err = strconv.Atoi(v); err != nil || threshold < 0 || threshold > 100 {
+			util.WriteJsonQuiet(w, http.StatusBadRequest, "invalid threshold")
+			return
+		}
+	}
+	span.AddAttributes(trace.StringAttribute("action", action), trace.Int64Attribute("bandwidth", int64(bandwidth)))
+	h.rebalance(w, action, bandwidth, float64(threshold)/100)
+}
+
+func (h *chunkerAPIHandlers) rebalance(w http.ResponseWriter, action string, bandwidth int, threshold float64) {
+	status, err := h.backend.Rebalance(action, bandwidth, threshold)
+	if err != nil {
+		if err == engine.ErrRebalanceNotSupported {
+			util.WriteJsonQuiet(w, http.StatusNotImplemented, err.Error())
+			return
+		}
+		logger.Errorf("rebalance %s failed: %s", action, err)
+		util.WriteJsonQuiet(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, status)
+}
+
+// PostRepoGC triggers the garbage collection of the storage endpoints,
+// gc控制器确认取消pin的cid没有再被引用后调用
+func (h *chunkerAPIHandlers) PostRepoGC(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "PostRepoGC")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	util.WriteJsonQuiet(w, http.StatusOK, h.backend.RepoGC())
+}
+
+// GetDrain reports the drain progress of the endpoint, or of all draining endpoints if endpoint is empty
+func (h *chunkerAPIHandlers) GetDrain(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "GetDrain")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	list, err := h.backend.DrainStatus(r.URL.Query().Get("endpoint"))
+	if err != nil {
+		writeDrainError(w, err)
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, list)
+}
+
+// PostDrain sets the drain state of the endpoint.
+// drain为true时节点不再写入新数据, 并在后台将其上的cid复制到其它节点; 为false时取消下线
+func (h *chunkerAPIHandlers) PostDrain(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "PostDrain")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	endpoint := r.URL.Query().Get("endpoint")
+	if endpoint == "" {
+		util.WriteJsonQuiet(w, http.StatusBadRequest, "endpoint is empty")
+		return
+	}
+	drain, err := strconv.ParseBool(r.URL.Query().Get("drain"))
+	if err != nil {
+		util.WriteJsonQuiet(w, http.StatusBadRequest, "drain should be true or false")
+		return
+	}
+	span.AddAttributes(trace.StringAttribute("endpoint", endpoint), trace.BoolAttribute("drain", drain))
+	status, err := h.backend.Drain(endpoint, drain)
+	if err != nil {
+		writeDrainError(w, err)
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, status)
+}
+
+func writeDrainError(w http.ResponseWriter, err error) {
+	switch err {
+	case engine.ErrEndpointNotFound:
+		util.WriteJsonQuiet(w, http.StatusNotFound, err.Error())
+	case engine.ErrDrainNotSupported:
+		util.WriteJsonQuiet(w, http.StatusNotImplemented, err.Error())
+	default:
+		logger.Error("drain failed: ", err)
+		util.WriteJsonQuiet(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// GetMultipartJanitor reports the aborted incomplete multipart uploads and the reclaimed bytes
+func (h *chunkerAPIHandlers) GetMultipartJanitor(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "GetMultipartJanitor")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	util.WriteJsonQuiet(w, http.StatusOK, h.backend.MultipartJanitorStatus())
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/api"
+	"mtcloud.com/mtstorage/node/client"
+	node_util "mtcloud.com/mtstorage/node/util"
+	"mtcloud.com/mtstorage/pkg/hash"
+	"mtcloud.com/mtstorage/pkg/logger"
+	utilruntime "mtcloud.com/mtstorage/pkg/runtime"
+	"mtcloud.com/mtstorage/pkg/storage"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+	"mtcloud.com/mtstorage/util"
+)
+
+// 下一次追加的位置
+const nextAppendPosition = "Next-Append-Position"
+
+// AppendObjectHandler appends the body to the appendable object at position and returns the next position,
+// the object is created by the first append at position 0.
+// 每次追加先写入新的数据块, 再写入链接原内容和新数据块的文件节点作为对象新的cid. 追加的对象不加密也不压缩
+// /cs/v1/appendObject?bucket=xx&object=xx&position=xx&storageClass=xx&acl=xx
+func (h *chunkerAPIHandlers) AppendObjectHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "AppendObjectHandler")
+	defer span.End()
+
+	vars := r.URL.Query()
+	bucket := vars.Get("bucket")
+	object := vars.Get("object")
+	position, err := strconv.ParseUint(vars.Get("position"), 10, 64)
+	if bucket == "" || object == "" || strings.HasSuffix(object, "/") || err != nil {
+		logger.Errorf("invalid arguments bucket: %s object: %s, position: %s", bucket, object, vars.Get("position"))
+		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrInvalidArguments), r.URL)
+		return
+	}
+	if r.Header.Get("crypto-key") != "" {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.InvalidArgument{Bucket: bucket, Object: object,
+			Err: errors.New("encrypted object can not be appended")}), r.URL)
+		return
+	}
+	// 存储空间不足时在读取数据前拒绝
+	if err = h.backend.Admit(r.ContentLength); err != nil {
+		logger.Errorf("reject append %s/%s, size: %d: %s", bucket, object, r.ContentLength, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.StorageFull{Bucket: bucket, Object: object, Err: err}), r.URL)
+		return
+	}
+
+	oi, err := h.backend.GetObjectInfo(client.WithTrack(ctx), bucket, object)
+	if err != nil {
+		logger.Errorf("get object %s/%s failed: %s", bucket, object, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	exists := oi.Name != "" && !oi.IsMarker
+	if exists && !oi.Appendable {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.ObjectNotAppendable{Bucket: bucket, Object: object}), r.URL)
+		return
+	}
+	var length uint64
+	if exists {
+		length = oi.Content_length
+	}
+	if position != length {
+		w.Header().Set(nextAppendPosition, strconv.FormatUint(length, 10))
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.PositionNotEqualToLength{
+			Bucket: bucket, Object: object, Position: position, Length: length}), r.URL)
+		return
+	}
+
+	reader, err := hash.NewReader(r.Body, r.ContentLength, "", "", r.ContentLength)
+	if err != nil {
+		logger.Error(err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	chunkCid, err := h.backend.WriteData(ctx, reader)
+	if err != nil {
+		logger.Errorf("write appended data of %s/%s failed: %s", bucket, object, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	if reader.BytesRead() < r.ContentLength {
+		logger.Errorf("receive in complete body! bucket: %s, object: %s", bucket, object)
+		go h.removeAppended(object, chunkCid)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.IncompleteBody{Bucket: bucket, Object: object}), r.URL)
+		return
+	}
+
+	// 原内容为空时新数据块即为对象
+	cid := chunkCid
+	if length > 0 {
+		cid, err = h.backend.ComposeData(ctx, []string{oi.Cid, chunkCid})
+		if err != nil {
+			logger.Errorf("link appended data of %s/%s failed: %s", bucket, object, err)
+			go h.removeAppended(object, chunkCid)
+			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+			return
+		}
+	}
+
+	next := position + uint64(reader.BytesRead())
+	etag := fmt.Sprintf("%x", md5.Sum([]byte(cid)))
+	dirName, objectName := storage.ParseObject(object)
+	if err = h.backend.CallBackNS(client.WithTrack(ctx), node_util.ReWriteObjectInfo{
+		Bucket:         bucket,
+		Name:           objectName,
+		Cid:            cid,
+		Size:           int64(next),
+		DirName:        dirName,
+		Etag:           etag,
+		ContenLength:   next,
+		StorageClass:   vars.Get("storageClass"),
+		ACL:            vars.Get("acl"),
+		ContentType:    r.Header.Get("Content-Type"),
+		ActualCid:      cid,
+		Appendable:     true,
+		AppendPosition: position,
+	}); err != nil {
+		// 并发追加时位置已被其它请求占用
+		logger.Errorf("save appended object %s/%s failed: %s", bucket, object, err)
+		if cid != chunkCid {
+			go h.removeAppended(object, cid, chunkCid)
+		} else {
+			go h.removeAppended(object, chunkCid)
+		}
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	// 新的文件节点已引用数据块, 取消数据块自身的pin
+	if cid != chunkCid {
+		go h.removeAppended(object, chunkCid)
+	}
+
+	w.Header().Set(nextAppendPosition, strconv.FormatUint(next, 10))
+	util.WriteJsonQuiet(w, http.StatusOK, map[string]interface{}{
+		"bucket":       bucket,
+		"object":       object,
+		"cid":          cid,
+		"etag":         etag,
+		"nextPosition": next,
+	})
+}
+
+// removeAppended removes the pins of the data written by the append in background,
+// 与其它对象或分片cid相同的数据不删除
+func (h *chunkerAPIHandlers) removeAppended(object string, cids ...string) {
+	defer utilruntime.HandleCrash()
+
+	ctx := context.Background()
+	for _, cid := range cids {
+		if _, err := h.backend.RemoveUnreferencedData(ctx, cid); err != nil {
+			logger.Errorf("remove appended data %s of %s failed: %s", cid, object, err)
+		}
+	}
+}
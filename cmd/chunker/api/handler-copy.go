@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/api"
+	"mtcloud.com/mtstorage/node/client"
+	"mtcloud.com/mtstorage/pkg/crypto"
+	"mtcloud.com/mtstorage/pkg/logger"
+	"mtcloud.com/mtstorage/pkg/policy"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+	"mtcloud.com/mtstorage/util"
+)
+
+// 源对象的加密秘钥, 新对象的秘钥仍使用crypto-key
+const copySourceCryptoKey = "copy-source-crypto-key"
+
+// copySource resolves the current version of the source object through nameserver and locates its plain content,
+// 需要源对象的读权限, 压缩算法取自源对象的元数据, 加密对象的cid由源对象的秘钥解密
+func (h *chunkerAPIHandlers) copySource(ctx context.Context, r *http.Request, bucket, object, ck string) (*objectLayout, error) {
+	if err := api.CheckPolicy(r, policy.GetObjectAction, bucket, strings.TrimPrefix(object, "/")); err != nil {
+		return nil, err
+	}
+	oi, err := h.backend.GetObjectInfo(client.WithTrack(ctx), bucket, strings.TrimPrefix(object, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if oi.Cid == "" || oi.Isdir || oi.IsMarker {
+		return nil, error2.ObjectNotFound{Bucket: bucket, Object: object}
+	}
+
+	cid, isCrypto := oi.Cid, false
+	if ck != "" {
+		c, err := crypto.Base64Decrypt(ck, cid)
+		if err == nil {
+			cid, isCrypto = c, true
+		} else if !strings.HasPrefix(cid, "Qm") {
+			return nil, error2.InvalidArgument{Err: err}
+		}
+	}
+	if !h.backend.CIDExist(ctx, cid) {
+		return nil, error2.ObjectNotFound{Bucket: bucket, Object: object}
+	}
+	return h.objectLayout(ctx, cid, ck, isCrypto, oi.Compression)
+}
+
+// CopyObjectHandler copies the object by reading and writing its data, used when the source is encrypted,
+// 数据按新对象的秘钥重新加密, 未加密时按目标桶的配置压缩. 未加密的对象由nameserver直接复制元数据
+// /cs/v1/copyObject?bucket=xx&object=xx&srcBucket=xx&srcObject=xx&storageClass=xx&acl=xx
+func (h *chunkerAPIHandlers) CopyObjectHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "CopyObjectHandler")
+	defer span.End()
+
+	vars := r.URL.Query()
+	srcBucket, srcObject := vars.Get("srcBucket"), vars.Get("srcObject")
+	if vars.Get("bucket") == "" || vars.Get("object") == "" || srcBucket == "" || srcObject == "" {
+		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrInvalidCopySource), r.URL)
+		return
+	}
+
+	l, err := h.copySource(ctx, r, srcBucket, srcObject, r.Header.Get(copySourceCryptoKey))
+	if err != nil {
+		logger.Errorf("open copy source %s/%s failed: %s", srcBucket, srcObject, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	rd, err := h.rangeReader(ctx, l, 0, l.size)
+	if err != nil {
+		logger.Errorf("read copy source %s/%s failed: %s", srcBucket, srcObject, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	defer rd.Close()
+
+	// 源对象的原始内容按上传的方式写入
+	vars.Del("encMd5Sum")
+	r.URL.RawQuery = vars.Encode()
+	r.Body = rd
+	r.ContentLength = l.size
+	h.PostObjectHandler(w, r)
+}
+
+// UploadPartCopy copies a range of the object into a part of the multipart upload,
+// 分片按上传时的crypto-key加密, etag为分片原始内容的md5
+// /cs/v1/copyObjectPart?bucket=xx&object=xx&uploadID=xx&partID=xx&srcBucket=xx&srcObject=xx&range=bytes=first-last
+func (h *chunkerAPIHandlers) UploadPartCopy(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "UploadPartCopy")
+	defer span.End()
+
+	vars := r.URL.Query()
+	bucket := vars.Get("bucket")
+	object := vars.Get("object")
+	uploadID := vars.Get("uploadID")
+	partID := vars.Get("partID")
+	srcBucket, srcObject := vars.Get("srcBucket"), vars.Get("srcObject")
+	pid, err := strconv.Atoi(partID)
+	if err != nil || !isValidUploadID(uploadID) {
+		logger.Errorf("invalid arguments uploadId: %s, partID: %s", uploadID, partID)
+		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrInvalidArguments), r.URL)
+		return
+	}
+	if srcBucket == "" || srcObject == "" {
+		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrInvalidCopySource), r.URL)
+		return
+	}
+	if _, err := h.backend.GetMultipartUploadOf(client.WithTrack(ctx), uploadID, bucket, object); err != nil {
+		logger.Errorf("get multipart upload %s of %s/%s failed: %s", uploadID, bucket, object, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+
+	l, err := h.copySource(ctx, r, srcBucket, srcObject, r.Header.Get(copySourceCryptoKey))
+	if err != nil {
+		logger.Errorf("open copy source %s/%s failed: %s", srcBucket, srcObject, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	start, length := int64(0), l.size
+	if spec := vars.Get("range"); spec != "" {
+		if start, length, err = parseRange(spec, l.size); err != nil {
+			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.InvalidRange{Range: spec, Size: l.size}), r.URL)
+			return
+		}
+	}
+	// 存储空间不足时在读取数据前拒绝
+	if err := h.backend.Admit(length); err != nil {
+		logger.Errorf("reject part %s of %s/%s, size: %d: %s", partID, bucket, object, length, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.StorageFull{Bucket: bucket, Object: object, Err: err}), r.URL)
+		return
+	}
+
+	rd, err := h.rangeReader(ctx, l, start, length)
+	if err != nil {
+		logger.Errorf("read copy source %s/%s failed: %s", srcBucket, srcObject, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	defer rd.Close()
+
+	_, etag, err := h.writePart(ctx, uploadID, pid, rd, length, r.Header.Get("crypto-key"), "", "")
+	if err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, etag)
+}
@@ -2,27 +2,57 @@ package api
 
 import (
 	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/api"
+	"mtcloud.com/mtstorage/cmd/chunker/engine"
 	"mtcloud.com/mtstorage/pkg/logger"
+	"mtcloud.com/mtstorage/pkg/policy"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
 	"mtcloud.com/mtstorage/util"
 	"net/http"
 	"strings"
 )
 
 // DeleteObjectHandler
+// /cs/v1/object/xxxx?bucket=xx&object=xx [delete], 除管理秘钥外按cid所属的对象检查权限, 加密对象需指定crypto-key.
+// 非管理秘钥的请求只删除没有被引用的数据
 func (h *chunkerAPIHandlers) DelObjectHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Info("===> DeleteObjectHandler")
 	ctx, span := trace.StartSpan(r.Context(), "PostObjectHandler")
 	defer span.End()
 
 	cid := strings.Split(r.URL.EscapedPath(), "/")[4]
-	if err := h.backend.DeleteDataFromIPFS(ctx, cid); err != nil {
-		if strings.Contains(err.Error(), "not pinned or pinned indirectly") {
+	if _, err := h.checkObjectCid(ctx, r, policy.DeleteObjectAction, cid); err != nil {
+		logger.Warnf("delete %s denied: %s", cid, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	// 只有管理秘钥(gc)直接删除数据, 由gc自行检查引用; 其它请求在数据不再被对象或分片引用时才删除
+	if !api.IsAdminRequest(ctx) {
+		deleted, err := h.backend.RemoveUnreferencedData(ctx, cid)
+		if err != nil {
+			logger.Errorf("delete %s failed: %s", cid, err)
+			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+			return
+		}
+		util.WriteJsonQuiet(w, http.StatusOK, map[string]bool{"deleted": deleted})
+		return
+	}
+	gc := r.URL.Query().Get("gc") == "true"
+	results, err := h.backend.DeleteDataFromIPFS(ctx, cid, gc)
+	if err != nil {
+		if err == engine.ErrNotPinned {
 			util.WriteJsonQuiet(w, http.StatusNotFound, "not found")
 			return
 		}
-		util.WriteJsonQuiet(w, http.StatusInternalServerError, err.Error())
+		logger.Errorf("delete %s failed: %s", cid, err)
+		if results == nil {
+			util.WriteJsonQuiet(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		// 部分节点失败, 返回每个节点的结果
+		util.WriteJsonQuiet(w, http.StatusInternalServerError, results)
 		return
 	}
-	util.WriteJsonQuiet(w, http.StatusOK, "success")
+	util.WriteJsonQuiet(w, http.StatusOK, results)
 	return
 }
@@ -5,20 +5,103 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/minio/sio"
 	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/api"
+	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
+	"mtcloud.com/mtstorage/pkg/compress"
 	"mtcloud.com/mtstorage/pkg/crypto"
 	"mtcloud.com/mtstorage/pkg/fips"
+	xhttp "mtcloud.com/mtstorage/pkg/http"
 	"mtcloud.com/mtstorage/pkg/logger"
+	"mtcloud.com/mtstorage/pkg/policy"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
 	"mtcloud.com/mtstorage/util"
 )
 
+// 兼容未指定对象的旧客户端时, 最多检查的引用cid的对象数
+const maxCidObjects = 10
+
+// checkObjectCid makes sure the cid is the content of the object in the query, the bucket policy and acl
+// are evaluated on the object so that the requester can not read or delete the other objects by their cid.
+// The object is returned for its metadata, e.g. the compression. 加密对象的元数据中保存cid的密文,
+// 请求的cid为密文或由crypto-key解密后的cid. 未指定对象时按cid查找有权限访问的对象, 兼容旧客户端.
+// 管理秘钥及未开启认证时不检查, 未指定对象或cid不符时返回空的对象, 按原始数据读取
+func (h *chunkerAPIHandlers) checkObjectCid(ctx context.Context, r *http.Request, action, cid string) (metadata.ObjectInfo, error) {
+	vars := r.URL.Query()
+	bucket, object := vars.Get("bucket"), strings.TrimPrefix(vars.Get("object"), "/")
+	admin := api.IsAdminRequest(ctx)
+	if bucket == "" || object == "" {
+		if admin {
+			return metadata.ObjectInfo{}, nil
+		}
+		return h.findCidObject(ctx, r, action, cid)
+	}
+	if err := api.CheckPolicy(r, action, bucket, object); err != nil {
+		return metadata.ObjectInfo{}, err
+	}
+	oi, err := h.backend.GetObjectInfo(ctx, bucket, object)
+	if err != nil {
+		if admin {
+			return metadata.ObjectInfo{}, nil
+		}
+		return oi, err
+	}
+	if objectHasCid(oi, cid, vars.Get("crypto-key")) {
+		return oi, nil
+	}
+	if admin {
+		return metadata.ObjectInfo{}, nil
+	}
+	return metadata.ObjectInfo{}, error2.AccessDenied{Bucket: bucket, Object: object}
+}
+
+// findCidObject returns the first object of the cid allowed to the requester
+func (h *chunkerAPIHandlers) findCidObject(ctx context.Context, r *http.Request, action, cid string) (metadata.ObjectInfo, error) {
+	objects, err := h.backend.GetObjectsByCid(ctx, cid, maxCidObjects)
+	if err != nil {
+		logger.Errorf("find objects of %s failed: %s", cid, err)
+		return metadata.ObjectInfo{}, err
+	}
+	for _, oi := range objects {
+		object := strings.TrimPrefix(path.Join(oi.Dirname, oi.Name), "/")
+		if api.CheckPolicy(r, action, oi.Bucket, object) == nil {
+			logger.Warnf("%s %s without object, resolved to %s/%s", action, cid, oi.Bucket, object)
+			return oi, nil
+		}
+	}
+	return metadata.ObjectInfo{}, error2.AccessDenied{}
+}
+
+// objectHasCid 元数据中的cid为密文时, 按请求的秘钥解密后比较
+func objectHasCid(oi metadata.ObjectInfo, cid, ck string) bool {
+	if oi.Cid == "" {
+		return false
+	}
+	if oi.Cid == cid {
+		return true
+	}
+	if ck == "" {
+		return false
+	}
+	c, err := crypto.Base64Decrypt(ck, oi.Cid)
+	return err == nil && c == cid
+}
+
 // GetObjectHandler
+// /cs/v1/object/xxxx?bucket=xx&object=xx [get], 除管理秘钥外按cid所属的对象检查权限.
+// 压缩的对象按元数据解压, 因此管理秘钥也需指定对象才能读取解压后的内容.
+// 已知限制: 压缩对象的范围读取需从头解压并跳过offset之前的数据, 耗时与offset成正比
 func (h *chunkerAPIHandlers) GetObjectHandler(w http.ResponseWriter, r *http.Request) {
 
 	ctx, span := trace.StartSpan(r.Context(), "PostObjectHandler")
@@ -28,13 +111,18 @@ func (h *chunkerAPIHandlers) GetObjectHandler(w http.ResponseWriter, r *http.Req
 	path := strings.Split(r.URL.EscapedPath(), "/")
 	cid := strings.Join(path[4:], "/")
 	//cid := vars.Get("cid")
+	oi, err := h.checkObjectCid(ctx, r, policy.GetObjectAction, cid)
+	if err != nil {
+		logger.Warnf("get %s denied: %s", cid, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
 	offset := vars.Get("offset")
 	length := vars.Get("length")
 	logger.Infof("===========================>开始下载", length, offset)
 	sc := vars.Get("storageclass")
 	ck := vars.Get("crypto-key")
 	var rd io.Reader
-	var err error
 	var isCrypto bool
 	logger.Info("====>GetObjectHandler,sc", sc)
 	if ck != "" {
@@ -79,12 +167,44 @@ func (h *chunkerAPIHandlers) GetObjectHandler(w http.ResponseWriter, r *http.Req
 	}
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Hour) // ctx有效期
 	defer cancel()
-	//rd, err = nodeimpl.GetData(ctx, cid, i_offset, i_length)
-	rd, err = h.backend.GetData(ctx, cid)
+
+	// 部分读取: Range头 或 offset/length参数
+	rangeSpec := r.Header.Get(xhttp.Range)
+	if rangeSpec == "" && offset != "" {
+		rangeSpec = "bytes=" + offset + "-"
+		if length != "" {
+			l, err := strconv.ParseInt(length, 10, 64)
+			o, err2 := strconv.ParseInt(offset, 10, 64)
+			if err != nil || err2 != nil || l <= 0 {
+				util.WriteJsonQuiet(w, http.StatusBadRequest, "invalid offset or length")
+				return
+			}
+			rangeSpec += strconv.FormatInt(o+l-1, 10)
+		}
+	}
+	if rangeSpec != "" {
+		h.getObjectRange(ctx, w, cid, ck, isCrypto, oi.Compression, rangeSpec)
+		return
+	}
+
+	data, err := h.backend.GetData(ctx, cid, 0, -1)
 	if err != nil {
 		util.WriteJsonQuiet(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	defer data.Close()
+	rd = data
+	// 压缩的对象解压后返回, 在写入状态码前读取压缩头部, 失败时返回500
+	if !isCrypto && oi.Compression != compress.None {
+		drd, _, err := compress.NewDecodeReader(rd, oi.Compression)
+		if err != nil {
+			logger.Error("下载文件失败 NewDecodeReader---》", err)
+			util.WriteJsonQuiet(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer drd.Close()
+		rd = drd
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if isCrypto {
@@ -123,7 +243,11 @@ func (h *chunkerAPIHandlers) GetObjectHandler(w http.ResponseWriter, r *http.Req
 					}
 				}
 			}
-			for i := int64(len(buf)); i < fileSize; {
+			for i, k := int64(len(buf)), 0; i < fileSize; k++ {
+				// 复制的分片大小不一定相同, 按头部记录的各分片大小读取
+				if isPart && k < len(info.Parts) {
+					buffSize = info.Parts[k].Size
+				}
 				if buffSize <= 0 || !isPart {
 					buffSize = 1024 * 1024 * 1024 * 6 //  最大单文件5GB 加密后大小也不会超过6GB
 				}
@@ -146,7 +270,6 @@ func (h *chunkerAPIHandlers) GetObjectHandler(w http.ResponseWriter, r *http.Req
 	} else {
 		_, err = io.Copy(w, rd)
 		if err != nil {
-			h.backend.FixCid(cid)
 			logger.Error(err)
 			util.WriteJsonQuiet(w, http.StatusInternalServerError, err.Error())
 			return
@@ -156,3 +279,284 @@ func (h *chunkerAPIHandlers) GetObjectHandler(w http.ResponseWriter, r *http.Req
 
 	return
 }
+
+// errInvalidRange 请求的范围无法满足
+var errInvalidRange = errors.New("invalid range")
+
+// parseRange parses a single range "bytes=start-end", "bytes=start-" or "bytes=-suffix"
+func parseRange(spec string, size int64) (start, length int64, err error) {
+	if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") {
+		return 0, 0, errInvalidRange
+	}
+	spec = strings.TrimSpace(strings.TrimPrefix(spec, "bytes="))
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, 0, errInvalidRange
+	}
+	first, last := spec[:i], spec[i+1:]
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 || size == 0 {
+			return 0, 0, errInvalidRange
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, suffix, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, errInvalidRange
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, errInvalidRange
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, nil
+}
+
+// dareSegment 加密对象中单独加密的一段数据, 分片上传的对象每个分片一段
+type dareSegment struct {
+	offset    int64 // 加密数据在文件中的偏移
+	size      int64 // 加密数据大小
+	plainSize int64
+}
+
+// objectLayout 对象数据的存储方式, 用于按原始内容的偏移读取
+type objectLayout struct {
+	cid      string
+	size     int64 // 原始内容的大小
+	key      []byte
+	segments []dareSegment
+	codec    string // 元数据中的压缩算法, 为空时未压缩
+}
+
+// errInvalidCryptoHeader 加密对象的头部无法解析
+var errInvalidCryptoHeader = errors.New("invalid crypto header")
+
+// objectLayout reads the header of the object to locate its plain content, codec is the compression in the metadata
+func (h *chunkerAPIHandlers) objectLayout(ctx context.Context, cid, ck string, isCrypto bool, codec string) (*objectLayout, error) {
+	l := &objectLayout{cid: cid, codec: codec}
+	if !isCrypto {
+		if codec == compress.None {
+			size, err := h.backend.GetDataSize(ctx, cid)
+			if err != nil {
+				return nil, err
+			}
+			l.size = size
+			return l, nil
+		}
+		// 压缩的对象按头部记录的原始大小计算范围, 头部需与元数据一致
+		rd, err := h.backend.GetData(ctx, cid, 0, compress.HeaderSize)
+		if err != nil {
+			return nil, err
+		}
+		defer rd.Close()
+		head := make([]byte, compress.HeaderSize)
+		if _, err = io.ReadFull(rd, head); err != nil {
+			return nil, err
+		}
+		c, size, ok := compress.ParseHeader(head)
+		if !ok || c != codec {
+			return nil, compress.ErrInvalidHeader
+		}
+		l.size = size
+		return l, nil
+	}
+
+	key, err := base64.URLEncoding.DecodeString(ck)
+	if err != nil {
+		return nil, error2.InvalidArgument{Err: err}
+	}
+	l.key = key
+	rd, err := h.backend.GetData(ctx, cid, 0, crypto.Size)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	var buf [crypto.Size]byte
+	if _, err = io.ReadFull(rd, buf[:]); err != nil {
+		return nil, err
+	}
+	b, t := crypto.CheckHeader(buf)
+	if !t {
+		return nil, errInvalidCryptoHeader
+	}
+	var info HeadInfo
+	if index := bytes.IndexByte(b, 0); index < 0 || json.Unmarshal(b[:index], &info) != nil {
+		return nil, errInvalidCryptoHeader
+	}
+	var encSizes []int64
+	if len(info.Parts) != 0 {
+		for _, v := range info.Parts {
+			encSizes = append(encSizes, v.Size)
+		}
+	} else {
+		fileSize, err := h.backend.GetDataSize(ctx, cid)
+		if err != nil {
+			return nil, err
+		}
+		encSizes = append(encSizes, fileSize-crypto.Size)
+	}
+	off := int64(crypto.Size)
+	for _, encSize := range encSizes {
+		plainSize, err := crypto.OrSize(encSize)
+		if err != nil {
+			return nil, err
+		}
+		l.segments = append(l.segments, dareSegment{offset: off, size: encSize, plainSize: int64(plainSize)})
+		off += encSize
+		l.size += int64(plainSize)
+	}
+	return l, nil
+}
+
+// rangeReader returns the plain content [start, start+length) of the object,
+// 加密对象按DARE加密包的边界读取后解密. 压缩数据没有保存可定位的块偏移,
+// 压缩对象只能从头解压后跳过前面的数据, 耗时与start成正比
+func (h *chunkerAPIHandlers) rangeReader(ctx context.Context, l *objectLayout, start, length int64) (io.ReadCloser, error) {
+	if length <= 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	if l.codec != compress.None {
+		rd, err := h.backend.GetData(ctx, l.cid, 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		drd, _, err := compress.NewDecodeReader(rd, l.codec)
+		if err != nil {
+			rd.Close()
+			return nil, err
+		}
+		if _, err = io.CopyN(ioutil.Discard, drd, start); err != nil {
+			drd.Close()
+			rd.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(drd, length), closers{drd, rd}}, nil
+	}
+	if l.key == nil {
+		return h.backend.GetData(ctx, l.cid, start, length)
+	}
+
+	var readers []io.Reader
+	var lazies closers
+	var plainOff int64
+	for _, seg := range l.segments {
+		segStart, segEnd := plainOff, plainOff+seg.plainSize
+		plainOff = segEnd
+		if segEnd <= start || segStart >= start+length {
+			continue
+		}
+		localOff := start - segStart
+		if localOff < 0 {
+			localOff = 0
+		}
+		localEnd := start + length - segStart
+		if localEnd > seg.plainSize {
+			localEnd = seg.plainSize
+		}
+		seg := seg
+		lr := &lazyReader{open: func() (io.Reader, io.Closer, error) {
+			encOffset, encLength, _ := crypto.DareRange(localOff, localEnd-localOff, seg.size)
+			rd, err := h.backend.GetData(ctx, l.cid, seg.offset+encOffset, encLength)
+			if err != nil {
+				return nil, nil, err
+			}
+			drd, err := crypto.DecryptRangeReader(rd, l.key, localOff, localEnd-localOff)
+			if err != nil {
+				rd.Close()
+				return nil, nil, err
+			}
+			return drd, rd, nil
+		}}
+		readers, lazies = append(readers, lr), append(lazies, lr)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(readers...), lazies}, nil
+}
+
+// lazyReader opens the reader on the first read
+type lazyReader struct {
+	open func() (io.Reader, io.Closer, error)
+	rd   io.Reader
+	c    io.Closer
+}
+
+func (r *lazyReader) Read(p []byte) (int, error) {
+	if r.rd == nil {
+		rd, c, err := r.open()
+		if err != nil {
+			return 0, err
+		}
+		r.rd, r.c = rd, c
+	}
+	return r.rd.Read(p)
+}
+
+func (r *lazyReader) Close() error {
+	if r.c == nil {
+		return nil
+	}
+	return r.c.Close()
+}
+
+// closers closes all of the readers
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var err error
+	for _, c := range cs {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// getObjectRange 读取对象的部分内容
+func (h *chunkerAPIHandlers) getObjectRange(ctx context.Context, w http.ResponseWriter, cid, ck string, isCrypto bool, codec, rangeSpec string) {
+	l, err := h.objectLayout(ctx, cid, ck, isCrypto, codec)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(error2.InvalidArgument); ok {
+			status = http.StatusBadRequest
+		}
+		util.WriteJsonQuiet(w, status, err.Error())
+		return
+	}
+
+	start, length, err := parseRange(rangeSpec, l.size)
+	if err != nil {
+		w.Header().Set(xhttp.ContentRange, fmt.Sprintf("bytes */%d", l.size))
+		util.WriteJsonQuiet(w, http.StatusRequestedRangeNotSatisfiable, err.Error())
+		return
+	}
+
+	rd, err := h.rangeReader(ctx, l, start, length)
+	if err != nil {
+		util.WriteJsonQuiet(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rd.Close()
+
+	w.Header().Set(xhttp.AcceptRanges, "bytes")
+	w.Header().Set(xhttp.ContentRange, fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, l.size))
+	w.Header().Set(xhttp.ContentLength, strconv.FormatInt(length, 10))
+	w.Header().Set(xhttp.ContentType, "application/octet-stream")
+	w.WriteHeader(http.StatusPartialContent)
+	if n, err := io.Copy(w, rd); err != nil {
+		logger.Error("下载文件失败 copy ---》", err, n)
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"testing"
+
+	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
+	"mtcloud.com/mtstorage/pkg/crypto"
+)
+
+func TestObjectHasCid(t *testing.T) {
+	const (
+		ck    = "NuE7q6aLS4m_ad3FujywX-U9KI76B4jw5Q9fdS8gBvQ="
+		other = "177yRvku660_0bQzFIqpFM2v4P5JgoL9lP35oyz5LNI="
+		cid   = "Qmd2zcCyaG4bpZB4b1JJN4uMPXgaqrN1TFPBxC78ZTxvr9"
+	)
+	// 加密对象的元数据中保存cid的密文
+	encrypted, err := crypto.Base64Encrypt(ck, cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		stored string
+		cid    string
+		ck     string
+		want   bool
+	}{
+		{name: "plain", stored: cid, cid: cid, want: true},
+		{name: "plain with key", stored: cid, cid: cid, ck: ck, want: true},
+		{name: "other cid", stored: cid, cid: "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o", want: false},
+		{name: "no cid", stored: "", cid: "", want: false},
+		{name: "encrypted by ciphertext", stored: encrypted, cid: encrypted, ck: ck, want: true},
+		{name: "encrypted by cid", stored: encrypted, cid: cid, ck: ck, want: true},
+		{name: "encrypted without key", stored: encrypted, cid: cid, want: false},
+		{name: "encrypted with other key", stored: encrypted, cid: cid, ck: other, want: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			oi := metadata.ObjectInfo{Cid: c.stored}
+			if got := objectHasCid(oi, c.cid, c.ck); got != c.want {
+				t.Fatalf("want %v, got %v", c.want, got)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
 	"encoding/base64"
@@ -10,25 +11,19 @@ import (
 	"io"
 	sysioutil "io/ioutil"
 	"net/http"
-	"os"
-	"path"
 	"regexp"
-	"sort"
 	"strconv"
 	"strings"
-	"sync/atomic"
-	"time"
 
 	"mtcloud.com/mtstorage/pkg/crypto"
 	utilruntime "mtcloud.com/mtstorage/pkg/runtime"
 
-	"github.com/minio/pkg/trie"
 	"go.opencensus.io/trace"
 	"mtcloud.com/mtstorage/api"
+	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
 	"mtcloud.com/mtstorage/node/client"
 	node_util "mtcloud.com/mtstorage/node/util"
 	"mtcloud.com/mtstorage/pkg/hash"
-	"mtcloud.com/mtstorage/pkg/ioutil"
 	"mtcloud.com/mtstorage/pkg/logger"
 	"mtcloud.com/mtstorage/pkg/storage"
 	error2 "mtcloud.com/mtstorage/pkg/storageerror"
@@ -62,14 +57,9 @@ type PartsInfo struct {
 
 var etagRegex = regexp.MustCompile("\"*?([^\"]*?)\"*?$")
 
-// Returns EXPORT/.minio.sys/multipart/SHA256/UPLOADID
-func (h *chunkerAPIHandlers) getUploadIDDir(bucket, object, uploadID string) string {
-	if len(uploadID) < 10 {
-		uploadID = util.GetRandString(10)
-	}
-	//return storage.PathJoin(h.backend.TempDir, hash.GetSHA256Hash([]byte(storage.PathJoin(bucket, object, "-", util.GetRandString(2)))), uploadID)
-	return storage.PathJoin(h.backend.TempDir, uploadID[:5])
-
+// isValidUploadID uploadID由nameserver生成, 不能包含路径分隔符
+func isValidUploadID(uploadID string) bool {
+	return uploadID != "" && uploadID != "." && uploadID != ".." && !strings.ContainsAny(uploadID, `/\`)
 }
 
 func (h *chunkerAPIHandlers) NewMultipart(w http.ResponseWriter, r *http.Request) {
@@ -82,25 +72,27 @@ func (h *chunkerAPIHandlers) NewMultipart(w http.ResponseWriter, r *http.Request
 	_ = vars.Get("content-type")
 	object := vars.Get("object")
 	uploadID := vars.Get("uploadID")
+	owner, _ := strconv.ParseUint(vars.Get("owner"), 10, 32)
 
-	uploadPath := h.getUploadIDDir(bucket, object, uploadID)
+	logger.Infof("NewMultipart bucket: %s object: %s, uploadId: %s ", bucket, object, uploadID)
 
-	logger.Infof("NewMultipart bucket: %s object: %s, uploadPath: %s uploadId: %s ", bucket, object, uploadPath, uploadID)
-
-	if bucket == "" || storageClass == "" || object == "" {
-		logger.Errorf("invalid arguments bucket: %s object: %s, storageClass: %s", bucket, object, storageClass)
+	if bucket == "" || storageClass == "" || object == "" || !isValidUploadID(uploadID) {
+		logger.Errorf("invalid arguments bucket: %s object: %s, storageClass: %s, uploadId: %s", bucket, object, storageClass, uploadID)
 		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrInvalidArguments), r.URL)
 		return
 	}
 
-	//uploadPath := fmt.Sprintf("%s/%s/%s", h.multiDir, bucket, uploadID, "parts")
-	_, err := os.Stat(uploadPath)
-	if os.IsNotExist(err) {
-		if err = os.MkdirAll(uploadPath, os.ModePerm); err != nil {
-			logger.Error("create multipart dir failed: ", err)
-			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.FileSystemError{}), r.URL)
-			return
-		}
+	// 分片直接写入存储引擎, 上传记录保存在nameserver, 后续请求可以由任一chunker处理
+	if err := h.backend.RegisterMultipartUpload(client.WithTrack(ctx), metadata.MultipartUploadInfo{
+		UploadId:     uploadID,
+		Bucket:       bucket,
+		Object:       object,
+		Owner:        uint32(owner),
+		StorageClass: storageClass,
+	}); err != nil {
+		logger.Error("register multipart upload failed: ", err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
 	}
 
 	api.WriteSuccessResponseJSON(w, []byte("success"))
@@ -120,9 +112,6 @@ func (h *chunkerAPIHandlers) PutObjectPart(w http.ResponseWriter, r *http.Reques
 	encMd5Sum := vars.Get("encMd5Sum")
 	rawMD5Sum := vars.Get("rawMD5sum")
 	ck := r.Header.Get("crypto-key") // 加密秘钥
-	decodeString, _ := base64.URLEncoding.DecodeString(ck)
-	var objectEncryptionKey hash.ObjectKey
-	copy(objectEncryptionKey[:len(decodeString)], decodeString)
 	//clientETag, err := etag.FromContentMD5(r.Header)
 	//if err != nil {
 
@@ -130,28 +119,65 @@ func (h *chunkerAPIHandlers) PutObjectPart(w http.ResponseWriter, r *http.Reques
 	//	return
 	//}
 	//md5hex := clientETag.String()
-	reader, err := hash.NewReader(r.Body, r.ContentLength, encMd5Sum, "", r.ContentLength)
+	pid, err := strconv.Atoi(partID)
+	if err != nil || !isValidUploadID(uploadID) {
+		logger.Errorf("invalid arguments uploadId: %s, partID: %s", uploadID, partID)
+		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrInvalidArguments), r.URL)
+		return
+	}
+	if _, err := h.backend.GetMultipartUploadOf(client.WithTrack(ctx), uploadID, bucket, object); err != nil {
+		logger.Errorf("get multipart upload %s of %s/%s failed: %s", uploadID, bucket, object, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	// 存储空间不足时在读取数据前拒绝
+	if err := h.backend.Admit(r.ContentLength); err != nil {
+		logger.Errorf("reject part %s of %s/%s, size: %d: %s", partID, bucket, object, r.ContentLength, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.StorageFull{Bucket: bucket, Object: object, Err: err}), r.URL)
+		return
+	}
+	etagS3, _, err := h.writePart(ctx, uploadID, pid, r.Body, r.ContentLength, ck, encMd5Sum, rawMD5Sum)
+	if err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+
+	util.WriteJsonQuiet(w, http.StatusOK, etagS3)
+
+}
+
+// writePart writes the data of the part into the storage engine and records it in the upload,
+// etag为空时使用分片原始内容的md5. 返回写入数据的md5和记录的etag
+func (h *chunkerAPIHandlers) writePart(ctx context.Context, uploadID string, pid int, body io.Reader, size int64, ck, encMd5Sum, etag string) (string, string, error) {
+	decodeString, _ := base64.URLEncoding.DecodeString(ck)
+	var objectEncryptionKey hash.ObjectKey
+	copy(objectEncryptionKey[:len(decodeString)], decodeString)
+
+	plain, err := hash.NewReader(body, size, encMd5Sum, "", size)
+	if err != nil {
+		logger.Error(err)
+		return "", "", err
+	}
+	reader := plain
 	if ck != "" {
-		reader, err = crypto.GetEncryptReader(reader, objectEncryptionKey, encMd5Sum, -1)
+		reader, err = crypto.GetEncryptReader(plain, objectEncryptionKey, encMd5Sum, -1)
 		if err != nil {
-			return
+			logger.Error(err)
+			return "", "", err
 		}
 	}
-	uploadIDDir := h.getUploadIDDir(bucket, object, uploadID)
-	multipartsPath := storage.PathJoin(uploadIDDir, "parts")
 
-	tmpPartFile := storage.PathJoin(multipartsPath, uploadID+"."+partID)
-	bytesWritten, err := storage.FsCreateFile(ctx, tmpPartFile, reader, r.ContentLength)
+	// 分片写入存储引擎, 完成时由各分片的cid组成对象
+	partCid, err := h.backend.WriteData(ctx, reader)
 	if err != nil {
-		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
-		return
+		logger.Errorf("write part %d of upload %s failed: %s", pid, uploadID, err)
+		return "", "", err
 	}
-	defer storage.FsRemoveFile(ctx, tmpPartFile)
 
-	if bytesWritten < r.ContentLength {
-		logger.Error("receive in complete body! bucket: %s, object: %s", bucket, object)
-		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.IncompleteBody{}), r.URL)
-		return
+	if plain.BytesRead() < size {
+		logger.Errorf("receive in complete body! upload: %s, part: %d", uploadID, pid)
+		go h.removeParts(uploadID, []string{partCid})
+		return "", "", error2.IncompleteBody{}
 	}
 
 	//check etag
@@ -159,32 +185,28 @@ func (h *chunkerAPIHandlers) PutObjectPart(w http.ResponseWriter, r *http.Reques
 	if etagS3 == "" {
 		etagS3 = hash.GenETag()
 	}
-
-	pid, err := strconv.Atoi(partID)
-	if err != nil {
-		logger.Error(err)
-		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrInvalidArguments), r.URL)
-		return
-	}
-	partFile := storage.PathJoin(multipartsPath, h.encodePartFile(pid, rawMD5Sum, reader.BytesRead()))
-	if err := os.Rename(tmpPartFile, partFile); err != nil {
-		logger.Error(err)
-		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.FileSystemError{}), r.URL)
-		return
+	if etag == "" {
+		etag = plain.MD5CurrentHexString()
 	}
 
-	_, err = storage.FsStatFile(ctx, partFile)
+	// nameserver中的记录是分片的唯一依据, 记录失败时上传失败
+	replaced, err := h.backend.RecordMultipartPart(client.WithTrack(ctx), metadata.MultipartPartInfo{
+		UploadId:   uploadID,
+		PartNumber: pid,
+		ETag:       etag,
+		Cid:        partCid,
+		Size:       reader.BytesRead(),
+		ActualSize: plain.BytesRead(),
+	})
 	if err != nil {
-		logger.Error(err)
-		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.FileSystemError{}), r.URL)
-		return
+		logger.Errorf("record part %d of upload %s failed: %s", pid, uploadID, err)
+		go h.removeParts(uploadID, []string{partCid})
+		return "", "", err
 	}
-
-	//merge on background
-	go h.backgroundAppend(ctx, bucket, object, uploadID)
-
-	util.WriteJsonQuiet(w, http.StatusOK, etagS3)
-
+	if replaced != "" {
+		go h.removeParts(uploadID, []string{replaced})
+	}
+	return etagS3, etag, nil
 }
 
 func (h *chunkerAPIHandlers) CompleteMultipart(w http.ResponseWriter, r *http.Request) {
@@ -219,209 +241,59 @@ func (h *chunkerAPIHandlers) CompleteMultipart(w http.ResponseWriter, r *http.Re
 	logger.Infof("=====>CompleteMultipart: %s , %s ,%s ", bucket, object, uploadID)
 	sc := param.StorageClass
 	acl := param.ACL
-	if bucket == "" || sc == "" || object == "" || acl == "" {
-		logger.Errorf("invalid arguments bucket: %s object: %s, storageClass: %s, acl: %s.", bucket, object, sc, acl)
+	if bucket == "" || sc == "" || object == "" || acl == "" || !isValidUploadID(uploadID) || len(param.Parts) == 0 {
+		logger.Errorf("invalid arguments bucket: %s object: %s, storageClass: %s, acl: %s, uploadId: %s.", bucket, object, sc, acl, uploadID)
 		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrInvalidArguments), r.URL)
 		return
 	}
 
 	logger.Debugf("CompleteMultipart bucket: %s, object: %s, uploadID: %s", bucket, object, uploadID)
 
-	uploadIDDir := h.getUploadIDDir(bucket, object, uploadID)
-	multipartDir := storage.PathJoin(uploadIDDir, "parts")
-	logger.Debug("multipartDir: ", multipartDir)
-	if _, err := os.Stat(multipartDir); err != nil {
-		if os.IsNotExist(err) {
-			logger.Error("multipartDir not exist: ", multipartDir)
-			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.FileSystemError{}), r.URL)
-			return
-		}
-	}
-
-	defer func() {
-		if err := storage.FsRemoveAll(ctx, uploadIDDir); err != nil {
-			logger.Error(err)
-		}
-	}()
-	//read multipart dir
-	entries, err := storage.ReadDir(multipartDir)
+	upload, err := h.backend.GetMultipartUploadOf(client.WithTrack(ctx), uploadID, bucket, object)
 	if err != nil {
-		logger.Error(err, multipartDir)
-		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.FileSystemError{}), r.URL)
+		logger.Errorf("get multipart upload %s of %s/%s failed: %s", uploadID, bucket, object, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
 		return
 	}
-
-	// Create entries trie structure for prefix match
-	entriesTrie := trie.NewTrie()
-	for _, entry := range entries {
-		entriesTrie.Insert(entry)
+	uploaded := make(map[int]metadata.MultipartPartInfo, len(upload.Parts))
+	for _, part := range upload.Parts {
+		uploaded[part.PartNumber] = part
 	}
 
 	var head HeadInfo
 	head.Version = "1.0.0"
 	partsInfo := make([]PartsInfo, len(param.Parts), len(param.Parts))
+	cids := make([]string, 0, len(param.Parts)+1)
 	// Save consolidated actual size.
 	var objectActualSize int64
 	var cipherSize uint64
-	// Validate all parts and then commit to disk.
+	var actualSize uint64
+	// Validate all parts.
 	for i, part := range param.Parts {
 		param.Parts[i].ETag = canonicalizeETag(part.ETag)
-		partFile := getPartFile(entriesTrie, part.PartNumber, part.ETag)
-		if partFile == "" {
-			logger.Error("invalid part! PartNumber: %d", part.PartNumber)
-			api.WriteErrorResponseJSON(w,
-				error2.ToAPIError(ctx,
-					error2.InvalidPart{
-						PartNumber: part.PartNumber,
-						GotETag:    part.ETag,
-					}),
-				r.URL)
-			return
-		}
-
-		// Read the actualSize from the pathFileName.
-		subParts := strings.Split(partFile, ".")
-		actualSize, err := strconv.ParseInt(subParts[len(subParts)-1], 10, 64)
-		if err != nil {
-			logger.Errorf("error: %v PartNumber: %d", err, part.PartNumber)
+		p, ok := uploaded[part.PartNumber]
+		if !ok || p.Cid == "" || p.ETag != param.Parts[i].ETag {
+			logger.Errorf("invalid part! PartNumber: %d", part.PartNumber)
 			api.WriteErrorResponseJSON(w,
 				error2.ToAPIError(ctx,
 					error2.InvalidPart{
 						PartNumber: part.PartNumber,
+						ExpETag:    p.ETag,
 						GotETag:    part.ETag,
 					}),
 				r.URL)
 			return
 		}
 		// 记录文件分片信息
-		partsInfo[i].Size = actualSize        // 加密后的大小
+		partsInfo[i].Size = p.Size            // 加密后的大小
 		partsInfo[i].Number = part.PartNumber // 分片编号
-
-		partPath := storage.PathJoin(multipartDir, partFile)
-
-		_, err = storage.FsStatFile(ctx, partPath)
-		if err != nil {
-			if err == storage.ErrFileNotFound || err == storage.ErrFileAccessDenied {
-				api.WriteErrorResponseJSON(w,
-					error2.ToAPIError(ctx,
-						error2.InvalidPart{
-							PartNumber: part.PartNumber,
-							GotETag:    part.ETag,
-						}),
-					r.URL)
-			}
-			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
-			return
-		}
+		cids = append(cids, p.Cid)
 
 		// Consolidate the actual size.
-		objectActualSize += actualSize
-
-		if i == len(param.Parts)-1 {
-			break
-		}
-
-		// All parts except the last part has to be atleast 5MB.
-		//if !isMinAllowedPartSize(actualSize) {
-		//	return oi, PartTooSmall{
-		//		PartNumber: part.PartNumber,
-		//		PartSize:   actualSize,
-		//		PartETag:   part.ETag,
-		//	}
-		//}
-	}
-
-	_, objName := storage.ParseObject(object)
-	appendFallback := true // In case background-append did not append the required parts.
-	appendFilePath := storage.PathJoin(uploadIDDir, objName)
-
-	h.backgroundAppend(ctx, bucket, object, uploadID)
-
-	h.appendFileMapMu.Lock()
-	file := h.appendFileMap[uploadID]
-	delete(h.appendFileMap, uploadID)
-	h.appendFileMapMu.Unlock()
-
-	parts := param.Parts
-	if file != nil {
-		file.Lock()
-		defer file.Unlock()
-		// Verify that appendFile has all the parts.
-		if len(file.parts) == len(parts) {
-			for i := range parts {
-				if parts[i].ETag != file.parts[i].ETag {
-					break
-				}
-				if parts[i].PartNumber != file.parts[i].PartNumber {
-					break
-				}
-				if i == len(parts)-1 {
-					appendFilePath = file.filePath
-					appendFallback = false
-				}
-			}
-		}
+		objectActualSize += p.Size
+		actualSize += uint64(p.ActualSize)
 	}
-
-	if appendFallback {
-		if file != nil {
-			storage.FsRemoveFile(ctx, file.filePath)
-		}
-		for _, part := range parts {
-			partFile := getPartFile(entriesTrie, part.PartNumber, part.ETag)
-			if partFile == "" {
-				logger.Error(fmt.Errorf("%.5d.%s missing will not proceed", part.PartNumber, part.ETag))
-				api.WriteErrorResponseJSON(w,
-					error2.ToAPIError(ctx,
-						error2.InvalidPart{
-							PartNumber: part.PartNumber,
-							GotETag:    part.ETag,
-						}),
-					r.URL)
-				return
-			}
-			// 合并分片
-			if err = ioutil.AppendFile(appendFilePath, storage.PathJoin(uploadIDDir, partFile), true); err != nil {
-				logger.Error(err)
-				api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
-				return
-			}
-		}
-	}
-
-	//check file again
-	logger.Debugf("FsStatFile bucket: %s, object: %s ", bucket, object)
-	_, err = storage.FsStatFile(ctx, appendFilePath)
-	if err != nil {
-		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
-		return
-
-	}
-	dirName, objectName := storage.ParseObject(object)
-	targetPath := path.Join(uploadIDDir, path.Base(object))
-
-	//marshalHead := crypto.AddHeader(marshal)
-
-	//headPath := path.Join(uploadIDDir, path.Base("head"))
-	///err = ioutil.AppendHead(headPath, marshalHead[:])
-	//fmt.Println(string(marshalHead[:]))
-	//if err != nil {
-	//	api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
-	//	return
-	//}
-	// 合并
-	//if err = ioutil.AppendFile(headPath, targetPath, true); err != nil {
-	//	logger.Error(err)
-	//	api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
-	//	return
-	//}
-	targetFile, err := os.Open(targetPath)
-	if err != nil {
-		logger.Errorf("open targetFile err %s", err)
-		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
-	}
-	defer os.Remove(targetPath)
-	var reader io.Reader
+	partCids := append([]string(nil), cids...)
 
 	cipherSize = uint64(objectActualSize)
 	// 增加2M加密头
@@ -436,43 +308,33 @@ func (h *chunkerAPIHandlers) CompleteMultipart(w http.ResponseWriter, r *http.Re
 			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
 			return
 		}
-		reader, err = crypto.NewReader(targetFile, marshal)
+		reader, err := crypto.NewReader(bytes.NewReader(nil), marshal)
 		if err != nil {
-			logger.Errorf("open targetFile err %s", err)
+			logger.Errorf("create crypto header err %s", err)
 			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
 			return
 		}
-	} else {
-
-		reader = targetFile
+		// 加密头作为第一个分片写入
+		headCid, err := h.backend.WriteData(ctx, reader)
+		if err != nil {
+			logger.Error("write crypto header failed: ", err)
+			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+			return
+		}
+		cids = append([]string{headCid}, cids...)
+		defer func() { go h.removeParts(uploadID, []string{headCid}) }()
 	}
 
-	logger.Info(" start ipfs")
-	var dataCid string
-	//switch sc {
-	//case "CA":
-	//	dataCid, err = SendToPowerGate(h.powerGateHost, h.powerGateToken, reader)
-	//	logger.Info(" start  ca")
-	//case "STANDARD":
-	//	dataCid, err = nodeimpl.WriteData(ctx, reader)
-	//	logger.Info(" start  STANDARD")
-	//default:
-	//	err = errors.New("不支持的存储类型")
-	//}
-	logger.Debugf("start WriteData bucket: %s, object: %s ", bucket, object)
-	dataCid, err = h.backend.WriteData(ctx, reader)
-	// 上传ipfs结束
-	if err != nil {
-		logger.Error("write ipfs failed", err)
-		util.WriteJsonQuiet(w, http.StatusInternalServerError, err.Error())
-		return
-	}
+	logger.Debugf("start ComposeData bucket: %s, object: %s, parts: %d", bucket, object, len(cids))
+	dataCid, err := h.backend.ComposeData(ctx, cids)
 	if err != nil || dataCid == "" {
-		logger.Errorf("open targetFile err %s", err)
+		logger.Errorf("compose parts of upload %s failed: %s", uploadID, err)
 		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
 		return
 	}
-	var actualSize uint64
+
+	// 确定文件的类型
+	contentType := h.partContentType(ctx, partCids[0])
 
 	if cryptoKey != "" {
 		dataCid, err = crypto.Base64Encrypt(cryptoKey, dataCid)
@@ -481,28 +343,10 @@ func (h *chunkerAPIHandlers) CompleteMultipart(w http.ResponseWriter, r *http.Re
 			util.WriteJsonQuiet(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		objectActualSize -= crypto.Size
-		actualSize, err = crypto.OrSize(objectActualSize)
-		if err != nil {
-			logger.Error("encrypt failed:", err)
-			util.WriteJsonQuiet(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-	} else {
-		actualSize = uint64(objectActualSize)
-	}
-
-	// 确定文件的类型
-	err = targetFile.Close()
-	if err != nil {
-		logger.Errorf("File stream close failed", err)
-	}
-	contentType, err := GetFileContentType(targetPath)
-	if err != nil {
-		logger.Errorf("GetFileContentType failed", err)
 	}
 
-	logger.Info("start  CallBackNS bucket: %s, object: %s ", bucket, object)
+	dirName, objectName := storage.ParseObject(object)
+	logger.Infof("start  CallBackNS bucket: %s, object: %s ", bucket, object)
 	if err = h.backend.CallBackNS(client.WithTrack(ctx), node_util.ReWriteObjectInfo{
 		Bucket:         bucket,
 		Name:           objectName,
@@ -521,11 +365,12 @@ func (h *chunkerAPIHandlers) CompleteMultipart(w http.ResponseWriter, r *http.Re
 		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
 		return
 	}
-	logger.Info(" end CallBackNS  bucket: %s, object: %s ", bucket, object)
-	// Purge multipart folders 749db986dd50b5d96c17a94f57ed029a-110
-	{
-		fsTmpObjPath := uploadIDDir
-		defer storage.FsRemoveAll(ctx, fsTmpObjPath) // remove multipart temporary files in background.
+	logger.Infof(" end CallBackNS  bucket: %s, object: %s ", bucket, object)
+	// 对象已引用分片的数据, 取消分片自身的pin, 未使用的分片一并删除
+	if removed, err := h.backend.RemoveMultipartUpload(client.WithTrack(ctx), uploadID); err != nil {
+		logger.Errorf("remove multipart upload %s failed: %s", uploadID, err)
+	} else {
+		go h.removeParts(uploadID, uploadedCids(removed.Parts))
 	}
 
 	result := map[string]string{
@@ -535,105 +380,50 @@ func (h *chunkerAPIHandlers) CompleteMultipart(w http.ResponseWriter, r *http.Re
 	util.WriteJsonQuiet(w, http.StatusOK, result)
 }
 
-// Returns the part file name which matches the partNumber and etag.
-func getPartFile(entriesTrie *trie.Trie, partNumber int, etag string) (partFile string) {
-	for _, match := range entriesTrie.PrefixMatch(fmt.Sprintf("%.5d.%s.", partNumber, etag)) {
-		partFile = match
-		break
+// uploadedCids returns the cids of the parts
+func uploadedCids(parts []metadata.MultipartPartInfo) []string {
+	cids := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p.Cid != "" {
+			cids = append(cids, p.Cid)
+		}
 	}
-	return partFile
+	return cids
 }
 
-var count int64 = 0
-
-// Appends parts to an appendFile sequentially.
-func (h *chunkerAPIHandlers) backgroundAppend(ctx context.Context, bucket, object, uploadID string) {
+// removeParts removes the pins of the parts in background,
+// 被合并的对象引用的数据由对象的递归pin保留, 与对象或其它分片cid相同的数据不删除
+func (h *chunkerAPIHandlers) removeParts(uploadID string, cids []string) {
 	defer utilruntime.HandleCrash()
 
-	h.appendFileMapMu.Lock()
-	file := h.appendFileMap[uploadID]
-	if file == nil {
-		uploadIDDir := h.getUploadIDDir(bucket, object, uploadID)
-		fileName := path.Base(object)
-		mergeFilePath := path.Join(uploadIDDir, fileName)
-		file = &fsAppendFile{
-			//filePath: storage.PathJoin(h.multiDir, minioMetaTmpBucket, h.fsUUID, fmt.Sprintf("%s.%s", uploadID, mustGetUUID())),
-			filePath: mergeFilePath,
-		}
-		h.appendFileMap[uploadID] = file
-	}
-	h.appendFileMapMu.Unlock()
-
-	file.Lock()
-	defer file.Unlock()
-
-	// Since we append sequentially nextPartNumber will always be len(file.parts)+1
-	nextPartNumber := len(file.parts) + 1
-	uploadIDDir := h.getUploadIDDir(bucket, object, uploadID)
-	multiPartpath := storage.PathJoin(uploadIDDir, "parts")
-
-	entries, err := storage.ReadDir(multiPartpath)
-	if err != nil {
-		logger.Errorf("error: %v multiPartpath: %s uploadIDDir: %s", err, multiPartpath, uploadIDDir)
-		return
-	}
-	sort.Strings(entries)
-
-	for i, entry := range entries {
-		partNumber, etag, actualSize, err := h.decodePartFile(entry)
-		if err != nil {
-			// Skip part files whose name don't match expected format. These could be backend filesystem specific files.
-			continue
-		}
-		if partNumber < nextPartNumber {
-			// Part already appended.
-			continue
-		}
-		if partNumber > nextPartNumber {
-			// Required part number is not yet uploaded.
-			return
-		}
-
-		partPath := storage.PathJoin(multiPartpath, entry)
-		start := time.Now()
-		err = ioutil.AppendFile(file.filePath, partPath, true)
-		mi := time.Now().Sub(start).Milliseconds()
-		atomic.AddInt64(&count, mi)
-		logger.Infof("======>合并上传分片，第 %d 个分片，耗时 %d ms 共耗时 %d ms", i, mi, count)
-		if err != nil {
-			logger.Error(err)
-			return
-		}
-
-		file.parts = append(file.parts, PartInfo{PartNumber: partNumber, ETag: etag, ActualSize: actualSize})
-		nextPartNumber++
+	ctx := context.Background()
+	for _, cid := range cids {
+		h.backend.RemoveMultipartData(ctx, uploadID, cid)
 	}
 }
 
-// Returns partNumber.etag
-func (h *chunkerAPIHandlers) encodePartFile(partNumber int, etag string, actualSize int64) string {
-	//return fmt.Sprintf("%.5d.%s.%d", partNumber, etag, actualSize)
-	return fmt.Sprintf("%.5d.%s.%d", partNumber, etag, actualSize)
-}
-
-// Returns partNumber and etag
-func (h *chunkerAPIHandlers) decodePartFile(name string) (partNumber int, etag string, actualSize int64, err error) {
-	result := strings.Split(name, ".")
-	if len(result) != 3 {
-		return 0, "", 0, storage.ErrUnexpected
-	}
-	partNumber, err = strconv.Atoi(result[0])
+// partContentType sniffs the content type from the first bytes of the part
+func (h *chunkerAPIHandlers) partContentType(ctx context.Context, cid string) string {
+	// Only the first 512 bytes are used to sniff the content type.
+	buffer := make([]byte, 512)
+	reader, err := h.backend.GetData(ctx, cid, 0, int64(len(buffer)))
 	if err != nil {
-		return 0, "", 0, storage.ErrUnexpected
+		logger.Error("GetContentType failed: ", err)
+		return ""
 	}
-	actualSize, err = strconv.ParseInt(result[2], 10, 64)
-	if err != nil {
-		return 0, "", 0, storage.ErrUnexpected
+	defer reader.Close()
+	n, err := io.ReadFull(reader, buffer)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		logger.Error("GetContentType failed: ", err)
+		return ""
 	}
-	return partNumber, result[1], actualSize, nil
+
+	// Use the net/http package's handy DectectContentType function. Always returns a valid
+	// content-type by returning "application/octet-stream" if no others seemed to match.
+	return http.DetectContentType(buffer[:n])
 }
 
-// AbortMultipartUpload 取消分片上传，删除存放分片的目录
+// AbortMultipartUpload 取消分片上传，删除已上传的分片
 func (h *chunkerAPIHandlers) AbortMultipartUpload(w http.ResponseWriter, r *http.Request) {
 
 	ctx, span := trace.StartSpan(r.Context(), "AbortMultipartUpload")
@@ -650,20 +440,29 @@ func (h *chunkerAPIHandlers) AbortMultipartUpload(w http.ResponseWriter, r *http
 		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrInvalidArguments), r.URL)
 		return
 	}
-	logger.Infof("=====>AbortMultipartUpload: json:", string(jsonBytes))
+	logger.Infof("=====>AbortMultipartUpload: json: %s", string(jsonBytes))
 
 	bucket := param.Bucket
 	object := param.Object
 	uploadID := param.UploadID
-	if bucket == "" || object == "" {
-		logger.Errorf("invalid arguments bucket: %s object: %s", bucket, object)
+	if bucket == "" || object == "" || !isValidUploadID(uploadID) {
+		logger.Errorf("invalid arguments bucket: %s object: %s, uploadId: %s", bucket, object, uploadID)
 		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrInvalidArguments), r.URL)
 		return
 	}
 	logger.Debugf("AbortMultipartUpload bucket: %s, object: %s, uploadID: %s", bucket, object, uploadID)
-	uploadIDDir := h.getUploadIDDir(bucket, object, uploadID)
-	fsTmpObjPath := uploadIDDir
-	defer storage.FsRemoveAll(ctx, fsTmpObjPath) // remove multipart temporary files in background.
+	if _, err := h.backend.GetMultipartUploadOf(client.WithTrack(ctx), uploadID, bucket, object); err != nil {
+		logger.Errorf("get multipart upload %s of %s/%s failed: %s", uploadID, bucket, object, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	upload, err := h.backend.RemoveMultipartUpload(client.WithTrack(ctx), uploadID)
+	if err != nil {
+		logger.Errorf("remove multipart upload %s failed: %s", uploadID, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	go h.removeParts(uploadID, uploadedCids(upload.Parts))
 	util.WriteJsonQuiet(w, http.StatusOK, "")
 	return
 }
@@ -675,46 +474,26 @@ func (h *chunkerAPIHandlers) ListObjectParts(w http.ResponseWriter, r *http.Requ
 	bucket := vars.Get("bucket")
 	object := vars.Get("object")
 	uploadID := vars.Get("uploadID")
+	if !isValidUploadID(uploadID) {
+		logger.Errorf("invalid uploadId: %s", uploadID)
+		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrInvalidArguments), r.URL)
+		return
+	}
 
-	uploadIDDir := h.getUploadIDDir(bucket, object, uploadID)
-	multipartPath := storage.PathJoin(uploadIDDir, "parts") // parts目录
-
-	entries, err := storage.ReadDir(multipartPath) // 遍历所有文件
+	upload, err := h.backend.GetMultipartUploadOf(client.WithTrack(ctx), uploadID, bucket, object)
 	if err != nil {
-		logger.Error(err, multipartPath)
-		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.FileSystemError{}), r.URL)
+		logger.Errorf("get multipart upload %s of %s/%s failed: %s", uploadID, bucket, object, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
 		return
 	}
-	type PartInfo struct {
-		PartNumber   int
-		LastModified time.Time
-		ETag         string
-		Size         int64
-		ActualSize   int64
-	}
-	var result []PartInfo
-	//
-	for _, partName := range entries {
-		subParts := strings.Split(partName, ".")
-		if len(subParts) < 3 {
-			// 如果文件名不是  00001.14b17234e237505421b6492b8d757507.15728640
-			// 跳过该文件
-			continue
-		}
-		partSize, err := strconv.ParseInt(subParts[2], 10, 64) // 切片大小
-		if err != nil {
-			continue
-		}
-		partNumber, err := strconv.Atoi(subParts[0]) // 切片编号
-		if err != nil {
-			continue
-		}
+	result := make([]PartInfo, 0, len(upload.Parts))
+	for _, part := range upload.Parts {
 		result = append(result, PartInfo{
-			PartNumber:   partNumber,
-			LastModified: util.GetFileModTime(storage.PathJoin(multipartPath, partName)), // 获取文件的修改时间
-			ETag:         subParts[1],                                                    //文件md5
-			Size:         partSize,
-			ActualSize:   partSize, //文件真实大小，s3没有该字段，minio有
+			PartNumber:   part.PartNumber,
+			LastModified: part.LastModified,
+			ETag:         part.ETag, //文件md5
+			Size:         part.Size,
+			ActualSize:   part.ActualSize, //文件真实大小，s3没有该字段，minio有
 		})
 	}
 	util.WriteJsonQuiet(w, http.StatusOK, result)
@@ -745,6 +524,9 @@ func (h *chunkerAPIHandlers) GetObjectDagTree1(w http.ResponseWriter, r *http.Re
 func (h *chunkerAPIHandlers) GetObjectDagTree(w http.ResponseWriter, r *http.Request) {
 	ctx, span := trace.StartSpan(r.Context(), "GetObjectDagTree")
 	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
 
 	vars := r.URL.Query()
 	cid := vars.Get("cid")
@@ -761,6 +543,9 @@ func (h *chunkerAPIHandlers) GetObjectDagTree(w http.ResponseWriter, r *http.Req
 func (h *chunkerAPIHandlers) AddObjectCid(w http.ResponseWriter, r *http.Request) {
 	ctx, span := trace.StartSpan(r.Context(), "AddObjectCid")
 	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
 
 	body, err := sysioutil.ReadAll(r.Body)
 	if err != nil {
@@ -784,28 +569,6 @@ func (h *chunkerAPIHandlers) AddObjectCid(w http.ResponseWriter, r *http.Request
 	return
 }
 
-func GetFileContentType(path string) (string, error) {
-
-	file, err := os.Open(path)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-	// Only the first 512 bytes are used to sniff the content type.
-	buffer := make([]byte, 512)
-
-	_, err = file.Read(buffer)
-	if err != nil {
-		return "", err
-	}
-
-	// Use the net/http package's handy DectectContentType function. Always returns a valid
-	// content-type by returning "application/octet-stream" if no others seemed to match.
-	contentType := http.DetectContentType(buffer)
-
-	return contentType, nil
-}
-
 func canonicalizeETag(etag string) string {
 	return etagRegex.ReplaceAllString(etag, "$1")
 }
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/api"
+	"mtcloud.com/mtstorage/pkg/auth"
+	"mtcloud.com/mtstorage/pkg/logger"
+	"mtcloud.com/mtstorage/pkg/policy"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+	"mtcloud.com/mtstorage/util"
+)
+
+// 预签名url的默认有效期
+const defaultPresignExpires = time.Hour
+
+// 预签名url中保留的请求参数
+var (
+	presignGetParams  = []string{"offset", "length", "storageclass", "crypto-key"}
+	presignPostParams = []string{"storageClass", "acl", auth.MinContentLength, auth.MaxContentLength}
+)
+
+type presignResponse struct {
+	URL     string    `json:"url"`
+	Method  string    `json:"method"`
+	Expires time.Time `json:"expires"`
+}
+
+// PresignHandler generates the url to download the object or to upload to the object without the credentials,
+// the url is signed with the access key of the bucket owner and bound to the bucket, object, method and expiry.
+// 管理秘钥可通过accessKey指定所有者的秘钥. 下载的url绑定对象当前的cid, 对象被覆盖后失效
+// /cs/v1/presign?bucket=xx&object=xx&method=GET|POST&expires=秒&accessKey=xx&maxContentLength=xx [post]
+func (h *chunkerAPIHandlers) PresignHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "PresignHandler")
+	defer span.End()
+
+	vars := r.URL.Query()
+	bucket, object := vars.Get("bucket"), vars.Get("object")
+	method := vars.Get("method")
+	if method == "" {
+		method = http.MethodGet
+	}
+	expires := defaultPresignExpires
+	if v := vars.Get("expires"); v != "" {
+		s, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.InvalidArgument{Err: err}), r.URL)
+			return
+		}
+		expires = time.Duration(s) * time.Second
+	}
+	if bucket == "" || object == "" || (method != http.MethodGet && method != http.MethodPost) {
+		err := errors.New("bucket or object empty, or method is not GET or POST")
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.InvalidArgument{Err: err}), r.URL)
+		return
+	}
+	if acl := vars.Get("acl"); acl != "" && !policy.IsValidACL(acl, true) {
+		err := fmt.Errorf("invalid acl %q", acl)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.InvalidArgument{Err: err}), r.URL)
+		return
+	}
+
+	cred, err := h.presignCredentials(r, bucket)
+	if err != nil {
+		logger.Warnf("presign %s/%s denied: %s", bucket, object, err)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	query := url.Values{"bucket": {bucket}, "object": {object}}
+	u := url.URL{Scheme: scheme, Host: r.Host}
+	params := presignPostParams
+	if method == http.MethodGet {
+		oi, err := h.backend.GetObjectInfo(ctx, bucket, strings.TrimPrefix(object, "/"))
+		if err == nil && oi.Cid == "" {
+			err = error2.ObjectNotFound{Bucket: bucket, Object: object}
+		}
+		if err != nil {
+			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+			return
+		}
+		u.Path = "/cs/" + chunkerPIVersion + "/object/" + oi.Cid
+		params = presignGetParams
+	} else {
+		u.Path = "/cs/" + chunkerPIVersion + "/postObject"
+	}
+	for _, k := range params {
+		if v := vars.Get(k); v != "" {
+			query.Set(k, v)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	signed, err := auth.PresignURL(method, u.String(), cred, api.AuthRegion(), expires)
+	if err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.InvalidArgument{Err: err}), r.URL)
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, presignResponse{
+		URL:     signed,
+		Method:  method,
+		Expires: time.Now().Add(expires).UTC(),
+	})
+}
+
+// presignCredentials returns the credentials to sign the url, which must belong to the bucket owner
+func (h *chunkerAPIHandlers) presignCredentials(r *http.Request, bucket string) (auth.Credentials, error) {
+	ctx := r.Context()
+	cred, ok := api.ReqCredentials(ctx)
+	if !ok {
+		// 未开启认证时没有秘钥
+		return cred, error2.InvalidArgument{Err: errors.New("authentication is not enabled")}
+	}
+	if ak := r.URL.Query().Get("accessKey"); ak != "" && ak != cred.AccessKey {
+		if !cred.Admin {
+			return cred, error2.AccessDenied{Bucket: bucket}
+		}
+		var err error
+		if cred, err = h.backend.GetCredentials(ctx, ak); err != nil {
+			if err == auth.ErrNoSuchAccessKey {
+				err = error2.AccessKeyNotFound{AccessKey: ak}
+			}
+			return cred, err
+		}
+	}
+	access, err := h.backend.GetBucketAccess(ctx, bucket)
+	if err != nil {
+		return cred, err
+	}
+	if cred.Owner == 0 || cred.Owner != access.Owner {
+		return cred, error2.AccessDenied{Bucket: bucket}
+	}
+	return cred, nil
+}
@@ -11,6 +11,7 @@ import (
 	"go.opencensus.io/trace"
 	"mtcloud.com/mtstorage/node/client"
 	node_util "mtcloud.com/mtstorage/node/util"
+	"mtcloud.com/mtstorage/pkg/compress"
 	"mtcloud.com/mtstorage/pkg/crypto"
 	"mtcloud.com/mtstorage/pkg/hash"
 	"mtcloud.com/mtstorage/pkg/logger"
@@ -50,8 +51,17 @@ func (h *chunkerAPIHandlers) PostObjectHandler(w http.ResponseWriter, r *http.Re
 		isDir      bool   // 是否是目录
 		dirName    string // 目录名称
 		hReader    io.Reader
+		codec      string           // 压缩算法
+		cReader    *compress.Reader // 压缩后的数据
 	)
 
+	// 存储空间不足时在读取数据前拒绝
+	if err = h.backend.Admit(r.ContentLength); err != nil {
+		logger.Errorf("reject upload %s/%s, size: %d: %s", bucket, object, r.ContentLength, err)
+		util.WriteJsonQuiet(w, http.StatusInsufficientStorage, err.Error())
+		return
+	}
+
 	hashReader, err := hash.NewReader(r.Body, r.ContentLength, encMd5Sum, "", r.ContentLength)
 	if err != nil {
 		logger.Error("json Marshal fail", err)
@@ -109,6 +119,21 @@ func (h *chunkerAPIHandlers) PostObjectHandler(w http.ResponseWriter, r *http.Re
 	//		return
 	//	}
 	//}
+
+	// 桶配置开启压缩时压缩后写入, 加密的对象和已压缩的内容类型跳过
+	if ck == "" && !isDir && r.ContentLength >= compress.MinSize && compress.Compressible(ct) {
+		codec = h.backend.BucketCompression(ctx, bucket)
+	}
+	if codec != compress.None {
+		cReader, err = compress.NewReader(hReader, codec, r.ContentLength)
+		if err != nil {
+			logger.Error("compress NewReader fail", err)
+			util.WriteJsonQuiet(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer cReader.Close()
+		hReader = cReader
+	}
 	cid, err = h.backend.WriteData(ctx, hReader)
 	logger.Infof("object: %s, cid: %s", object, cid)
 	if err != nil {
@@ -151,6 +176,12 @@ func (h *chunkerAPIHandlers) PostObjectHandler(w http.ResponseWriter, r *http.Re
 		}
 	}
 
+	var compressedSize int64
+	if cReader != nil {
+		compressedSize = cReader.Size()
+		logger.Infof("object: %s compressed by %s, size: %d, compressed: %d", object, codec, size, compressedSize)
+	}
+
 	objectName := path.Base(object)
 	if etags == "" {
 		etags = etagHash
@@ -170,6 +201,8 @@ func (h *chunkerAPIHandlers) PostObjectHandler(w http.ResponseWriter, r *http.Re
 		ACL:            acl,
 		ContentType:    ct,
 		ActualCid:      actualCid,
+		Compression:    codec,
+		CompressedSize: uint64(compressedSize),
 	}); err != nil {
 		logger.Error("Rewrite DB failed:", err)
 		util.WriteJsonQuiet(w, http.StatusInternalServerError, err.Error())
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/cmd/chunker/engine"
+	"mtcloud.com/mtstorage/pkg/logger"
+	"mtcloud.com/mtstorage/util"
+)
+
+// GetReplicas reports on which endpoints the cid is pinned
+func (h *chunkerAPIHandlers) GetReplicas(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "GetReplicas")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	cid := r.URL.Query().Get("cid")
+	if cid == "" {
+		util.WriteJsonQuiet(w, http.StatusBadRequest, "cid is empty")
+		return
+	}
+	report, err := h.backend.GetReplicas(ctx, cid)
+	if err != nil {
+		if err == engine.ErrReplicaNotSupported {
+			util.WriteJsonQuiet(w, http.StatusNotImplemented, err.Error())
+			return
+		}
+		logger.Errorf("get replicas of %s failed: %s", cid, err)
+		util.WriteJsonQuiet(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, report)
+}
+
+// RepairReplicas re-pins the cid onto healthy endpoints until it reaches the replication factor
+func (h *chunkerAPIHandlers) RepairReplicas(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "RepairReplicas")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	cid := r.URL.Query().Get("cid")
+	if cid == "" {
+		util.WriteJsonQuiet(w, http.StatusBadRequest, "cid is empty")
+		return
+	}
+	report, err := h.backend.RepairReplicas(ctx, cid)
+	if err != nil {
+		if err == engine.ErrReplicaNotSupported {
+			util.WriteJsonQuiet(w, http.StatusNotImplemented, err.Error())
+			return
+		}
+		logger.Errorf("repair replicas of %s failed: %s", cid, err)
+		// 部分修复时仍返回各节点的情况
+		util.WriteJsonQuiet(w, http.StatusInternalServerError, report)
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, report)
+}
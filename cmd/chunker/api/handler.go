@@ -4,7 +4,6 @@ import (
 	"compress/gzip"
 	"mtcloud.com/mtstorage/cmd/chunker/services"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -12,6 +11,7 @@ import (
 	"mtcloud.com/mtstorage/api"
 	xhttp "mtcloud.com/mtstorage/pkg/http"
 	"mtcloud.com/mtstorage/pkg/logger"
+	"mtcloud.com/mtstorage/pkg/policy"
 )
 
 const (
@@ -19,16 +19,7 @@ const (
 )
 
 type chunkerAPIHandlers struct {
-	appendFileMap   map[string]*fsAppendFile
-	appendFileMapMu sync.Mutex
-	backend         *services.Chunker
-}
-
-// Represents the background append file.
-type fsAppendFile struct {
-	sync.Mutex
-	parts    []PartInfo // List of parts appended.
-	filePath string     // Absolute path of the file in the temp location.
+	backend *services.Chunker
 }
 
 // PartInfo - represents individual part metadata.
@@ -60,8 +51,7 @@ func RegisterMetricsRouter(router *mux.Router) {
 
 func RegisterAPIRouter(router *mux.Router, ck *services.Chunker) {
 	chunkerAPI := chunkerAPIHandlers{
-		appendFileMap: make(map[string]*fsAppendFile),
-		backend:       ck,
+		backend: ck,
 	}
 
 	// API Router
@@ -76,40 +66,77 @@ func RegisterAPIRouter(router *mux.Router, ck *services.Chunker) {
 	//register router handler
 	// /cs/v1/object [post]
 	apiRouter.Methods(http.MethodPost).Path("/object").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(chunkerAPI.PutObjectHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutObjectAction, chunkerAPI.PutObjectHandler)))))
 	// /cs/v1/object? [post]
 	apiRouter.Methods(http.MethodPost).Path("/postObject").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(chunkerAPI.PostObjectHandler))))
-	// /cs/v1/object/xxxx [get]
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutObjectAction, chunkerAPI.PostObjectHandler)))))
+	// /cs/v1/appendObject?bucket=xx&object=xx&position=xx [post]
+	apiRouter.Methods(http.MethodPost).Path("/appendObject").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutObjectAction, chunkerAPI.AppendObjectHandler)))))
+	// /cs/v1/object/xxxx?bucket=xx&object=xx [get], 公开读的对象允许匿名下载, 权限由处理函数按cid所属的对象检查
+	api.AllowAnonymousRead("/cs/" + chunkerPIVersion + "/object/")
 	apiRouter.Methods(http.MethodGet).Path("/object/{cid:.+}").HandlerFunc(
 		maxClients(gz(api.HttpTraceAll(chunkerAPI.GetObjectHandler))))
 
-	// /cs/v1/object/xxxx [delete]
+	// /cs/v1/object/xxxx?bucket=xx&object=xx [delete]
 	apiRouter.Methods(http.MethodDelete).Path("/object/{cid:.+}").HandlerFunc(
 		maxClients(gz(api.HttpTraceAll(chunkerAPI.DelObjectHandler))))
 
 	// /cs/v1/newMultipart [post]
 	apiRouter.Methods(http.MethodPost).Path("/newMultipart").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(chunkerAPI.NewMultipart))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutObjectAction, chunkerAPI.NewMultipart)))))
 
 	// /cs/v1/putObjectPart [post]
 	apiRouter.Methods(http.MethodPost).Path("/putObjectPart").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(chunkerAPI.PutObjectPart))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutObjectAction, chunkerAPI.PutObjectPart)))))
 
 	// /cs/v1/completeMultipart [post]
 	apiRouter.Methods(http.MethodPost).Path("/completeMultipart").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(chunkerAPI.CompleteMultipart))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutObjectAction, chunkerAPI.CompleteMultipart)))))
 
 	// /cs/v1/abortMultipartUpload [post]
 	apiRouter.Methods(http.MethodPost).Path("/abortMultipartUpload").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(chunkerAPI.AbortMultipartUpload))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.AbortMultipartUploadAction, chunkerAPI.AbortMultipartUpload)))))
+	// /cs/v1/copyObject?bucket=xx&object=xx&srcBucket=xx&srcObject=xx [post]
+	apiRouter.Methods(http.MethodPost).Path("/copyObject").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutObjectAction, chunkerAPI.CopyObjectHandler)))))
+	// /cs/v1/copyObjectPart?uploadID=xx&partID=xx&srcBucket=xx&srcObject=xx&range=xx [post]
+	apiRouter.Methods(http.MethodPost).Path("/copyObjectPart").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutObjectAction, chunkerAPI.UploadPartCopy)))))
 	// /cs/v1/listObjectParts [get]
 	apiRouter.Methods(http.MethodGet).Path("/listObjectParts").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(chunkerAPI.ListObjectParts))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.ListMultipartUploadPartsAction, chunkerAPI.ListObjectParts)))))
+	// /cs/v1/presign?bucket=xx&object=xx&method=GET|POST&expires=xx [post]
+	apiRouter.Methods(http.MethodPost).Path("/presign").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(chunkerAPI.PresignHandler))))
 	// /cs/v1/getObjectDagTree [get]
 	apiRouter.Methods(http.MethodGet).Path("/getObjectDagTree").HandlerFunc(
 		maxClients(gz(api.HttpTraceAll(chunkerAPI.GetObjectDagTree))))
 	// /cs/v1/AddObjectCid [post]
 	apiRouter.Methods(http.MethodPost).Path("/addObjectCid").HandlerFunc(
 		maxClients(gz(api.HttpTraceAll(chunkerAPI.AddObjectCid))))
+	// /cs/v1/replicas?cid=xxx [get]
+	apiRouter.Methods(http.MethodGet).Path("/replicas").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(chunkerAPI.GetReplicas))))
+	// /cs/v1/replicas?cid=xxx [post]
+	apiRouter.Methods(http.MethodPost).Path("/replicas").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(chunkerAPI.RepairReplicas))))
+	// /cs/v1/admin/rebalance [get]
+	apiRouter.Methods(http.MethodGet).Path("/admin/rebalance").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(chunkerAPI.GetRebalance))))
+	// /cs/v1/admin/rebalance?action=start|pause&bandwidth=xx [post]
+	apiRouter.Methods(http.MethodPost).Path("/admin/rebalance").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(chunkerAPI.PostRebalance))))
+	// /cs/v1/admin/drain?endpoint=xxx [get]
+	apiRouter.Methods(http.MethodGet).Path("/admin/drain").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(chunkerAPI.GetDrain))))
+	// /cs/v1/admin/drain?endpoint=xxx&drain=true|false [post]
+	apiRouter.Methods(http.MethodPost).Path("/admin/drain").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(chunkerAPI.PostDrain))))
+	// /cs/v1/admin/gc [post]
+	apiRouter.Methods(http.MethodPost).Path("/admin/gc").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(chunkerAPI.PostRepoGC))))
+	// /cs/v1/admin/multipart [get]
+	apiRouter.Methods(http.MethodGet).Path("/admin/multipart").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(chunkerAPI.GetMultipartJanitor))))
 }
@@ -19,14 +19,23 @@ type ChunkerConfig struct {
 	Jaeger  config.JaegerConfig
 	Request config.RequestConfig
 	Profile config.ProfileConfig
+	Auth    config.AuthConfig
 
-	TempDir string
+	TempDir   string
+	Multipart MultipartConfig
+}
+
+// MultipartConfig 未完成的分片上传的自动清理
+type MultipartConfig struct {
+	Expiry   int //分片上传发起后超过该时间(小时)仍未完成时自动取消, 小于0为只按桶的生命周期规则清理
+	Interval int //清理检查的间隔(分钟)
 }
 
 type NodeConfig struct {
 	config.CommonNodeConfig
 	NameServer_group string
 	Api              string
+	RegionId         int64 //区域编号, 未配置时由区域名计算
 }
 
 func LoadChunkerConfig(serviceId string) (*ChunkerConfig, error) {
@@ -122,12 +131,36 @@ func (c *ChunkerConfig) checkConfig() error {
 		c.Storage.Replication = -1
 	}
 
+	if c.Storage.ReadTimeout == 0 {
+		c.Storage.ReadTimeout = 30
+	}
+
+	if c.Storage.WriteTimeout == 0 {
+		c.Storage.WriteTimeout = 30
+	}
+
+	if c.Storage.HighWater == 0 {
+		c.Storage.HighWater = 90
+	}
+
+	if c.Storage.StateDir == "" {
+		c.Storage.StateDir = "state"
+	}
+
 	//todo ,check writable
 	if len(c.TempDir) == 0 {
 		c.TempDir = "multiPart"
 
 	}
 
+	if c.Multipart.Expiry == 0 {
+		c.Multipart.Expiry = 7 * 24
+	}
+
+	if c.Multipart.Interval <= 0 {
+		c.Multipart.Interval = 60
+	}
+
 	return nil
 
 }
@@ -7,6 +7,7 @@ import (
 	shell "github.com/ipfs/go-ipfs-api"
 	"io"
 	"mtcloud.com/mtstorage/pkg/logger"
+	"strings"
 	"time"
 )
 
@@ -25,7 +26,7 @@ func (c *IpfsCluster) getClient() *shell.Shell {
 	//todo: get a random endpoint,map所有一定随机性
 	if length != 0 {
 		for host, config := range c.endpoints {
-			if !config.CanStore || !config.health {
+			if !config.CanStore || !config.healthy() {
 				continue
 			}
 			url := fmt.Sprintf("http://%s:%d", host, config.Http)
@@ -64,9 +65,9 @@ func (c *IpfsCluster) checkAlive() {
 		e := shell.NewShell(url)
 		if !e.IsUp() {
 			logger.Errorf("cluster节点连接异常:  %s", url)
-			config.health = false
+			config.setHealth(false)
 		} else {
-			config.health = true
+			config.setHealth(true)
 		}
 	}
 }
@@ -82,13 +83,20 @@ func (c *IpfsCluster) Write(ctx context.Context, file io.Reader) (string, error)
 
 }
 
-func (c *IpfsCluster) Read(ctx context.Context, cid string) (io.Reader, error) {
+func (c *IpfsCluster) Read(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
 	cli := c.getClient()
 	if cli == nil {
 		return nil, errors.New("no endpoint found")
 	}
 
-	resp, err := cli.Request("cat", cid).Send(ctx)
+	req := cli.Request("cat", cid)
+	if offset > 0 {
+		req.Option("offset", offset)
+	}
+	if length >= 0 {
+		req.Option("length", length)
+	}
+	resp, err := req.Send(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -98,22 +106,36 @@ func (c *IpfsCluster) Read(ctx context.Context, cid string) (io.Reader, error) {
 	return resp.Output, nil
 }
 
-func (c *IpfsCluster) Delete(ctx context.Context, cid string) error {
+// Delete cluster会在所有副本上取消pin
+func (c *IpfsCluster) Delete(ctx context.Context, cid string, gc bool) ([]DeleteResult, error) {
 	cli := c.getClient()
 	if cli == nil {
-		return errors.New("no endpoint found")
+		return nil, errors.New("no endpoint found")
 	}
 
+	res := DeleteResult{Endpoint: STORAGE_CLUSTER}
 	resp, err := cli.Request("pin/rm", cid).
 		Option("recursive", true).
 		Send(ctx)
+	if err == nil {
+		if resp.Error != nil {
+			err = resp.Error
+		}
+		resp.Close()
+	}
 	if err != nil {
-		return err
+		if strings.Contains(err.Error(), ErrNotPinned.Error()) {
+			return []DeleteResult{res}, ErrNotPinned
+		}
+		res.Error = err.Error()
+		return []DeleteResult{res}, err
 	}
-	if resp.Error != nil {
-		return resp.Error
+	res.Deleted = true
+	if gc {
+		go repoGC(cli, STORAGE_CLUSTER)
+		res.GC = true
 	}
-	return nil
+	return []DeleteResult{res}, nil
 }
 
 func (c *IpfsCluster) Stat(ctx context.Context, cid string) error {
@@ -164,6 +186,21 @@ func (c *IpfsCluster) DagSize(ctx context.Context, cid string) (string, error) {
 	return fmt.Sprintf("%d", ObjectStats.CumulativeSize), nil
 }
 
+func (c *IpfsCluster) FileSize(ctx context.Context, cid string) (int64, error) {
+	cli := c.getClient()
+	if cli == nil {
+		return 0, errors.New("no endpoint found")
+	}
+	var raw = struct {
+		Size int64
+	}{}
+	if err := cli.Request("files/stat", "/ipfs/"+cid).Exec(ctx, &raw); err != nil {
+		logger.Error("get file size fail: ", err)
+		return 0, err
+	}
+	return raw.Size, nil
+}
+
 // Pin the given path
 func (c *IpfsCluster) Pin(path string) error {
 	cli := c.getClient()
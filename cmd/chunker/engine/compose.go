@@ -0,0 +1,253 @@
+package engine
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	cid2 "github.com/ipfs/go-cid"
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/pkg/logger"
+)
+
+// ErrComposeNotSupported 存储类型不支持直接合并, 由Engine读取后重新写入
+var ErrComposeNotSupported = errors.New("compose not supported by storage provider")
+
+type composer interface {
+	Compose(ctx context.Context, cids []string) (string, error)
+}
+
+// Compose joins the files of cids into one file in order and returns its cid.
+// providers which can not link the existing data read the files and write them again.
+func (e *Engine) Compose(ctx context.Context, cids []string) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "Compose")
+	defer span.End()
+
+	if len(cids) == 0 {
+		return "", errors.New("nothing to compose")
+	}
+	if c, ok := e.provider.(composer); ok {
+		id, err := c.Compose(ctx, cids)
+		if err != ErrComposeNotSupported {
+			return id, err
+		}
+	}
+	rd := &concatReader{ctx: ctx, storage: e.provider, cids: cids}
+	defer rd.Close()
+	return e.provider.Write(ctx, rd)
+}
+
+// concatReader reads the files of cids one after another, each file is opened when it is reached
+type concatReader struct {
+	ctx     context.Context
+	storage Storage
+	cids    []string
+	cur     io.ReadCloser
+}
+
+func (r *concatReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.cids) == 0 {
+				return 0, io.EOF
+			}
+			rd, err := r.storage.Read(r.ctx, r.cids[0], 0, -1)
+			if err != nil {
+				return 0, err
+			}
+			r.cur, r.cids = rd, r.cids[1:]
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Close closes the file being read when the write stops early
+func (r *concatReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	err := r.cur.Close()
+	r.cur = nil
+	return err
+}
+
+// composeLink 合并后文件节点的一个子节点
+type composeLink struct {
+	cid   cid2.Cid
+	size  uint64 //文件内容大小
+	tsize uint64 //子树的总大小
+}
+
+// Compose links the files of cids under a new UnixFS file node, no data is copied.
+// 新节点在选出的节点上写入并递归pin, 缺少的分块由节点从持有分片的节点拉取
+func (c *Ipfs) Compose(ctx context.Context, cids []string) (string, error) {
+	if c.erasure.enabled() {
+		// 清单按条带记录分片, 不能直接拼接
+		return "", ErrComposeNotSupported
+	}
+	ctx, span := trace.StartSpan(ctx, "composeOfIPFS")
+	defer span.End()
+
+	links := make([]composeLink, len(cids))
+	for i, id := range cids {
+		l, err := c.statLink(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		links[i] = l
+	}
+	node := encodeFileNode(links)
+
+	hosts, err := c.allocate()
+	if err != nil {
+		logger.Error(err)
+		return "", err
+	}
+	want := c.replication
+	if want == -1 {
+		want = len(hosts)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	votes := make(map[string][]string)
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			id, err := c.putFileNode(ctx, host, node)
+			if err != nil {
+				logger.Errorf("ipfs节点 %s 合并文件失败: %s", host, err)
+				return
+			}
+			mu.Lock()
+			votes[id] = append(votes[id], host)
+			mu.Unlock()
+		}(host)
+	}
+	wg.Wait()
+
+	var cid string
+	var holders []string
+	for id, hs := range votes {
+		if len(hs) > len(holders) {
+			cid, holders = id, hs
+		}
+	}
+	if len(votes) > 1 {
+		logger.Errorf("cid不一致出现错误！%v", votes)
+	}
+	span.AddAttributes(trace.Int64Attribute("replicas", int64(len(holders))))
+	quorum := c.quorum(want)
+	if len(holders) < quorum {
+		return "", fmt.Errorf("write quorum not reached, need: %d, have: %d", quorum, len(holders))
+	}
+	if len(holders) < want {
+		c.repairs.add(cid, holders, want, fmt.Sprintf("compose succeeded on %d of %d replicas", len(holders), want))
+	}
+	return cid, nil
+}
+
+// statLink returns the content size and the cumulative size of the file
+func (c *Ipfs) statLink(ctx context.Context, id string) (composeLink, error) {
+	l := composeLink{}
+	parsed, err := cid2.Decode(id)
+	if err != nil {
+		return l, err
+	}
+	l.cid = parsed
+	cli := c.getClient(id)
+	if cli == nil {
+		return l, errors.New("no endpoint found")
+	}
+	var stat = struct {
+		Type           string
+		Size           uint64
+		CumulativeSize uint64
+	}{}
+	sctx, cancel := context.WithTimeout(ctx, c.readTimeout)
+	defer cancel()
+	if err = cli.Request("files/stat", "/ipfs/"+id).Exec(sctx, &stat); err != nil {
+		logger.Errorf("get stat of %s failed: %s", id, err)
+		return l, err
+	}
+	if stat.Type != "file" {
+		return l, fmt.Errorf("%s is not a file", id)
+	}
+	l.size, l.tsize = stat.Size, stat.CumulativeSize
+	return l, nil
+}
+
+// putFileNode writes the node on host and pins it recursively
+func (c *Ipfs) putFileNode(ctx context.Context, host string, node []byte) (string, error) {
+	cli := c.endpointClient(host)
+	if cli == nil {
+		return "", errors.New("endpoint unavailable")
+	}
+	id, err := cli.BlockPut(node, "v0", "sha2-256", -1)
+	if err != nil {
+		return "", err
+	}
+	pctx, cancel := context.WithTimeout(ctx, repairTimeout)
+	defer cancel()
+	if err = cli.Request("pin/add", id).Option("recursive", true).Exec(pctx, nil); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// encodeFileNode encodes a dag-pb node holding a UnixFS file made of links, in the layout written by ipfs add:
+//
+//	PBNode { Links: [PBLink { Hash, Name: "", Tsize }], Data: UnixFS { Type: File, filesize, blocksizes } }
+//
+// dag-pb要求Links在Data之前编码
+func encodeFileNode(links []composeLink) []byte {
+	var data []byte
+	var filesize uint64
+	data = appendVarintField(data, 1, 2) // Type: File
+	for _, l := range links {
+		filesize += l.size
+	}
+	data = appendVarintField(data, 3, filesize)
+	for _, l := range links {
+		data = appendVarintField(data, 4, l.size)
+	}
+
+	var node []byte
+	for _, l := range links {
+		var link []byte
+		link = appendBytesField(link, 1, l.cid.Bytes())
+		link = appendBytesField(link, 2, nil)
+		link = appendVarintField(link, 3, l.tsize)
+		node = appendBytesField(node, 2, link)
+	}
+	return appendBytesField(node, 1, data)
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// appendVarintField appends a protobuf varint field
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	return appendVarint(appendVarint(b, uint64(field)<<3), v)
+}
+
+// appendBytesField appends a protobuf length-delimited field
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = appendVarint(appendVarint(b, uint64(field)<<3|2), uint64(len(v)))
+	return append(b, v...)
+}
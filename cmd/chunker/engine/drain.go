@@ -0,0 +1,226 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"mtcloud.com/mtstorage/pkg/logger"
+)
+
+// 下线任务一轮结束后仍有副本不足的cid时, 等待后重试
+const drainRetryInterval = time.Minute
+
+var (
+	// ErrDrainNotSupported 存储类型不支持节点下线
+	ErrDrainNotSupported = errors.New("drain not supported by storage provider")
+	// ErrEndpointNotFound 节点不存在或不是存储节点
+	ErrEndpointNotFound = errors.New("endpoint not found")
+)
+
+// DrainStatus 节点下线的进度
+type DrainStatus struct {
+	Endpoint   string     `json:"endpoint"`
+	Draining   bool       `json:"draining"`
+	Running    bool       `json:"running"`
+	Pins       int        `json:"pins"`      //节点上的cid数
+	Checked    int        `json:"checked"`   //本轮已检查的cid数
+	Copied     int        `json:"copied"`    //已复制到其它节点的cid数
+	Remaining  int        `json:"remaining"` //其它节点上副本仍不足的cid数
+	Safe       bool       `json:"safe"`      //所有cid在其它节点上都有足够的副本, 可以移除节点
+	LastError  string     `json:"lastError,omitempty"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+type drainer interface {
+	Drain(host string, drain bool) (DrainStatus, error)
+	DrainStatus(host string) ([]DrainStatus, error)
+	SyncDrain(states map[string]bool)
+}
+
+type drainJob struct {
+	mu     sync.Mutex
+	status DrainStatus
+	cancel context.CancelFunc
+}
+
+func (j *drainJob) update(fn func(s *DrainStatus)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.status)
+}
+
+func (j *drainJob) get() DrainStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// Drain sets the drain state of host, a draining endpoint gets no new data and
+// the cids it holds are copied to other endpoints until it is safe to remove.
+// 这里只修改本chunker的状态, 由调用方保存到nameserver后通过SyncDrain同步到其它chunker
+func (c *Ipfs) Drain(host string, drain bool) (DrainStatus, error) {
+	config, ok := c.endpoints[host]
+	if !ok || !config.CanStore {
+		return DrainStatus{}, ErrEndpointNotFound
+	}
+	if drain && c.erasure.enabled() {
+		// 分片所在节点记录在清单中, 不能迁移
+		return DrainStatus{}, ErrDrainNotSupported
+	}
+
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	config.setDrain(drain)
+	job, ok := c.drains[host]
+	if !drain {
+		if ok {
+			job.cancel()
+			delete(c.drains, host)
+			logger.Infof("ipfs节点 %s 已取消下线", host)
+		}
+		return DrainStatus{Endpoint: host}, nil
+	}
+	if !ok {
+		job = c.startDrain(host)
+	}
+	return job.get(), nil
+}
+
+// SyncDrain applies the drain states saved in the nameserver, endpoints without a saved state keep the configured one.
+// 每个chunker都会运行下线任务, 已补齐的cid再次检查时不会重复复制
+func (c *Ipfs) SyncDrain(states map[string]bool) {
+	for host, config := range c.endpoints {
+		if !config.CanStore {
+			continue
+		}
+		want, ok := states[host]
+		if !ok {
+			want = config.drainConfigured
+		}
+		if config.draining() == want || (want && c.erasure.enabled()) {
+			continue
+		}
+		if _, err := c.Drain(host, want); err != nil {
+			logger.Errorf("ipfs节点 %s 同步下线状态失败: %s", host, err)
+		}
+	}
+}
+
+// startDrain must be called with drainMu held
+func (c *Ipfs) startDrain(host string) *drainJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	job := &drainJob{
+		status: DrainStatus{Endpoint: host, Draining: true, Running: true, StartedAt: &now},
+		cancel: cancel,
+	}
+	c.drains[host] = job
+	logger.Infof("ipfs节点 %s 开始下线", host)
+	go c.drain(ctx, host, job)
+	return job
+}
+
+// DrainStatus returns the drain progress of host, or of all draining endpoints if host is empty
+func (c *Ipfs) DrainStatus(host string) ([]DrainStatus, error) {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	if host != "" {
+		config, ok := c.endpoints[host]
+		if !ok || !config.CanStore {
+			return nil, ErrEndpointNotFound
+		}
+		if job, ok := c.drains[host]; ok {
+			return []DrainStatus{job.get()}, nil
+		}
+		return []DrainStatus{{Endpoint: host}}, nil
+	}
+	list := make([]DrainStatus, 0, len(c.drains))
+	for _, job := range c.drains {
+		list = append(list, job.get())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Endpoint < list[j].Endpoint })
+	return list, nil
+}
+
+func (c *Ipfs) drain(ctx context.Context, host string, job *drainJob) {
+	for {
+		err := c.drainPass(ctx, host, job)
+		if ctx.Err() != nil {
+			return
+		}
+		safe := false
+		job.update(func(s *DrainStatus) {
+			now := time.Now()
+			s.FinishedAt = &now
+			s.LastError = ""
+			if err != nil {
+				s.LastError = err.Error()
+			}
+			s.Safe = err == nil && s.Remaining == 0
+			s.Running = !s.Safe
+			safe = s.Safe
+		})
+		if safe {
+			logger.Infof("ipfs节点 %s 上的数据已全部复制到其它节点, 可以移除", host)
+			return
+		}
+		if err != nil {
+			logger.Errorf("ipfs节点 %s 下线失败, 稍后重试: %s", host, err)
+		}
+		select {
+		case <-time.After(drainRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drainPass copies every cid pinned on host to other endpoints until it reaches the replication factor there
+func (c *Ipfs) drainPass(ctx context.Context, host string, job *drainJob) error {
+	cli := c.endpointClient(host)
+	if cli == nil {
+		return errors.New("endpoint unavailable")
+	}
+	var out struct {
+		Keys map[string]interface{}
+	}
+	lctx, cancel := context.WithTimeout(ctx, c.readTimeout)
+	err := cli.Request("pin/ls").Option("type", "recursive").Exec(lctx, &out)
+	cancel()
+	if err != nil {
+		return err
+	}
+	cids := make([]string, 0, len(out.Keys))
+	for id := range out.Keys {
+		cids = append(cids, id)
+	}
+	sort.Strings(cids)
+	job.update(func(s *DrainStatus) {
+		s.Pins, s.Checked, s.Remaining = len(cids), 0, 0
+	})
+
+	for _, id := range cids {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Replicate 不计下线中节点上的副本, 从其它节点补齐
+		report, err := c.Replicate(ctx, id)
+		job.update(func(s *DrainStatus) {
+			s.Checked++
+			if len(report.Added) > 0 {
+				s.Copied++
+			}
+			if err != nil {
+				s.Remaining++
+			}
+		})
+		if err != nil {
+			logger.Errorf("ipfs节点 %s 下线, 复制 %s 失败: %s", host, id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestSyncDrain(t *testing.T) {
+	var nodes []*fakeIpfs
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+		nodes = append(nodes, newFakeIpfs(t, ip))
+	}
+	c := newTestIpfs(t, StorageConfig{Replication: 2}, nodes...)
+	c.endpoints["127.0.0.3"].drainConfigured = true
+
+	// nameserver中保存的状态优先, 没有保存的使用配置
+	c.SyncDrain(map[string]bool{"127.0.0.2": true})
+	for host, want := range map[string]bool{"127.0.0.1": false, "127.0.0.2": true, "127.0.0.3": true} {
+		if c.endpoints[host].draining() != want {
+			t.Fatalf("%s drain: want %v", host, want)
+		}
+	}
+	if list, _ := c.DrainStatus(""); len(list) != 2 {
+		t.Fatalf("want 2 drain jobs, got %+v", list)
+	}
+
+	c.SyncDrain(map[string]bool{"127.0.0.3": false})
+	for host := range c.endpoints {
+		if c.endpoints[host].draining() {
+			t.Fatalf("%s still draining", host)
+		}
+	}
+	if list, _ := c.DrainStatus(""); len(list) != 0 {
+		t.Fatalf("drain jobs not cancelled: %+v", list)
+	}
+}
@@ -15,6 +15,8 @@ type Engine struct {
 const (
 	STORAGE_IPFS    = "ipfs"
 	STORAGE_CLUSTER = "ipfscluster"
+	STORAGE_LOCAL   = "local"
+	STORAGE_S3      = "s3"
 )
 
 func NewEngine(config StorageConfig) (*Engine, error) {
@@ -28,6 +30,10 @@ func NewEngine(config StorageConfig) (*Engine, error) {
 		engine.provider = newIpfs(engine.config)
 	case STORAGE_CLUSTER:
 		engine.provider = newIpfsCluster(engine.config.Targets[STORAGE_CLUSTER])
+	case STORAGE_LOCAL:
+		engine.provider = newLocalFs(engine.config)
+	case STORAGE_S3:
+		engine.provider = newS3(engine.config)
 	}
 	return engine, nil
 }
@@ -37,6 +43,12 @@ func (e *Engine) checkConfig() error {
 		err := errors.New("storage target endpoint not set")
 		return err
 	}
+	if e.config.Erasure.Data < 0 || e.config.Erasure.Parity < 0 {
+		return errors.New("erasure data and parity must not be negative")
+	}
+	if e.config.WriteQuorum < 0 || (e.config.Replication > 0 && e.config.WriteQuorum > e.config.Replication) {
+		return errors.New("write quorum must be between 0 and replication")
+	}
 	return nil
 }
 
@@ -44,12 +56,12 @@ func (e *Engine) Write(ctx context.Context, file io.Reader) (string, error) {
 	return e.provider.Write(ctx, file)
 }
 
-func (e *Engine) Read(ctx context.Context, cid string) (io.Reader, error) {
-	return e.provider.Read(ctx, cid)
+func (e *Engine) Read(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+	return e.provider.Read(ctx, cid, offset, length)
 }
 
-func (e *Engine) Delete(ctx context.Context, cid string) error {
-	return nil
+func (e *Engine) Delete(ctx context.Context, cid string, gc bool) ([]DeleteResult, error) {
+	return e.provider.Delete(ctx, cid, gc)
 }
 func (e *Engine) Stat(ctx context.Context, cid string) error {
 	return e.provider.Stat(ctx, cid)
@@ -64,6 +76,10 @@ func (e *Engine) DagSize(ctx context.Context, cid string) (string, error) {
 
 }
 
+func (e *Engine) FileSize(ctx context.Context, cid string) (int64, error) {
+	return e.provider.FileSize(ctx, cid)
+}
+
 // Pin the given path
 func (e *Engine) Pin(path string) error {
 	return e.provider.Pin(path)
@@ -82,6 +98,113 @@ func (e *Engine) RepoStat() (RepoStat, error) {
 	return e.provider.RepoStat()
 }
 
+// Admit rejects the upload early if no endpoint can take size bytes, size < 0 means unknown
+func (e *Engine) Admit(size int64) error {
+	if a, ok := e.provider.(interface{ Admit(size int64) error }); ok {
+		return a.Admit(size)
+	}
+	return nil
+}
+
+// RepoGC triggers the garbage collection of the endpoints, providers which delete the data directly do nothing
+func (e *Engine) RepoGC() []DeleteResult {
+	if g, ok := e.provider.(interface{ RepoGC() []DeleteResult }); ok {
+		return g.RepoGC()
+	}
+	return nil
+}
+
+// EndpointStats returns the capacity of each storage endpoint,
+// providers without endpoints report their RepoStat as a single one with empty Endpoint.
+func (e *Engine) EndpointStats() []EndpointStat {
+	if s, ok := e.provider.(interface{ EndpointStats() []EndpointStat }); ok {
+		return s.EndpointStats()
+	}
+	stat, err := e.provider.RepoStat()
+	if err != nil {
+		return []EndpointStat{{Error: err.Error()}}
+	}
+	return []EndpointStat{{Healthy: true, RepoStat: stat}}
+}
+
+// UnderReplicated returns the cids waiting for repair, nil if the provider has no repair queue
+func (e *Engine) UnderReplicated() []RepairTask {
+	if r, ok := e.provider.(interface{ UnderReplicated() []RepairTask }); ok {
+		return r.UnderReplicated()
+	}
+	return nil
+}
+
+// SetRefCounter sets how the repair checks whether the cid is still referenced, the providers without repair ignore it
+func (e *Engine) SetRefCounter(refs RefCounter) {
+	if r, ok := e.provider.(interface{ SetRefCounter(RefCounter) }); ok {
+		r.SetRefCounter(refs)
+	}
+}
+
+// Replicas reports the replicas of cid on each endpoint
+func (e *Engine) Replicas(ctx context.Context, cid string) (ReplicaReport, error) {
+	if r, ok := e.provider.(replicator); ok {
+		return r.Replicas(ctx, cid)
+	}
+	return ReplicaReport{}, ErrReplicaNotSupported
+}
+
+// Replicate re-pins cid onto healthy endpoints until it reaches the replication factor
+func (e *Engine) Replicate(ctx context.Context, cid string) (ReplicaReport, error) {
+	if r, ok := e.provider.(replicator); ok {
+		return r.Replicate(ctx, cid)
+	}
+	return ReplicaReport{}, ErrReplicaNotSupported
+}
+
+// StartRebalance starts or resumes moving pins from the fullest endpoints to the emptiest ones
+func (e *Engine) StartRebalance(bandwidth int, threshold float64) (RebalanceStatus, error) {
+	if r, ok := e.provider.(rebalancing); ok {
+		return r.StartRebalance(bandwidth, threshold)
+	}
+	return RebalanceStatus{}, ErrRebalanceNotSupported
+}
+
+// PauseRebalance pauses the rebalance job
+func (e *Engine) PauseRebalance() (RebalanceStatus, error) {
+	if r, ok := e.provider.(rebalancing); ok {
+		return r.PauseRebalance(), nil
+	}
+	return RebalanceStatus{}, ErrRebalanceNotSupported
+}
+
+// RebalanceStatus returns the progress of the rebalance job
+func (e *Engine) RebalanceStatus() (RebalanceStatus, error) {
+	if r, ok := e.provider.(rebalancing); ok {
+		return r.RebalanceStatus(), nil
+	}
+	return RebalanceStatus{}, ErrRebalanceNotSupported
+}
+
+// Drain sets the drain state of the endpoint
+func (e *Engine) Drain(host string, drain bool) (DrainStatus, error) {
+	if d, ok := e.provider.(drainer); ok {
+		return d.Drain(host, drain)
+	}
+	return DrainStatus{}, ErrDrainNotSupported
+}
+
+// SyncDrain applies the drain states saved in the nameserver
+func (e *Engine) SyncDrain(states map[string]bool) {
+	if d, ok := e.provider.(drainer); ok {
+		d.SyncDrain(states)
+	}
+}
+
+// DrainStatus returns the drain progress of the endpoint, or of all draining endpoints if host is empty
+func (e *Engine) DrainStatus(host string) ([]DrainStatus, error) {
+	if d, ok := e.provider.(drainer); ok {
+		return d.DrainStatus(host)
+	}
+	return nil, ErrDrainNotSupported
+}
+
 func (e *Engine) Start() error {
 	return e.provider.Start()
 }
@@ -0,0 +1,544 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	cid2 "github.com/ipfs/go-cid"
+	shell "github.com/ipfs/go-ipfs-api"
+	"github.com/ipfs/go-ipfs-api/options"
+	"github.com/klauspost/reedsolomon"
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/pkg/logger"
+)
+
+// 条带中每个分片的大小
+const erasureBlockSize = 1 << 20
+
+// erasureManifestType 清单的标记, 用于区分其它dag-cbor数据
+const erasureManifestType = "mtstorage/erasure"
+
+// errNotManifest dag-cbor数据不是纠删码清单
+var errNotManifest = errors.New("not an erasure manifest")
+
+// erasureManifest 纠删码对象的分片布局, 以dag-cbor保存, 其cid即对象的cid.
+// 版本1的清单没有Type标记, 按字段校验
+type erasureManifest struct {
+	Type      string `json:",omitempty"`
+	Version   int
+	Data      int
+	Parity    int
+	BlockSize int64
+	Size      int64
+	Shards    []erasureShard
+}
+
+type erasureShard struct {
+	Cid      string
+	Endpoint string
+}
+
+func (e ErasureConfig) enabled() bool {
+	return e.Data > 0 && e.Parity > 0
+}
+
+// isCbor 普通对象为dag-pb/raw, 纠删码清单是dag-cbor
+func isCbor(cid string) bool {
+	id, err := cid2.Decode(strings.TrimPrefix(cid, "/ipfs/"))
+	return err == nil && id.Type() == cid2.DagCBOR
+}
+
+// lookupManifest returns the erasure manifest of cid, nil if cid is not an erasure coded object
+func (c *Ipfs) lookupManifest(ctx context.Context, cid string) (*erasureManifest, error) {
+	if !isCbor(cid) {
+		return nil, nil
+	}
+	man, err := c.readManifest(ctx, cid)
+	if err == errNotManifest {
+		return nil, nil
+	}
+	return man, err
+}
+
+// endpointClient returns the client of host, nil if it is unavailable
+func (c *Ipfs) endpointClient(host string) *shell.Shell {
+	config, ok := c.endpoints[host]
+	if !ok || !config.healthy() {
+		return nil
+	}
+	return shell.NewShell(fmt.Sprintf("http://%s:%d", host, config.Http))
+}
+
+// storeHosts returns all healthy endpoints which can store
+func (c *Ipfs) storeHosts() []string {
+	var hosts []string
+	for host, config := range c.endpoints {
+		if config.CanStore && config.healthy() {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// writeHosts returns the store endpoints which accept new data, draining and nearly full ones are excluded
+func (c *Ipfs) writeHosts() []string {
+	var hosts []string
+	for _, host := range c.storeHosts() {
+		if c.endpoints[host].draining() {
+			continue
+		}
+		// 超过高水位的节点
+		if free, ok := c.room(host); ok && free == 0 {
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+func (c *Ipfs) erasureWrite(ctx context.Context, file io.Reader) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "writeDataToIPFSErasure")
+	defer span.End()
+
+	k, m := c.erasure.Data, c.erasure.Parity
+	hosts := c.selector.Select(c.writeHosts(), k+m, "")
+	if len(hosts) < k+m {
+		err := fmt.Errorf("not enough node to allocate, need: %d, have: %d", k+m, len(hosts))
+		logger.Error(err)
+		return "", err
+	}
+	enc, err := reedsolomon.New(k, m)
+	if err != nil {
+		return "", err
+	}
+
+	// 每个分片一个写入管道, 分别写入不同的节点. 同时在本地计算分片的cid, 写入失败的分片可由修复任务重建出相同的cid
+	man := erasureManifest{Type: erasureManifestType, Version: 2, Data: k, Parity: m, BlockSize: erasureBlockSize, Shards: make([]erasureShard, k+m)}
+	pws := make([]*io.PipeWriter, k+m)
+	hashers := make([]*unixfsHasher, k+m)
+	errs := make([]error, k+m)
+	var wg sync.WaitGroup
+	for i := 0; i < k+m; i++ {
+		pr, pw := io.Pipe()
+		pws[i] = pw
+		hashers[i] = newUnixfsHasher()
+		man.Shards[i].Endpoint = hosts[i]
+		wg.Add(1)
+		go func(index int, pr *io.PipeReader) {
+			defer wg.Done()
+			cli := c.endpointClient(man.Shards[index].Endpoint)
+			if cli == nil {
+				errs[index] = errors.New("no endpoint found")
+				pr.CloseWithError(errs[index])
+				return
+			}
+			cid, err := cli.Add(pr, shell.Pin(true))
+			if err != nil {
+				logger.Errorf("ipfs节点 %s 分片上传失败: %s", man.Shards[index].Endpoint, err)
+				pr.CloseWithError(err)
+			}
+			man.Shards[index].Cid, errs[index] = cid, err
+		}(i, pr)
+	}
+	closeAll := func(err error) {
+		for _, pw := range pws {
+			pw.CloseWithError(err)
+		}
+		wg.Wait()
+	}
+
+	// 最多允许Parity个分片写入失败
+	failed := make([]bool, k+m)
+	lost := 0
+	stripe := make([]byte, k*erasureBlockSize, (k+m)*erasureBlockSize)
+	for {
+		n, rerr := io.ReadFull(file, stripe)
+		if n > 0 {
+			man.Size += int64(n)
+			shards, err := enc.Split(stripe[:n])
+			if err == nil {
+				err = enc.Encode(shards)
+			}
+			if err != nil {
+				closeAll(err)
+				return "", err
+			}
+			for i := range shards {
+				hashers[i].Write(shards[i])
+				if failed[i] {
+					continue
+				}
+				if _, err := pws[i].Write(shards[i]); err != nil {
+					failed[i] = true
+					lost++
+				}
+			}
+			if lost > m {
+				err = fmt.Errorf("too many shards failed, parity: %d, failed: %d", m, lost)
+				closeAll(err)
+				return "", err
+			}
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			closeAll(rerr)
+			return "", rerr
+		}
+	}
+	closeAll(nil)
+	var missing []int
+	for i, err := range errs {
+		want := hashers[i].Cid().String()
+		if err != nil {
+			man.Shards[i].Cid = want
+			missing = append(missing, i)
+			continue
+		}
+		if man.Shards[i].Cid != want {
+			logger.Errorf("ipfs节点 %s 分片cid与本地计算的不一致: %s, %s", man.Shards[i].Endpoint, man.Shards[i].Cid, want)
+		}
+	}
+	if len(missing) > m {
+		err := fmt.Errorf("too many shards failed, parity: %d, failed: %d", m, len(missing))
+		logger.Error(err)
+		return "", err
+	}
+
+	// 清单保存到所有可用节点, 任意节点都能读取
+	data, err := json.Marshal(man)
+	if err != nil {
+		return "", err
+	}
+	var cid string
+	for _, host := range c.writeHosts() {
+		cli := c.endpointClient(host)
+		if cli == nil {
+			continue
+		}
+		id, err := cli.DagPutWithOpts(data, options.Dag.Pin("true"))
+		if err != nil {
+			logger.Errorf("ipfs节点 %s 清单上传失败: %s", host, err)
+			continue
+		}
+		if cid != "" && cid != id {
+			return "", errors.New("cid不一致出现错误！")
+		}
+		cid = id
+	}
+	if cid == "" {
+		return "", errors.New("上传失败！")
+	}
+	if len(missing) > 0 {
+		c.repairs.addShards(cid, missing, fmt.Sprintf("%d of %d shards failed to write", len(missing), k+m))
+	}
+	return cid, nil
+}
+
+func (c *Ipfs) readManifest(ctx context.Context, cid string) (*erasureManifest, error) {
+	cid = strings.TrimPrefix(cid, "/ipfs/")
+	var err = errors.New("no endpoint found")
+	for _, host := range c.storeHosts() {
+		var man erasureManifest
+		if err = c.endpointClient(host).Request("dag/get", cid).Exec(ctx, &man); err != nil {
+			continue
+		}
+		if man.Type != erasureManifestType && (man.Type != "" || man.Version != 1) {
+			return nil, errNotManifest
+		}
+		if man.Data <= 0 || man.Parity <= 0 || len(man.Shards) != man.Data+man.Parity || man.BlockSize <= 0 {
+			if man.Type == "" {
+				return nil, errNotManifest
+			}
+			return nil, fmt.Errorf("invalid erasure manifest %s", cid)
+		}
+		return &man, nil
+	}
+	return nil, err
+}
+
+// erasureRead 按条带读取分片, 最多缺失Parity个分片时重建数据
+func (c *Ipfs) erasureRead(ctx context.Context, man *erasureManifest, cid string, offset, length int64) (io.ReadCloser, error) {
+	if offset >= man.Size || length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	if length < 0 || offset+length > man.Size {
+		length = man.Size - offset
+	}
+	enc, err := reedsolomon.New(man.Data, man.Parity)
+	if err != nil {
+		return nil, err
+	}
+
+	k := man.Data
+	stripeSize := man.BlockSize * int64(k)
+	stripe := offset / stripeSize
+	readers := make([]io.ReadCloser, len(man.Shards))
+	tried := make([]bool, len(man.Shards))
+	// 等待重建的分片不在节点上, 读取会阻塞在节点的网络查找
+	for _, i := range c.repairs.missingShards(cid) {
+		tried[i] = true
+	}
+	// 优先打开数据分片, 不可用时使用校验分片
+	open := func() int {
+		live := 0
+		for i := range readers {
+			if readers[i] != nil {
+				live++
+			}
+		}
+		for i, s := range man.Shards {
+			if live >= k {
+				break
+			}
+			if readers[i] != nil || tried[i] {
+				continue
+			}
+			tried[i] = true
+			cli := c.endpointClient(s.Endpoint)
+			if cli == nil {
+				continue
+			}
+			resp, err := cli.Request("cat", s.Cid).Option("offset", stripe*man.BlockSize).Send(ctx)
+			if err == nil && resp.Error != nil {
+				err = resp.Error
+			}
+			if err != nil {
+				logger.Errorf("ipfs节点 %s 分片读取失败: %s", s.Endpoint, err)
+				continue
+			}
+			readers[i] = resp.Output
+			live++
+		}
+		return live
+	}
+	closeAll := func() {
+		for i, r := range readers {
+			if r != nil {
+				r.Close()
+				readers[i] = nil
+			}
+		}
+	}
+	if open() < k {
+		closeAll()
+		return nil, fmt.Errorf("not enough shards to rebuild %s", cid)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer closeAll()
+		skip := offset - stripe*stripeSize
+		left := length
+		for left > 0 {
+			stripeLen := man.Size - stripe*stripeSize
+			if stripeLen > stripeSize {
+				stripeLen = stripeSize
+			}
+			shardLen := (stripeLen + int64(k) - 1) / int64(k)
+			shards := make([][]byte, len(man.Shards))
+			live := 0
+			for i, r := range readers {
+				if r == nil {
+					continue
+				}
+				buf := make([]byte, shardLen)
+				if _, err := io.ReadFull(r, buf); err != nil {
+					logger.Errorf("ipfs节点 %s 分片读取失败: %s", man.Shards[i].Endpoint, err)
+					r.Close()
+					readers[i] = nil
+					continue
+				}
+				shards[i] = buf
+				live++
+			}
+			if live < k {
+				pw.CloseWithError(fmt.Errorf("not enough shards to rebuild %s", cid))
+				return
+			}
+			if err := enc.ReconstructData(shards); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			var buf bytes.Buffer
+			if err := enc.Join(&buf, shards, int(stripeLen)); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			data := buf.Bytes()[skip:]
+			if int64(len(data)) > left {
+				data = data[:left]
+			}
+			if _, err := pw.Write(data); err != nil {
+				return
+			}
+			left -= int64(len(data))
+			skip = 0
+			stripe++
+			// 读取失败的分片在下一个条带用其它分片补齐
+			if left > 0 && live < len(man.Shards) {
+				open()
+			}
+		}
+		pw.Close()
+	}()
+	return pr, nil
+}
+
+// erasurePin pins or unpins the shards on their endpoints and the manifest on all endpoints
+func (c *Ipfs) erasurePin(man *erasureManifest, cid string, pin bool) error {
+	call := func(cli *shell.Shell, id string) error {
+		if pin {
+			return cli.Pin(id)
+		}
+		return cli.Unpin(id)
+	}
+	for _, s := range man.Shards {
+		cli := c.endpointClient(s.Endpoint)
+		if cli == nil {
+			logger.Errorf("ipfs节点 %s 不可用, 分片 %s 未处理", s.Endpoint, s.Cid)
+			continue
+		}
+		if err := call(cli, s.Cid); err != nil {
+			logger.Errorf("ipfs节点 %s 分片 %s 处理失败: %s", s.Endpoint, s.Cid, err)
+			return err
+		}
+	}
+	for _, host := range c.storeHosts() {
+		if err := call(c.endpointClient(host), strings.TrimPrefix(cid, "/ipfs/")); err != nil && pin {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildShards rebuilds the missing shards from the live ones and adds them back to their endpoints,
+// 重建的分片内容与写入时相同, cid和清单都不变
+func (c *Ipfs) rebuildShards(ctx context.Context, cid string, missing []int) error {
+	man, err := c.readManifest(ctx, cid)
+	if err != nil {
+		return err
+	}
+	enc, err := reedsolomon.New(man.Data, man.Parity)
+	if err != nil {
+		return err
+	}
+	k := man.Data
+	lost := make(map[int]bool, len(missing))
+	for _, i := range missing {
+		lost[i] = true
+	}
+	readers := make([]io.ReadCloser, len(man.Shards))
+	defer func() {
+		for _, r := range readers {
+			if r != nil {
+				r.Close()
+			}
+		}
+	}()
+	live := 0
+	for i, s := range man.Shards {
+		if live >= k {
+			break
+		}
+		cli := c.endpointClient(s.Endpoint)
+		if lost[i] || cli == nil {
+			continue
+		}
+		resp, err := cli.Request("cat", s.Cid).Send(ctx)
+		if err == nil && resp.Error != nil {
+			err = resp.Error
+		}
+		if err != nil {
+			logger.Errorf("ipfs节点 %s 分片读取失败: %s", s.Endpoint, err)
+			continue
+		}
+		readers[i] = resp.Output
+		live++
+	}
+	if live < k {
+		return fmt.Errorf("not enough shards to rebuild %s", cid)
+	}
+
+	pws := make([]*io.PipeWriter, len(missing))
+	errs := make([]error, len(missing))
+	var wg sync.WaitGroup
+	for j, i := range missing {
+		cli := c.endpointClient(man.Shards[i].Endpoint)
+		if cli == nil {
+			err = fmt.Errorf("endpoint %s unavailable", man.Shards[i].Endpoint)
+			break
+		}
+		pr, pw := io.Pipe()
+		pws[j] = pw
+		wg.Add(1)
+		go func(j int, want string, pr *io.PipeReader) {
+			defer wg.Done()
+			id, err := cli.Add(pr, shell.Pin(true))
+			if err == nil && id != want {
+				err = fmt.Errorf("rebuilt shard %s does not match %s", id, want)
+			}
+			if err != nil {
+				pr.CloseWithError(err)
+			}
+			errs[j] = err
+		}(j, man.Shards[i].Cid, pr)
+	}
+	closeAll := func(err error) {
+		for _, pw := range pws {
+			if pw != nil {
+				pw.CloseWithError(err)
+			}
+		}
+		wg.Wait()
+	}
+	if err != nil {
+		closeAll(err)
+		return err
+	}
+
+	stripeSize := man.BlockSize * int64(k)
+	for off := int64(0); off < man.Size; off += stripeSize {
+		stripeLen := man.Size - off
+		if stripeLen > stripeSize {
+			stripeLen = stripeSize
+		}
+		shardLen := (stripeLen + int64(k) - 1) / int64(k)
+		shards := make([][]byte, len(man.Shards))
+		for i, r := range readers {
+			if r == nil {
+				continue
+			}
+			shards[i] = make([]byte, shardLen)
+			if _, err := io.ReadFull(r, shards[i]); err != nil {
+				closeAll(err)
+				return err
+			}
+		}
+		if err := enc.Reconstruct(shards); err != nil {
+			closeAll(err)
+			return err
+		}
+		for j, i := range missing {
+			if _, err := pws[j].Write(shards[i]); err != nil {
+				closeAll(err)
+				return err
+			}
+		}
+	}
+	closeAll(nil)
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func newErasureTest(t *testing.T) (*Ipfs, map[string]*fakeIpfs) {
+	nodes := map[string]*fakeIpfs{}
+	var list []*fakeIpfs
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+		n := newFakeIpfs(t, ip)
+		nodes[ip] = n
+		list = append(list, n)
+	}
+	return newTestIpfs(t, StorageConfig{Erasure: ErasureConfig{Data: 2, Parity: 1}}, list...), nodes
+}
+
+func readAll(t *testing.T, c *Ipfs, cid string, offset, length int64) []byte {
+	rd, err := c.Read(context.Background(), cid, offset, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	b, err := ioutil.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestErasureMissingShard(t *testing.T) {
+	ctx := context.Background()
+	c, nodes := newErasureTest(t)
+	// 两个完整条带加半个条带
+	data := make([]byte, 5*erasureBlockSize)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	cid, err := c.Write(ctx, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	man, err := c.lookupManifest(ctx, cid)
+	if err != nil || man == nil {
+		t.Fatalf("manifest of %s not found: %v", cid, err)
+	}
+	if man.Size != int64(len(data)) {
+		t.Fatalf("size mismatch: %d", man.Size)
+	}
+
+	// 丢失一个数据分片后重建
+	lost := man.Shards[0]
+	n := nodes[lost.Endpoint]
+	n.mu.Lock()
+	delete(n.blocks, lost.Cid)
+	n.mu.Unlock()
+
+	if got := readAll(t, c, cid, 0, -1); !bytes.Equal(got, data) {
+		t.Fatal("content mismatch after losing a shard")
+	}
+	offset, length := int64(erasureBlockSize+100), int64(3*erasureBlockSize)
+	if got := readAll(t, c, cid, offset, length); !bytes.Equal(got, data[offset:offset+length]) {
+		t.Fatal("range mismatch after losing a shard")
+	}
+}
+
+func TestErasureWriteFailure(t *testing.T) {
+	ctx := context.Background()
+	c, nodes := newErasureTest(t)
+	data := make([]byte, 3*erasureBlockSize+7)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	// 一个节点写入失败时仍然成功, 缺失的分片加入修复队列
+	nodes["127.0.0.3"].failAdd = true
+	cid, err := c.Write(ctx, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks := c.UnderReplicated()
+	if len(tasks) != 1 || tasks[0].Cid != cid || len(tasks[0].Shards) != 1 {
+		t.Fatalf("unexpected repair tasks: %+v", tasks)
+	}
+	if got := readAll(t, c, cid, 0, -1); !bytes.Equal(got, data) {
+		t.Fatal("content mismatch with a missing shard")
+	}
+
+	// 节点恢复后重建出相同cid的分片
+	nodes["127.0.0.3"].failAdd = false
+	c.repair()
+	if tasks = c.UnderReplicated(); len(tasks) != 0 {
+		t.Fatalf("shard not rebuilt: %+v", tasks)
+	}
+	man, err := c.lookupManifest(ctx, cid)
+	if err != nil || man == nil {
+		t.Fatalf("manifest of %s not found: %v", cid, err)
+	}
+	for _, s := range man.Shards {
+		if !nodes[s.Endpoint].has(s.Cid) {
+			t.Fatalf("shard %s missing on %s", s.Cid, s.Endpoint)
+		}
+	}
+
+	// 超过校验分片数的节点失败时写入失败
+	nodes["127.0.0.2"].failAdd = true
+	nodes["127.0.0.3"].failAdd = true
+	if _, err = c.Write(ctx, bytes.NewReader(data)); err == nil {
+		t.Fatal("write should fail when more shards than parity fail")
+	}
+}
+
+func TestLookupManifest(t *testing.T) {
+	ctx := context.Background()
+	c, nodes := newErasureTest(t)
+
+	// 其它dag-cbor数据不是清单
+	other, err := c.endpointClient("127.0.0.1").DagPut(`{"Version":2,"Data":1}`, "json", "cbor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes["127.0.0.2"].blocks[other] = nodes["127.0.0.1"].blocks[other]
+	nodes["127.0.0.3"].blocks[other] = nodes["127.0.0.1"].blocks[other]
+	if man, err := c.lookupManifest(ctx, other); err != nil || man != nil {
+		t.Fatalf("dag-cbor without marker taken as manifest: %v, %v", man, err)
+	}
+	if man, err := c.lookupManifest(ctx, "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"); err != nil || man != nil {
+		t.Fatalf("dag-pb taken as manifest: %v, %v", man, err)
+	}
+}
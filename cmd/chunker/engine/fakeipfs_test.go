@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	cid2 "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// fakeIpfs 模拟ipfs节点的http api, 数据保存在内存中
+type fakeIpfs struct {
+	mu      sync.Mutex
+	blocks  map[string][]byte
+	pins    map[string]bool
+	failAdd bool // add请求返回错误
+	srv     *httptest.Server
+}
+
+// newFakeIpfs starts a fake node listening on ip, every node uses a different loopback ip as its endpoint key
+func newFakeIpfs(t *testing.T, ip string) *fakeIpfs {
+	f := &fakeIpfs{blocks: make(map[string][]byte), pins: make(map[string]bool)}
+	l, err := net.Listen("tcp", ip+":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.srv = httptest.NewUnstartedServer(http.HandlerFunc(f.serve))
+	f.srv.Listener.Close()
+	f.srv.Listener = l
+	f.srv.Start()
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeIpfs) endpoint() (string, *EndpointConfig) {
+	addr := f.srv.Listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), &EndpointConfig{Http: addr.Port, CanStore: true, CanRead: true}
+}
+
+func (f *fakeIpfs) has(cid string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.blocks[cid]
+	return ok
+}
+
+func (f *fakeIpfs) fail(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]interface{}{"Message": msg, "Code": 0, "Type": "error"})
+}
+
+func (f *fakeIpfs) serve(w http.ResponseWriter, r *http.Request) {
+	arg := r.URL.Query().Get("arg")
+	reply := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	switch r.URL.Path {
+	case "/api/v0/version":
+		reply(map[string]string{"Version": "0.0.0"})
+	case "/api/v0/repo/stat":
+		stat := RepoStat{StorageMax: 1 << 40}
+		if r.URL.Query().Get("size-only") != "true" {
+			f.mu.Lock()
+			stat.NumObjects = uint64(len(f.blocks))
+			f.mu.Unlock()
+		}
+		reply(stat)
+	case "/api/v0/add", "/api/v0/dag/put":
+		if f.failAdd {
+			f.fail(w, "add failed")
+			return
+		}
+		mr, err := r.MultipartReader()
+		if err != nil {
+			f.fail(w, err.Error())
+			return
+		}
+		part, err := mr.NextPart()
+		if err != nil {
+			f.fail(w, err.Error())
+			return
+		}
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			f.fail(w, err.Error())
+			return
+		}
+		var id string
+		if r.URL.Path == "/api/v0/add" {
+			h := newUnixfsHasher()
+			h.Write(data)
+			id = h.Cid().String()
+		} else {
+			sum := sha256.Sum256(data)
+			hash, _ := mh.Encode(sum[:], mh.SHA2_256)
+			id = cid2.NewCidV1(cid2.DagCBOR, hash).String()
+		}
+		f.mu.Lock()
+		f.blocks[id], f.pins[id] = data, true
+		f.mu.Unlock()
+		if r.URL.Path == "/api/v0/add" {
+			reply(map[string]string{"Hash": id})
+		} else {
+			reply(map[string]interface{}{"Cid": map[string]string{"/": id}})
+		}
+	case "/api/v0/cat", "/api/v0/dag/get":
+		f.mu.Lock()
+		data, ok := f.blocks[arg]
+		f.mu.Unlock()
+		if !ok {
+			f.fail(w, "block not found")
+			return
+		}
+		if v := r.URL.Query().Get("offset"); v != "" {
+			off, _ := strconv.Atoi(v)
+			if off > len(data) {
+				off = len(data)
+			}
+			data = data[off:]
+		}
+		if v := r.URL.Query().Get("length"); v != "" {
+			n, _ := strconv.Atoi(v)
+			if n < len(data) {
+				data = data[:n]
+			}
+		}
+		w.Write(data)
+	case "/api/v0/dag/export":
+		// 用 "cid\n数据" 代替car格式
+		f.mu.Lock()
+		data, ok := f.blocks[arg]
+		f.mu.Unlock()
+		if !ok {
+			f.fail(w, "block not found")
+			return
+		}
+		w.Write(append([]byte(arg+"\n"), data...))
+	case "/api/v0/dag/import":
+		mr, err := r.MultipartReader()
+		if err != nil {
+			f.fail(w, err.Error())
+			return
+		}
+		part, err := mr.NextPart()
+		if err != nil {
+			f.fail(w, err.Error())
+			return
+		}
+		car, err := ioutil.ReadAll(part)
+		i := bytes.IndexByte(car, '\n')
+		if err != nil || i < 0 {
+			f.fail(w, "invalid car")
+			return
+		}
+		id := string(car[:i])
+		f.mu.Lock()
+		f.blocks[id], f.pins[id] = car[i+1:], r.URL.Query().Get("pin-roots") == "true"
+		f.mu.Unlock()
+		reply(map[string]interface{}{"Root": map[string]interface{}{"Cid": map[string]string{"/": id}}})
+	case "/api/v0/pin/add", "/api/v0/pin/rm":
+		f.mu.Lock()
+		_, ok := f.blocks[arg]
+		if ok {
+			f.pins[arg] = r.URL.Path == "/api/v0/pin/add"
+		}
+		f.mu.Unlock()
+		if !ok {
+			f.fail(w, "not pinned or pinned indirectly")
+			return
+		}
+		reply(map[string][]string{"Pins": {arg}})
+	default:
+		f.fail(w, "command not found")
+	}
+}
+
+// newTestIpfs starts an Ipfs storage on the fake nodes
+func newTestIpfs(t *testing.T, c StorageConfig, nodes ...*fakeIpfs) *Ipfs {
+	endpoints := make(map[string]*EndpointConfig)
+	for _, n := range nodes {
+		host, config := n.endpoint()
+		endpoints[host] = config
+	}
+	c.Targets = map[string]EngineConfig{STORAGE_IPFS: {Endpoints: endpoints}}
+	s := newIpfs(c).(*Ipfs)
+	s.checkAlive()
+	return s
+}
@@ -2,14 +2,31 @@ package engine
 
 import (
 	"context"
+	"errors"
 	shell "github.com/ipfs/go-ipfs-api"
 	"io"
+	"sync"
 )
 
 type StorageConfig struct {
-	Provider    string
-	Replication int
-	Targets     map[string]EngineConfig
+	Provider           string
+	Replication        int
+	Selector           string //节点选择策略: random, roundrobin, leastused, consistenthash
+	ReadTimeout        int    //单个节点读取的超时时间(秒), 超时后尝试下一个副本
+	HedgeDelay         int    //对冲读取的延迟阈值(毫秒), 超过后同时向下一个副本发起读取, 0为不启用
+	WriteQuorum        int    //写入仲裁数, 相同cid写入成功的副本数达到后即成功, 0为全部副本
+	WriteTimeout       int    //单个副本写入阻塞的超时时间(秒), 超时的副本被剔除
+	RebalanceBandwidth int    //再平衡的默认限速(MB/s), 0为不限速
+	HighWater          int    //容量高水位(百分比), 使用率达到后节点不再写入新数据
+	StateDir           string //修复队列等运行状态的保存目录, 为空时只保存在内存中
+	Erasure            ErasureConfig
+	Targets            map[string]EngineConfig
+}
+
+// ErasureConfig 纠删码配置, Data和Parity都大于0时启用, 启用后Replication不再生效
+type ErasureConfig struct {
+	Data   int
+	Parity int
 }
 
 type EngineConfig struct {
@@ -17,11 +34,74 @@ type EngineConfig struct {
 }
 
 type EndpointConfig struct {
-	Http     int
-	Fix      int
-	CanStore bool
-	CanRead  bool
-	health   bool
+	Http            int
+	Fix             int
+	CanStore        bool
+	CanRead         bool
+	Drain           bool //下线中, 不再写入新数据, 可在运行时通过管理接口设置
+	drainConfigured bool //配置的下线状态, nameserver中没有保存状态时使用
+	// 以下运行时状态由健康检查, 管理接口和读写路径并发访问, 通过mu保护, Drain启动后也只通过方法读写
+	mu      sync.RWMutex
+	health  bool
+	used    uint64 //已使用空间, 健康检查时更新
+	max     uint64 //存储上限, 健康检查时更新
+	objects uint64 //对象数, 统计需遍历仓库, 由后台定时更新
+
+	//s3 target
+	AccessKey string
+	SecretKey string //加密后的密钥, 同 redis.password
+	Bucket    string
+	Secure    bool
+	Capacity  uint64 //s3无法获取容量, 由配置给出
+}
+
+func (e *EndpointConfig) healthy() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.health
+}
+
+func (e *EndpointConfig) setHealth(health bool) {
+	e.mu.Lock()
+	e.health = health
+	e.mu.Unlock()
+}
+
+func (e *EndpointConfig) draining() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.Drain
+}
+
+func (e *EndpointConfig) setDrain(drain bool) {
+	e.mu.Lock()
+	e.Drain = drain
+	e.mu.Unlock()
+}
+
+// usage returns the used space and the capacity
+func (e *EndpointConfig) usage() (used, max uint64) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.used, e.max
+}
+
+func (e *EndpointConfig) setUsage(used, max uint64) {
+	e.mu.Lock()
+	e.used, e.max = used, max
+	e.mu.Unlock()
+}
+
+func (e *EndpointConfig) objectCount() uint64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.objects
+}
+
+func (e *EndpointConfig) setObjects(objects uint64) {
+	e.mu.Lock()
+	e.objects = objects
+	e.mu.Unlock()
 }
 
 type RepoStat struct {
@@ -32,20 +112,45 @@ type RepoStat struct {
 	Version    string
 }
 
+// EndpointStat 单个存储节点的容量和状态
+type EndpointStat struct {
+	Endpoint string
+	Healthy  bool
+	Draining bool
+	RepoStat
+	Error string
+}
+
+// DeleteResult 删除时单个节点的结果
+type DeleteResult struct {
+	Endpoint string `json:"endpoint"`
+	Deleted  bool   `json:"deleted"` //已取消pin或已删除
+	GC       bool   `json:"gc"`      //已触发垃圾回收
+	Error    string `json:"error,omitempty"`
+}
+
+// ErrStorageFull 没有足够的节点能容纳写入的数据
+var ErrStorageFull = errors.New("storage full, no endpoint can take the upload")
+
+// ErrNotPinned 所有节点上都不存在该cid
+var ErrNotPinned = errors.New("not pinned or pinned indirectly")
+
 type Storage interface {
 	Start() error
 	//Write save file
 	Write(ctx context.Context, file io.Reader) (string, error)
-	//Read : read file
-	Read(ctx context.Context, cid string) (io.Reader, error)
-	//Delete delete file
-	Delete(ctx context.Context, cid string) error
+	//Read : read file, length < 0 means read to the end, the reader must be closed
+	Read(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error)
+	//Delete delete file on every endpoint holding it, gc为true时触发节点的垃圾回收
+	Delete(ctx context.Context, cid string, gc bool) ([]DeleteResult, error)
 	//Stat get file stat
 	Stat(ctx context.Context, cid string) error
 	//DagTree get file dag tree
 	DagTree(ctx context.Context, cid string) (interface{}, error)
 	//DagSize get dag size
 	DagSize(ctx context.Context, cid string) (string, error)
+	//FileSize get the size of file content
+	FileSize(ctx context.Context, cid string) (int64, error)
 	//Pin pin a file
 	Pin(path string) error
 	//Unpin unpin a file
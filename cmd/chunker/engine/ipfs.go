@@ -7,37 +7,78 @@ import (
 	shell "github.com/ipfs/go-ipfs-api"
 	"go.opencensus.io/trace"
 	"io"
+	"io/ioutil"
 	"mtcloud.com/mtstorage/pkg/logger"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+// repo/stat 需要统计对象数, 耗时较长
+const statTimeout = 10 * time.Second
+
+// 统计对象数需遍历整个仓库, 在后台按较长的间隔执行, 心跳上报缓存的结果
+const (
+	objectCountInterval = 10 * time.Minute
+	objectCountTimeout  = 5 * time.Minute
+)
+
 type Ipfs struct {
 	replication int
+	erasure     ErasureConfig
 	endpoints   map[string]*EndpointConfig
+	selector    Selector
+	readTimeout time.Duration
+	hedgeDelay  time.Duration
+	// 写入仲裁, 成功写入的副本数达到后即返回
+	writeQuorum  int
+	writeTimeout time.Duration
+	repairs      *repairQueue
+	refs         RefCounter
+	rebalancer   *rebalancer
+	// 容量高水位, 已用空间超过StorageMax*highWater的节点不再写入
+	highWater float64
+	// 下线中节点的迁移任务
+	drainMu sync.Mutex
+	drains  map[string]*drainJob
 }
 
 func newIpfs(c StorageConfig) Storage {
 	ic := &Ipfs{
-		replication: c.Replication,
+		replication:  c.Replication,
+		erasure:      c.Erasure,
+		readTimeout:  time.Duration(c.ReadTimeout) * time.Second,
+		hedgeDelay:   time.Duration(c.HedgeDelay) * time.Millisecond,
+		writeQuorum:  c.WriteQuorum,
+		writeTimeout: time.Duration(c.WriteTimeout) * time.Second,
+		highWater:    float64(c.HighWater) / 100,
+		repairs:      newRepairQueue(c.StateDir),
+		rebalancer:   newRebalancer(c.RebalanceBandwidth),
+		drains:       make(map[string]*drainJob),
+	}
+	if ic.readTimeout <= 0 {
+		ic.readTimeout = 30 * time.Second
+	}
+	if ic.writeTimeout <= 0 {
+		ic.writeTimeout = 30 * time.Second
+	}
+	if ic.highWater <= 0 || ic.highWater > 1 {
+		ic.highWater = 1
 	}
 	ic.endpoints = c.Targets[STORAGE_IPFS].Endpoints
+	ic.selector = newSelector(c.Selector, func(host string) uint64 {
+		used, _ := ic.endpoints[host].usage()
+		return used
+	})
 	return ic
 }
 
-func (c *Ipfs) getClient() *shell.Shell {
-	length := len(c.endpoints)
-	//todo: get a random endpoint,map所有一定随机性
-	if length != 0 {
-		for host, config := range c.endpoints {
-			if !config.CanStore || !config.health {
-				continue
-			}
-			url := fmt.Sprintf("http://%s:%d", host, config.Http)
-			e := shell.NewShell(url)
-			//logger.Info("cluster上传节点: ", url)
-			return e
-		}
+// getClient 按选择策略返回一个可用节点, key为cid, 未知时为空
+func (c *Ipfs) getClient(key string) *shell.Shell {
+	hosts := c.selector.Select(c.storeHosts(), 1, key)
+	if len(hosts) != 0 {
+		return c.endpointClient(hosts[0])
 	}
 	err := errors.New("no endpoint found")
 	logger.Error(err)
@@ -45,10 +86,23 @@ func (c *Ipfs) getClient() *shell.Shell {
 	return nil
 }
 
+// writeClient 按选择策略返回一个可写入的节点, 排除下线中的节点
+func (c *Ipfs) writeClient(key string) *shell.Shell {
+	hosts := c.selector.Select(c.writeHosts(), 1, key)
+	if len(hosts) != 0 {
+		return c.endpointClient(hosts[0])
+	}
+	logger.Error("no endpoint found")
+	return nil
+}
+
 func (c *Ipfs) Write(ctx context.Context, file io.Reader) (string, error) {
-	// 随机选择一个节点。
+	if c.erasure.enabled() {
+		return c.erasureWrite(ctx, file)
+	}
+	// 按选择策略选择一个节点。
 	if c.replication == 1 {
-		cli := c.getClient()
+		cli := c.writeClient("")
 		if cli == nil {
 			return "", errors.New("no endpoint found")
 		}
@@ -59,172 +113,412 @@ func (c *Ipfs) Write(ctx context.Context, file io.Reader) (string, error) {
 
 }
 
-func (c *Ipfs) allocate() ([]*shell.Shell, error) {
-	var eps []*shell.Shell
-	if c.replication == -1 {
-		for host, config := range c.endpoints {
+// room returns the free space of host below the high-water mark, ok is false if the capacity is unknown yet
+func (c *Ipfs) room(host string) (free uint64, ok bool) {
+	used, max := c.endpoints[host].usage()
+	if max == 0 {
+		return 0, false
+	}
+	limit := uint64(float64(max) * c.highWater)
+	if used >= limit {
+		return 0, true
+	}
+	return limit - used, true
+}
 
-			if !config.CanStore || !config.health {
-				continue
-			}
-			url := fmt.Sprintf("http://%s:%d", host, config.Http)
-			cli := shell.NewShell(url)
-			//logger.Info("cluster上传节点: ", url)
-			eps = append(eps, cli)
+// Admit checks whether enough endpoints have room for size bytes before the upload starts,
+// size < 0 means unknown and only the endpoints above the high-water mark are excluded.
+func (c *Ipfs) Admit(size int64) error {
+	need, per := 1, size
+	if c.erasure.enabled() {
+		need = c.erasure.Data + c.erasure.Parity
+		per = (size + int64(c.erasure.Data) - 1) / int64(c.erasure.Data)
+	} else if c.replication > 0 {
+		need = c.quorum(c.replication)
+	}
+	have := 0
+	for _, host := range c.writeHosts() {
+		free, ok := c.room(host)
+		if !ok || per < 0 || free >= uint64(per) {
+			have++
 		}
-		return eps, nil
 	}
+	if have < need {
+		logger.Errorf("存储空间不足, 需要 %d 个节点各有 %d 字节可用空间, 满足的节点: %d", need, per, have)
+		return ErrStorageFull
+	}
+	return nil
+}
+
+// quorum returns how many of n replicas must succeed
+func (c *Ipfs) quorum(n int) int {
+	if c.writeQuorum <= 0 || c.writeQuorum > n {
+		return n
+	}
+	return c.writeQuorum
+}
 
-	//寻找与replication一致的节点数
-	for host, config := range c.endpoints {
-		if !config.CanStore || !config.health {
-			continue
-		}
-		url := fmt.Sprintf("http://%s:%d", host, config.Http)
-		cli := shell.NewShell(url)
-		//logger.Info("cluster上传节点: ", url)
-		eps = append(eps, cli)
-		if len(eps) == c.replication {
-			break
+func (c *Ipfs) allocate() ([]string, error) {
+	//replication为-1时写入所有节点, 否则按选择策略寻找与replication一致的节点数
+	hosts := c.selector.Select(c.writeHosts(), c.replication, "")
+	if c.replication == -1 {
+		if len(hosts) == 0 {
+			return hosts, errors.New("no endpoint found")
 		}
+		return hosts, nil
 	}
 
-	if len(eps) != c.replication {
-		err := fmt.Errorf("not enough node to allocate, need: %d, have: %d", c.replication, len(eps))
-		return eps, err
+	if len(hosts) != c.replication {
+		err := fmt.Errorf("not enough node to allocate, need: %d, have: %d", c.replication, len(hosts))
+		// 可用节点满足写入仲裁时继续写入, 缺少的副本由修复队列补齐
+		if c.writeQuorum > 0 && len(hosts) >= c.writeQuorum {
+			logger.Warn(err)
+			return hosts, nil
+		}
+		return hosts, err
 	}
 
-	return eps, nil
+	return hosts, nil
 }
 
 func (c *Ipfs) MultiStream(ctx context.Context, file io.Reader) (cid string, err error) {
 	ctx, span := trace.StartSpan(ctx, "writeDataToMutIPFS")
 	defer span.End()
 
-	eps, err := c.allocate()
+	hosts, err := c.allocate()
 	if err != nil {
 		logger.Error(err)
 		return "", err
 	}
+	want := c.replication
+	if want == -1 {
+		want = len(hosts)
+	}
 
-	var sm sync.Map
-	var wg sync.WaitGroup
-	length := len(eps)
-	var w = make([]io.Writer, length, length)
-	var pr = make([]*io.PipeReader, length, length)
-	ch := make(chan struct{}, length)
-	for i := 0; i < length; i++ {
-		wg.Add(1)
-		go func(index int) {
-			pr[index], w[index] = io.Pipe()
-			ch <- struct{}{}
-			cli := eps[index]
-			cid, err := cli.Add(pr[index], shell.Pin(true))
-			sm.Store(cid, err)
-			if err != nil {
-				if pw, ok := w[index].(*io.PipeWriter); ok {
-					pw.CloseWithError(err)
-				}
-				id, _ := cli.ID()
-				logger.Error("ipfs节点--》", id, "  上传失败", err)
+	length := len(hosts)
+	streams := make([]*replicaStream, length)
+	cids := make([]string, length)
+	errs := make([]error, length)
+	finished := make([]chan struct{}, length)
+	for i, host := range hosts {
+		pr, pw := io.Pipe()
+		streams[i] = newReplicaStream(host, pw)
+		finished[i] = make(chan struct{})
+		go func(index int, pr *io.PipeReader) {
+			defer close(finished[index])
+			cli := c.endpointClient(hosts[index])
+			if cli == nil {
+				errs[index] = errors.New("no endpoint found")
+				pr.CloseWithError(errs[index])
+				return
 			}
-			wg.Done()
-		}(i)
+			cids[index], errs[index] = cli.Add(pr, shell.Pin(true))
+			if errs[index] != nil {
+				pr.CloseWithError(errs[index])
+				logger.Errorf("ipfs节点 %s 上传失败: %s", hosts[index], errs[index])
+			}
+		}(i, pr)
 	}
-	// 写入通道全部启动才开始copy
-	for {
-		if len(ch) == length {
-			break
+
+	quorum := c.quorum(want)
+	qw := newQuorumWriter(streams, quorum, c.writeTimeout)
+	_, err = io.Copy(qw, file)
+	qw.close(err)
+	// 被剔除的副本不再等待其返回
+	for i, r := range streams {
+		if !r.dropped {
+			<-finished[i]
 		}
 	}
-	mw := io.MultiWriter(w...) // 向mw统一写入
-	_, err = io.Copy(mw, file)
 	if err != nil {
 		return "", err
 	}
-	for i := 0; i < length; i++ {
-		if pw, ok := w[i].(*io.PipeWriter); ok {
-			pw.CloseWithError(err)
+
+	// 按cid统计成功的副本, 达到仲裁数的cid即为结果
+	votes := make(map[string][]string)
+	for i, r := range streams {
+		if r.dropped || errs[i] != nil || cids[i] == "" {
+			continue
 		}
+		votes[cids[i]] = append(votes[cids[i]], r.host)
 	}
-	wg.Wait()
-	sm.Range(func(key, value interface{}) bool {
-		if value != nil {
-			err = value.(error)
-			return false
-		}
-		if cid != "" && cid != key.(string) {
-			err = errors.New("cid不一致出现错误！")
-			return false
+	var holders []string
+	for id, hs := range votes {
+		if len(hs) > len(holders) {
+			cid, holders = id, hs
 		}
-		cid = key.(string)
-		return true
-	})
-	if cid == "" {
-		return "", errors.New("上传失败！")
 	}
-	return cid, err
-}
-
-func (c *Ipfs) Read(ctx context.Context, cid string) (io.Reader, error) {
-	cli := c.getClient()
-	if cli == nil {
-		return nil, errors.New("no endpoint found")
+	if len(votes) > 1 {
+		logger.Errorf("cid不一致出现错误！%v", votes)
+	}
+	span.AddAttributes(trace.Int64Attribute("replicas", int64(len(holders))))
+	if len(holders) < quorum {
+		return "", fmt.Errorf("write quorum not reached, need: %d, have: %d", quorum, len(holders))
 	}
+	if len(holders) < want {
+		c.repairs.add(cid, holders, want, fmt.Sprintf("write succeeded on %d of %d replicas", len(holders), want))
+	}
+	return cid, nil
+}
 
-	resp, err := cli.Request("cat", cid).Send(ctx)
+func (c *Ipfs) Read(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+	man, err := c.lookupManifest(ctx, cid)
 	if err != nil {
 		return nil, err
+	}
+	if man != nil {
+		return c.erasureRead(ctx, man, cid, offset, length)
+	}
+	ctx, span := trace.StartSpan(ctx, "readDataFromIPFS")
+	defer span.End()
 
+	hosts := c.selector.Select(c.storeHosts(), -1, cid)
+	if len(hosts) == 0 {
+		return nil, errors.New("no endpoint found")
 	}
-	if resp.Error != nil {
-		return nil, resp.Error
+
+	type result struct {
+		host   string
+		resp   *shell.Response
+		cancel context.CancelFunc
+		err    error
 	}
-	return resp.Output, nil
+	results := make(chan result, len(hosts))
+	next, pending := 0, 0
+	launch := func() {
+		host := hosts[next]
+		next++
+		pending++
+		rctx, cancel := context.WithCancel(ctx)
+		go func() {
+			req := c.endpointClient(host).Request("cat", cid)
+			if offset > 0 {
+				req.Option("offset", offset)
+			}
+			if length >= 0 {
+				req.Option("length", length)
+			}
+			resp, err := req.Send(rctx)
+			if err == nil && resp.Error != nil {
+				err = resp.Error
+				resp.Close()
+			}
+			results <- result{host: host, resp: resp, cancel: cancel, err: err}
+		}()
+	}
+	// 放弃其余还在进行的读取
+	discard := func(n int) {
+		go func() {
+			for i := 0; i < n; i++ {
+				r := <-results
+				if r.err == nil {
+					r.resp.Close()
+				}
+				r.cancel()
+			}
+		}()
+	}
+
+	// 按顺序尝试各副本, 出错或超时后读取下一个, 开启对冲读取时超过阈值即发起第二个读取
+	launch()
+	hedged := c.hedgeDelay <= 0
+	err = errors.New("no endpoint found")
+	for pending > 0 {
+		var hedge, stall <-chan time.Time
+		if next < len(hosts) {
+			if !hedged {
+				hedge = time.After(c.hedgeDelay)
+			}
+			stall = time.After(c.readTimeout)
+		}
+		select {
+		case r := <-results:
+			pending--
+			if r.err != nil {
+				logger.Errorf("ipfs节点 %s 读取 %s 失败: %s", r.host, cid, r.err)
+				span.AddAttributes(trace.StringAttribute("failed."+r.host, r.err.Error()))
+				r.cancel()
+				err = r.err
+				if next < len(hosts) {
+					launch()
+				}
+				continue
+			}
+			span.AddAttributes(trace.StringAttribute("replica", r.host))
+			discard(pending)
+			return &cancelReader{ReadCloser: r.resp.Output, cancel: r.cancel}, nil
+		case <-hedge:
+			hedged = true
+			span.AddAttributes(trace.BoolAttribute("hedged", true))
+			launch()
+		case <-stall:
+			logger.Warnf("ipfs节点读取 %s 超时, 尝试下一个副本", cid)
+			launch()
+		case <-ctx.Done():
+			discard(pending)
+			return nil, ctx.Err()
+		}
+	}
+	return nil, err
+}
 
+// cancelReader releases the request context when the read finishes
+type cancelReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
 }
 
-func (c *Ipfs) Delete(ctx context.Context, cid string) error {
-	cli := c.getClient()
-	if cli == nil {
-		return errors.New("no endpoint found")
+func (r *cancelReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil {
+		r.cancel()
 	}
+	return n, err
+}
+
+func (r *cancelReader) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
 
-	resp, err := cli.Request("pin/rm", cid).
-		Option("recursive", true).
-		Send(ctx)
+// Delete unpins cid on every endpoint, 纠删码对象同时取消各分片的pin
+func (c *Ipfs) Delete(ctx context.Context, cid string, gc bool) ([]DeleteResult, error) {
+	targets := make(map[string][]string)
+	for host, config := range c.endpoints {
+		if config.CanStore {
+			targets[host] = []string{cid}
+		}
+	}
+	man, err := c.lookupManifest(ctx, cid)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if resp.Error != nil {
-		return resp.Error
+	if man != nil {
+		for _, s := range man.Shards {
+			targets[s.Endpoint] = append(targets[s.Endpoint], s.Cid)
+		}
 	}
-	return nil
+	c.forget(cid, man)
+	results := c.unpinAll(ctx, targets, gc)
+	return results, deleteError(results)
 }
 
-func (c *Ipfs) Stat(ctx context.Context, cid string) error {
-	cli := c.getClient()
+// unpinAll unpins the cids of each endpoint concurrently
+func (c *Ipfs) unpinAll(ctx context.Context, targets map[string][]string, gc bool) []DeleteResult {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make([]DeleteResult, 0, len(targets))
+	for host, cids := range targets {
+		wg.Add(1)
+		go func(host string, cids []string) {
+			defer wg.Done()
+			res := c.unpin(ctx, host, cids, gc)
+			mu.Lock()
+			results = append(results, res)
+			mu.Unlock()
+		}(host, cids)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool { return results[i].Endpoint < results[j].Endpoint })
+	return results
+}
+
+// unpin 在单个节点上取消pin, 节点上未pin的cid忽略
+func (c *Ipfs) unpin(ctx context.Context, host string, cids []string, gc bool) DeleteResult {
+	res := DeleteResult{Endpoint: host}
+	cli := c.endpointClient(host)
 	if cli == nil {
-		return errors.New("no endpoint found")
+		res.Error = "endpoint unavailable"
+		return res
+	}
+	for _, id := range cids {
+		resp, err := cli.Request("pin/rm", id).
+			Option("recursive", true).
+			Send(ctx)
+		if err == nil {
+			if resp.Error != nil {
+				err = resp.Error
+			}
+			resp.Close()
+		}
+		if err != nil {
+			if strings.Contains(err.Error(), ErrNotPinned.Error()) {
+				continue
+			}
+			logger.Errorf("ipfs节点 %s 取消pin %s 失败: %s", host, id, err)
+			res.Error = err.Error()
+			return res
+		}
+		res.Deleted = true
+	}
+	if gc && res.Deleted {
+		go repoGC(cli, host)
+		res.GC = true
 	}
+	return res
+}
 
-	var raw = struct {
-		Key  string
-		Size uint64
-	}{}
-	err := cli.Request("block/stat", cid).Exec(ctx, &raw)
+// repoGC 垃圾回收耗时较长, 在后台执行
+func repoGC(cli *shell.Shell, host string) {
+	resp, err := cli.Request("repo/gc").Send(context.Background())
+	if err == nil {
+		if resp.Error != nil {
+			err = resp.Error
+		} else {
+			_, err = io.Copy(ioutil.Discard, resp.Output)
+		}
+		resp.Close()
+	}
 	if err != nil {
-		return err
+		logger.Errorf("ipfs节点 %s 垃圾回收失败: %s", host, err)
+		return
 	}
+	logger.Infof("ipfs节点 %s 垃圾回收完成", host)
+}
 
-	if raw.Size <= 0 {
-		return errors.New("block size unexpected")
+// deleteError 任一节点失败返回错误, 所有节点都不存在时返回ErrNotPinned
+func deleteError(results []DeleteResult) error {
+	deleted := false
+	var failed []string
+	for _, res := range results {
+		if res.Error != "" {
+			failed = append(failed, res.Endpoint)
+		}
+		deleted = deleted || res.Deleted
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("delete failed on endpoints: %s", strings.Join(failed, ","))
+	}
+	if !deleted {
+		return ErrNotPinned
 	}
 	return nil
 }
 
+func (c *Ipfs) Stat(ctx context.Context, cid string) error {
+	// 依次尝试各副本
+	err := errors.New("no endpoint found")
+	for _, host := range c.selector.Select(c.storeHosts(), -1, cid) {
+		var raw = struct {
+			Key  string
+			Size uint64
+		}{}
+		sctx, cancel := context.WithTimeout(ctx, c.readTimeout)
+		err = c.endpointClient(host).Request("block/stat", cid).Exec(sctx, &raw)
+		cancel()
+		if err != nil {
+			continue
+		}
+		if raw.Size <= 0 {
+			return errors.New("block size unexpected")
+		}
+		return nil
+	}
+	return err
+}
+
 func (c *Ipfs) DagTree(ctx context.Context, cid string) (interface{}, error) {
-	cli := c.getClient()
+	cli := c.getClient(cid)
 	if cli == nil {
 		return nil, errors.New("no endpoint found")
 	}
@@ -237,8 +531,15 @@ func (c *Ipfs) DagTree(ctx context.Context, cid string) (interface{}, error) {
 }
 
 func (c *Ipfs) DagSize(ctx context.Context, cid string) (string, error) {
+	man, err := c.lookupManifest(ctx, cid)
+	if err != nil {
+		return "", err
+	}
+	if man != nil {
+		return fmt.Sprintf("%d", man.Size), nil
+	}
 
-	cli := c.getClient()
+	cli := c.getClient(cid)
 	if cli == nil {
 		return "", errors.New("no endpoint found")
 	}
@@ -250,42 +551,194 @@ func (c *Ipfs) DagSize(ctx context.Context, cid string) (string, error) {
 	return fmt.Sprintf("%d", ObjectStats.CumulativeSize), nil
 }
 
-// Pin the given path
-func (c *Ipfs) Pin(path string) error {
-	cli := c.getClient()
+func (c *Ipfs) FileSize(ctx context.Context, cid string) (int64, error) {
+	man, err := c.lookupManifest(ctx, cid)
+	if err != nil {
+		return 0, err
+	}
+	if man != nil {
+		return man.Size, nil
+	}
+	cli := c.getClient(cid)
 	if cli == nil {
+		return 0, errors.New("no endpoint found")
+	}
+	var raw = struct {
+		Size int64
+	}{}
+	if err := cli.Request("files/stat", "/ipfs/"+cid).Exec(ctx, &raw); err != nil {
+		logger.Error("get file size fail: ", err)
+		return 0, err
+	}
+	return raw.Size, nil
+}
+
+// Pin pins the path on as many endpoints as the replication factor,
+// 没有数据的节点从其它节点拉取, 未达到副本数的由修复队列补齐
+func (c *Ipfs) Pin(path string) error {
+	man, err := c.lookupManifest(context.Background(), path)
+	if err != nil {
+		return err
+	}
+	if man != nil {
+		return c.erasurePin(man, path, true)
+	}
+	cid := strings.TrimPrefix(path, "/ipfs/")
+	want := c.want()
+	hosts := c.selector.Select(c.writeHosts(), want, cid)
+	if len(hosts) == 0 {
 		return errors.New("no endpoint found")
 	}
-	return cli.Pin(path)
+	var holders []string
+	for _, host := range hosts {
+		cli := c.endpointClient(host)
+		if cli == nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), repairTimeout)
+		err = cli.Request("pin/add", cid).Option("recursive", true).Exec(ctx, nil)
+		cancel()
+		if err != nil {
+			logger.Errorf("ipfs节点 %s pin %s 失败: %s", host, cid, err)
+			continue
+		}
+		holders = append(holders, host)
+	}
+	if quorum := c.quorum(want); len(holders) < quorum {
+		if err == nil {
+			err = fmt.Errorf("pin quorum not reached, need: %d, have: %d", quorum, len(holders))
+		}
+		return err
+	}
+	if len(holders) < want {
+		c.repairs.add(cid, holders, want, fmt.Sprintf("pinned on %d of %d replicas", len(holders), want))
+	}
+	return nil
 }
 
+// RepoGC runs the repo gc on every healthy store endpoint in the background
+func (c *Ipfs) RepoGC() []DeleteResult {
+	var results []DeleteResult
+	for _, host := range c.storeHosts() {
+		res := DeleteResult{Endpoint: host}
+		if cli := c.endpointClient(host); cli != nil {
+			go repoGC(cli, host)
+			res.GC = true
+		} else {
+			res.Error = "endpoint unavailable"
+		}
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Endpoint < results[j].Endpoint })
+	return results
+}
+
+// RepoStat returns the total capacity of all healthy store endpoints
 func (c *Ipfs) RepoStat() (RepoStat, error) {
-	//todo :多个ipfs节点情况下的状态收集
-	//get repo stat
-	cli := c.getClient()
-	if cli == nil {
-		return RepoStat{}, errors.New("no endpoint found")
+	var total RepoStat
+	ok := false
+	for _, s := range c.EndpointStats() {
+		if !s.Healthy || s.Error != "" {
+			continue
+		}
+		ok = true
+		total.NumObjects += s.NumObjects
+		total.RepoSize += s.RepoSize
+		total.StorageMax += s.StorageMax
+		total.Version = s.Version
+	}
+	if !ok {
+		return total, errors.New("no endpoint found")
 	}
-	var stat RepoStat
-	cli.SetTimeout(5 * time.Second)
-	err := cli.Request("repo/stat").Option("size-only", "true").Exec(context.Background(), &stat)
-	return stat, err
+	return total, nil
+}
+
+// EndpointStats collects repo/stat from every store endpoint concurrently.
+// 只统计容量, 对象数使用countObjects缓存的结果
+func (c *Ipfs) EndpointStats() []EndpointStat {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	stats := make([]EndpointStat, 0, len(c.endpoints))
+	for host, config := range c.endpoints {
+		if !config.CanStore {
+			continue
+		}
+		wg.Add(1)
+		go func(host string, draining bool) {
+			defer wg.Done()
+			stat := EndpointStat{Endpoint: host, Draining: draining}
+			if cli := c.endpointClient(host); cli != nil {
+				stat.Healthy = true
+				ctx, cancel := context.WithTimeout(context.Background(), statTimeout)
+				if err := cli.Request("repo/stat").Option("size-only", "true").Exec(ctx, &stat.RepoStat); err != nil {
+					stat.Error = err.Error()
+				}
+				cancel()
+				stat.NumObjects = c.endpoints[host].objectCount()
+			}
+			mu.Lock()
+			stats = append(stats, stat)
+			mu.Unlock()
+		}(host, config.draining())
+	}
+	wg.Wait()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Endpoint < stats[j].Endpoint })
+	return stats
 }
 
 func (c *Ipfs) Start() error {
 	c.checkAlive()
+	// 配置为下线的节点
+	c.drainMu.Lock()
+	for host, config := range c.endpoints {
+		config.drainConfigured = config.draining()
+		if config.CanStore && config.drainConfigured && !c.erasure.enabled() {
+			c.startDrain(host)
+		}
+	}
+	c.drainMu.Unlock()
 	go func() {
 		ticker := time.NewTicker(10 * time.Second)
-		for {
-			select {
-			case <-ticker.C:
-				c.checkAlive()
-			}
+		for range ticker.C {
+			c.checkAlive()
+		}
+	}()
+	// 修复时pin可能耗时较长, 不能阻塞健康检查
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		for range ticker.C {
+			c.repair()
+		}
+	}()
+	go func() {
+		c.countObjects()
+		ticker := time.NewTicker(objectCountInterval)
+		for range ticker.C {
+			c.countObjects()
 		}
 	}()
 	return nil
 }
 
+// countObjects updates the object count of every healthy store endpoint
+func (c *Ipfs) countObjects() {
+	for _, host := range c.storeHosts() {
+		cli := c.endpointClient(host)
+		if cli == nil {
+			continue
+		}
+		var stat RepoStat
+		ctx, cancel := context.WithTimeout(context.Background(), objectCountTimeout)
+		err := cli.Request("repo/stat").Exec(ctx, &stat)
+		cancel()
+		if err != nil {
+			logger.Errorf("ipfs节点 %s 统计对象数失败: %s", host, err)
+			continue
+		}
+		c.endpoints[host].setObjects(stat.NumObjects)
+	}
+}
+
 func (c *Ipfs) checkAlive() {
 
 	for host, config := range c.endpoints {
@@ -296,23 +749,40 @@ func (c *Ipfs) checkAlive() {
 		e := shell.NewShell(url)
 		if !e.IsUp() {
 			logger.Errorf("cluster节点连接异常:  %s", url)
-			config.health = false
+			config.setHealth(false)
 		} else {
-			config.health = true
+			config.setHealth(true)
+			var stat RepoStat
+			e.SetTimeout(5 * time.Second)
+			if err := e.Request("repo/stat").Option("size-only", "true").Exec(context.Background(), &stat); err == nil {
+				config.setUsage(stat.RepoSize, stat.StorageMax)
+			}
 		}
 	}
 }
 
+// Unpin removes the pin of path on every store endpoint, 副本分布在多个节点上
 func (c *Ipfs) Unpin(path string) error {
-	cli := c.getClient()
-	if cli == nil {
-		return errors.New("no endpoint found")
+	man, err := c.lookupManifest(context.Background(), path)
+	if err != nil {
+		return err
+	}
+	c.forget(path, man)
+	if man != nil {
+		return c.erasurePin(man, path, false)
+	}
+	cid := strings.TrimPrefix(path, "/ipfs/")
+	targets := make(map[string][]string)
+	for host, config := range c.endpoints {
+		if config.CanStore {
+			targets[host] = []string{cid}
+		}
 	}
-	return cli.Unpin(path)
+	return deleteError(c.unpinAll(context.Background(), targets, false))
 }
 
 func (c *Ipfs) List(cid string) ([]*shell.LsLink, error) {
-	cli := c.getClient()
+	cli := c.getClient(cid)
 	if cli == nil {
 		return nil, errors.New("no endpoint found")
 	}
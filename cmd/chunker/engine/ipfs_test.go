@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestPinReplication(t *testing.T) {
+	var nodes []*fakeIpfs
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+		nodes = append(nodes, newFakeIpfs(t, ip))
+	}
+	c := newTestIpfs(t, StorageConfig{Replication: 2}, nodes...)
+	cid := "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+	for _, n := range nodes {
+		n.blocks[cid] = []byte("hello world\n")
+	}
+
+	// 按副本数pin到多个节点
+	if err := c.Pin(cid); err != nil {
+		t.Fatal(err)
+	}
+	pinned := 0
+	for _, n := range nodes {
+		if n.pins[cid] {
+			pinned++
+		}
+	}
+	if pinned != 2 {
+		t.Fatalf("want 2 replicas, got %d", pinned)
+	}
+	if tasks := c.UnderReplicated(); len(tasks) != 0 {
+		t.Fatalf("unexpected repair tasks: %+v", tasks)
+	}
+
+	// 取消pin时所有副本都取消
+	if err := c.Unpin(cid); err != nil {
+		t.Fatal(err)
+	}
+	for i, n := range nodes {
+		if n.pins[cid] {
+			t.Fatalf("replica on node %d still pinned", i)
+		}
+	}
+
+	// 最多一个节点成功时未达到仲裁
+	for _, n := range nodes[1:] {
+		delete(n.blocks, cid)
+	}
+	if err := c.Pin(cid); err == nil {
+		t.Fatal("pin should fail below the quorum")
+	}
+}
+
+func TestEndpointStats(t *testing.T) {
+	n := newFakeIpfs(t, "127.0.0.1")
+	c := newTestIpfs(t, StorageConfig{Replication: 1}, n)
+	n.blocks["QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"] = []byte("hello world\n")
+
+	// 心跳只查询容量, 对象数来自后台统计
+	stats := c.EndpointStats()
+	if len(stats) != 1 || stats[0].StorageMax == 0 || stats[0].NumObjects != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	c.countObjects()
+	if stats = c.EndpointStats(); stats[0].NumObjects != 1 {
+		t.Fatalf("object count not cached: %+v", stats)
+	}
+}
@@ -0,0 +1,404 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cid2 "github.com/ipfs/go-cid"
+	shell "github.com/ipfs/go-ipfs-api"
+	ds "github.com/ipfs/go-ipfs-ds-help"
+	"github.com/shirou/gopsutil/disk"
+	"mtcloud.com/mtstorage/pkg/logger"
+)
+
+const (
+	// 与 cmd/tools/parsecid 的目录分片方式保持一致
+	localSuffixLen = 2
+	localDataExt   = ".data"
+	localTempDir   = ".temp"
+)
+
+// LocalFs stores content-addressed blobs on local disks,
+// each endpoint key of the "local" target is a root directory.
+type LocalFs struct {
+	replication int
+	endpoints   map[string]*EndpointConfig
+
+	usageMu sync.Mutex
+	usage   map[string]*localUsage
+}
+
+// localUsage 目录中blob的数量和大小, 启动时统计一次, 之后随写入和删除更新
+type localUsage struct {
+	objects uint64
+	size    uint64
+}
+
+func newLocalFs(c StorageConfig) Storage {
+	lc := &LocalFs{
+		replication: c.Replication,
+		usage:       make(map[string]*localUsage),
+	}
+	lc.endpoints = c.Targets[STORAGE_LOCAL].Endpoints
+	for root := range lc.endpoints {
+		lc.usage[root] = &localUsage{}
+	}
+	return lc
+}
+
+// blobKey returns <dir>/<key>.data
+func blobKey(cid cid2.Cid) string {
+	key := strings.TrimPrefix(ds.NewKeyFromBinary(cid.Bytes()).String(), "/")
+	offset := len(key) - localSuffixLen - 1
+	return key[offset:offset+localSuffixLen] + "/" + key + localDataExt
+}
+
+// blobPath returns <root>/<dir>/<key>.data
+func blobPath(root string, cid cid2.Cid) string {
+	return filepath.Join(root, filepath.FromSlash(blobKey(cid)))
+}
+
+func parseCidPath(path string) (cid2.Cid, error) {
+	path = strings.TrimPrefix(path, "/ipfs/")
+	return cid2.Decode(path)
+}
+
+func (c *LocalFs) allocate() ([]string, error) {
+	var roots []string
+	for root, config := range c.endpoints {
+		if !config.CanStore || !config.healthy() {
+			continue
+		}
+		roots = append(roots, root)
+		if len(roots) == c.replication {
+			break
+		}
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("no endpoint found")
+	}
+	if c.replication > 0 && len(roots) != c.replication {
+		err := fmt.Errorf("not enough node to allocate, need: %d, have: %d", c.replication, len(roots))
+		return roots, err
+	}
+	return roots, nil
+}
+
+// locate returns the blob paths of cid on all healthy endpoints, readable limits them to the CanRead ones
+func (c *LocalFs) locate(cid cid2.Cid, readable bool) []string {
+	var paths []string
+	for root, config := range c.endpoints {
+		if !config.healthy() || (readable && !config.CanRead) {
+			continue
+		}
+		p := blobPath(root, cid)
+		if _, err := os.Stat(p); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+func (c *LocalFs) Write(ctx context.Context, file io.Reader) (string, error) {
+	roots, err := c.allocate()
+	if err != nil {
+		logger.Error(err)
+		return "", err
+	}
+
+	// 先写入各个目录的临时文件，计算出cid后再重命名
+	var temps []*os.File
+	defer func() {
+		for _, f := range temps {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	h := newUnixfsHasher()
+	writers := []io.Writer{h}
+	for _, root := range roots {
+		dir := filepath.Join(root, localTempDir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+		f, err := ioutil.TempFile(dir, "blob-")
+		if err != nil {
+			return "", err
+		}
+		temps = append(temps, f)
+		writers = append(writers, f)
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), file); err != nil {
+		return "", err
+	}
+
+	cid := h.Cid()
+
+	for i, root := range roots {
+		if err := temps[i].Sync(); err != nil {
+			return "", err
+		}
+		fi, err := temps[i].Stat()
+		if err != nil {
+			return "", err
+		}
+		p := blobPath(root, cid)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return "", err
+		}
+		_, exists := os.Stat(p)
+		if err := os.Rename(temps[i].Name(), p); err != nil {
+			logger.Errorf("local节点 %s 写入失败: %s", root, err)
+			return "", err
+		}
+		// 相同内容的blob已存在时只是覆盖
+		if exists != nil {
+			c.addUsage(root, 1, int64(fi.Size()))
+		}
+	}
+	return cid.String(), nil
+}
+
+// addUsage updates the blob count and size of root
+func (c *LocalFs) addUsage(root string, objects, size int64) {
+	c.usageMu.Lock()
+	defer c.usageMu.Unlock()
+	u, ok := c.usage[root]
+	if !ok {
+		return
+	}
+	u.objects = uint64(int64(u.objects) + objects)
+	u.size = uint64(int64(u.size) + size)
+}
+
+// fileReader closes the blob file after a partial read
+type fileReader struct {
+	io.Reader
+	f *os.File
+}
+
+func (r *fileReader) Close() error {
+	return r.f.Close()
+}
+
+func (c *LocalFs) Read(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+	id, err := cid2.Decode(cid)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range c.locate(id, true) {
+		f, err := os.Open(p)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		if offset > 0 {
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+		if length >= 0 {
+			return &fileReader{Reader: io.LimitReader(f, length), f: f}, nil
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("block %s not found", cid)
+}
+
+func (c *LocalFs) Delete(ctx context.Context, cid string, gc bool) ([]DeleteResult, error) {
+	id, err := cid2.Decode(cid)
+	if err != nil {
+		return nil, err
+	}
+	var results []DeleteResult
+	for root, config := range c.endpoints {
+		res := DeleteResult{Endpoint: root}
+		p := blobPath(root, id)
+		if !config.healthy() {
+			res.Error = "endpoint unavailable"
+		} else if fi, err := os.Stat(p); err != nil {
+			if !os.IsNotExist(err) {
+				res.Error = err.Error()
+			}
+		} else if err := os.Remove(p); err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Deleted = true
+			c.addUsage(root, -1, -fi.Size())
+		}
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Endpoint < results[j].Endpoint })
+	return results, deleteError(results)
+}
+
+func (c *LocalFs) Stat(ctx context.Context, cid string) error {
+	id, err := cid2.Decode(cid)
+	if err != nil {
+		return err
+	}
+	for _, p := range c.locate(id, true) {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if fi.Size() <= 0 {
+			return errors.New("block size unexpected")
+		}
+		return nil
+	}
+	return fmt.Errorf("block %s not found", cid)
+}
+
+// DagTree blobs are stored whole without the dag, so the tree has no links
+func (c *LocalFs) DagTree(ctx context.Context, cid string) (interface{}, error) {
+	size, err := c.DagSize(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"Hash":  cid,
+		"Size":  size,
+		"Links": []interface{}{},
+	}, nil
+}
+
+func (c *LocalFs) DagSize(ctx context.Context, cid string) (string, error) {
+	size, err := c.FileSize(ctx, cid)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d", size), nil
+}
+
+func (c *LocalFs) FileSize(ctx context.Context, cid string) (int64, error) {
+	id, err := cid2.Decode(cid)
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range c.locate(id, true) {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		return fi.Size(), nil
+	}
+	return 0, fmt.Errorf("block %s not found", cid)
+}
+
+// Pin blobs on local disks are always kept, only check existence
+func (c *LocalFs) Pin(path string) error {
+	id, err := parseCidPath(path)
+	if err != nil {
+		return err
+	}
+	if len(c.locate(id, false)) == 0 {
+		return fmt.Errorf("block %s not found", id)
+	}
+	return nil
+}
+
+// Unpin there is no repo gc on local disks, remove the blob directly
+func (c *LocalFs) Unpin(path string) error {
+	id, err := parseCidPath(path)
+	if err != nil {
+		return err
+	}
+	_, err = c.Delete(context.Background(), id.String(), false)
+	return err
+}
+
+func (c *LocalFs) List(cid string) ([]*shell.LsLink, error) {
+	if _, err := cid2.Decode(cid); err != nil {
+		return nil, err
+	}
+	return []*shell.LsLink{}, nil
+}
+
+// RepoStat 使用量来自内存中的统计, 不再遍历目录
+func (c *LocalFs) RepoStat() (RepoStat, error) {
+	stat := RepoStat{Version: STORAGE_LOCAL}
+	for root, config := range c.endpoints {
+		if !config.CanStore || !config.healthy() {
+			continue
+		}
+		usage, err := disk.Usage(root)
+		if err != nil {
+			return stat, err
+		}
+		stat.StorageMax += usage.Total
+		c.usageMu.Lock()
+		if u, ok := c.usage[root]; ok {
+			stat.NumObjects += u.objects
+			stat.RepoSize += u.size
+		}
+		c.usageMu.Unlock()
+	}
+	return stat, nil
+}
+
+// scanUsage counts the blobs under root, it runs once at start
+func (c *LocalFs) scanUsage(root string) error {
+	u := localUsage{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == localTempDir {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), localDataExt) {
+			u.objects++
+			u.size += uint64(info.Size())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.usageMu.Lock()
+	*c.usage[root] = u
+	c.usageMu.Unlock()
+	return nil
+}
+
+func (c *LocalFs) Start() error {
+	c.checkAlive()
+	for root := range c.endpoints {
+		if err := c.scanUsage(root); err != nil {
+			logger.Errorf("local节点 %s 统计使用量失败: %s", root, err)
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		for {
+			select {
+			case <-ticker.C:
+				c.checkAlive()
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *LocalFs) checkAlive() {
+	for root, config := range c.endpoints {
+		if err := os.MkdirAll(filepath.Join(root, localTempDir), 0755); err != nil {
+			logger.Errorf("local节点目录异常:  %s, %s", root, err)
+			config.setHealth(false)
+		} else {
+			config.setHealth(true)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTestLocalFs(t *testing.T, roots ...string) *LocalFs {
+	endpoints := make(map[string]*EndpointConfig)
+	for _, root := range roots {
+		endpoints[root] = &EndpointConfig{CanStore: true, CanRead: true}
+	}
+	c := newLocalFs(StorageConfig{
+		Replication: len(roots),
+		Targets:     map[string]EngineConfig{STORAGE_LOCAL: {Endpoints: endpoints}},
+	}).(*LocalFs)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestLocalFsRoundTrip(t *testing.T) {
+	root1, err := ioutil.TempDir("", "localfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root1)
+	root2, err := ioutil.TempDir("", "localfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root2)
+
+	ctx := context.Background()
+	c := newTestLocalFs(t, root1, root2)
+	cid, err := c.Write(ctx, strings.NewReader("hello world\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cid != "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o" {
+		t.Fatalf("cid mismatch: %s", cid)
+	}
+	// 重复写入不重复计数
+	if _, err = c.Write(ctx, strings.NewReader("hello world\n")); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := c.RepoStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.NumObjects != 2 || stat.RepoSize != 24 {
+		t.Fatalf("unexpected usage: %+v", stat)
+	}
+
+	cases := []struct {
+		offset, length int64
+		want           string
+	}{
+		{offset: 0, length: -1, want: "hello world\n"},
+		{offset: 6, length: 5, want: "world"},
+		{offset: 6, length: -1, want: "world\n"},
+	}
+	for _, tc := range cases {
+		rd, err := c.Read(ctx, cid, tc.offset, tc.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = rd.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tc.want {
+			t.Fatalf("read [%d, %d): got %q - want %q", tc.offset, tc.length, b, tc.want)
+		}
+	}
+	if size, err := c.FileSize(ctx, cid); err != nil || size != 12 {
+		t.Fatalf("unexpected size %d: %v", size, err)
+	}
+
+	results, err := c.Delete(ctx, cid, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range results {
+		if !res.Deleted {
+			t.Fatalf("not deleted on %s", res.Endpoint)
+		}
+	}
+	if _, err = c.Read(ctx, cid, 0, -1); err == nil {
+		t.Fatal("read after delete should fail")
+	}
+	if stat, _ = c.RepoStat(); stat.NumObjects != 0 || stat.RepoSize != 0 {
+		t.Fatalf("unexpected usage after delete: %+v", stat)
+	}
+
+	// 重启后重新统计已有的blob
+	if _, err = c.Write(ctx, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	c = newTestLocalFs(t, root1, root2)
+	if stat, _ = c.RepoStat(); stat.NumObjects != 2 || stat.RepoSize != 10 {
+		t.Fatalf("unexpected usage after restart: %+v", stat)
+	}
+}
+
+func TestLocalFsReadableEndpoints(t *testing.T) {
+	root, err := ioutil.TempDir("", "localfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	ctx := context.Background()
+	c := newTestLocalFs(t, root)
+	cid, err := c.Write(ctx, strings.NewReader("hello world\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不可读的节点只保存数据, 不提供读取
+	c.endpoints[root].CanRead = false
+	if _, err = c.Read(ctx, cid, 0, -1); err == nil {
+		t.Fatal("read from a non-readable endpoint")
+	}
+	if err = c.Pin("/ipfs/" + cid); err != nil {
+		t.Fatal(err)
+	}
+	c.endpoints[root].CanRead = true
+	c.endpoints[root].setHealth(false)
+	if _, err = c.FileSize(ctx, cid); err == nil {
+		t.Fatal("read from an unhealthy endpoint")
+	}
+}
@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// 每个副本最多缓存的写入块数, 超过后等待该副本消费
+	replicaBuffer = 64
+	replicaChunk  = 64 << 10
+)
+
+var errReplicaTimeout = errors.New("replica write timeout")
+
+// replicaStream 向单个副本写入数据, 写入失败后丢弃剩余数据
+type replicaStream struct {
+	host    string
+	pw      *io.PipeWriter
+	ch      chan []byte
+	done    chan struct{} // 写入失败后关闭
+	err     error
+	dropped bool
+}
+
+func newReplicaStream(host string, pw *io.PipeWriter) *replicaStream {
+	r := &replicaStream{
+		host: host,
+		pw:   pw,
+		ch:   make(chan []byte, replicaBuffer),
+		done: make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+func (r *replicaStream) run() {
+	for buf := range r.ch {
+		if r.err != nil {
+			continue
+		}
+		if _, err := r.pw.Write(buf); err != nil {
+			r.err = err
+			close(r.done)
+		}
+	}
+	if r.err == nil {
+		r.pw.Close()
+	}
+}
+
+// quorumWriter 将数据写入所有副本, 失败或超时的副本被剔除, 存活副本少于quorum时写入失败
+type quorumWriter struct {
+	streams []*replicaStream
+	live    int
+	quorum  int
+	timeout time.Duration
+}
+
+func newQuorumWriter(streams []*replicaStream, quorum int, timeout time.Duration) *quorumWriter {
+	return &quorumWriter{
+		streams: streams,
+		live:    len(streams),
+		quorum:  quorum,
+		timeout: timeout,
+	}
+}
+
+func (q *quorumWriter) drop(r *replicaStream, err error) {
+	r.dropped = true
+	q.live--
+	r.pw.CloseWithError(err)
+}
+
+func (q *quorumWriter) Write(p []byte) (int, error) {
+	// 按块发送, 限制每个副本缓存的数据量
+	for off := 0; off < len(p); off += replicaChunk {
+		end := off + replicaChunk
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := q.send(p[off:end]); err != nil {
+			return off, err
+		}
+	}
+	return len(p), nil
+}
+
+func (q *quorumWriter) send(p []byte) error {
+	// io.Copy会复用p, 各副本共享一份拷贝
+	buf := append([]byte(nil), p...)
+	for _, r := range q.streams {
+		if r.dropped {
+			continue
+		}
+		// 失败的副本仍在消费缓存, 先检查是否已失败
+		select {
+		case <-r.done:
+			q.drop(r, r.err)
+			continue
+		default:
+		}
+		select {
+		case r.ch <- buf:
+			continue
+		default:
+		}
+		// 副本缓存已满, 等待其消费
+		timer := time.NewTimer(q.timeout)
+		select {
+		case r.ch <- buf:
+		case <-r.done:
+			q.drop(r, r.err)
+		case <-timer.C:
+			q.drop(r, errReplicaTimeout)
+		}
+		timer.Stop()
+	}
+	if q.live < q.quorum {
+		return fmt.Errorf("write quorum not reached, need: %d, have: %d", q.quorum, q.live)
+	}
+	return nil
+}
+
+// close finishes all streams, err is passed to the replicas if not nil
+func (q *quorumWriter) close(err error) {
+	for _, r := range q.streams {
+		if err != nil {
+			r.pw.CloseWithError(err)
+		}
+		close(r.ch)
+	}
+}
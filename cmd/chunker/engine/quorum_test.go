@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestQuorumWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), (replicaBuffer+2)*replicaChunk)
+	cases := []struct {
+		name    string
+		replica []string // ok: 正常读取, fail: 读取出错, stall: 不读取
+		quorum  int
+		live    int
+		fail    bool
+	}{
+		{name: "all", replica: []string{"ok", "ok", "ok"}, quorum: 3, live: 3},
+		{name: "one failed", replica: []string{"ok", "ok", "fail"}, quorum: 2, live: 2},
+		{name: "one stalled", replica: []string{"ok", "stall", "ok"}, quorum: 2, live: 2},
+		{name: "below quorum", replica: []string{"ok", "fail", "stall"}, quorum: 2, fail: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			streams := make([]*replicaStream, len(c.replica))
+			for i, mode := range c.replica {
+				pr, pw := io.Pipe()
+				streams[i] = newReplicaStream(mode, pw)
+				switch mode {
+				case "ok":
+					go ioutil.ReadAll(pr)
+				case "fail":
+					pr.CloseWithError(errors.New("replica failed"))
+				}
+			}
+			qw := newQuorumWriter(streams, c.quorum, 50*time.Millisecond)
+			// 失败的副本在写入第一块后才能发现
+			_, err := qw.Write(data[:1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, mode := range c.replica {
+				if mode == "fail" {
+					<-streams[i].done
+				}
+			}
+			_, err = qw.Write(data)
+			qw.close(err)
+			if c.fail {
+				if err == nil {
+					t.Fatal("write should fail below the quorum")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if qw.live != c.live {
+				t.Fatalf("want %d live replicas, got %d", c.live, qw.live)
+			}
+		})
+	}
+}
+
+func TestMultiStreamQuorum(t *testing.T) {
+	var nodes []*fakeIpfs
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+		nodes = append(nodes, newFakeIpfs(t, ip))
+	}
+	c := newTestIpfs(t, StorageConfig{Replication: 3, WriteQuorum: 2}, nodes...)
+	ctx := context.Background()
+	data := []byte("hello world\n")
+
+	// 恰好达到仲裁时成功, 缺少的副本加入修复队列
+	nodes[2].failAdd = true
+	cid, err := c.MultiStream(ctx, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cid != "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o" {
+		t.Fatalf("unexpected cid %s", cid)
+	}
+	tasks := c.UnderReplicated()
+	if len(tasks) != 1 || tasks[0].Cid != cid || len(tasks[0].Holders) != 2 || tasks[0].Want != 3 {
+		t.Fatalf("unexpected repair tasks: %+v", tasks)
+	}
+
+	// 低于仲裁时失败
+	nodes[1].failAdd = true
+	if _, err = c.MultiStream(ctx, bytes.NewReader(data)); err == nil {
+		t.Fatal("write should fail below the quorum")
+	}
+}
+
+func TestRepairQueueState(t *testing.T) {
+	dir := t.TempDir()
+	q := newRepairQueue(dir)
+	q.add("QmA", []string{"127.0.0.1"}, 2, "test")
+	q.addShards("QmB", []int{1}, "test")
+
+	// 重启后从状态文件恢复
+	q = newRepairQueue(dir)
+	if len(q.tasks) != 2 || q.tasks["QmA"] == nil || len(q.tasks["QmB"].Shards) != 1 {
+		t.Fatalf("tasks not restored: %+v", q.tasks)
+	}
+	q.done("QmA")
+	q.done("QmB")
+	if q = newRepairQueue(dir); len(q.tasks) != 0 {
+		t.Fatalf("finished tasks restored: %+v", q.tasks)
+	}
+}
+
+func TestRepairForgetsDeleted(t *testing.T) {
+	var nodes []*fakeIpfs
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+		nodes = append(nodes, newFakeIpfs(t, ip))
+	}
+	c := newTestIpfs(t, StorageConfig{Replication: 3, WriteQuorum: 2}, nodes...)
+	ctx := context.Background()
+
+	nodes[2].failAdd = true
+	cid, err := c.MultiStream(ctx, bytes.NewReader([]byte("hello world\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.UnderReplicated()) != 1 {
+		t.Fatalf("repair task not queued: %+v", c.UnderReplicated())
+	}
+	// 删除后不再补齐副本
+	if _, err = c.Delete(ctx, cid, false); err != nil {
+		t.Fatal(err)
+	}
+	if tasks := c.UnderReplicated(); len(tasks) != 0 {
+		t.Fatalf("deleted cid still queued: %+v", tasks)
+	}
+}
+
+func TestRepairSkipsUnreferenced(t *testing.T) {
+	var nodes []*fakeIpfs
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2"} {
+		nodes = append(nodes, newFakeIpfs(t, ip))
+	}
+	c := newTestIpfs(t, StorageConfig{Replication: 2}, nodes...)
+	refs := map[string]int{}
+	c.SetRefCounter(func(ctx context.Context, cid string) (int, error) {
+		return refs[cid], nil
+	})
+	// 模拟的节点pin时不从其它节点拉取数据
+	for _, n := range nodes {
+		n.blocks["QmA"], n.blocks["QmB"] = []byte("a"), []byte("b")
+	}
+	c.repairs.add("QmA", []string{"127.0.0.1"}, 2, "test")
+	c.repairs.add("QmB", []string{"127.0.0.1"}, 2, "test")
+
+	// 刚写入还没有保存元数据的cid等待宽限期
+	c.repair()
+	if len(c.UnderReplicated()) != 2 || nodes[1].pins["QmA"] {
+		t.Fatalf("unreferenced cid repaired or dropped in the grace period: %+v", c.UnderReplicated())
+	}
+	refs["QmA"] = 1
+	c.repairs.tasks["QmB"].Since = time.Now().Add(-2 * repairRefGrace)
+	c.repair()
+	if tasks := c.UnderReplicated(); len(tasks) != 0 {
+		t.Fatalf("tasks left: %+v", tasks)
+	}
+	if !nodes[1].pins["QmA"] {
+		t.Fatal("referenced cid not repaired")
+	}
+	if nodes[1].pins["QmB"] {
+		t.Fatal("unreferenced cid repaired")
+	}
+}
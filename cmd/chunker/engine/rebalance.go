@@ -0,0 +1,384 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"sort"
+	"sync"
+	"time"
+
+	shell "github.com/ipfs/go-ipfs-api"
+	"mtcloud.com/mtstorage/pkg/logger"
+)
+
+// 再平衡任务状态
+const (
+	REBALANCE_IDLE    = "idle"
+	REBALANCE_RUNNING = "running"
+	REBALANCE_PAUSED  = "paused"
+)
+
+const (
+	// 每批迁移的cid数, 每批结束后重新选择源节点和目标节点
+	rebalanceBatch = 100
+	// 默认阈值, 最满与最空节点的使用率相差不超过该值时视为均衡
+	rebalanceThreshold = 0.1
+)
+
+// ErrRebalanceNotSupported 存储类型不支持再平衡
+var ErrRebalanceNotSupported = errors.New("rebalance not supported by storage provider")
+
+// EndpointUsage 节点的空间使用情况
+type EndpointUsage struct {
+	Endpoint string  `json:"endpoint"`
+	Used     uint64  `json:"used"`
+	Max      uint64  `json:"max"`
+	Ratio    float64 `json:"ratio"`
+}
+
+// RebalanceStatus 再平衡任务的进度
+type RebalanceStatus struct {
+	State      string          `json:"state"`
+	Bandwidth  int             `json:"bandwidth"` //限速(MB/s), 0为不限速
+	Threshold  float64         `json:"threshold"` //使用率之差不超过该值时结束
+	Source     string          `json:"source,omitempty"`
+	Target     string          `json:"target,omitempty"`
+	Moved      int             `json:"moved"`  //已迁移的cid
+	Copied     int             `json:"copied"` //副本数不足, 复制后保留了源节点的副本
+	Failed     int             `json:"failed"`
+	Bytes      int64           `json:"bytes"`
+	LastError  string          `json:"lastError,omitempty"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+	Endpoints  []EndpointUsage `json:"endpoints"`
+}
+
+type rebalancing interface {
+	StartRebalance(bandwidth int, threshold float64) (RebalanceStatus, error)
+	PauseRebalance() RebalanceStatus
+	RebalanceStatus() RebalanceStatus
+}
+
+type rebalancer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	status RebalanceStatus
+	// 默认限速
+	bandwidth int
+	// 限速的计量起点, 启动和恢复时重置
+	since time.Time
+	sent  int64
+}
+
+func newRebalancer(bandwidth int) *rebalancer {
+	r := &rebalancer{
+		status:    RebalanceStatus{State: REBALANCE_IDLE},
+		bandwidth: bandwidth,
+	}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// start returns true if a new job should be launched
+func (r *rebalancer) start(bandwidth int, threshold float64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if bandwidth <= 0 {
+		bandwidth = r.bandwidth
+	}
+	if threshold <= 0 {
+		threshold = rebalanceThreshold
+	}
+	r.status.Bandwidth, r.status.Threshold = bandwidth, threshold
+	r.since, r.sent = time.Now(), 0
+	switch r.status.State {
+	case REBALANCE_RUNNING:
+		return false
+	case REBALANCE_PAUSED:
+		r.status.State = REBALANCE_RUNNING
+		r.cond.Broadcast()
+		logger.Info("再平衡任务已恢复")
+		return false
+	}
+	now := time.Now()
+	r.status = RebalanceStatus{State: REBALANCE_RUNNING, Bandwidth: bandwidth, Threshold: threshold, StartedAt: &now}
+	logger.Infof("再平衡任务开始, 限速: %d MB/s, 阈值: %.2f", bandwidth, threshold)
+	return true
+}
+
+func (r *rebalancer) pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status.State == REBALANCE_RUNNING {
+		r.status.State = REBALANCE_PAUSED
+		logger.Info("再平衡任务已暂停")
+	}
+}
+
+// wait blocks while the job is paused
+func (r *rebalancer) wait() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.status.State == REBALANCE_PAUSED {
+		r.cond.Wait()
+	}
+}
+
+func (r *rebalancer) finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.status.State = REBALANCE_IDLE
+	r.status.Source, r.status.Target = "", ""
+	r.status.FinishedAt = &now
+	if err != nil {
+		r.status.LastError = err.Error()
+		logger.Errorf("再平衡任务失败: %s", err)
+		return
+	}
+	logger.Infof("再平衡任务完成, 迁移: %d, 复制: %d, 失败: %d", r.status.Moved, r.status.Copied, r.status.Failed)
+}
+
+func (r *rebalancer) update(fn func(s *RebalanceStatus)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(&r.status)
+}
+
+func (r *rebalancer) get() RebalanceStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.status
+	s.Endpoints = append([]EndpointUsage(nil), r.status.Endpoints...)
+	return s
+}
+
+// throttle 按限速计算已传输数据应耗费的时间, 未到时等待
+func (r *rebalancer) throttle(n int64) {
+	r.mu.Lock()
+	r.sent += n
+	r.status.Bytes += n
+	bandwidth, sent, since := r.status.Bandwidth, r.sent, r.since
+	r.mu.Unlock()
+	if bandwidth <= 0 {
+		return
+	}
+	expect := time.Duration(float64(sent) / float64(bandwidth<<20) * float64(time.Second))
+	if d := expect - time.Since(since); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// throttledReader 读取时按再平衡的限速等待
+type throttledReader struct {
+	r  io.Reader
+	rb *rebalancer
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// 限制单次读取的数据量, 使等待更平滑
+	if len(p) > replicaChunk {
+		p = p[:replicaChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.rb.throttle(int64(n))
+	}
+	return n, err
+}
+
+// endpointUsage returns the usage of healthy store endpoints, the emptiest first.
+// 下线中的节点由下线任务迁移, 不参与再平衡
+func (c *Ipfs) endpointUsage() []EndpointUsage {
+	var usage []EndpointUsage
+	for _, host := range c.storeHosts() {
+		config := c.endpoints[host]
+		used, max := config.usage()
+		if config.draining() || max == 0 {
+			continue
+		}
+		usage = append(usage, EndpointUsage{
+			Endpoint: host,
+			Used:     used,
+			Max:      max,
+			Ratio:    float64(used) / float64(max),
+		})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Ratio < usage[j].Ratio })
+	return usage
+}
+
+// StartRebalance starts moving pins from the fullest endpoints to the emptiest ones, a paused job is resumed.
+// bandwidth is in MB/s and threshold is the usage ratio difference to stop at, defaults are used if they are not positive.
+func (c *Ipfs) StartRebalance(bandwidth int, threshold float64) (RebalanceStatus, error) {
+	if c.erasure.enabled() {
+		// 分片所在节点记录在清单中, 不能迁移
+		return c.RebalanceStatus(), ErrRebalanceNotSupported
+	}
+	if c.rebalancer.start(bandwidth, threshold) {
+		go c.rebalance()
+	}
+	return c.RebalanceStatus(), nil
+}
+
+// PauseRebalance pauses the job after the cid being moved
+func (c *Ipfs) PauseRebalance() RebalanceStatus {
+	c.rebalancer.pause()
+	return c.RebalanceStatus()
+}
+
+// RebalanceStatus returns the progress of the job and the current usage of endpoints
+func (c *Ipfs) RebalanceStatus() RebalanceStatus {
+	s := c.rebalancer.get()
+	s.Endpoints = c.endpointUsage()
+	return s
+}
+
+func (c *Ipfs) rebalance() {
+	for {
+		c.rebalancer.wait()
+		usage := c.endpointUsage()
+		if len(usage) < 2 {
+			c.rebalancer.finish(nil)
+			return
+		}
+		src, dst := usage[len(usage)-1], usage[0]
+		if src.Ratio-dst.Ratio <= c.rebalancer.get().Threshold {
+			c.rebalancer.finish(nil)
+			return
+		}
+		c.rebalancer.update(func(s *RebalanceStatus) {
+			s.Source, s.Target = src.Endpoint, dst.Endpoint
+		})
+
+		n, done, err := c.moveBatch(src, dst)
+		if err == nil && n != 0 && done == 0 {
+			err = fmt.Errorf("no cid could be moved from %s to %s", src.Endpoint, dst.Endpoint)
+		}
+		if err != nil {
+			c.rebalancer.finish(err)
+			return
+		}
+		if n == 0 {
+			// 源节点的cid都已在目标节点上
+			c.rebalancer.finish(nil)
+			return
+		}
+		// 取消pin后需垃圾回收才能释放空间, 之后刷新使用量
+		if cli := c.endpointClient(src.Endpoint); cli != nil {
+			repoGC(cli, src.Endpoint)
+		}
+		c.checkAlive()
+	}
+}
+
+// moveBatch pins at most rebalanceBatch cids of src onto dst until both have the same usage ratio,
+// the pin on src is removed only if the other replicas still meet the replication factor.
+// n is the number of cids tried, done is the number of cids pinned onto dst.
+func (c *Ipfs) moveBatch(src, dst EndpointUsage) (n, done int, err error) {
+	srcCli, dstCli := c.endpointClient(src.Endpoint), c.endpointClient(dst.Endpoint)
+	if srcCli == nil || dstCli == nil {
+		return 0, 0, errors.New("endpoint unavailable")
+	}
+	ctx := context.Background()
+	var out struct {
+		Keys map[string]interface{}
+	}
+	lctx, cancel := context.WithTimeout(ctx, c.readTimeout)
+	err = srcCli.Request("pin/ls").Option("type", "recursive").Exec(lctx, &out)
+	cancel()
+	if err != nil {
+		return 0, 0, err
+	}
+	cids := make([]string, 0, len(out.Keys))
+	for id := range out.Keys {
+		cids = append(cids, id)
+	}
+	sort.Strings(cids)
+
+	// 两个节点使用率相同时需迁移的数据量, 超出的cid跳过, 避免来回迁移
+	budget := (float64(src.Used)*float64(dst.Max) - float64(dst.Used)*float64(src.Max)) / float64(src.Max+dst.Max)
+	for _, id := range cids {
+		if n >= rebalanceBatch || budget <= 0 {
+			break
+		}
+		c.rebalancer.wait()
+
+		stat, err := srcCli.ObjectStat(id)
+		if err != nil {
+			logger.Errorf("ipfs节点 %s 获取 %s 大小失败: %s", src.Endpoint, id, err)
+			continue
+		}
+		if float64(stat.CumulativeSize) > budget {
+			continue
+		}
+		report, _ := c.Replicas(ctx, id)
+		onDst := false
+		for _, s := range report.Replicas {
+			onDst = onDst || (s.Endpoint == dst.Endpoint && s.Pinned)
+		}
+		if onDst {
+			continue
+		}
+		n++
+
+		err = c.transfer(ctx, srcCli, dstCli, id)
+		if err != nil {
+			logger.Errorf("ipfs节点 %s 迁移 %s 失败: %s", dst.Endpoint, id, err)
+			c.rebalancer.update(func(s *RebalanceStatus) { s.Failed++ })
+			continue
+		}
+		done++
+		budget -= float64(stat.CumulativeSize)
+
+		// 加上目标节点后, 除源节点外的副本数仍满足副本数要求时才取消源节点的pin
+		if report.Have >= report.Want {
+			if res := c.unpin(ctx, src.Endpoint, []string{id}, false); res.Error != "" {
+				c.rebalancer.update(func(s *RebalanceStatus) { s.Failed++ })
+			} else {
+				c.rebalancer.update(func(s *RebalanceStatus) { s.Moved++ })
+			}
+		} else {
+			c.rebalancer.update(func(s *RebalanceStatus) { s.Copied++ })
+		}
+	}
+	return n, done, nil
+}
+
+// transfer exports the dag of id from src as a car stream and imports it into dst with the roots pinned.
+// 数据经由chunker转发以便按限速读取, 由目标节点pin时自行拉取则无法限速
+func (c *Ipfs) transfer(ctx context.Context, src, dst *shell.Shell, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, repairTimeout)
+	defer cancel()
+	resp, err := src.Request("dag/export", id).Send(ctx)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", id+".car")
+		if err == nil {
+			_, err = io.Copy(part, &throttledReader{r: resp.Output, rb: c.rebalancer})
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	err = dst.Request("dag/import").
+		Option("pin-roots", true).
+		Header("Content-Type", mw.FormDataContentType()).
+		Body(pr).
+		Exec(ctx, nil)
+	pr.CloseWithError(err)
+	return err
+}
@@ -0,0 +1,32 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRebalanceTransfer(t *testing.T) {
+	src, dst := newFakeIpfs(t, "127.0.0.1"), newFakeIpfs(t, "127.0.0.2")
+	c := newTestIpfs(t, StorageConfig{Replication: 1}, src, dst)
+	cid := "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+	data := bytes.Repeat([]byte("x"), 512<<10)
+	src.blocks[cid] = data
+
+	// 限速1MB/s时传输512KB至少需要约0.5秒
+	c.rebalancer.start(1, 0)
+	start := time.Now()
+	if err := c.transfer(context.Background(), c.endpointClient("127.0.0.1"), c.endpointClient("127.0.0.2"), cid); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatalf("transfer not throttled: %s", d)
+	}
+	if !dst.pins[cid] || !bytes.Equal(dst.blocks[cid], data) {
+		t.Fatal("dag not imported into the target")
+	}
+	if s := c.rebalancer.get(); s.Bytes < int64(len(data)) || s.Threshold != rebalanceThreshold {
+		t.Fatalf("unexpected status: %+v", s)
+	}
+}
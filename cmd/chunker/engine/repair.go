@@ -0,0 +1,283 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mtcloud.com/mtstorage/pkg/logger"
+)
+
+// 单次补齐副本的超时时间, pin时节点需要从其它节点拉取数据
+const repairTimeout = 10 * time.Minute
+
+// repairStateFile 修复队列在StateDir中的保存文件, 重启后继续修复
+const repairStateFile = "repair.json"
+
+// 数据先写入, 元数据随后保存, 加入队列超过该时间仍没有被引用的cid不再修复
+const repairRefGrace = 10 * time.Minute
+
+// RefCounter counts the objects and multipart parts referencing cid
+type RefCounter func(ctx context.Context, cid string) (int, error)
+
+// RepairTask 副本数不足的cid, 由后台任务在其它节点上补齐.
+// 纠删码对象的Shards为写入失败的分片序号, 由其余分片重建
+type RepairTask struct {
+	Cid      string    `json:"cid"`
+	Holders  []string  `json:"holders"`
+	Want     int       `json:"want"`
+	Shards   []int     `json:"shards,omitempty"`
+	Reason   string    `json:"reason"`
+	Attempts int       `json:"attempts"`
+	Since    time.Time `json:"since"`
+}
+
+type repairQueue struct {
+	mu    sync.Mutex
+	tasks map[string]*RepairTask
+	path  string // 为空时不保存
+}
+
+// newRepairQueue loads the tasks saved in dir, the queue is kept in memory only if dir is empty
+func newRepairQueue(dir string) *repairQueue {
+	q := &repairQueue{tasks: make(map[string]*RepairTask)}
+	if dir == "" {
+		return q
+	}
+	q.path = filepath.Join(dir, repairStateFile)
+	data, err := ioutil.ReadFile(q.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Errorf("读取修复队列 %s 失败: %s", q.path, err)
+		}
+		return q
+	}
+	var tasks []*RepairTask
+	if err = json.Unmarshal(data, &tasks); err != nil {
+		logger.Errorf("解析修复队列 %s 失败: %s", q.path, err)
+		return q
+	}
+	for _, t := range tasks {
+		q.tasks[t.Cid] = t
+	}
+	logger.Infof("已加载 %d 个修复任务", len(tasks))
+	return q
+}
+
+// save writes the tasks to the state file, the caller holds the lock
+func (q *repairQueue) save() {
+	if q.path == "" {
+		return
+	}
+	tasks := make([]*RepairTask, 0, len(q.tasks))
+	for _, t := range q.tasks {
+		tasks = append(tasks, t)
+	}
+	data, err := json.Marshal(tasks)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(q.path), 0755)
+	}
+	if err == nil {
+		// 先写临时文件再重命名, 避免写入中断后文件损坏
+		tmp := q.path + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, q.path)
+		}
+	}
+	if err != nil {
+		logger.Errorf("保存修复队列 %s 失败: %s", q.path, err)
+	}
+}
+
+func (q *repairQueue) add(cid string, holders []string, want int, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.save()
+	if t, ok := q.tasks[cid]; ok {
+		t.Reason = reason
+		return
+	}
+	q.tasks[cid] = &RepairTask{
+		Cid:     cid,
+		Holders: append([]string(nil), holders...),
+		Want:    want,
+		Reason:  reason,
+		Since:   time.Now(),
+	}
+	logger.Warnf("%s 副本不足, 已加入修复队列: have %d, want %d, %s", cid, len(holders), want, reason)
+}
+
+// addShards queues the missing shards of the erasure coded object cid
+func (q *repairQueue) addShards(cid string, shards []int, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.save()
+	if t, ok := q.tasks[cid]; ok {
+		t.Shards, t.Reason = append([]int(nil), shards...), reason
+		return
+	}
+	q.tasks[cid] = &RepairTask{
+		Cid:    cid,
+		Shards: append([]int(nil), shards...),
+		Reason: reason,
+		Since:  time.Now(),
+	}
+	logger.Warnf("%s 分片缺失, 已加入修复队列: %v, %s", cid, shards, reason)
+}
+
+// missingShards returns the shards of cid waiting to be rebuilt
+func (q *repairQueue) missingShards(cid string) []int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if t, ok := q.tasks[cid]; ok {
+		return append([]int(nil), t.Shards...)
+	}
+	return nil
+}
+
+func (q *repairQueue) done(cid string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.tasks, cid)
+	q.save()
+}
+
+// list returns a copy of the tasks, the oldest first
+func (q *repairQueue) list() []RepairTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	tasks := make([]RepairTask, 0, len(q.tasks))
+	for _, t := range q.tasks {
+		task := *t
+		task.Holders = append([]string(nil), t.Holders...)
+		task.Shards = append([]int(nil), t.Shards...)
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Since.Before(tasks[j].Since) })
+	return tasks
+}
+
+// holder records a new replica of cid, the task is done once it has enough replicas
+func (q *repairQueue) holder(cid, host string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t, ok := q.tasks[cid]
+	if !ok {
+		return
+	}
+	t.Holders = append(t.Holders, host)
+	if len(t.Holders) >= t.Want {
+		delete(q.tasks, cid)
+		logger.Infof("%s 副本已补齐", cid)
+	}
+	q.save()
+}
+
+func (q *repairQueue) retry(cid string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if t, ok := q.tasks[cid]; ok {
+		t.Attempts++
+		q.save()
+	}
+}
+
+// UnderReplicated returns the cids waiting for repair
+func (c *Ipfs) UnderReplicated() []RepairTask {
+	return c.repairs.list()
+}
+
+// SetRefCounter sets the reference check before the repair
+func (c *Ipfs) SetRefCounter(refs RefCounter) {
+	c.refs = refs
+}
+
+// forget drops the tasks of the deleted cid and its shards, so that the repair does not pin them again
+func (c *Ipfs) forget(cid string, man *erasureManifest) {
+	c.repairs.done(strings.TrimPrefix(cid, "/ipfs/"))
+	if man != nil {
+		for _, s := range man.Shards {
+			c.repairs.done(s.Cid)
+		}
+	}
+}
+
+// referenced 统计失败时按仍被引用处理, 不再被引用的任务在宽限期后移出队列
+func (c *Ipfs) referenced(t RepairTask) bool {
+	if c.refs == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), statTimeout)
+	count, err := c.refs(ctx, t.Cid)
+	cancel()
+	if err != nil {
+		logger.Errorf("统计 %s 的引用失败: %s", t.Cid, err)
+		return true
+	}
+	if count > 0 {
+		return true
+	}
+	if time.Since(t.Since) > repairRefGrace {
+		logger.Infof("%s 已不再被引用, 移出修复队列", t.Cid)
+		c.repairs.done(t.Cid)
+	}
+	return false
+}
+
+// repair pins under-replicated cids on healthy endpoints which do not hold them yet
+func (c *Ipfs) repair() {
+	for _, t := range c.repairs.list() {
+		if !c.referenced(t) {
+			continue
+		}
+		if len(t.Shards) > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), repairTimeout)
+			err := c.rebuildShards(ctx, t.Cid, t.Shards)
+			cancel()
+			if err != nil {
+				logger.Errorf("重建 %s 的分片 %v 失败: %s", t.Cid, t.Shards, err)
+				c.repairs.retry(t.Cid)
+				continue
+			}
+			c.repairs.done(t.Cid)
+			logger.Infof("%s 的分片已重建", t.Cid)
+			continue
+		}
+		holders := make(map[string]bool, len(t.Holders))
+		for _, h := range t.Holders {
+			holders[h] = true
+		}
+		var candidates []string
+		for _, h := range c.writeHosts() {
+			if !holders[h] {
+				candidates = append(candidates, h)
+			}
+		}
+		hosts := c.selector.Select(candidates, t.Want-len(t.Holders), t.Cid)
+		if len(hosts) == 0 {
+			c.repairs.retry(t.Cid)
+			continue
+		}
+		for _, host := range hosts {
+			cli := c.endpointClient(host)
+			if cli == nil {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), repairTimeout)
+			err := cli.Request("pin/add", t.Cid).Option("recursive", true).Exec(ctx, nil)
+			cancel()
+			if err != nil {
+				logger.Errorf("ipfs节点 %s 补齐副本 %s 失败: %s", host, t.Cid, err)
+				c.repairs.retry(t.Cid)
+				continue
+			}
+			c.repairs.holder(t.Cid, host)
+		}
+	}
+}
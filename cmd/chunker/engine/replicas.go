@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/pkg/logger"
+)
+
+// ErrReplicaNotSupported 存储类型不支持按节点管理副本
+var ErrReplicaNotSupported = errors.New("replica management not supported by storage provider")
+
+// ReplicaStatus cid在单个节点上的状态
+type ReplicaStatus struct {
+	Endpoint string `json:"endpoint"`
+	Healthy  bool   `json:"healthy"`
+	Pinned   bool   `json:"pinned"`
+	Draining bool   `json:"draining,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ReplicaReport cid的副本情况, Have不包括下线中节点上的副本, Added为本次修复新增的节点
+type ReplicaReport struct {
+	Cid      string          `json:"cid"`
+	Want     int             `json:"want"`
+	Have     int             `json:"have"`
+	Replicas []ReplicaStatus `json:"replicas"`
+	Added    []string        `json:"added,omitempty"`
+}
+
+type replicator interface {
+	Replicas(ctx context.Context, cid string) (ReplicaReport, error)
+	Replicate(ctx context.Context, cid string) (ReplicaReport, error)
+}
+
+// want returns the replication factor, -1 means every store endpoint which is not draining
+func (c *Ipfs) want() int {
+	if c.replication > 0 {
+		return c.replication
+	}
+	n := 0
+	for _, config := range c.endpoints {
+		if config.CanStore && !config.draining() {
+			n++
+		}
+	}
+	return n
+}
+
+// Replicas checks whether cid is pinned on each store endpoint
+func (c *Ipfs) Replicas(ctx context.Context, cid string) (ReplicaReport, error) {
+	ctx, span := trace.StartSpan(ctx, "replicasOfIPFS")
+	defer span.End()
+
+	cid = strings.TrimPrefix(cid, "/ipfs/")
+	report := ReplicaReport{Cid: cid, Want: c.want()}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for host, config := range c.endpoints {
+		if !config.CanStore {
+			continue
+		}
+		wg.Add(1)
+		go func(host string, draining bool) {
+			defer wg.Done()
+			status := ReplicaStatus{Endpoint: host, Draining: draining}
+			if cli := c.endpointClient(host); cli != nil {
+				status.Healthy = true
+				rctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+				var out struct {
+					Keys map[string]interface{}
+				}
+				err := cli.Request("pin/ls", cid).Option("type", "recursive").Exec(rctx, &out)
+				cancel()
+				if err == nil {
+					status.Pinned = len(out.Keys) > 0
+				} else if !strings.Contains(err.Error(), "not pinned") {
+					status.Error = err.Error()
+				}
+			}
+			mu.Lock()
+			report.Replicas = append(report.Replicas, status)
+			if status.Pinned && !status.Draining {
+				report.Have++
+			}
+			mu.Unlock()
+		}(host, config.draining())
+	}
+	wg.Wait()
+	sort.Slice(report.Replicas, func(i, j int) bool { return report.Replicas[i].Endpoint < report.Replicas[j].Endpoint })
+	span.AddAttributes(trace.Int64Attribute("have", int64(report.Have)), trace.Int64Attribute("want", int64(report.Want)))
+	return report, nil
+}
+
+// Replicate pins cid onto healthy endpoints until it reaches the replication factor,
+// the endpoints fetch the blocks from the ones still holding it.
+func (c *Ipfs) Replicate(ctx context.Context, cid string) (ReplicaReport, error) {
+	report, err := c.Replicas(ctx, cid)
+	if err != nil {
+		return report, err
+	}
+	if report.Have >= report.Want {
+		return report, nil
+	}
+
+	// 下线中节点上的副本不计入副本数, 但仍可作为数据来源
+	held := false
+	var candidates []string
+	for _, s := range report.Replicas {
+		held = held || s.Pinned
+		if s.Healthy && !s.Pinned && !s.Draining && s.Error == "" {
+			candidates = append(candidates, s.Endpoint)
+		}
+	}
+	if !held {
+		return report, fmt.Errorf("no replica of %s left", report.Cid)
+	}
+	for _, host := range c.selector.Select(candidates, report.Want-report.Have, report.Cid) {
+		cli := c.endpointClient(host)
+		if cli == nil {
+			continue
+		}
+		rctx, cancel := context.WithTimeout(ctx, repairTimeout)
+		err = cli.Request("pin/add", report.Cid).Option("recursive", true).Exec(rctx, nil)
+		cancel()
+		if err != nil {
+			logger.Errorf("ipfs节点 %s 补齐副本 %s 失败: %s", host, report.Cid, err)
+			continue
+		}
+		report.Have++
+		report.Added = append(report.Added, host)
+		for i := range report.Replicas {
+			if report.Replicas[i].Endpoint == host {
+				report.Replicas[i].Pinned = true
+			}
+		}
+		c.repairs.holder(report.Cid, host)
+	}
+	if report.Have < report.Want {
+		return report, fmt.Errorf("%s still under-replicated, have: %d, want: %d", report.Cid, report.Have, report.Want)
+	}
+	return report, nil
+}
@@ -0,0 +1,426 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cid2 "github.com/ipfs/go-cid"
+	shell "github.com/ipfs/go-ipfs-api"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/pkg/crypto"
+	"mtcloud.com/mtstorage/pkg/logger"
+)
+
+// S3 stores content-addressed blobs on s3 compatible targets,
+// each endpoint key of the "s3" target is the host of a s3 service.
+type S3 struct {
+	replication int
+	endpoints   map[string]*EndpointConfig
+	clients     map[string]*minio.Client
+
+	usageMu sync.Mutex
+	usage   map[string]RepoStat // 各节点的使用量, 由后台定时列举bucket更新
+}
+
+// s3UsageInterval 列举bucket统计使用量的间隔, 心跳上报使用缓存的结果
+const s3UsageInterval = 10 * time.Minute
+
+func newS3(c StorageConfig) Storage {
+	sc := &S3{
+		replication: c.Replication,
+		clients:     make(map[string]*minio.Client),
+		usage:       make(map[string]RepoStat),
+	}
+	sc.endpoints = c.Targets[STORAGE_S3].Endpoints
+	for host, config := range sc.endpoints {
+		endpoint := host
+		if config.Http > 0 {
+			endpoint = fmt.Sprintf("%s:%d", host, config.Http)
+		}
+		secret := config.SecretKey
+		if secret != "" {
+			secret = crypto.DecryptLocalPassword(secret)
+		}
+		cli, err := minio.New(endpoint, &minio.Options{
+			Creds:  credentials.NewStaticV4(config.AccessKey, secret, ""),
+			Secure: config.Secure,
+		})
+		if err != nil {
+			logger.Errorf("s3节点 %s 初始化失败: %s", endpoint, err)
+			continue
+		}
+		sc.clients[host] = cli
+	}
+	return sc
+}
+
+func (c *S3) allocate() ([]string, error) {
+	var hosts []string
+	for host, config := range c.endpoints {
+		if !config.CanStore || !config.healthy() {
+			continue
+		}
+		hosts = append(hosts, host)
+		if len(hosts) == c.replication {
+			break
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("no endpoint found")
+	}
+	if c.replication > 0 && len(hosts) != c.replication {
+		err := fmt.Errorf("not enough node to allocate, need: %d, have: %d", c.replication, len(hosts))
+		return hosts, err
+	}
+	return hosts, nil
+}
+
+// stat finds the first healthy target that holds cid
+func (c *S3) stat(ctx context.Context, cid string) (string, minio.ObjectInfo, error) {
+	id, err := cid2.Decode(cid)
+	if err != nil {
+		return "", minio.ObjectInfo{}, err
+	}
+	key := blobKey(id)
+	err = fmt.Errorf("block %s not found", cid)
+	for host, config := range c.endpoints {
+		if !config.healthy() {
+			continue
+		}
+		info, e := c.clients[host].StatObject(ctx, config.Bucket, key, minio.StatObjectOptions{})
+		if e != nil {
+			if minio.ToErrorResponse(e).Code != "NoSuchKey" {
+				err = e
+			}
+			continue
+		}
+		return host, info, nil
+	}
+	return "", minio.ObjectInfo{}, err
+}
+
+func (c *S3) Write(ctx context.Context, file io.Reader) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "writeDataToS3")
+	defer span.End()
+
+	hosts, err := c.allocate()
+	if err != nil {
+		logger.Error(err)
+		return "", err
+	}
+
+	// 对象key由cid决定, 先落盘计算cid
+	tmp, err := ioutil.TempFile("", "s3-blob-")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	h := newUnixfsHasher()
+	size, err := io.Copy(io.MultiWriter(tmp, h), file)
+	if err != nil {
+		return "", err
+	}
+	cid := h.Cid()
+	key := blobKey(cid)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(hosts))
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(index int, host string) {
+			defer wg.Done()
+			config := c.endpoints[host]
+			cli := c.clients[host]
+			// key由内容决定, 已存在时不再上传也不重复计数
+			if _, err := cli.StatObject(ctx, config.Bucket, key, minio.StatObjectOptions{}); err == nil {
+				return
+			}
+			_, err := cli.PutObject(ctx, config.Bucket, key, io.NewSectionReader(tmp, 0, size), size,
+				minio.PutObjectOptions{ContentType: "application/octet-stream"})
+			if err != nil {
+				logger.Errorf("s3节点 %s 上传失败: %s", host, err)
+				errs[index] = err
+				return
+			}
+			c.addUsage(host, 1, size)
+		}(i, host)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return "", err
+		}
+	}
+	return cid.String(), nil
+}
+
+func (c *S3) Read(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+	host, info, err := c.stat(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	opts := minio.GetObjectOptions{}
+	if length == 0 || offset >= info.Size {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	if offset > 0 || length > 0 {
+		end := info.Size - 1
+		if length > 0 && offset+length-1 < end {
+			end = offset + length - 1
+		}
+		if err := opts.SetRange(offset, end); err != nil {
+			return nil, err
+		}
+	}
+	// minio.Object持有连接, 由调用方读取后关闭
+	return c.clients[host].GetObject(ctx, c.endpoints[host].Bucket, info.Key, opts)
+}
+
+func (c *S3) Delete(ctx context.Context, cid string, gc bool) ([]DeleteResult, error) {
+	id, err := cid2.Decode(cid)
+	if err != nil {
+		return nil, err
+	}
+	key := blobKey(id)
+	var results []DeleteResult
+	for host, config := range c.endpoints {
+		res := DeleteResult{Endpoint: host}
+		if !config.healthy() {
+			res.Error = "endpoint unavailable"
+			results = append(results, res)
+			continue
+		}
+		cli := c.clients[host]
+		if info, err := cli.StatObject(ctx, config.Bucket, key, minio.StatObjectOptions{}); err != nil {
+			if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+				res.Error = err.Error()
+			}
+		} else if err := cli.RemoveObject(ctx, config.Bucket, key, minio.RemoveObjectOptions{}); err != nil {
+			logger.Errorf("s3节点 %s 删除失败: %s", host, err)
+			res.Error = err.Error()
+		} else {
+			res.Deleted = true
+			c.addUsage(host, -1, -info.Size)
+		}
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Endpoint < results[j].Endpoint })
+	return results, deleteError(results)
+}
+
+func (c *S3) Stat(ctx context.Context, cid string) error {
+	_, info, err := c.stat(ctx, cid)
+	if err != nil {
+		return err
+	}
+	if info.Size <= 0 {
+		return errors.New("block size unexpected")
+	}
+	return nil
+}
+
+// DagTree blobs are stored whole without the dag, so the tree has no links
+func (c *S3) DagTree(ctx context.Context, cid string) (interface{}, error) {
+	size, err := c.DagSize(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"Hash":  cid,
+		"Size":  size,
+		"Links": []interface{}{},
+	}, nil
+}
+
+func (c *S3) DagSize(ctx context.Context, cid string) (string, error) {
+	_, info, err := c.stat(ctx, cid)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d", info.Size), nil
+}
+
+func (c *S3) FileSize(ctx context.Context, cid string) (int64, error) {
+	_, info, err := c.stat(ctx, cid)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// Pin objects on s3 are always kept, only check existence
+func (c *S3) Pin(path string) error {
+	id, err := parseCidPath(path)
+	if err != nil {
+		return err
+	}
+	_, _, err = c.stat(context.Background(), id.String())
+	return err
+}
+
+// Unpin there is no repo gc on s3, remove the object directly
+func (c *S3) Unpin(path string) error {
+	id, err := parseCidPath(path)
+	if err != nil {
+		return err
+	}
+	_, err = c.Delete(context.Background(), id.String(), false)
+	return err
+}
+
+func (c *S3) List(cid string) ([]*shell.LsLink, error) {
+	if _, err := cid2.Decode(cid); err != nil {
+		return nil, err
+	}
+	return []*shell.LsLink{}, nil
+}
+
+// RepoStat 使用量来自最近一次列举的结果, 不在心跳中列举整个bucket
+func (c *S3) RepoStat() (RepoStat, error) {
+	stat := RepoStat{Version: STORAGE_S3}
+	c.usageMu.Lock()
+	defer c.usageMu.Unlock()
+	for host, config := range c.endpoints {
+		if !config.CanStore || !config.healthy() {
+			continue
+		}
+		stat.StorageMax += config.Capacity
+		u := c.usage[host]
+		stat.NumObjects += u.NumObjects
+		stat.RepoSize += u.RepoSize
+	}
+	return stat, nil
+}
+
+// addUsage updates the cached usage of host between two listings
+func (c *S3) addUsage(host string, objects, size int64) {
+	c.usageMu.Lock()
+	defer c.usageMu.Unlock()
+	u := c.usage[host]
+	u.NumObjects = uint64(int64(u.NumObjects) + objects)
+	u.RepoSize = uint64(int64(u.RepoSize) + size)
+	c.usage[host] = u
+}
+
+// Admit checks whether enough endpoints have room for size bytes by the cached usage,
+// endpoints without a configured capacity are not limited.
+func (c *S3) Admit(size int64) error {
+	need := c.replication
+	if need <= 0 {
+		need = 1
+	}
+	have := 0
+	c.usageMu.Lock()
+	for host, config := range c.endpoints {
+		if !config.CanStore || !config.healthy() {
+			continue
+		}
+		if config.Capacity == 0 || size < 0 || c.usage[host].RepoSize+uint64(size) <= config.Capacity {
+			have++
+		}
+	}
+	c.usageMu.Unlock()
+	if have < need {
+		logger.Errorf("存储空间不足, 需要 %d 个节点各有 %d 字节可用空间, 满足的节点: %d", need, size, have)
+		return ErrStorageFull
+	}
+	return nil
+}
+
+// refreshUsage lists the buckets to count the objects and their size
+func (c *S3) refreshUsage() {
+	for host, config := range c.endpoints {
+		cli, ok := c.clients[host]
+		if !ok || !config.CanStore {
+			continue
+		}
+		u := RepoStat{}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		var err error
+		for obj := range cli.ListObjects(ctx, config.Bucket, minio.ListObjectsOptions{Recursive: true}) {
+			if obj.Err != nil {
+				err = obj.Err
+				break
+			}
+			u.NumObjects++
+			u.RepoSize += uint64(obj.Size)
+		}
+		cancel()
+		if err != nil {
+			logger.Errorf("s3节点 %s 统计使用量失败: %s", host, err)
+			continue
+		}
+		c.usageMu.Lock()
+		c.usage[host] = u
+		c.usageMu.Unlock()
+	}
+}
+
+func (c *S3) Start() error {
+	for host, config := range c.endpoints {
+		cli, ok := c.clients[host]
+		if !ok || !config.CanStore {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		exists, err := cli.BucketExists(ctx, config.Bucket)
+		if err == nil && !exists {
+			err = cli.MakeBucket(ctx, config.Bucket, minio.MakeBucketOptions{})
+		}
+		cancel()
+		if err != nil {
+			logger.Errorf("s3节点 %s 创建bucket失败: %s", host, err)
+		}
+	}
+
+	c.checkAlive()
+	go func() {
+		c.refreshUsage()
+		ticker := time.NewTicker(s3UsageInterval)
+		for range ticker.C {
+			c.refreshUsage()
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		for {
+			select {
+			case <-ticker.C:
+				c.checkAlive()
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *S3) checkAlive() {
+	for host, config := range c.endpoints {
+		cli, ok := c.clients[host]
+		if !ok {
+			config.setHealth(false)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		exists, err := cli.BucketExists(ctx, config.Bucket)
+		cancel()
+		if err != nil || !exists {
+			logger.Errorf("s3节点连接异常:  %s", host)
+			config.setHealth(false)
+		} else {
+			config.setHealth(true)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 模拟s3服务的path-style请求, 对象保存在内存中
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string][]byte
+	puts    int // 上传次数
+	srv     *httptest.Server
+}
+
+// newFakeS3 starts a fake s3 service listening on ip, like newFakeIpfs
+func newFakeS3(t *testing.T, ip string) *fakeS3 {
+	s := &fakeS3{buckets: make(map[string]map[string][]byte)}
+	l, err := net.Listen("tcp", ip+":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.srv = httptest.NewUnstartedServer(http.HandlerFunc(s.serve))
+	s.srv.Listener.Close()
+	s.srv.Listener = l
+	s.srv.Start()
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+func (s *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, objects := path[0], s.buckets[path[0]]
+	if len(path) == 1 || path[1] == "" {
+		s.serveBucket(w, r, bucket, objects)
+		return
+	}
+	key := path[1]
+	if objects == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	data, ok := objects[key]
+	switch r.Method {
+	case http.MethodPut:
+		body, err := readStreamingBody(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		objects[key] = body
+		s.puts++
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodHead, http.MethodGet:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code><Key>%s</Key></Error>", key)
+			}
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		status := http.StatusOK
+		if rng := r.Header.Get("Range"); rng != "" && r.Method == http.MethodGet {
+			var start, end int
+			fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			data, status = data[start:end+1], http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *fakeS3) serveBucket(w http.ResponseWriter, r *http.Request, bucket string, objects map[string][]byte) {
+	switch {
+	case r.Method == http.MethodPut:
+		s.buckets[bucket] = make(map[string][]byte)
+	case r.Method == http.MethodHead:
+		if objects == nil {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.URL.Query().Has("location"):
+		fmt.Fprint(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`)
+	default:
+		type content struct {
+			Key          string
+			Size         int
+			LastModified time.Time
+			ETag         string
+		}
+		result := struct {
+			XMLName     xml.Name `xml:"ListBucketResult"`
+			Name        string
+			IsTruncated bool
+			Contents    []content
+		}{Name: bucket}
+		for key, data := range objects {
+			result.Contents = append(result.Contents, content{Key: key, Size: len(data), LastModified: time.Now().UTC(), ETag: `"etag"`})
+		}
+		xml.NewEncoder(w).Encode(result)
+	}
+}
+
+// readStreamingBody decodes the aws-chunked body the client signs over http
+func readStreamingBody(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return ioutil.ReadAll(r.Body)
+	}
+	var body []byte
+	rd := bufio.NewReader(r.Body)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.SplitN(line, ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		chunk := make([]byte, size+2)
+		if _, err = io.ReadFull(rd, chunk); err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return body, nil
+		}
+		body = append(body, chunk[:size]...)
+	}
+}
+
+func newTestS3(t *testing.T, replication int, servers ...*fakeS3) *S3 {
+	endpoints := make(map[string]*EndpointConfig)
+	for _, s := range servers {
+		// bucket已存在, 不启动后台统计, 使用量只由读写路径更新
+		s.buckets["blobs"] = make(map[string][]byte)
+		addr := s.srv.Listener.Addr().(*net.TCPAddr)
+		endpoints[addr.IP.String()] = &EndpointConfig{Http: addr.Port, CanStore: true, CanRead: true, Bucket: "blobs", Capacity: 100}
+	}
+	c := newS3(StorageConfig{
+		Replication: replication,
+		Targets:     map[string]EngineConfig{STORAGE_S3: {Endpoints: endpoints}},
+	}).(*S3)
+	c.checkAlive()
+	return c
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	s1, s2 := newFakeS3(t, "127.0.0.1"), newFakeS3(t, "127.0.0.2")
+	ctx := context.Background()
+	c := newTestS3(t, 2, s1, s2)
+	cid, err := c.Write(ctx, strings.NewReader("hello world\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cid != "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o" {
+		t.Fatalf("cid mismatch: %s", cid)
+	}
+	// 已存在的对象不重复上传和计数
+	if _, err = c.Write(ctx, strings.NewReader("hello world\n")); err != nil {
+		t.Fatal(err)
+	}
+	if s1.puts != 1 || s2.puts != 1 {
+		t.Fatalf("unexpected uploads: %d, %d", s1.puts, s2.puts)
+	}
+	stat, err := c.RepoStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.NumObjects != 2 || stat.RepoSize != 24 || stat.StorageMax != 200 {
+		t.Fatalf("unexpected usage: %+v", stat)
+	}
+	// 写入后的使用量立即用于准入判断
+	if err = c.Admit(80); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Admit(90); err != ErrStorageFull {
+		t.Fatalf("want ErrStorageFull, got %v", err)
+	}
+
+	cases := []struct {
+		offset, length int64
+		want           string
+	}{
+		{offset: 0, length: -1, want: "hello world\n"},
+		{offset: 6, length: 5, want: "world"},
+		{offset: 6, length: -1, want: "world\n"},
+	}
+	for _, tc := range cases {
+		rd, err := c.Read(ctx, cid, tc.offset, tc.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rd.Close()
+		if string(b) != tc.want {
+			t.Fatalf("read [%d, %d): got %q - want %q", tc.offset, tc.length, b, tc.want)
+		}
+	}
+
+	results, err := c.Delete(ctx, cid, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range results {
+		if !res.Deleted {
+			t.Fatalf("not deleted on %s", res.Endpoint)
+		}
+	}
+	if _, err = c.Read(ctx, cid, 0, -1); err == nil {
+		t.Fatal("read after delete should fail")
+	}
+	if stat, _ = c.RepoStat(); stat.NumObjects != 0 || stat.RepoSize != 0 {
+		t.Fatalf("unexpected usage after delete: %+v", stat)
+	}
+
+	// 列举bucket得到的使用量覆盖缓存
+	if _, err = c.Write(ctx, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	c.usage = make(map[string]RepoStat)
+	c.refreshUsage()
+	if stat, _ = c.RepoStat(); stat.NumObjects != 2 || stat.RepoSize != 10 {
+		t.Fatalf("unexpected usage after listing: %+v", stat)
+	}
+}
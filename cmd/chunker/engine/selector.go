@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"hash/crc32"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 节点选择策略
+const (
+	SELECT_RANDOM          = "random"
+	SELECT_ROUND_ROBIN     = "roundrobin"
+	SELECT_LEAST_USED      = "leastused"
+	SELECT_CONSISTENT_HASH = "consistenthash"
+)
+
+// Selector picks endpoints for reads and writes
+type Selector interface {
+	// Select returns at most n hosts from candidates, key is the cid if it is known
+	Select(candidates []string, n int, key string) []string
+}
+
+// usageFunc returns the used space of the endpoint
+type usageFunc func(host string) uint64
+
+func newSelector(policy string, usage usageFunc) Selector {
+	switch policy {
+	case SELECT_ROUND_ROBIN:
+		return &roundRobinSelector{}
+	case SELECT_LEAST_USED:
+		return &leastUsedSelector{usage: usage}
+	case SELECT_CONSISTENT_HASH:
+		return &consistentHashSelector{replicas: 64}
+	default:
+		return &randomSelector{}
+	}
+}
+
+var (
+	rndMu sync.Mutex
+	rnd   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func randPerm(n int) []int {
+	rndMu.Lock()
+	defer rndMu.Unlock()
+	return rnd.Perm(n)
+}
+
+func limit(hosts []string, n int) []string {
+	if n >= 0 && len(hosts) > n {
+		return hosts[:n]
+	}
+	return hosts
+}
+
+type randomSelector struct{}
+
+func (s *randomSelector) Select(candidates []string, n int, key string) []string {
+	hosts := make([]string, len(candidates))
+	for i, j := range randPerm(len(candidates)) {
+		hosts[i] = candidates[j]
+	}
+	return limit(hosts, n)
+}
+
+type roundRobinSelector struct {
+	next uint64
+}
+
+func (s *roundRobinSelector) Select(candidates []string, n int, key string) []string {
+	if len(candidates) == 0 {
+		return nil
+	}
+	sorted := append([]string(nil), candidates...)
+	sort.Strings(sorted)
+	start := int(atomic.AddUint64(&s.next, 1) % uint64(len(sorted)))
+	return limit(append(sorted[start:], sorted[:start]...), n)
+}
+
+type leastUsedSelector struct {
+	usage usageFunc
+}
+
+func (s *leastUsedSelector) Select(candidates []string, n int, key string) []string {
+	hosts := append([]string(nil), candidates...)
+	used := make(map[string]uint64, len(hosts))
+	for _, h := range hosts {
+		used[h] = s.usage(h)
+	}
+	sort.SliceStable(hosts, func(i, j int) bool {
+		if used[hosts[i]] == used[hosts[j]] {
+			return hosts[i] < hosts[j]
+		}
+		return used[hosts[i]] < used[hosts[j]]
+	})
+	return limit(hosts, n)
+}
+
+// consistentHashSelector 按cid在哈希环上选择节点, 写入时cid未知则随机选择
+type consistentHashSelector struct {
+	replicas int
+}
+
+func (s *consistentHashSelector) Select(candidates []string, n int, key string) []string {
+	if key == "" {
+		return (&randomSelector{}).Select(candidates, n, key)
+	}
+	type vnode struct {
+		hash uint32
+		host string
+	}
+	ring := make([]vnode, 0, len(candidates)*s.replicas)
+	for _, h := range candidates {
+		for i := 0; i < s.replicas; i++ {
+			ring = append(ring, vnode{crc32.ChecksumIEEE([]byte(h + "#" + strconv.Itoa(i))), h})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= hash })
+	var hosts []string
+	seen := make(map[string]bool, len(candidates))
+	for i := 0; i < len(ring) && len(seen) < len(candidates); i++ {
+		h := ring[(start+i)%len(ring)].host
+		if !seen[h] {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+	return limit(hosts, n)
+}
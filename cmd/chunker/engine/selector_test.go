@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var selectorHosts = []string{"10.0.0.3", "10.0.0.1", "10.0.0.2"}
+
+func TestSelectorLimit(t *testing.T) {
+	for _, policy := range []string{SELECT_RANDOM, SELECT_ROUND_ROBIN, SELECT_LEAST_USED, SELECT_CONSISTENT_HASH} {
+		s := newSelector(policy, func(string) uint64 { return 0 })
+		for _, key := range []string{"", "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"} {
+			// n < 0 返回全部候选节点, 顺序可能不同
+			all := s.Select(selectorHosts, -1, key)
+			got := append([]string(nil), all...)
+			sort.Strings(got)
+			want := append([]string(nil), selectorHosts...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: got %v - want %v", policy, got, want)
+			}
+			if got := s.Select(selectorHosts, 2, key); len(got) != 2 || got[0] == got[1] {
+				t.Fatalf("%s: unexpected selection %v", policy, got)
+			}
+			if got := s.Select(nil, 2, key); len(got) != 0 {
+				t.Fatalf("%s: selected %v from no candidates", policy, got)
+			}
+		}
+	}
+}
+
+func TestRoundRobinSelector(t *testing.T) {
+	s := newSelector(SELECT_ROUND_ROBIN, nil)
+	seen := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		seen[s.Select(selectorHosts, 1, "")[0]]++
+	}
+	for _, h := range selectorHosts {
+		if seen[h] != 2 {
+			t.Fatalf("uneven round robin: %v", seen)
+		}
+	}
+}
+
+func TestLeastUsedSelector(t *testing.T) {
+	used := map[string]uint64{"10.0.0.1": 300, "10.0.0.2": 100, "10.0.0.3": 100}
+	s := newSelector(SELECT_LEAST_USED, func(h string) uint64 { return used[h] })
+	// 使用量相同时按节点名排序
+	if got := s.Select(selectorHosts, -1, ""); !reflect.DeepEqual(got, []string{"10.0.0.2", "10.0.0.3", "10.0.0.1"}) {
+		t.Fatalf("unexpected order %v", got)
+	}
+}
+
+func TestConsistentHashSelector(t *testing.T) {
+	s := newSelector(SELECT_CONSISTENT_HASH, nil)
+	key := "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+	first := s.Select(selectorHosts, 2, key)
+	// 候选顺序不影响结果
+	if got := s.Select([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, 2, key); !reflect.DeepEqual(got, first) {
+		t.Fatalf("selection depends on candidate order: %v - %v", got, first)
+	}
+	// 去掉未被选中的节点不影响结果
+	var rest []string
+	for _, h := range selectorHosts {
+		if h == first[0] || h == first[1] {
+			rest = append(rest, h)
+		}
+	}
+	if got := s.Select(rest, 2, key); !reflect.DeepEqual(got, first) {
+		t.Fatalf("selection moved after removing an unused host: %v - %v", got, first)
+	}
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"crypto/sha256"
+
+	cid2 "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// 与 ipfs add 的默认参数一致: size-262144 分块, balanced 布局, 非raw叶子, CIDv0
+const (
+	unixfsChunkSize = 256 * 1024
+	unixfsMaxLinks  = 174
+)
+
+// unixfsHasher computes the cid which ipfs add gives to the data written into it, without storing the dag.
+// local and s3 targets keep each blob whole but name it by this cid, so an object has the same cid on every target.
+type unixfsHasher struct {
+	buf    []byte
+	leaves int
+	levels [][]composeLink // 每层尚未合并到上层的节点
+}
+
+func newUnixfsHasher() *unixfsHasher {
+	return &unixfsHasher{buf: make([]byte, 0, unixfsChunkSize)}
+}
+
+func (h *unixfsHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		m := copy(h.buf[len(h.buf):cap(h.buf)], p)
+		h.buf, p = h.buf[:len(h.buf)+m], p[m:]
+		if len(h.buf) == cap(h.buf) {
+			h.addLeaf()
+		}
+	}
+	return n, nil
+}
+
+// Cid finishes the dag and returns the cid of its root
+func (h *unixfsHasher) Cid() cid2.Cid {
+	if len(h.buf) > 0 || h.leaves == 0 {
+		h.addLeaf()
+	}
+	for i := 0; ; i++ {
+		if i == len(h.levels)-1 {
+			if len(h.levels[i]) == 1 {
+				return h.levels[i][0].cid
+			}
+			return fileNodeLink(h.levels[i]).cid
+		}
+		if len(h.levels[i]) > 0 {
+			h.add(i+1, fileNodeLink(h.levels[i]))
+			h.levels[i] = nil
+		}
+	}
+}
+
+// addLeaf 叶子节点为带数据的UnixFS File节点: PBNode { Data: UnixFS { Type: File, Data, filesize } }
+func (h *unixfsHasher) addLeaf() {
+	var data []byte
+	data = appendVarintField(data, 1, 2) // Type: File
+	if len(h.buf) > 0 {
+		data = appendBytesField(data, 2, h.buf)
+	}
+	data = appendVarintField(data, 3, uint64(len(h.buf)))
+	node := appendBytesField(nil, 1, data)
+	h.add(0, composeLink{cid: dagPbCid(node), size: uint64(len(h.buf)), tsize: uint64(len(node))})
+	h.buf = h.buf[:0]
+	h.leaves++
+}
+
+// add appends the node to the level, a full level is first linked under a node of the upper level
+func (h *unixfsHasher) add(level int, l composeLink) {
+	if level == len(h.levels) {
+		h.levels = append(h.levels, nil)
+	}
+	if len(h.levels[level]) == unixfsMaxLinks {
+		h.add(level+1, fileNodeLink(h.levels[level]))
+		h.levels[level] = nil
+	}
+	h.levels[level] = append(h.levels[level], l)
+}
+
+// fileNodeLink encodes the file node of links and returns the link to it
+func fileNodeLink(links []composeLink) composeLink {
+	node := encodeFileNode(links)
+	l := composeLink{cid: dagPbCid(node), tsize: uint64(len(node))}
+	for _, c := range links {
+		l.size += c.size
+		l.tsize += c.tsize
+	}
+	return l
+}
+
+func dagPbCid(node []byte) cid2.Cid {
+	sum := sha256.Sum256(node)
+	hash, _ := mh.Encode(sum[:], mh.SHA2_256)
+	return cid2.NewCidV0(hash)
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	cid2 "github.com/ipfs/go-cid"
+)
+
+// cid 来自 ipfs add 的输出
+var unixfsHasherTests = []struct {
+	Data string
+	Cid  string
+}{
+	{Data: "", Cid: "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH"},              // 0
+	{Data: "hello world\n", Cid: "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"}, // 1
+}
+
+func TestUnixfsHasher(t *testing.T) {
+	for i, test := range unixfsHasherTests {
+		h := newUnixfsHasher()
+		if _, err := io.Copy(h, strings.NewReader(test.Data)); err != nil {
+			t.Fatal(err)
+		}
+		if id := h.Cid().String(); id != test.Cid {
+			t.Fatalf("Test %d: cid mismatch: got %s - want %s", i, id, test.Cid)
+		}
+	}
+}
+
+func TestUnixfsHasherLayout(t *testing.T) {
+	chunk := bytes.Repeat([]byte{'a'}, unixfsChunkSize)
+	leaf := newUnixfsHasher()
+	leaf.Write(chunk)
+	leafLink := leaf.levels[0][0]
+
+	cases := []struct {
+		chunks int
+		want   cid2.Cid
+	}{
+		// 恰好一个分块时根节点就是叶子
+		{chunks: 1, want: leafLink.cid},
+		{chunks: 2, want: fileNodeLink([]composeLink{leafLink, leafLink}).cid},
+		{chunks: unixfsMaxLinks, want: fileNodeLink(repeatLink(leafLink, unixfsMaxLinks)).cid},
+		// 超过一层的链接数时为两层的平衡树, 最后一个子树只有一个叶子
+		{chunks: unixfsMaxLinks + 1, want: fileNodeLink([]composeLink{
+			fileNodeLink(repeatLink(leafLink, unixfsMaxLinks)),
+			fileNodeLink([]composeLink{leafLink}),
+		}).cid},
+	}
+	for _, c := range cases {
+		h := newUnixfsHasher()
+		for i := 0; i < c.chunks; i++ {
+			h.Write(chunk)
+		}
+		if got := h.Cid(); !got.Equals(c.want) {
+			t.Fatalf("%d chunks: got %s - want %s", c.chunks, got, c.want)
+		}
+	}
+}
+
+func repeatLink(l composeLink, n int) []composeLink {
+	links := make([]composeLink, n)
+	for i := range links {
+		links[i] = l
+	}
+	return links
+}
+
+func TestEncodeFileNode(t *testing.T) {
+	a, _ := cid2.Decode("QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o")
+	b, _ := cid2.Decode("QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH")
+	node := encodeFileNode([]composeLink{{cid: a, size: 12, tsize: 20}, {cid: b, size: 0, tsize: 6}})
+
+	// PBLink { Hash, Name: "", Tsize } 在前, UnixFS { Type: File, filesize: 12, blocksizes: [12, 0] } 在后
+	var want []byte
+	want = append(want, 0x12, 0x28, 0x0a, 0x22)
+	want = append(want, a.Bytes()...)
+	want = append(want, 0x12, 0x00, 0x18, 0x14)
+	want = append(want, 0x12, 0x28, 0x0a, 0x22)
+	want = append(want, b.Bytes()...)
+	want = append(want, 0x12, 0x00, 0x18, 0x06)
+	want = append(want, 0x0a, 0x08, 0x08, 0x02, 0x18, 0x0c, 0x20, 0x0c, 0x20, 0x00)
+	if !bytes.Equal(node, want) {
+		t.Fatalf("node mismatch:\n got %x\nwant %x", node, want)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/spf13/cobra"
@@ -14,10 +15,12 @@ import (
 	"mtcloud.com/mtstorage/cmd/chunker/nodeimpl"
 	"mtcloud.com/mtstorage/cmd/chunker/services"
 	"mtcloud.com/mtstorage/node/client"
+	"mtcloud.com/mtstorage/pkg/auth"
 	"mtcloud.com/mtstorage/pkg/discall"
 	xhttp "mtcloud.com/mtstorage/pkg/http"
 	"mtcloud.com/mtstorage/pkg/logger"
 	"mtcloud.com/mtstorage/pkg/mq"
+	"mtcloud.com/mtstorage/pkg/policy"
 	utilruntime "mtcloud.com/mtstorage/pkg/runtime"
 	"mtcloud.com/mtstorage/pkg/tracing"
 	"mtcloud.com/mtstorage/util"
@@ -117,6 +120,16 @@ func initChunkerRouter(c *config2.ChunkerConfig, ck *services.Chunker) {
 	// Add API router.
 	chunkerapi.RegisterAPIRouter(router, ck)
 
+	// 请求签名认证
+	if c.Auth.Enable {
+		// 用户的秘钥由nameserver管理, 缓存一分钟
+		api.InitAuth(c.Auth, auth.Stores{auth.NewStaticStore(c.Auth.Credentials), auth.NewCachedStore(ck, time.Minute)})
+		// 桶的访问策略同样缓存一分钟
+		api.InitPolicy(policy.NewCachedGetter(ck, time.Minute))
+	} else {
+		logger.Warn("request authentication is disabled")
+	}
+
 	// Use all the middlewares
 	router.Use(api.GlobalHandlers...)
 
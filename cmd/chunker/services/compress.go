@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/node/client"
+	"mtcloud.com/mtstorage/pkg/compress"
+	"mtcloud.com/mtstorage/pkg/logger"
+)
+
+// 桶配置的缓存时间, 修改桶配置后最迟在该时间后生效
+const profileTTL = time.Minute
+
+type bucketProfile struct {
+	compression string
+	expire      time.Time
+}
+
+// BucketCompression returns the compression codec enabled in the profile of bucket, empty if disabled
+func (ck *Chunker) BucketCompression(ctx context.Context, bucket string) string {
+	ctx, span := trace.StartSpan(ctx, "BucketCompression")
+	defer span.End()
+
+	ck.profileMu.Lock()
+	p, ok := ck.profiles[bucket]
+	ck.profileMu.Unlock()
+	if ok && time.Now().Before(p.expire) {
+		return p.compression
+	}
+
+	profile, err := ck.NameServer.GetBucketProfile(client.WithTraceSpan(ctx, span), bucket)
+	if err != nil {
+		// 查询失败时不压缩, 不影响上传
+		logger.Errorf("get profile of bucket %s failed: %s", bucket, err)
+		return compress.None
+	}
+	p = bucketProfile{compression: compress.ParseProfile(profile), expire: time.Now().Add(profileTTL)}
+	ck.profileMu.Lock()
+	if ck.profiles == nil {
+		ck.profiles = make(map[string]bucketProfile)
+	}
+	ck.profiles[bucket] = p
+	ck.profileMu.Unlock()
+	return p.compression
+}
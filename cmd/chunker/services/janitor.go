@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
+	"mtcloud.com/mtstorage/node/client"
+	"mtcloud.com/mtstorage/pkg/lifecycle"
+	"mtcloud.com/mtstorage/pkg/logger"
+	utilruntime "mtcloud.com/mtstorage/pkg/runtime"
+)
+
+const (
+	// 每次从nameserver读取的分片上传数
+	janitorBatch = 1000
+	// 生命周期规则按天计算, 发起不足一天的上传不用检查
+	lifecycleUnit = 24 * time.Hour
+)
+
+var (
+	mExpiredUploads = stats.Int64("chunker/multipart_expired_uploads", "Number of incomplete multipart uploads aborted by the janitor", stats.UnitDimensionless)
+	mReclaimedBytes = stats.Int64("chunker/multipart_reclaimed_bytes", "Bytes reclaimed from incomplete multipart uploads", stats.UnitBytes)
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{Name: mExpiredUploads.Name(), Description: mExpiredUploads.Description(), Measure: mExpiredUploads, Aggregation: view.Sum()},
+		&view.View{Name: mReclaimedBytes.Name(), Description: mReclaimedBytes.Description(), Measure: mReclaimedBytes, Aggregation: view.Sum()},
+	); err != nil {
+		logger.Error("register multipart janitor views failed: ", err)
+	}
+}
+
+// JanitorStatus 未完成分片上传的清理情况
+type JanitorStatus struct {
+	Expiry         string     `json:"expiry"` //按时间取消的期限, 为空时只按生命周期规则
+	Interval       string     `json:"interval"`
+	Running        bool       `json:"running"`
+	Passes         int        `json:"passes"`
+	Expired        int        `json:"expired"`        //已取消的分片上传
+	ExpiredDirs    int        `json:"expiredDirs"`    //已删除的本地分片目录
+	ReclaimedBytes int64      `json:"reclaimedBytes"` //已释放的空间
+	LastError      string     `json:"lastError,omitempty"`
+	LastRun        *time.Time `json:"lastRun,omitempty"`
+}
+
+type multipartJanitor struct {
+	mu       sync.Mutex
+	status   JanitorStatus
+	expiry   time.Duration
+	interval time.Duration
+}
+
+func (j *multipartJanitor) update(fn func(s *JanitorStatus)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.status)
+}
+
+func (j *multipartJanitor) get() JanitorStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// startMultipartJanitor aborts the incomplete multipart uploads periodically,
+// expiry < 0 means only the lifecycle rules of buckets are applied.
+func (ck *Chunker) startMultipartJanitor(expiry, interval time.Duration) {
+	j := &multipartJanitor{expiry: expiry, interval: interval}
+	j.status.Interval = interval.String()
+	if expiry > 0 {
+		j.status.Expiry = expiry.String()
+	}
+	ck.janitor = j
+	logger.Infof("multipart janitor started, expiry: %s, interval: %s", j.status.Expiry, interval)
+
+	go func() {
+		defer utilruntime.HandleCrash()
+		for {
+			ck.cleanMultipartUploads()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// MultipartJanitorStatus returns the progress of the janitor
+func (ck *Chunker) MultipartJanitorStatus() JanitorStatus {
+	if ck.janitor == nil {
+		return JanitorStatus{}
+	}
+	return ck.janitor.get()
+}
+
+// cleanMultipartUploads runs one pass of the janitor
+func (ck *Chunker) cleanMultipartUploads() {
+	ctx, span := trace.StartSpan(context.Background(), "cleanMultipartUploads")
+	defer span.End()
+
+	j := ck.janitor
+	now := time.Now()
+	j.update(func(s *JanitorStatus) {
+		s.Running = true
+		s.LastRun = &now
+	})
+
+	expired, reclaimed, err := ck.expireMultipartUploads(ctx, now)
+	dirs, dirBytes := ck.expireMultipartDirs(now)
+	reclaimed += dirBytes
+
+	j.update(func(s *JanitorStatus) {
+		s.Running = false
+		s.Passes++
+		s.Expired += expired
+		s.ExpiredDirs += dirs
+		s.ReclaimedBytes += reclaimed
+		s.LastError = ""
+		if err != nil {
+			s.LastError = err.Error()
+		}
+	})
+	if err != nil {
+		logger.Errorf("multipart janitor failed: %s", err)
+	}
+	if expired > 0 || dirs > 0 {
+		stats.Record(ctx, mExpiredUploads.M(int64(expired+dirs)), mReclaimedBytes.M(reclaimed))
+		logger.Infof("multipart janitor aborted %d uploads and %d local dirs, reclaimed %d bytes", expired, dirs, reclaimed)
+	}
+}
+
+// expireMultipartUploads aborts the uploads older than the expiry or the lifecycle rule of their bucket
+func (ck *Chunker) expireMultipartUploads(ctx context.Context, now time.Time) (expired int, reclaimed int64, err error) {
+	j := ck.janitor
+	before := now.Add(-lifecycleUnit)
+	if j.expiry > 0 && j.expiry < lifecycleUnit {
+		before = now.Add(-j.expiry)
+	}
+
+	rules := make(map[string]*lifecycle.Configuration)
+	marker := ""
+	for {
+		uploads, err := ck.NameServer.GetStaleMultipartUploads(client.WithTrack(ctx), before, marker, janitorBatch)
+		if err != nil {
+			return expired, reclaimed, err
+		}
+		for _, u := range uploads {
+			limit, ok := ck.uploadExpiry(ctx, rules, u)
+			if !ok || now.Sub(u.Initiated) < limit {
+				continue
+			}
+			// 最近仍有分片写入的上传可能正在完成, 下次再检查
+			if now.Sub(u.UpdatedAt) < j.interval {
+				continue
+			}
+			removed, n, err := ck.abortMultipartUpload(ctx, u.UploadId)
+			if err != nil {
+				logger.Errorf("abort stale multipart upload %s of %s/%s failed: %s", u.UploadId, u.Bucket, u.Object, err)
+				continue
+			}
+			if !removed {
+				continue
+			}
+			logger.Infof("stale multipart upload %s of %s/%s initiated at %s aborted, reclaimed %d bytes",
+				u.UploadId, u.Bucket, u.Object, u.Initiated.Format(time.RFC3339), n)
+			expired++
+			reclaimed += n
+		}
+		if len(uploads) < janitorBatch {
+			return expired, reclaimed, nil
+		}
+		marker = uploads[len(uploads)-1].UploadId
+	}
+}
+
+// uploadExpiry returns how long the upload is kept, the shorter one of the expiry and the lifecycle rule.
+// ok is false if neither applies.
+func (ck *Chunker) uploadExpiry(ctx context.Context, rules map[string]*lifecycle.Configuration, u metadata.MultipartUploadInfo) (time.Duration, bool) {
+	limit, ok := ck.janitor.expiry, ck.janitor.expiry > 0
+
+	config, cached := rules[u.Bucket]
+	if !cached {
+		s, err := ck.NameServer.GetBucketLifecycle(client.WithTrack(ctx), u.Bucket)
+		if err == nil {
+			config, err = lifecycle.Parse(s)
+		}
+		if err != nil {
+			logger.Errorf("read lifecycle of bucket %s failed: %s", u.Bucket, err)
+		}
+		rules[u.Bucket] = config
+	}
+	if days, found := config.AbortIncompleteMultipartUpload(strings.TrimPrefix(u.Object, "/")); found {
+		if d := time.Duration(days) * lifecycleUnit; !ok || d < limit {
+			limit, ok = d, true
+		}
+	}
+	return limit, ok
+}
+
+// abortMultipartUpload removes the record and the parts of the upload, returns the bytes of the parts removed.
+// removed is false if the upload was already completed or aborted by another chunker.
+func (ck *Chunker) abortMultipartUpload(ctx context.Context, uploadId string) (removed bool, reclaimed int64, err error) {
+	upload, err := ck.RemoveMultipartUpload(ctx, uploadId)
+	if err != nil || upload.UploadId == "" {
+		return false, 0, err
+	}
+	for _, p := range upload.Parts {
+		if p.Cid == "" {
+			continue
+		}
+		// 被对象或其它分片引用的数据保留, 不计入释放的空间
+		deleted, err := ck.RemoveMultipartData(ctx, uploadId, p.Cid)
+		if err != nil || !deleted {
+			continue
+		}
+		reclaimed += p.Size
+	}
+	return true, reclaimed, nil
+}
+
+// RemoveMultipartData removes the data of a part, returns whether it was removed.
+// 对象或其它分片引用相同cid时保留数据, 已删除的数据不报错
+func (ck *Chunker) RemoveMultipartData(ctx context.Context, uploadId, cid string) (bool, error) {
+	deleted, err := ck.RemoveUnreferencedData(ctx, cid)
+	if err != nil {
+		logger.Errorf("remove part %s of upload %s failed: %s", cid, uploadId, err)
+	}
+	return deleted, err
+}
+
+// expireMultipartDirs removes the part dirs left in TempDir by the old versions which merged parts locally
+func (ck *Chunker) expireMultipartDirs(now time.Time) (dirs int, reclaimed int64) {
+	expiry := ck.janitor.expiry
+	if ck.TempDir == "" || expiry <= 0 {
+		return 0, 0
+	}
+	entries, err := os.ReadDir(ck.TempDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Errorf("read multipart dir %s failed: %s", ck.TempDir, err)
+		}
+		return 0, 0
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() || now.Sub(info.ModTime()) < expiry {
+			continue
+		}
+		dir := filepath.Join(ck.TempDir, entry.Name())
+		var size int64
+		filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+			if err == nil && !fi.IsDir() {
+				size += fi.Size()
+			}
+			return nil
+		})
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Errorf("remove stale multipart dir %s failed: %s", dir, err)
+			continue
+		}
+		logger.Infof("stale multipart dir %s removed, reclaimed %d bytes", dir, size)
+		dirs++
+		reclaimed += size
+	}
+	return dirs, reclaimed
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
+)
+
+const logsRule = `{"Rules":[{"Status":"Enabled","Filter":{"Prefix":"logs/"},"AbortIncompleteMultipartUpload":{"DaysAfterInitiation":3}}]}`
+
+func TestExpireMultipartUploads(t *testing.T) {
+	ns := &fakeNameServer{refs: map[string]int{}, lifecycles: map[string]string{"b2": logsRule}}
+	ck := newTestChunker(t, ns)
+	ck.janitor = &multipartJanitor{expiry: 7 * lifecycleUnit, interval: time.Hour}
+	ctx := context.Background()
+
+	unused, err := ck.storageEngine.Write(ctx, strings.NewReader("unused part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := ck.storageEngine.Write(ctx, strings.NewReader("shared part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.refs[shared] = 1
+
+	now := time.Now()
+	days := func(n float64) time.Time { return now.Add(-time.Duration(n * float64(lifecycleUnit))) }
+	ns.uploads = map[string]metadata.MultipartUploadInfo{}
+	for _, u := range []metadata.MultipartUploadInfo{
+		{UploadId: "expired", Bucket: "b1", Object: "/a", Initiated: days(8), UpdatedAt: days(8), Parts: []metadata.MultipartPartInfo{
+			{PartNumber: 1, Cid: unused, Size: 11},
+			{PartNumber: 2, Cid: shared, Size: 11},
+		}},
+		{UploadId: "young", Bucket: "b1", Object: "/a", Initiated: days(2), UpdatedAt: days(2)},
+		{UploadId: "recently-updated", Bucket: "b1", Object: "/a", Initiated: days(8), UpdatedAt: now.Add(-10 * time.Minute)},
+		{UploadId: "lifecycle", Bucket: "b2", Object: "/logs/a", Initiated: days(4), UpdatedAt: days(4)},
+		{UploadId: "other-prefix", Bucket: "b2", Object: "/data/a", Initiated: days(4), UpdatedAt: days(4)},
+	} {
+		ns.uploads[u.UploadId] = u
+	}
+
+	expired, reclaimed, err := ck.expireMultipartUploads(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 2 {
+		t.Fatalf("want 2 expired uploads, got %d", expired)
+	}
+	// 被对象引用的分片不计入
+	if reclaimed != 11 {
+		t.Fatalf("want 11 bytes reclaimed, got %d", reclaimed)
+	}
+	var kept []string
+	for id := range ns.uploads {
+		kept = append(kept, id)
+	}
+	sort.Strings(kept)
+	if strings.Join(kept, ",") != "other-prefix,recently-updated,young" {
+		t.Fatalf("unexpected uploads kept: %v", kept)
+	}
+	if err := ck.storageEngine.Stat(ctx, shared); err != nil {
+		t.Fatalf("referenced part removed: %v", err)
+	}
+	if err := ck.storageEngine.Stat(ctx, unused); err == nil {
+		t.Fatal("unused part not removed")
+	}
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
+	"mtcloud.com/mtstorage/node/client"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+)
+
+// RegisterMultipartUpload records the upload in nameserver, the parts can then be uploaded to any chunker
+func (ck *Chunker) RegisterMultipartUpload(ctx context.Context, upload metadata.MultipartUploadInfo) error {
+	ctx, span := trace.StartSpan(ctx, "RegisterMultipartUpload")
+	defer span.End()
+
+	upload.Chunker = ck.Id
+	return ck.NameServer.PutMultipartUpload(client.WithTraceSpan(ctx, span), upload)
+}
+
+// RecordMultipartPart records an uploaded part of the upload, returns the cid of the part it replaced
+func (ck *Chunker) RecordMultipartPart(ctx context.Context, part metadata.MultipartPartInfo) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "RecordMultipartPart")
+	defer span.End()
+	return ck.NameServer.PutMultipartPart(client.WithTraceSpan(ctx, span), part)
+}
+
+// RemoveMultipartUpload removes the record after the upload is completed or aborted,
+// returns the removed upload with its parts, UploadId is empty if it was already removed.
+func (ck *Chunker) RemoveMultipartUpload(ctx context.Context, uploadId string) (metadata.MultipartUploadInfo, error) {
+	ctx, span := trace.StartSpan(ctx, "RemoveMultipartUpload")
+	defer span.End()
+	return ck.NameServer.RemoveMultipartUpload(client.WithTraceSpan(ctx, span), uploadId)
+}
+
+// GetMultipartUpload returns the upload with its parts, UploadId is empty if it does not exist
+func (ck *Chunker) GetMultipartUpload(ctx context.Context, uploadId string) (metadata.MultipartUploadInfo, error) {
+	ctx, span := trace.StartSpan(ctx, "GetMultipartUpload")
+	defer span.End()
+	return ck.NameServer.GetMultipartUpload(client.WithTraceSpan(ctx, span), uploadId)
+}
+
+// GetMultipartUploadOf returns the upload if it was initiated for the object, InvalidUploadID otherwise
+func (ck *Chunker) GetMultipartUploadOf(ctx context.Context, uploadId, bucket, object string) (metadata.MultipartUploadInfo, error) {
+	upload, err := ck.GetMultipartUpload(ctx, uploadId)
+	if err != nil {
+		return upload, err
+	}
+	if upload.UploadId == "" || upload.Bucket != bucket || upload.Object != object {
+		return metadata.MultipartUploadInfo{}, error2.InvalidUploadID{Bucket: bucket, Object: object, UploadID: uploadId}
+	}
+	return upload, nil
+}
+
+// ComposeData joins the stored parts into one object without copying them through the chunker when possible
+func (ck *Chunker) ComposeData(ctx context.Context, cids []string) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "ComposeData")
+	defer span.End()
+	return ck.storageEngine.Compose(ctx, cids)
+}
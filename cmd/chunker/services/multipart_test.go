@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mtcloud.com/mtstorage/cmd/chunker/engine"
+	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
+	"mtcloud.com/mtstorage/node/api"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+)
+
+// fakeNameServer 只实现测试用到的方法
+type fakeNameServer struct {
+	api.ServerNode
+	mu         sync.Mutex
+	uploads    map[string]metadata.MultipartUploadInfo
+	refs       map[string]int
+	lifecycles map[string]string
+}
+
+func (f *fakeNameServer) GetMultipartUpload(ctx context.Context, uploadId string) (metadata.MultipartUploadInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.uploads[uploadId], nil
+}
+
+func (f *fakeNameServer) GetStaleMultipartUploads(ctx context.Context, before time.Time, marker string, limit int) ([]metadata.MultipartUploadInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var uploads []metadata.MultipartUploadInfo
+	for _, u := range f.uploads {
+		if u.Initiated.Before(before) && u.UploadId > marker {
+			uploads = append(uploads, u)
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].UploadId < uploads[j].UploadId })
+	if len(uploads) > limit {
+		uploads = uploads[:limit]
+	}
+	return uploads, nil
+}
+
+func (f *fakeNameServer) RemoveMultipartUpload(ctx context.Context, uploadId string) (metadata.MultipartUploadInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := f.uploads[uploadId]
+	delete(f.uploads, uploadId)
+	return u, nil
+}
+
+func (f *fakeNameServer) GetBucketLifecycle(ctx context.Context, bucket string) (string, error) {
+	return f.lifecycles[bucket], nil
+}
+
+func (f *fakeNameServer) CountCidRef(ctx context.Context, cid string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.refs[cid], nil
+}
+
+// newTestChunker returns a chunker storing into a local dir
+func newTestChunker(t *testing.T, ns *fakeNameServer) *Chunker {
+	e, err := engine.NewEngine(engine.StorageConfig{
+		Provider:    engine.STORAGE_LOCAL,
+		Replication: 1,
+		Targets: map[string]engine.EngineConfig{engine.STORAGE_LOCAL: {
+			Endpoints: map[string]*engine.EndpointConfig{t.TempDir(): {CanStore: true, CanRead: true}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return &Chunker{NameServer: ns, storageEngine: e}
+}
+
+func TestGetMultipartUploadOf(t *testing.T) {
+	ns := &fakeNameServer{uploads: map[string]metadata.MultipartUploadInfo{
+		"u1": {UploadId: "u1", Bucket: "b1", Object: "/dir/o1"},
+	}}
+	ck := &Chunker{NameServer: ns}
+	ctx := context.Background()
+
+	if u, err := ck.GetMultipartUploadOf(ctx, "u1", "b1", "/dir/o1"); err != nil || u.UploadId != "u1" {
+		t.Fatalf("upload of its own object rejected: %+v, %v", u, err)
+	}
+	cases := []struct {
+		name                     string
+		uploadId, bucket, object string
+	}{
+		{name: "other bucket", uploadId: "u1", bucket: "b2", object: "/dir/o1"},
+		{name: "other object", uploadId: "u1", bucket: "b1", object: "/dir/o2"},
+		{name: "not found", uploadId: "u2", bucket: "b1", object: "/dir/o1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ck.GetMultipartUploadOf(ctx, c.uploadId, c.bucket, c.object)
+			if _, ok := err.(error2.InvalidUploadID); !ok {
+				t.Fatalf("want InvalidUploadID, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRemoveMultipartData(t *testing.T) {
+	ns := &fakeNameServer{refs: map[string]int{}}
+	ck := newTestChunker(t, ns)
+	ctx := context.Background()
+
+	shared, err := ck.storageEngine.Write(ctx, strings.NewReader("shared part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unused, err := ck.storageEngine.Write(ctx, strings.NewReader("unused part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 对象引用相同内容的分片不删除
+	ns.refs[shared] = 1
+	if deleted, err := ck.RemoveMultipartData(ctx, "u1", shared); err != nil || deleted {
+		t.Fatalf("referenced part deleted: %v, %v", deleted, err)
+	}
+	if deleted, err := ck.RemoveMultipartData(ctx, "u1", unused); err != nil || !deleted {
+		t.Fatalf("unused part not deleted: %v, %v", deleted, err)
+	}
+	if err := ck.storageEngine.Stat(ctx, shared); err != nil {
+		t.Fatalf("referenced part removed: %v", err)
+	}
+	if err := ck.storageEngine.Stat(ctx, unused); err == nil {
+		t.Fatal("unused part not removed")
+	}
+	// 已删除的数据不报错
+	if _, err := ck.RemoveMultipartData(ctx, "u1", unused); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,19 +4,24 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	config2 "mtcloud.com/mtstorage/cmd/chunker/config"
 	"mtcloud.com/mtstorage/cmd/chunker/engine"
-	"net/http"
+	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"go.opencensus.io/trace"
 	"mtcloud.com/mtstorage/node/api"
 	"mtcloud.com/mtstorage/node/client"
 	node_util "mtcloud.com/mtstorage/node/util"
+	"mtcloud.com/mtstorage/pkg/auth"
+	"mtcloud.com/mtstorage/pkg/crypto"
 	"mtcloud.com/mtstorage/pkg/logger"
+	"mtcloud.com/mtstorage/pkg/policy"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
 	"mtcloud.com/mtstorage/util"
 )
 
@@ -27,15 +32,22 @@ type Chunker struct {
 	NodeGroup       string
 	NameServerGroup string
 	Region          string
+	RegionId        int64
 	NameServer      api.ServerNode
 	storageEngine   *engine.Engine
 
 	netSpeedCollect *util.NetSpeed
 	master          []string
-	fixNode         []string
 	storageType     string // 选择上传的策略
 
 	TempDir string
+
+	// 桶配置缓存, 用于判断是否压缩
+	profileMu sync.Mutex
+	profiles  map[string]bucketProfile
+
+	// 未完成分片上传的清理任务
+	janitor *multipartJanitor
 }
 
 func NewChunkerNode(c *config2.ChunkerConfig) *Chunker {
@@ -68,6 +80,11 @@ func NewChunkerNode(c *config2.ChunkerConfig) *Chunker {
 		panic("region is empty")
 	}
 	node.Region = region
+	node.RegionId = c.Node.RegionId
+	if node.RegionId == 0 {
+		// 同一区域的节点得到相同的编号
+		node.RegionId = int64(crc32.ChecksumIEEE([]byte(region)))
+	}
 
 	node_id := c.Node.Id
 	if node_id == "" {
@@ -94,6 +111,10 @@ func NewChunkerNode(c *config2.ChunkerConfig) *Chunker {
 		panic(err)
 	}
 	node.storageEngine = engine
+	// 已删除的cid不再补齐副本
+	engine.SetRefCounter(func(ctx context.Context, cid string) (int, error) {
+		return node.NameServer.CountCidRef(client.WithTrack(ctx), cid)
+	})
 
 	return node
 }
@@ -107,18 +128,121 @@ func (ck *Chunker) Start(c *config2.ChunkerConfig) {
 	ck.storageEngine.Start()
 	//start hearbeat
 	go ck.startHeartbeat()
+
+	expiry := time.Duration(c.Multipart.Expiry) * time.Hour
+	ck.startMultipartJanitor(expiry, time.Duration(c.Multipart.Interval)*time.Minute)
+}
+
+func (ck *Chunker) GetData(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+	return ck.storageEngine.Read(ctx, cid, offset, length)
 }
 
-func (ck *Chunker) GetData(ctx context.Context, cid string) (io.Reader, error) {
-	return ck.storageEngine.Read(ctx, cid)
+func (ck *Chunker) GetDataSize(ctx context.Context, cid string) (int64, error) {
+	return ck.storageEngine.FileSize(ctx, cid)
 }
 
 func (ck *Chunker) CIDExist(ctx context.Context, cid string) bool {
 	return ck.storageEngine.Stat(ctx, cid) == nil
 }
 
-func (ck *Chunker) DeleteDataFromIPFS(ctx context.Context, cid string) error {
-	return ck.storageEngine.Delete(ctx, cid)
+func (ck *Chunker) DeleteDataFromIPFS(ctx context.Context, cid string, gc bool) ([]engine.DeleteResult, error) {
+	return ck.storageEngine.Delete(ctx, cid, gc)
+}
+
+// RemoveUnreferencedData removes the data of cid if no object or multipart part references it.
+// 与gc相同, 删除后重新统计引用, 期间又被引用时重新pin
+func (ck *Chunker) RemoveUnreferencedData(ctx context.Context, cid string) (bool, error) {
+	count, err := ck.NameServer.CountCidRef(client.WithTrack(ctx), cid)
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		logger.Infof("%s is referenced %d times, keep it", cid, count)
+		return false, nil
+	}
+	if _, err := ck.storageEngine.Delete(ctx, cid, false); err != nil {
+		if err == engine.ErrNotPinned {
+			return false, nil
+		}
+		return false, err
+	}
+
+	count, err = ck.NameServer.CountCidRef(client.WithTrack(ctx), cid)
+	if err == nil && count == 0 {
+		return true, nil
+	}
+	if err != nil {
+		logger.Errorf("count references of %s failed: %s", cid, err)
+	} else {
+		logger.Warnf("%s referenced again after removal, pin it back", cid)
+	}
+	if err := ck.storageEngine.Pin(cid); err != nil {
+		logger.Errorf("pin %s back failed: %s", cid, err)
+	}
+	return false, nil
+}
+
+func (ck *Chunker) GetReplicas(ctx context.Context, cid string) (engine.ReplicaReport, error) {
+	return ck.storageEngine.Replicas(ctx, cid)
+}
+
+func (ck *Chunker) RepairReplicas(ctx context.Context, cid string) (engine.ReplicaReport, error) {
+	return ck.storageEngine.Replicate(ctx, cid)
+}
+
+// RepoGC triggers the garbage collection of the storage endpoints
+func (ck *Chunker) RepoGC() []engine.DeleteResult {
+	return ck.storageEngine.RepoGC()
+}
+
+// Rebalance starts, pauses or inspects the rebalance job of the storage endpoints
+func (ck *Chunker) Rebalance(action string, bandwidth int, threshold float64) (engine.RebalanceStatus, error) {
+	switch action {
+	case "start":
+		return ck.storageEngine.StartRebalance(bandwidth, threshold)
+	case "pause":
+		return ck.storageEngine.PauseRebalance()
+	default:
+		return ck.storageEngine.RebalanceStatus()
+	}
+}
+
+// Drain sets the drain state of a storage endpoint and saves it in the nameserver,
+// other chunkers sync the state with the heartbeat
+func (ck *Chunker) Drain(endpoint string, drain bool) (engine.DrainStatus, error) {
+	status, err := ck.storageEngine.Drain(endpoint, drain)
+	if err != nil {
+		return status, err
+	}
+	if err = ck.NameServer.PutEndpointDrain(context.Background(), endpoint, drain); err != nil {
+		logger.Errorf("save drain state of %s failed: %s", endpoint, err)
+		// 保存失败时恢复原状态, 避免与其它chunker不一致
+		if _, rerr := ck.storageEngine.Drain(endpoint, !drain); rerr != nil {
+			logger.Errorf("restore drain state of %s failed: %s", endpoint, rerr)
+		}
+		return engine.DrainStatus{}, err
+	}
+	return status, nil
+}
+
+// syncDrain applies the drain states saved in the nameserver
+func (ck *Chunker) syncDrain(ctx context.Context) {
+	states, err := ck.NameServer.GetEndpointDrains(ctx)
+	if err != nil {
+		logger.Error("get endpoint drain states err: ", err)
+		return
+	}
+	ck.storageEngine.SyncDrain(states)
+}
+
+// DrainStatus returns the drain progress of a storage endpoint, all draining endpoints if it is empty
+func (ck *Chunker) DrainStatus(endpoint string) ([]engine.DrainStatus, error) {
+	return ck.storageEngine.DrainStatus(endpoint)
+}
+
+// Admit checks whether the storage can take an upload of size bytes
+func (ck *Chunker) Admit(size int64) error {
+	return ck.storageEngine.Admit(size)
 }
 
 func (ck *Chunker) WriteData(ctx context.Context, file io.Reader) (cid string, err error) {
@@ -134,8 +258,58 @@ func (ck *Chunker) CallBackNS(ctx context.Context, d node_util.ReWriteObjectInfo
 	return ck.NameServer.SaveObjectMeta(client.WithTraceSpan(ctx, span), d)
 }
 
+// GetObjectInfo returns the current version of the object from nameserver, Name is empty if it does not exist
+func (ck *Chunker) GetObjectInfo(ctx context.Context, bucket, object string) (metadata.ObjectInfo, error) {
+	ctx, span := trace.StartSpan(ctx, "GetObjectInfo")
+	defer span.End()
+	return ck.NameServer.GetObjectInfo(client.WithTraceSpan(ctx, span), bucket, object)
+}
+
+// GetObjectsByCid returns the current objects whose content is cid
+func (ck *Chunker) GetObjectsByCid(ctx context.Context, cid string, limit int) ([]metadata.ObjectInfo, error) {
+	ctx, span := trace.StartSpan(ctx, "GetObjectsByCid")
+	defer span.End()
+	return ck.NameServer.GetObjectsByCid(client.WithTraceSpan(ctx, span), cid, limit)
+}
+
+// GetCredentials looks up the access key of the users in nameserver for the request authentication
+func (ck *Chunker) GetCredentials(ctx context.Context, accessKey string) (auth.Credentials, error) {
+	ctx, span := trace.StartSpan(ctx, "GetCredentials")
+	defer span.End()
+
+	cred, err := ck.NameServer.GetCredentials(client.WithTraceSpan(ctx, span), accessKey)
+	if err != nil {
+		return cred, err
+	}
+	if cred.AccessKey == "" {
+		return cred, auth.ErrNoSuchAccessKey
+	}
+	// 秘钥加密后传输
+	cred.SecretKey = crypto.DecryptLocalPassword(cred.SecretKey)
+	return cred, nil
+}
+
+// GetObjectAcl returns the acl of the object from nameserver for the policy evaluation, empty if the object does not exist
+func (ck *Chunker) GetObjectAcl(ctx context.Context, bucket, object string) (string, error) {
+	oi, err := ck.GetObjectInfo(ctx, bucket, object)
+	return oi.Acl, err
+}
+
+// GetBucketAccess returns the owner, policy and acl of the bucket from nameserver for the policy evaluation
+func (ck *Chunker) GetBucketAccess(ctx context.Context, bucket string) (policy.BucketAccess, error) {
+	ctx, span := trace.StartSpan(ctx, "GetBucketAccess")
+	defer span.End()
+
+	access, err := ck.NameServer.GetBucketAccess(client.WithTraceSpan(ctx, span), bucket)
+	if err == nil && access.Bucket == "" {
+		err = error2.BucketNotFound{Bucket: bucket}
+	}
+	return access, err
+}
+
 func (ck *Chunker) startHeartbeat() {
 	ctx := context.Background()
+	ck.syncDrain(ctx)
 	if err := ck.NameServer.Heartbeat(ctx, ck.GetHeartbeatInfo()); err != nil {
 		logger.Errorf("send heartbeat storageerror: ", err)
 	}
@@ -145,6 +319,7 @@ func (ck *Chunker) startHeartbeat() {
 		select {
 		case <-ticker.C:
 			logger.Info("send heartbeat")
+			ck.syncDrain(ctx)
 			hi := ck.GetHeartbeatInfo()
 			if err := ck.NameServer.Heartbeat(ctx, hi); err != nil {
 				logger.Error("send heartbeat storageerror: ", err)
@@ -161,21 +336,47 @@ func (ck *Chunker) GetHeartbeatInfo() node_util.ChunkerNodeInfo {
 		State:    node_util.State_Health,
 		Time:     time.Now(),
 		Region: &node_util.Region{
-			RegionId: 111,
+			RegionId: ck.RegionId,
 			Name:     ck.Region,
 		},
 	}
 	info.LatestNetSpeed = ck.netSpeedCollect.KBSpeed()
-	var available, usage uint64
 
-	stat, err := ck.storageEngine.RepoStat()
-
-	if err == nil {
-		available = stat.StorageMax - stat.RepoSize
-		usage = stat.RepoSize
+	// 汇总所有存储节点, 不可用的节点不计入容量
+	for _, s := range ck.storageEngine.EndpointStats() {
+		disk := node_util.Disk{
+			Endpoint:  s.Endpoint,
+			State:     node_util.State_Health,
+			PoolIndex: -1,
+			SetIndex:  -1,
+			DiskIndex: -1,
+		}
+		if disk.Endpoint == "" {
+			disk.Endpoint = ck.Endpoint
+		}
+		switch {
+		case !s.Healthy || s.Error != "":
+			disk.State = node_util.State_Offline
+		case s.Draining:
+			disk.State = node_util.State_Draining
+		}
+		if disk.State != node_util.State_Offline {
+			disk.TotalSpace = s.StorageMax
+			disk.UsedSpace = s.RepoSize
+			if s.StorageMax > s.RepoSize {
+				disk.AvailableSpace = s.StorageMax - s.RepoSize
+			}
+			disk.NumObjects = s.NumObjects
+			info.TotalSpace += disk.TotalSpace
+			info.UsedSpace += disk.UsedSpace
+			info.NumObjects += disk.NumObjects
+			// 下线中的节点不再写入
+			if disk.State == node_util.State_Health {
+				info.AvailableSpace += disk.AvailableSpace
+			}
+		}
+		info.Disks = append(info.Disks, disk)
 	}
-	info.AvailableSpace = available
-	info.UsedSpace = usage
 	return info
 
 }
@@ -200,29 +401,6 @@ func (ck *Chunker) GetDagSize(ctx context.Context, cid string) string {
 	return dz
 }
 
-// FixCid 下载出错时候修复文件
-func (ck *Chunker) FixCid(cid string) {
-	for _, v := range ck.fixNode {
-		for i := 0; i < 3; i++ {
-			url := v
-			payload := fmt.Sprintf("cid=%s", cid)
-			c := &http.Client{}
-			req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(payload))
-			if err != nil {
-				logger.Error("fixCid", err)
-				continue
-			}
-			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-			_, err = c.Do(req)
-			if err != nil {
-				logger.Error("fixCid", err)
-				continue
-			}
-			break
-		}
-	}
-}
-
 func (ck *Chunker) GetObjectDagTree(ctx context.Context, cid string) (map[string]interface{}, error) {
 	result := make(map[string]interface{})
 	result["Hash"] = cid
@@ -282,7 +460,7 @@ func (ck *Chunker) AddObjectCid(cids []string) ([]map[string]interface{}, error)
 	//	return exeStatus, nil
 	//}
 
-	for flag, cid := range cids {
+	for _, cid := range cids {
 		//node := nodes[flag%len(nodes)]
 		//exeStatus = append(exeStatus, map[string]interface{}{
 		//	"cid":      cid,
@@ -290,10 +468,15 @@ func (ck *Chunker) AddObjectCid(cids []string) ([]map[string]interface{}, error)
 		//	"status":   true,
 		//})
 		// todo 执行 pin add 命令，传入不存在cid时不会返回
+		status := true
 		if err := ck.storageEngine.Pin(cid); err != nil {
-			exeStatus[flag]["status"] = false
+			status = false
 			logger.Errorf("%s ipfs pin add fail: %s", cid, err)
 		}
+		exeStatus = append(exeStatus, map[string]interface{}{
+			"cid":    cid,
+			"status": status,
+		})
 	}
 
 	return exeStatus, nil
@@ -0,0 +1,28 @@
+package clientbuilder
+
+import (
+	"net/http"
+
+	"mtcloud.com/mtstorage/pkg/auth"
+	"mtcloud.com/mtstorage/pkg/config"
+	"mtcloud.com/mtstorage/pkg/crypto"
+)
+
+// CreateChunkerClient returns the client to call the chunker apis,
+// 开启认证时用配置的第一个管理秘钥签名, 不修改http.DefaultClient
+func CreateChunkerClient() (*http.Client, error) {
+	var ac config.AuthConfig
+	if err := config.UnmarshalKey("auth", &ac); err != nil {
+		return nil, err
+	}
+	if !ac.Enable || len(ac.Credentials) == 0 {
+		return &http.Client{}, nil
+	}
+	c := ac.Credentials[0]
+	return &http.Client{
+		Transport: &auth.Transport{
+			Credentials: auth.Credentials{AccessKey: c.AccessKey, SecretKey: crypto.DecryptLocalPassword(c.SecretKey)},
+			Region:      ac.Region,
+		},
+	}, nil
+}
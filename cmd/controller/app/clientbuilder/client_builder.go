@@ -2,13 +2,15 @@ package clientbuilder
 
 import (
 	"fmt"
+	"net/http"
 
 	"mtcloud.com/mtstorage/pkg/config"
 	"mtcloud.com/mtstorage/pkg/logger"
 )
 
 type SimpleControllerClientBuilder struct {
-	nscn *NameserverClient //name server controlnode client
+	nscn    *NameserverClient //name server controlnode client
+	chunker *http.Client      //请求chunker接口的client
 }
 
 func CreateControllerClientBuilder() SimpleControllerClientBuilder {
@@ -21,8 +23,15 @@ func CreateControllerClientBuilder() SimpleControllerClientBuilder {
 		logger.Error(err)
 	}
 
+	chunker, err := CreateChunkerClient()
+	if err != nil {
+		logger.Error("create chunker client err: ", err)
+		chunker = &http.Client{}
+	}
+
 	return SimpleControllerClientBuilder{
-		nscn: client,
+		nscn:    client,
+		chunker: chunker,
 	}
 }
 
@@ -30,3 +39,8 @@ func CreateControllerClientBuilder() SimpleControllerClientBuilder {
 func (ccb *SimpleControllerClientBuilder) NameserverClient() *NameserverClient {
 	return ccb.nscn
 }
+
+// ChunkerClient client to call the chunker apis
+func (ccb *SimpleControllerClientBuilder) ChunkerClient() *http.Client {
+	return ccb.chunker
+}
@@ -1,6 +1,9 @@
 package clientbuilder
 
+import "net/http"
+
 // client interface
 type ControllerClientBuilder interface {
 	NameserverClient() *NameserverClient
+	ChunkerClient() *http.Client
 }
@@ -0,0 +1,218 @@
+package gc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"mtcloud.com/mtstorage/cmd/controller/app/clientbuilder"
+	"mtcloud.com/mtstorage/node/client"
+	"mtcloud.com/mtstorage/node/util"
+	"mtcloud.com/mtstorage/pkg/config"
+	"mtcloud.com/mtstorage/pkg/logger"
+	"mtcloud.com/mtstorage/pkg/runtime"
+)
+
+const (
+	defaultInterval = 5 * time.Minute
+	defaultGrace    = 24 * time.Hour
+	defaultBatch    = 100
+	defaultSettle   = time.Minute
+)
+
+// Controller unpins cids which are no longer referenced by any object or version.
+type Controller struct {
+	nameserverClient *clientbuilder.NameserverClient
+	// 请求chunker接口, 开启认证时签名
+	chunkerClient *http.Client
+
+	interval time.Duration
+	// 引用数归零后保留的时间, 避免回收正在上传或刚被删除又恢复的数据
+	grace time.Duration
+	batch int
+	// 取消pin后是否触发ipfs的repo gc
+	repoGC bool
+	// 取消pin后等待的时间, 之后重新统计引用, 覆盖先写入数据后保存元数据的上传
+	settle time.Duration
+}
+
+// NewGCController returns a new *Controller.
+func NewGCController(nscli *clientbuilder.NameserverClient, chunkerClient *http.Client) *Controller {
+	c := &Controller{
+		nameserverClient: nscli,
+		chunkerClient:    chunkerClient,
+		interval:         time.Duration(config.GetInt("gc.interval")) * time.Second,
+		grace:            time.Duration(config.GetInt("gc.grace")) * time.Second,
+		batch:            config.GetInt("gc.batch"),
+		repoGC:           config.GetBool("gc.repogc"),
+		settle:           time.Duration(config.GetInt("gc.settle")) * time.Second,
+	}
+	if c.interval <= 0 {
+		c.interval = defaultInterval
+	}
+	if c.grace <= 0 {
+		c.grace = defaultGrace
+	}
+	if c.batch <= 0 {
+		c.batch = defaultBatch
+	}
+	if c.settle <= 0 {
+		c.settle = defaultSettle
+	}
+	return c
+}
+
+func (c *Controller) Run(workers int, stopCh <-chan struct{}) {
+	defer runtime.HandleCrash()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.collect()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (c *Controller) collect() {
+	refs, err := c.nameserverClient.GetUnreferencedCids(client.WithTrack(nil), c.grace, c.batch)
+	if err != nil {
+		logger.Error("get unreferenced cids err: ", err)
+		return
+	}
+	if len(refs) == 0 {
+		return
+	}
+	node, err := c.nameserverClient.GetChunkerNode(client.WithTrack(nil))
+	if err != nil {
+		logger.Error("get chunkerNode err: ", err)
+		return
+	}
+
+	var unpinned []string
+	for _, ref := range refs {
+		if err := c.unpin(node, ref.Cid); err != nil {
+			logger.Errorf("gc %s fail: %s", ref.Cid, err)
+			continue
+		}
+		removed, err := c.nameserverClient.RemoveCidRef(client.WithTrack(nil), ref.Cid)
+		if err != nil {
+			logger.Errorf("remove %s ref err: %s", ref.Cid, err)
+			continue
+		}
+		if !removed {
+			// 取消pin期间cid又被引用, 重新pin
+			logger.Warnf("%s referenced again during gc, pin it back", ref.Cid)
+			if err := c.pin(node, ref.Cid); err != nil {
+				logger.Errorf("pin %s back fail: %s", ref.Cid, err)
+			}
+			continue
+		}
+		unpinned = append(unpinned, ref.Cid)
+	}
+	if len(unpinned) == 0 {
+		return
+	}
+
+	// 数据先写入节点, 元数据随后保存, 等待正在进行的上传完成后重新统计引用, 数据被回收前还能重新pin
+	time.Sleep(c.settle)
+	collected := 0
+	for _, cid := range unpinned {
+		count, err := c.nameserverClient.CountCidRef(client.WithTrack(nil), cid)
+		if err == nil && count == 0 {
+			collected++
+			continue
+		}
+		if err != nil {
+			logger.Errorf("count %s ref err: %s", cid, err)
+		} else {
+			logger.Warnf("%s referenced again after unpin, pin it back", cid)
+		}
+		if err := c.pin(node, cid); err != nil {
+			logger.Errorf("pin %s back fail: %s", cid, err)
+		}
+	}
+	logger.Infof("gc collected %d of %d unreferenced cids", collected, len(refs))
+	if c.repoGC && collected > 0 {
+		if err := c.runRepoGC(node); err != nil {
+			logger.Error("repo gc err: ", err)
+		}
+	}
+}
+
+// unpin removes the cid from all endpoints of the chunker, not found is treated as done.
+// repo gc在重新统计引用后执行
+func (c *Controller) unpin(node util.ChunkerNodeInfo, cid string) error {
+	url := fmt.Sprintf("http://%s/cs/v1/object/%s", node.Endpoint, cid)
+	request, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.chunkerClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status: %d, %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// pin pins the cid back to the replication factor, the chunker reports the result of each cid
+func (c *Controller) pin(node util.ChunkerNodeInfo, cid string) error {
+	url := fmt.Sprintf("http://%s/cs/v1/addObjectCid", node.Endpoint)
+	reqBody, err := json.Marshal([]string{cid})
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.chunkerClient.Post(url, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status: %d, %s", resp.StatusCode, body)
+	}
+	var statuses []struct {
+		Cid    string `json:"cid"`
+		Status bool   `json:"status"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		if s.Cid == cid && s.Status {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s not pinned", cid)
+}
+
+// runRepoGC triggers the repo gc of the storage endpoints of the chunker
+func (c *Controller) runRepoGC(node util.ChunkerNodeInfo) error {
+	url := fmt.Sprintf("http://%s/cs/v1/admin/gc", node.Endpoint)
+	resp, err := c.chunkerClient.Post(url, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status: %d, %s", resp.StatusCode, body)
+	}
+	return nil
+}
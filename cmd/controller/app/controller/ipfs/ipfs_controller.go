@@ -30,14 +30,16 @@ type Controller struct {
 	queue workqueue.RateLimitingInterface
 
 	nameserverClient *clientbuilder.NameserverClient
+	chunkerClient    *http.Client
 }
 
-func NewIpfsCidAnalysisController(objectInformer coreinformers.Informer, nscli *clientbuilder.NameserverClient) *Controller {
+func NewIpfsCidAnalysisController(objectInformer coreinformers.Informer, nscli *clientbuilder.NameserverClient, chunkerClient *http.Client) *Controller {
 
 	c := &Controller{
 		queue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "object"),
 
 		nameserverClient: nscli,
+		chunkerClient:    chunkerClient,
 	}
 
 	objectInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -198,7 +200,7 @@ func (c *Controller) getObjectDagTree(cid string) (string, error) {
 	q.Add("cid", cid)
 	request.URL.RawQuery = q.Encode()
 
-	resp, err := http.DefaultClient.Do(request)
+	resp, err := c.chunkerClient.Do(request)
 	if err != nil {
 		logger.Error("get object cid err: ", err)
 		return "", err
@@ -241,7 +243,7 @@ func (c *Controller) addObjectCid(cid []string, node util.ChunkerNodeInfo) ([]ma
 		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(request)
+	resp, err := c.chunkerClient.Do(request)
 	if err != nil {
 		logger.Error("addObjectCid fail: ", err)
 		return nil, err
@@ -0,0 +1,176 @@
+package rebalance
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"mtcloud.com/mtstorage/cmd/controller/app/clientbuilder"
+	"mtcloud.com/mtstorage/node/client"
+	"mtcloud.com/mtstorage/node/util"
+	"mtcloud.com/mtstorage/pkg/config"
+	"mtcloud.com/mtstorage/pkg/logger"
+	"mtcloud.com/mtstorage/pkg/runtime"
+)
+
+const (
+	defaultInterval  = time.Hour
+	defaultThreshold = 0.1
+)
+
+// rebalanceStatus 与chunker /cs/v1/admin/rebalance 的返回一致
+type rebalanceStatus struct {
+	State     string `json:"state"`
+	Moved     int    `json:"moved"`
+	Copied    int    `json:"copied"`
+	Failed    int    `json:"failed"`
+	LastError string `json:"lastError"`
+	Endpoints []struct {
+		Endpoint string  `json:"endpoint"`
+		Ratio    float64 `json:"ratio"`
+	} `json:"endpoints"`
+}
+
+// Controller starts the rebalance job on the chunker when the usage of the storage
+// endpoints differs too much, e.g. after new endpoints are added.
+// 任务状态只保存在执行任务的chunker中, 因此任务固定在一个chunker上执行
+type Controller struct {
+	nameserverClient *clientbuilder.NameserverClient
+	chunkerClient    *http.Client
+
+	// 执行再平衡任务的chunker
+	chunker string
+
+	interval time.Duration
+	// 最满与最空节点的使用率之差超过该值时开始再平衡
+	threshold float64
+	// 限速(MB/s), 0时使用chunker配置的默认值
+	bandwidth int
+}
+
+// NewRebalanceController returns a new *Controller.
+func NewRebalanceController(nscli *clientbuilder.NameserverClient, chunkerClient *http.Client) *Controller {
+	c := &Controller{
+		nameserverClient: nscli,
+		chunkerClient:    chunkerClient,
+		interval:         time.Duration(config.GetInt("rebalance.interval")) * time.Second,
+		threshold:        float64(config.GetInt("rebalance.threshold")) / 100,
+		bandwidth:        config.GetInt("rebalance.bandwidth"),
+	}
+	if c.interval <= 0 {
+		c.interval = defaultInterval
+	}
+	if c.threshold <= 0 {
+		c.threshold = defaultThreshold
+	}
+	return c
+}
+
+func (c *Controller) Run(workers int, stopCh <-chan struct{}) {
+	defer runtime.HandleCrash()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.check()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (c *Controller) check() {
+	nodes, err := c.nameserverClient.GetChunkerNodes(client.WithTrack(nil))
+	if err != nil {
+		logger.Error("get chunker nodes err: ", err)
+		return
+	}
+	node, status, ok := c.pick(nodes)
+	if !ok {
+		return
+	}
+	if len(status.Endpoints) < 2 {
+		return
+	}
+	min, max := status.Endpoints[0].Ratio, status.Endpoints[len(status.Endpoints)-1].Ratio
+	if max-min <= c.threshold {
+		return
+	}
+
+	logger.Infof("endpoint usage differs by %.2f, start rebalance", max-min)
+	query := fmt.Sprintf("action=start&threshold=%d", int(math.Round(c.threshold*100)))
+	if c.bandwidth > 0 {
+		query += fmt.Sprintf("&bandwidth=%d", c.bandwidth)
+	}
+	if _, _, err = c.rebalance(node, http.MethodPost, query); err != nil {
+		logger.Error("start rebalance err: ", err)
+	}
+}
+
+// pick returns the chunker to run the job and its status. ok is false if the job is already running
+// or paused on any chunker, or no chunker supports it.
+// 上次执行任务的chunker仍在时继续使用, 否则选择地址最小的chunker
+func (c *Controller) pick(nodes []util.ChunkerNodeInfo) (node util.ChunkerNodeInfo, status rebalanceStatus, ok bool) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Endpoint < nodes[j].Endpoint })
+	found := false
+	for _, n := range nodes {
+		s, supported, err := c.rebalance(n, http.MethodGet, "")
+		if err != nil {
+			logger.Errorf("get rebalance status of %s err: %s", n.Endpoint, err)
+			continue
+		}
+		if !supported {
+			continue
+		}
+		// 运行中或被人为暂停时不干预
+		if s.State != "idle" {
+			c.chunker = n.Endpoint
+			logger.Infof("rebalance %s on %s, moved: %d, copied: %d, failed: %d", s.State, n.Endpoint, s.Moved, s.Copied, s.Failed)
+			return n, s, false
+		}
+		if !found || n.Endpoint == c.chunker {
+			node, status, found = n, s, true
+		}
+	}
+	if found {
+		c.chunker = node.Endpoint
+	}
+	return node, status, found
+}
+
+// rebalance calls the chunker rebalance api, supported is false if the storage provider cannot rebalance
+func (c *Controller) rebalance(node util.ChunkerNodeInfo, method, query string) (status rebalanceStatus, supported bool, err error) {
+	u := fmt.Sprintf("http://%s/cs/v1/admin/rebalance", node.Endpoint)
+	if query != "" {
+		u += "?" + query
+	}
+	request, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return status, false, err
+	}
+
+	resp, err := c.chunkerClient.Do(request)
+	if err != nil {
+		return status, false, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return status, false, err
+	}
+	if resp.StatusCode == http.StatusNotImplemented {
+		return status, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return status, true, fmt.Errorf("status: %d, %s", resp.StatusCode, body)
+	}
+	err = json.Unmarshal(body, &status)
+	return status, true, err
+}
@@ -1,31 +1,226 @@
 package replication
 
 import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
 	"mtcloud.com/mtstorage/cmd/controller/app/clientbuilder"
+	"mtcloud.com/mtstorage/cmd/controller/app/util/workqueue"
+	"mtcloud.com/mtstorage/node/client"
+	"mtcloud.com/mtstorage/node/util"
+	"mtcloud.com/mtstorage/pkg/config"
+	"mtcloud.com/mtstorage/pkg/logger"
 	"mtcloud.com/mtstorage/pkg/runtime"
 )
 
-// Controller manages selector-based service bucket.
+const (
+	defaultInterval = time.Hour
+	defaultBatch    = 500
+	maxRetries      = 5
+)
+
+// 巡检进度和积压, 通过 /debug/vars 暴露
+var (
+	stats           = expvar.NewMap("replication")
+	backlog         = new(expvar.Int)
+	scanned         = new(expvar.Int)
+	audited         = new(expvar.Int)
+	underReplicated = new(expvar.Int)
+	repaired        = new(expvar.Int)
+	failed          = new(expvar.Int)
+	lastPass        = new(expvar.String)
+)
+
+func init() {
+	stats.Set("backlog", backlog)
+	stats.Set("scanned", scanned)
+	stats.Set("audited", audited)
+	stats.Set("under_replicated", underReplicated)
+	stats.Set("repaired", repaired)
+	stats.Set("failed", failed)
+	stats.Set("last_pass", lastPass)
+}
+
+// replicaReport 与chunker /cs/v1/replicas 的返回一致
+type replicaReport struct {
+	Cid   string   `json:"cid"`
+	Want  int      `json:"want"`
+	Have  int      `json:"have"`
+	Added []string `json:"added"`
+}
+
+// Controller audits every referenced cid against the replication factor
+// and re-pins missing copies onto healthy endpoints through the chunker.
 type Controller struct {
+	queue workqueue.RateLimitingInterface
+
 	nameserverClient *clientbuilder.NameserverClient
+	chunkerClient    *http.Client
+
+	interval time.Duration
+	batch    int
 }
 
 // NewReplicationController returns a new *Controller.
-func NewReplicationController(nscli *clientbuilder.NameserverClient) *Controller {
+func NewReplicationController(nscli *clientbuilder.NameserverClient, chunkerClient *http.Client) *Controller {
 
 	c := &Controller{
+		queue:            workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "replication"),
 		nameserverClient: nscli,
+		chunkerClient:    chunkerClient,
+		interval:         time.Duration(config.GetInt("replication.interval")) * time.Second,
+		batch:            config.GetInt("replication.batch"),
+	}
+	if c.interval <= 0 {
+		c.interval = defaultInterval
+	}
+	if c.batch <= 0 {
+		c.batch = defaultBatch
 	}
 	return c
 }
 
 func (c *Controller) Run(workers int, stopCh <-chan struct{}) {
+	defer runtime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	for i := 0; i < workers; i++ {
+		go c.worker()
+	}
 
 	go func() {
 		defer runtime.HandleCrash()
+		c.scan()
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.scan()
+			case <-stopCh:
+				return
+			}
+		}
 	}()
 
-	//todo: start work
-
 	<-stopCh
 }
+
+// scan queues all referenced cids, the queue ignores cids still waiting from the last pass
+func (c *Controller) scan() {
+	logger.Info("start replication audit")
+	lastPass.Set(time.Now().Format(time.RFC3339))
+	scanned.Set(0)
+
+	var marker uint
+	for {
+		refs, err := c.nameserverClient.GetReferencedCids(client.WithTrack(nil), marker, c.batch)
+		if err != nil {
+			logger.Error("get referenced cids err: ", err)
+			return
+		}
+		for _, ref := range refs {
+			c.queue.Add(ref.Cid)
+			marker = ref.ID
+		}
+		scanned.Add(int64(len(refs)))
+		backlog.Set(int64(c.queue.Len()))
+		if len(refs) < c.batch {
+			break
+		}
+	}
+	logger.Infof("replication audit queued %d cids", scanned.Value())
+}
+
+func (c *Controller) worker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *Controller) processNextWorkItem() bool {
+	item, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(item)
+	defer func() { backlog.Set(int64(c.queue.Len())) }()
+
+	cid := item.(string)
+	err := c.sync(cid)
+	if err == nil {
+		c.queue.Forget(item)
+		return true
+	}
+	if c.queue.NumRequeues(item) < maxRetries {
+		logger.Warnf("repair %s err, retry: %s", cid, err)
+		c.queue.AddRateLimited(item)
+		return true
+	}
+	logger.Errorf("repair %s fail: %s", cid, err)
+	failed.Add(1)
+	c.queue.Forget(item)
+	return true
+}
+
+func (c *Controller) sync(cid string) error {
+	node, err := c.nameserverClient.GetChunkerNode(client.WithTrack(nil))
+	if err != nil {
+		return err
+	}
+
+	report, supported, err := c.replicas(node, http.MethodGet, cid)
+	if err != nil || !supported {
+		return err
+	}
+	audited.Add(1)
+	if report.Have >= report.Want {
+		return nil
+	}
+
+	logger.Warnf("%s under-replicated, have: %d, want: %d", cid, report.Have, report.Want)
+	underReplicated.Add(1)
+	report, _, err = c.replicas(node, http.MethodPost, cid)
+	if len(report.Added) > 0 {
+		logger.Infof("%s re-pinned on %v", cid, report.Added)
+	}
+	if err != nil {
+		return err
+	}
+	repaired.Add(1)
+	return nil
+}
+
+// replicas calls the chunker replicas api, supported is false if the storage provider manages replicas by itself
+func (c *Controller) replicas(node util.ChunkerNodeInfo, method, cid string) (report replicaReport, supported bool, err error) {
+	u := fmt.Sprintf("http://%s/cs/v1/replicas?cid=%s", node.Endpoint, url.QueryEscape(cid))
+	request, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return report, false, err
+	}
+
+	resp, err := c.chunkerClient.Do(request)
+	if err != nil {
+		return report, false, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return report, false, err
+	}
+	if resp.StatusCode == http.StatusNotImplemented {
+		return report, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		// 部分修复时返回的仍是副本情况
+		json.Unmarshal(body, &report)
+		return report, true, fmt.Errorf("status: %d, %s", resp.StatusCode, body)
+	}
+	err = json.Unmarshal(body, &report)
+	return report, true, err
+}
@@ -3,10 +3,13 @@ package app
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"time"
 
+	"mtcloud.com/mtstorage/cmd/controller/app/controller/gc"
 	"mtcloud.com/mtstorage/cmd/controller/app/controller/ipfs"
+	"mtcloud.com/mtstorage/cmd/controller/app/controller/rebalance"
 	"mtcloud.com/mtstorage/cmd/controller/app/informers/core"
 	"mtcloud.com/mtstorage/node/client"
 	"mtcloud.com/mtstorage/pkg/crypto"
@@ -46,6 +49,15 @@ func Run(args []string, stopCh <-chan struct{}) error {
 		lock.Init(redisaddr, redispwd)
 	}
 
+	//expose controller metrics on /debug/vars
+	if addr := config.GetString("metrics.addr"); addr != "" {
+		go func() {
+			if err := http.ListenAndServe(addr, nil); err != nil {
+				logger.Errorf("metrics server stopped: %s", err)
+			}
+		}()
+	}
+
 	run := func(ctx context.Context, startSATokenController InitFunc, initializersFunc ControllerInitializersFunc) {
 		//client builder
 		clientBuilder := clientbuilder.CreateControllerClientBuilder()
@@ -137,6 +149,8 @@ func NewControllerInitializers() map[string]InitFunc {
 	controllers["bucketLogArchive"] = startBucketLogArchiveController
 	controllers["replication"] = startReplicationController
 	controllers["IpfsCidAnalysis"] = startIpfsCidAnalysisController
+	controllers["gc"] = startGCController
+	controllers["rebalance"] = startRebalanceController
 
 	return controllers
 
@@ -228,6 +242,7 @@ func startReplicationController(ctx context.Context, controllerCtx ControllerCon
 	logger.Info("start replication controller")
 	go replication.NewReplicationController(
 		controllerCtx.ClientBuilder.NameserverClient(),
+		controllerCtx.ClientBuilder.ChunkerClient(),
 	).Run(3, ctx.Done())
 
 	return nil, true, nil
@@ -238,7 +253,28 @@ func startIpfsCidAnalysisController(ctx context.Context, controllerCtx Controlle
 	go ipfs.NewIpfsCidAnalysisController(
 		controllerCtx.InformerFactory.Core().Objects(),
 		controllerCtx.ClientBuilder.NameserverClient(),
+		controllerCtx.ClientBuilder.ChunkerClient(),
 	).Run(3, ctx.Done())
 
 	return nil, true, nil
 }
+
+func startGCController(ctx context.Context, controllerCtx ControllerContext) (controller Interface, enabled bool, err error) {
+	logger.Info("start gc controller")
+	go gc.NewGCController(
+		controllerCtx.ClientBuilder.NameserverClient(),
+		controllerCtx.ClientBuilder.ChunkerClient(),
+	).Run(1, ctx.Done())
+
+	return nil, true, nil
+}
+
+func startRebalanceController(ctx context.Context, controllerCtx ControllerContext) (controller Interface, enabled bool, err error) {
+	logger.Info("start rebalance controller")
+	go rebalance.NewRebalanceController(
+		controllerCtx.ClientBuilder.NameserverClient(),
+		controllerCtx.ClientBuilder.ChunkerClient(),
+	).Run(1, ctx.Done())
+
+	return nil, true, nil
+}
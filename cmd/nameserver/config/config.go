@@ -22,6 +22,7 @@ type NameServerConfig struct {
 	DB      db.DBconfig
 	Redis   config.RedisConfig
 	Profile config.ProfileConfig
+	Auth    config.AuthConfig
 }
 
 type NodeConfig struct {
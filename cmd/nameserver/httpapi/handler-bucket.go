@@ -12,6 +12,7 @@ import (
 
 	util3 "mtcloud.com/mtstorage/cmd/controller/app/informers/core/util"
 	"mtcloud.com/mtstorage/pkg/logger"
+	policy2 "mtcloud.com/mtstorage/pkg/policy"
 	error2 "mtcloud.com/mtstorage/pkg/storageerror"
 
 	"go.opencensus.io/trace"
@@ -38,7 +39,21 @@ func (h *NameserverAPIHandlers) GetStorageInfo(w http.ResponseWriter, r *http.Re
 
 	ss := h.backend.GetStorageInfoFromNameServer(ctx)
 	disks := make([]util.Disk, 0)
+	// 多个chunker共用相同的存储节点, 按存储节点去重, 优先使用可连接的chunker上报的状态
+	seen := make(map[string]int)
 	for _, ele := range ss {
+		if len(ele.Disks) != 0 {
+			for _, d := range ele.Disks {
+				i, ok := seen[d.Endpoint]
+				if !ok {
+					seen[d.Endpoint] = len(disks)
+					disks = append(disks, d)
+				} else if disks[i].State == util.State_Offline {
+					disks[i] = d
+				}
+			}
+			continue
+		}
 		disks = append(disks, util.Disk{
 			Endpoint:       ele.Endpoint,
 			UUID:           ele.UUID,
@@ -118,6 +133,12 @@ func (h *NameserverAPIHandlers) ListBucketHandler(w http.ResponseWriter, r *http
 		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.InvalidArgument{Err: err}), r.URL)
 		return
 	}
+	// 按所有者列出, 非管理员只能列出自己的桶
+	if !api.IsAdminRequest(ctx) && !api.IsOwnerRequest(ctx, uint32(parseUID)) {
+		logger.Warnf("list buckets of user %d is denied", parseUID)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.AccessDenied{}), r.URL)
+		return
+	}
 
 	var bs []metadata.BucketInfo
 	if parseUID == 0x7fffffff {
@@ -173,18 +194,36 @@ func (h *NameserverAPIHandlers) MakeBucketWithLocationHandler(w http.ResponseWri
 		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.InvalidArgument{Err: err}), r.URL)
 		return
 	}
-	json.Unmarshal(body, &obj)
+	if err = json.Unmarshal(body, &obj); err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.InvalidArgument{Err: err}), r.URL)
+		return
+	}
 	u_id, ok := obj["user_id"].(float64)
 	if !ok {
 		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx,
 			error2.InvalidArgument{Err: fmt.Errorf("user_id error")}),
 			r.URL)
+		return
 	}
+	name, _ := obj["bucket"].(string)
+	storageClass, _ := obj["storageclass"].(string)
+	location, _ := obj["location"].(string)
+	acl, _ := obj["acl"].(string)
 	var bi = metadata.BucketInfo{
-		Name:         obj["bucket"].(string),
+		Name:         name,
 		Owner:        uint32(u_id),
-		StorageClass: obj["storageclass"].(string),
-		Location:     obj["location"].(string),
+		StorageClass: storageClass,
+		Location:     location,
+	}
+	// 桶已存在时由桶的策略检查, 非管理员只能为自己创建桶
+	if err = api.CheckPolicy(r, policy2.CreateBucketAction, bi.Name, ""); err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	if !api.IsAdminRequest(ctx) && !api.IsOwnerRequest(ctx, bi.Owner) {
+		logger.Warnf("create bucket %s for user %d is denied", bi.Name, bi.Owner)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.AccessDenied{Bucket: bi.Name}), r.URL)
+		return
 	}
 	bi.Bucketid = fmt.Sprintf("%x", md5.Sum([]byte(bi.Name)))
 	bi.Versioning = metadata.VersioningUnset
@@ -198,7 +237,7 @@ func (h *NameserverAPIHandlers) MakeBucketWithLocationHandler(w http.ResponseWri
 		return
 	}
 
-	if err = metadata.PutBucketAcl(ctx, bi.Name, obj["acl"].(string)); err != nil {
+	if err = metadata.PutBucketAcl(ctx, bi.Name, acl); err != nil {
 		logger.Error(err)
 		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
 		return
@@ -242,6 +281,23 @@ func (h *NameserverAPIHandlers) UpdateBucketHandler(w http.ResponseWriter, r *ht
 			r.URL)
 		return
 	}
+	// 覆盖桶的记录, 与创建桶检查相同的操作
+	if err := api.CheckPolicy(r, policy2.CreateBucketAction, ubParams.Bucket, ""); err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	old, err := metadata.QueryBucketInfo(ctx, ubParams.Bucket)
+	if err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	// 只有所有者或管理员可以修改所有者和策略
+	if (ubParams.Owner != old.Owner || ubParams.Policy != old.Policy) &&
+		!api.IsAdminRequest(ctx) && !api.IsOwnerRequest(ctx, old.Owner) {
+		logger.Warnf("change owner or policy of bucket %s is denied", ubParams.Bucket)
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.AccessDenied{Bucket: ubParams.Bucket}), r.URL)
+		return
+	}
 
 	var bi = metadata.BucketInfo{
 		Name:       ubParams.Bucket,
@@ -497,6 +553,15 @@ func (h NameserverAPIHandlers) PutBucketPolicyHandler(w http.ResponseWriter, r *
 		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.InvalidArgument{Err: err}), r.URL)
 		return
 	}
+	if len(policy) > maxBucketPolicySize {
+		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrPolicyTooLarge), r.URL)
+		return
+	}
+	if _, err = policy2.Parse(string(policy), bucket); err != nil {
+		logger.Errorf("invalid policy of bucket %s: %s", bucket, err)
+		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErrWithErr(error2.ErrMalformedPolicy, err), r.URL)
+		return
+	}
 
 	err = metadata.PutBucketPolicy(ctx, bucket, string(policy))
 	if err != nil {
@@ -670,6 +735,9 @@ func (h NameserverAPIHandlers) PutBucketAclHandler(w http.ResponseWriter, r *htt
 	}
 
 	acl, err := ioutil.ReadAll(r.Body)
+	if err == nil && !policy2.IsValidACL(string(acl), false) {
+		err = fmt.Errorf("invalid acl %q", acl)
+	}
 	if err != nil {
 		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.InvalidArgument{Err: err}), r.URL)
 		return
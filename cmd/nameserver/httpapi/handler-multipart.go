@@ -0,0 +1,49 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/api"
+	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+	"mtcloud.com/mtstorage/util"
+)
+
+// ListMultipartUploadsHandler 列举桶中进行中的分片上传
+func (h *NameserverAPIHandlers) ListMultipartUploadsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "ListMultipartUploadsHandler")
+	defer span.End()
+	vars := r.URL.Query()
+	bucket := vars.Get("bucket")
+	if bucket == "" {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx,
+			error2.InvalidArgument{Err: fmt.Errorf("bucket name empty")}),
+			r.URL)
+		return
+	}
+	maxUploads := 0
+	if s := vars.Get("max-uploads"); s != "" {
+		var err error
+		if maxUploads, err = strconv.Atoi(s); err != nil || maxUploads < 0 {
+			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx,
+				error2.InvalidArgument{Err: fmt.Errorf("max-uploads param %s error", s)}),
+				r.URL)
+			return
+		}
+	}
+	if !metadata.CheckBucketExist(ctx, bucket) {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.BucketNotFound{Bucket: bucket}), r.URL)
+		return
+	}
+
+	result, err := metadata.ListMultipartUploads(ctx, bucket, vars.Get("prefix"),
+		vars.Get("key-marker"), vars.Get("upload-id-marker"), maxUploads)
+	if err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, result)
+}
@@ -16,6 +16,7 @@ import (
 
 	"mtcloud.com/mtstorage/api"
 	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
+	"mtcloud.com/mtstorage/pkg/policy"
 	error2 "mtcloud.com/mtstorage/pkg/storageerror"
 	"mtcloud.com/mtstorage/util"
 )
@@ -291,6 +292,9 @@ func (h *NameserverAPIHandlers) PutObjectAclHandler(w http.ResponseWriter, r *ht
 	}
 
 	acl, err := ioutil.ReadAll(r.Body)
+	if err == nil && !policy.IsValidACL(string(acl), true) {
+		err = fmt.Errorf("invalid acl %q", acl)
+	}
 	if err != nil {
 		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.InvalidArgument{Err: err}), r.URL)
 		return
@@ -488,3 +492,60 @@ func (h *NameserverAPIHandlers) GetObjectCidHandler(w http.ResponseWriter, r *ht
 	util.WriteJsonQuiet(w, http.StatusOK, res)
 	return
 }
+
+// CopyObjectHandler copies the object by its cid, no data is read.
+// bucket=xx&object=xx&srcBucket=xx&srcObject=xx&srcVersionId=xx&storageClass=xx&acl=xx
+func (h *NameserverAPIHandlers) CopyObjectHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "CopyObjectHandler")
+	defer span.End()
+
+	vars := r.URL.Query()
+	bucket, object := vars.Get("bucket"), vars.Get("object")
+	srcBucket, srcObject := vars.Get("srcBucket"), vars.Get("srcObject")
+	if bucket == "" || srcBucket == "" || srcObject == "" || object == "" || strings.HasSuffix(object, "/") {
+		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrInvalidCopySource), r.URL)
+		return
+	}
+	for _, b := range []string{srcBucket, bucket} {
+		if !metadata.CheckBucketExist(ctx, b) {
+			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.BucketNotFound{Bucket: b}), r.URL)
+			return
+		}
+	}
+	// 目标桶由路由检查, 源对象需要读权限
+	if err := api.CheckPolicy(r, policy.GetObjectAction, srcBucket, strings.TrimPrefix(srcObject, "/")); err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+
+	src := metadata.ObjectOptions{Bucket: srcBucket, VersionID: vars.Get("srcVersionId")}
+	src.Prefix, src.Object = splitObjectPath(srcObject)
+	dst := &metadata.ObjectInfo{
+		Bucket:       bucket,
+		StorageClass: vars.Get("storageClass"),
+		Acl:          vars.Get("acl"),
+	}
+	dst.Dirname, dst.Name = splitObjectPath(object)
+	if err := metadata.CopyObjectInfo(ctx, src, dst); err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+
+	bi, _ := metadata.QueryBucketInfo(ctx, bucket)
+	if bi.Versioning != metadata.VersioningEnabled {
+		dst.Version = ""
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, dst)
+}
+
+// splitObjectPath returns the dirname and the name of the object like parseObjectOptions
+func splitObjectPath(object string) (string, string) {
+	if !strings.HasPrefix(object, "/") {
+		object = "/" + object
+	}
+	prefix := path.Dir(object)
+	if prefix == "." || prefix == "" {
+		prefix = "/"
+	}
+	return prefix, path.Base(object)
+}
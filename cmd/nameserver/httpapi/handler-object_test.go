@@ -24,3 +24,20 @@ func Test_parseObjectOptions(t *testing.T) {
 	t.Log(r.FormValue("object"))
 	t.Log(r.URL.Query().Get("object"))
 }
+
+func Test_splitObjectPath(t *testing.T) {
+	tests := []struct {
+		object, prefix, name string
+	}{
+		{"a.txt", "/", "a.txt"},
+		{"/a.txt", "/", "a.txt"},
+		{"dir/sub/a.txt", "/dir/sub", "a.txt"},
+		{"/dir/a.txt", "/dir", "a.txt"},
+	}
+	for i, test := range tests {
+		prefix, name := splitObjectPath(test.object)
+		if prefix != test.prefix || name != test.name {
+			t.Fatalf("Test %d: got %s, %s - want %s, %s", i, prefix, name, test.prefix, test.name)
+		}
+	}
+}
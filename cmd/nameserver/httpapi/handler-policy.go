@@ -0,0 +1,119 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/api"
+	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
+	"mtcloud.com/mtstorage/pkg/auth"
+	"mtcloud.com/mtstorage/pkg/policy"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+	"mtcloud.com/mtstorage/util"
+)
+
+// 与 t_ns_bucket_ext.policy 的长度一致
+const maxBucketPolicySize = 20480
+
+// explainRequest 需要评估的请求, 秘钥或用户都为空时为匿名请求
+type explainRequest struct {
+	AccessKey string `json:"accessKey"`
+	User      string `json:"user"`
+	Action    string `json:"action"`
+	Object    string `json:"object"`
+	SourceIp  string `json:"sourceIp"`
+	UserAgent string `json:"userAgent"`
+	Referer   string `json:"referer"`
+	Prefix    string `json:"prefix"`
+	// Time 请求的时间, RFC3339格式, 默认为当前时间
+	Time string `json:"time"`
+	// Policy 试运行的策略, 为空时使用桶已保存的策略
+	Policy json.RawMessage `json:"policy"`
+}
+
+// ExplainBucketPolicyHandler evaluates the bucket policy for the given request without executing it,
+// and reports which statement allows or denies the request.
+// /ns/v1/policy/explain?bucket=xx [post] {"user":"xx","action":"s3:GetObject","object":"xx","sourceIp":"xx"}
+func (h *NameserverAPIHandlers) ExplainBucketPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "ExplainBucketPolicyHandler")
+	defer span.End()
+
+	bucket := r.URL.Query().Get("bucket")
+	var req explainRequest
+	if err := api.GetValidator().ReadJsonObject(r, &req); err != nil || bucket == "" || req.Action == "" {
+		if err == nil {
+			err = errors.New("bucket or action empty")
+		}
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.InvalidArgument{Err: err}), r.URL)
+		return
+	}
+
+	access, err := metadata.GetBucketAccess(ctx, bucket)
+	if err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	if len(req.Policy) > 0 && string(req.Policy) != "null" {
+		access.Policy = string(req.Policy)
+	}
+	p, err := policy.Parse(access.Policy, bucket)
+	if err != nil {
+		api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErrWithErr(error2.ErrMalformedPolicy, err), r.URL)
+		return
+	}
+
+	args := policy.Args{
+		Action:     req.Action,
+		Bucket:     bucket,
+		Object:     strings.TrimPrefix(req.Object, "/"),
+		Conditions: make(map[string]string),
+	}
+	// 请求者及是否为桶的所有者
+	switch {
+	case req.AccessKey != "":
+		cred, err := metadata.GetCredentials(ctx, req.AccessKey)
+		if err == auth.ErrNoSuchAccessKey {
+			err = error2.AccessKeyNotFound{AccessKey: req.AccessKey}
+		}
+		if err != nil {
+			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+			return
+		}
+		args.AccessKey, args.User, args.IsOwner = cred.AccessKey, cred.User, cred.Owner == access.Owner
+	case req.User != "":
+		u, _, err := metadata.QueryUser(ctx, req.User)
+		if err != nil {
+			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+			return
+		}
+		args.User, args.IsOwner = u.Name, u.ID == access.Owner
+	}
+	args.BucketACL = access.Acl
+	if args.Action == policy.GetObjectAction && args.Object != "" {
+		if args.ObjectACL, err = metadata.GetObjectAcl(ctx, bucket, args.Object); err != nil {
+			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+			return
+		}
+	}
+
+	if req.Time == "" {
+		req.Time = time.Now().UTC().Format(time.RFC3339)
+	}
+	for k, v := range map[string]string{
+		policy.KeyCurrentTime: req.Time,
+		policy.KeyUsername:    args.User,
+		policy.KeySourceIp:    req.SourceIp,
+		policy.KeyUserAgent:   req.UserAgent,
+		policy.KeyReferer:     req.Referer,
+		policy.KeyPrefix:      req.Prefix,
+	} {
+		if v != "" {
+			args.Conditions[k] = v
+		}
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, p.Explain(args))
+}
@@ -0,0 +1,206 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/api"
+	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+	"mtcloud.com/mtstorage/util"
+)
+
+// userResponse 用户及其秘钥, 新建的秘钥只在创建时返回secretKey
+type userResponse struct {
+	User        metadata.UserInfo        `json:"user"`
+	AccessKeys  []metadata.AccessKeyInfo `json:"accessKeys,omitempty"`
+	Credentials interface{}              `json:"credentials,omitempty"`
+}
+
+// checkAdmin 用户管理接口只允许管理秘钥访问
+func checkAdmin(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	if api.IsAdminRequest(ctx) {
+		return true
+	}
+	api.WriteErrorResponseJSON(w, error2.ErrorCodes.ToAPIErr(error2.ErrAccessDenied), r.URL)
+	return false
+}
+
+func checkStatus(status string) error {
+	if status != metadata.StatusEnabled && status != metadata.StatusDisabled {
+		return error2.InvalidArgument{Err: fmt.Errorf("invalid status %s", status)}
+	}
+	return nil
+}
+
+// CreateUserHandler creates the user with an access key
+// /ns/v1/admin/users [post] {"name":"xx","tenant":"xx"}
+func (h *NameserverAPIHandlers) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "CreateUserHandler")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	var params struct {
+		Name   string `json:"name"`
+		Tenant string `json:"tenant"`
+	}
+	if err := api.GetValidator().ReadJsonObject(r, &params); err != nil || params.Name == "" {
+		if err == nil {
+			err = errors.New("user name empty")
+		}
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, error2.InvalidArgument{Err: err}), r.URL)
+		return
+	}
+
+	u := metadata.UserInfo{Name: params.Name, Tenant: params.Tenant}
+	cred, err := metadata.CreateUser(ctx, &u)
+	if err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, userResponse{User: u, Credentials: cred})
+}
+
+// GetUsersHandler lists the users, or returns the user with its access keys if the name is given
+// /ns/v1/admin/users?name=xx [get]
+func (h *NameserverAPIHandlers) GetUsersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "GetUsersHandler")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		users, err := metadata.ListUsers(ctx)
+		if err != nil {
+			api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+			return
+		}
+		util.WriteJsonQuiet(w, http.StatusOK, users)
+		return
+	}
+	u, keys, err := metadata.QueryUser(ctx, name)
+	if err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, userResponse{User: u, AccessKeys: keys})
+}
+
+// SetUserStatusHandler enables or disables the user
+// /ns/v1/admin/users?name=xx&status=enabled|disabled [put]
+func (h *NameserverAPIHandlers) SetUserStatusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "SetUserStatusHandler")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	vars := r.URL.Query()
+	status := vars.Get("status")
+	if err := checkStatus(status); err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	if err := metadata.SetUserStatus(ctx, vars.Get("name"), status); err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, "success")
+}
+
+// DeleteUserHandler deletes the user and its access keys
+// /ns/v1/admin/users?name=xx [delete]
+func (h *NameserverAPIHandlers) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "DeleteUserHandler")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	if err := metadata.DeleteUser(ctx, r.URL.Query().Get("name")); err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, "success")
+}
+
+// CreateAccessKeyHandler creates a new access key of the user
+// /ns/v1/admin/users/keys?name=xx [post]
+func (h *NameserverAPIHandlers) CreateAccessKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "CreateAccessKeyHandler")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	cred, err := metadata.CreateAccessKey(ctx, r.URL.Query().Get("name"))
+	if err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, cred)
+}
+
+// RotateAccessKeyHandler replaces the access key of the user with a new one
+// /ns/v1/admin/users/keys/rotate?name=xx&accessKey=xx [post]
+func (h *NameserverAPIHandlers) RotateAccessKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "RotateAccessKeyHandler")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	vars := r.URL.Query()
+	cred, err := metadata.RotateAccessKey(ctx, vars.Get("name"), vars.Get("accessKey"))
+	if err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, cred)
+}
+
+// SetAccessKeyStatusHandler enables or disables the access key of the user
+// /ns/v1/admin/users/keys?name=xx&accessKey=xx&status=enabled|disabled [put]
+func (h *NameserverAPIHandlers) SetAccessKeyStatusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "SetAccessKeyStatusHandler")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	vars := r.URL.Query()
+	status := vars.Get("status")
+	if err := checkStatus(status); err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	if err := metadata.SetAccessKeyStatus(ctx, vars.Get("name"), vars.Get("accessKey"), status); err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, "success")
+}
+
+// DeleteAccessKeyHandler deletes the access key of the user
+// /ns/v1/admin/users/keys?name=xx&accessKey=xx [delete]
+func (h *NameserverAPIHandlers) DeleteAccessKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.StartSpan(r.Context(), "DeleteAccessKeyHandler")
+	defer span.End()
+	if !checkAdmin(ctx, w, r) {
+		return
+	}
+
+	vars := r.URL.Query()
+	if err := metadata.DeleteAccessKey(ctx, vars.Get("name"), vars.Get("accessKey")); err != nil {
+		api.WriteErrorResponseJSON(w, error2.ToAPIError(ctx, err), r.URL)
+		return
+	}
+	util.WriteJsonQuiet(w, http.StatusOK, "success")
+}
@@ -11,6 +11,7 @@ import (
 
 	xhttp "mtcloud.com/mtstorage/pkg/http"
 	"mtcloud.com/mtstorage/pkg/logger"
+	"mtcloud.com/mtstorage/pkg/policy"
 )
 
 const (
@@ -59,39 +60,47 @@ func RegisterAPIRouter(router *mux.Router, ns *backend.NameServer) {
 
 	//xxx:8000/ns/v1/bucketinfo [get]
 	apiRouter.Methods(http.MethodGet).Path("/bucketinfo").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.GetBucketInfoDetail))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.ListBucketAction, nsAPI.GetBucketInfoDetail)))))
+
+	// 公开读的桶及对象允许匿名查询对象信息及列举
+	api.AllowAnonymousRead("/ns/"+nameserverPIVersion+"/object", "/ns/"+nameserverPIVersion+"/headobject",
+		"/ns/"+nameserverPIVersion+"/object/list")
 
 	// /ns/v1/object?bucket=xxx&&object=xxx  [get]
 	apiRouter.Methods(http.MethodGet).Path("/object").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.GetObjectInfoHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetObjectAction, nsAPI.GetObjectInfoHandler)))))
 
 	// /ns/v1/headobject?bucket=xxx&&object=xxx  [get]
 	apiRouter.Methods(http.MethodHead).Path("/headobject").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.HeadObjectHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetObjectAction, nsAPI.HeadObjectHandler)))))
 
 	// /ns/v1/object/list?xxx  [get] listobject
 	apiRouter.Methods(http.MethodGet).Path("/object/list").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.ListObjectsHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.ListBucketAction, nsAPI.ListObjectsHandler)))))
 
 	// /ns/v1/object/listversions?xxx  [get]
 	apiRouter.Methods(http.MethodGet).Path("/object/versions").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.ListObjectVersionsHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.ListBucketVersionsAction, nsAPI.ListObjectVersionsHandler)))))
 
 	// /ns/v1/object/get/xxxx  [get]
 	apiRouter.Methods(http.MethodGet).Path("/object/get/{object:.+}").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.GetObjectData))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetObjectAction, nsAPI.GetObjectData)))))
 
 	// /ns/v1/object/delete?bucket=xxxx&object=xxx [delete]
 	apiRouter.Methods(http.MethodDelete).Path("/object/delete").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.DeleteObject))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.DeleteObjectAction, nsAPI.DeleteObject)))))
+
+	// /ns/v1/object/copy?bucket=xxx&object=xxx&srcBucket=xxx&srcObject=xxx&srcVersionId=xxx [post]
+	apiRouter.Methods(http.MethodPost).Path("/object/copy").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutObjectAction, nsAPI.CopyObjectHandler)))))
 
 	// /ns/v1/object/check?bucket=xxx&object=xxx [get]
 	apiRouter.Methods(http.MethodGet).Path("/object/check").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.ObjectExistHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetObjectAction, nsAPI.ObjectExistHandler)))))
 
 	// /ns/v1/object/cid?bucket=xxx&object=xxx [get]
 	apiRouter.Methods(http.MethodGet).Path("/object/cid").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.GetObjectCidHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetObjectAction, nsAPI.GetObjectCidHandler)))))
 
 	// /ns/v1/chunker/address [get]
 	apiRouter.Methods(http.MethodGet).Path("/chunker/address").HandlerFunc(
@@ -101,99 +110,131 @@ func RegisterAPIRouter(router *mux.Router, ns *backend.NameServer) {
 	apiRouter.Methods(http.MethodGet).Path("/chunker/address/all").HandlerFunc(
 		maxClients(gz(api.HttpTraceAll(nsAPI.GetAllChunkerAddresses))))
 
+	// /ns/v1/multipart/list?bucket=xxx&prefix=xxx&key-marker=xxx&upload-id-marker=xxx&max-uploads=xxx [get]
+	apiRouter.Methods(http.MethodGet).Path("/multipart/list").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.ListBucketMultipartUploadsAction, nsAPI.ListMultipartUploadsHandler)))))
+
 	// /ns/v1/object/tag   [delete]
 	apiRouter.Methods(http.MethodDelete).Path("/object/tag").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.DeleteObjectTagsHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.DeleteObjectTaggingAction, nsAPI.DeleteObjectTagsHandler)))))
 	// /ns/v1/object/tag  [get]
 	apiRouter.Methods(http.MethodGet).Path("/object/tag").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.GetObjectTagsHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetObjectTaggingAction, nsAPI.GetObjectTagsHandler)))))
 	// /ns/v1/object/tag  [put]
 	apiRouter.Methods(http.MethodPut).Path("/object/tag").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.PutObjectTagsHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutObjectTaggingAction, nsAPI.PutObjectTagsHandler)))))
 	// /ns/v1/object/acl?bucket=xx&object=xx   [delete]
 	apiRouter.Methods(http.MethodDelete).Path("/object/acl").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.DeleteObjectAclHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutObjectAclAction, nsAPI.DeleteObjectAclHandler)))))
 	// /ns/v1/object/acl?bucket=xx&object=xx  [get]
 	apiRouter.Methods(http.MethodGet).Path("/object/acl").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.GetObjectAclHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetObjectAclAction, nsAPI.GetObjectAclHandler)))))
 	// /ns/v1/object/acl?bucket=xx&object=xxacl=xx  [put]
 	apiRouter.Methods(http.MethodPut).Path("/object/acl").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.PutObjectAclHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutObjectAclAction, nsAPI.PutObjectAclHandler)))))
 
 	// /ns/v1/object/metadata  [put]
 	apiRouter.Methods(http.MethodPost).Path("/object/metadata").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.PutObjectMetadata))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutObjectAction, nsAPI.PutObjectMetadata)))))
 
 	// /ns/v1/bucket [post]
 	apiRouter.Methods(http.MethodPost).Path("/bucket").HandlerFunc(
 		maxClients(gz(api.HttpTraceAll(nsAPI.MakeBucketWithLocationHandler))))
-	// /ns/v1/bucket?name=xxxx [get]
+	// /ns/v1/bucket?userid=xxxx&bucket=xxxx [get]
 	apiRouter.Methods(http.MethodGet).Path("/bucket").HandlerFunc(
 		maxClients(gz(api.HttpTraceAll(nsAPI.ListBucketHandler))))
-	// /ns/v1/bucket?name=xxxx [head]
+	// /ns/v1/headbucket?bucket=xxxx [head]
 	apiRouter.Methods(http.MethodHead).Path("/headbucket").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.HeadBucketHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.ListBucketAction, nsAPI.HeadBucketHandler)))))
 	// /ns/v1/bucket [put]
 	apiRouter.Methods(http.MethodPut).Path("/bucket").HandlerFunc(
 		maxClients(gz(api.HttpTraceAll(nsAPI.UpdateBucketHandler))))
 	// /ns/v1/bucket/{bucket} [delete]
 	apiRouter.Methods(http.MethodDelete).Path("/bucket/{bucket:.+}").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.DeleteBucketHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.DeleteBucketAction, nsAPI.DeleteBucketHandler)))))
 	// /ns/v1/versioning?bucket=xx?status=xx [put]
 	apiRouter.Methods(http.MethodPut).Path("/versioning").HandlerFunc(
-		gz(api.HttpTraceAll(nsAPI.PutBucketVersioningHandler)))
+		gz(api.HttpTraceAll(api.Authorize(policy.PutBucketVersioningAction, nsAPI.PutBucketVersioningHandler))))
 	// /ns/v1/versioning?bucket=xx [get]
 	apiRouter.Methods(http.MethodGet).Path("/versioning").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.GetBucketVersioningHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetBucketVersioningAction, nsAPI.GetBucketVersioningHandler)))))
 
 	// /ns/v1/logging?bucket=xx&logging=xx [put]
 	apiRouter.Methods(http.MethodPut).Path("/logging").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.PutBucketLoggingHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutBucketLoggingAction, nsAPI.PutBucketLoggingHandler)))))
 	// /ns/v1/logging?bucket=xx [get]
 	apiRouter.Methods(http.MethodGet).Path("/logging").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.GetBucketLoggingHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetBucketLoggingAction, nsAPI.GetBucketLoggingHandler)))))
 	// /ns/v1/logging?bucket=xx [delete]
 	apiRouter.Methods(http.MethodDelete).Path("/logging").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.DeleteBucketLoggingHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutBucketLoggingAction, nsAPI.DeleteBucketLoggingHandler)))))
 	// /ns/v1/policy?bucket=xx&policy=xx [put]
 	apiRouter.Methods(http.MethodPut).Path("/policy").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.PutBucketPolicyHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutBucketPolicyAction, nsAPI.PutBucketPolicyHandler)))))
 	// /ns/v1/policy?bucket=xx [get]
 	apiRouter.Methods(http.MethodGet).Path("/policy").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.GetBucketPolicyHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetBucketPolicyAction, nsAPI.GetBucketPolicyHandler)))))
 	// /ns/v1/policy?bucket=xx [delete]
 	apiRouter.Methods(http.MethodDelete).Path("/policy").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.DeleteBucketPolicyHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.DeleteBucketPolicyAction, nsAPI.DeleteBucketPolicyHandler)))))
+	// /ns/v1/policy/explain?bucket=xx [post]
+	apiRouter.Methods(http.MethodPost).Path("/policy/explain").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetBucketPolicyAction, nsAPI.ExplainBucketPolicyHandler)))))
 	// /ns/v1/lifecycle?bucket=xx&lifecycle=xx [put]
 	apiRouter.Methods(http.MethodPut).Path("/lifecycle").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.PutBucketLifecycleHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutLifecycleConfigurationAction, nsAPI.PutBucketLifecycleHandler)))))
 	// /ns/v1/lifecycle?bucket=xx [get]
 	apiRouter.Methods(http.MethodGet).Path("/lifecycle").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.GetBucketLifecycleHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetLifecycleConfigurationAction, nsAPI.GetBucketLifecycleHandler)))))
 	// /ns/v1/lifecycle?bucket=xx [delete]
 	apiRouter.Methods(http.MethodDelete).Path("/lifecycle").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.DeleteBucketLifecycleHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutLifecycleConfigurationAction, nsAPI.DeleteBucketLifecycleHandler)))))
 	// /ns/v1/acl?bucket=xx&acl=xx [put]
 	apiRouter.Methods(http.MethodPut).Path("/acl").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.PutBucketAclHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutBucketAclAction, nsAPI.PutBucketAclHandler)))))
 	// /ns/v1/acl?bucket=xx [get]
 	apiRouter.Methods(http.MethodGet).Path("/acl").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.GetBucketAclHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetBucketAclAction, nsAPI.GetBucketAclHandler)))))
 	// /ns/v1/acl?bucket=xx [delete]
 	apiRouter.Methods(http.MethodDelete).Path("/acl").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.DeleteBucketAclHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutBucketAclAction, nsAPI.DeleteBucketAclHandler)))))
 	// /ns/v1/tag?bucket=xx&tag=xx [put]
 	apiRouter.Methods(http.MethodPut).Path("/tag").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.PutBucketTagsHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutBucketTaggingAction, nsAPI.PutBucketTagsHandler)))))
 	// /ns/v1/tag?bucket=xx [get]
 	apiRouter.Methods(http.MethodGet).Path("/tag").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.GetBucketTagsHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetBucketTaggingAction, nsAPI.GetBucketTagsHandler)))))
 	// /ns/v1/tag?bucket=xx [delete]
 	apiRouter.Methods(http.MethodDelete).Path("/tag").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.DeleteBucketTagsHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutBucketTaggingAction, nsAPI.DeleteBucketTagsHandler)))))
 	// /ns/v1/encryption?bucket=xx [delete]
 	apiRouter.Methods(http.MethodGet).Path("/getEncryption").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.GetBucketEncryptionHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.GetEncryptionConfigurationAction, nsAPI.GetBucketEncryptionHandler)))))
 	apiRouter.Methods(http.MethodPost).Path("/putEncryption").HandlerFunc(
-		maxClients(gz(api.HttpTraceAll(nsAPI.PutBucketEncryptionHandler))))
+		maxClients(gz(api.HttpTraceAll(api.Authorize(policy.PutEncryptionConfigurationAction, nsAPI.PutBucketEncryptionHandler)))))
+
+	// /ns/v1/admin/users [post]
+	apiRouter.Methods(http.MethodPost).Path("/admin/users").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(nsAPI.CreateUserHandler))))
+	// /ns/v1/admin/users?name=xx [get]
+	apiRouter.Methods(http.MethodGet).Path("/admin/users").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(nsAPI.GetUsersHandler))))
+	// /ns/v1/admin/users?name=xx&status=xx [put]
+	apiRouter.Methods(http.MethodPut).Path("/admin/users").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(nsAPI.SetUserStatusHandler))))
+	// /ns/v1/admin/users?name=xx [delete]
+	apiRouter.Methods(http.MethodDelete).Path("/admin/users").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(nsAPI.DeleteUserHandler))))
+	// /ns/v1/admin/users/keys?name=xx [post]
+	apiRouter.Methods(http.MethodPost).Path("/admin/users/keys").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(nsAPI.CreateAccessKeyHandler))))
+	// /ns/v1/admin/users/keys/rotate?name=xx&accessKey=xx [post]
+	apiRouter.Methods(http.MethodPost).Path("/admin/users/keys/rotate").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(nsAPI.RotateAccessKeyHandler))))
+	// /ns/v1/admin/users/keys?name=xx&accessKey=xx&status=xx [put]
+	apiRouter.Methods(http.MethodPut).Path("/admin/users/keys").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(nsAPI.SetAccessKeyStatusHandler))))
+	// /ns/v1/admin/users/keys?name=xx&accessKey=xx [delete]
+	apiRouter.Methods(http.MethodDelete).Path("/admin/users/keys").HandlerFunc(
+		maxClients(gz(api.HttpTraceAll(nsAPI.DeleteAccessKeyHandler))))
 }
@@ -7,6 +7,7 @@ import (
 	config2 "mtcloud.com/mtstorage/cmd/nameserver/config"
 	"mtcloud.com/mtstorage/cmd/nameserver/nodeimpl"
 	"mtcloud.com/mtstorage/node/client"
+	"mtcloud.com/mtstorage/pkg/auth"
 	"mtcloud.com/mtstorage/pkg/discall"
 	"mtcloud.com/mtstorage/pkg/mq"
 	"net"
@@ -140,6 +141,14 @@ func initNameserverRouter(c *config2.NameServerConfig, ns *backend.NameServer) {
 	// Add API router.
 	httpapi.RegisterAPIRouter(router, ns)
 
+	// 请求签名认证
+	if c.Auth.Enable {
+		api.InitAuth(c.Auth, auth.Stores{auth.NewStaticStore(c.Auth.Credentials), metadata.CredentialStore{}})
+		api.InitPolicy(metadata.BucketAccessGetter{})
+	} else {
+		logger.Warn("request authentication is disabled")
+	}
+
 	// Use all the middlewares
 	router.Use(api.GlobalHandlers...)
 	//register swagger
@@ -3,11 +3,13 @@ package metadata
 import (
 	"context"
 	"fmt"
+	"path"
 	"strings"
 
 	"go.opencensus.io/trace"
 	"mtcloud.com/mtstorage/pkg/cache"
 	"mtcloud.com/mtstorage/pkg/logger"
+	"mtcloud.com/mtstorage/pkg/policy"
 	error2 "mtcloud.com/mtstorage/pkg/storageerror"
 )
 
@@ -256,6 +258,52 @@ func QueryBucketPolicy(ctx context.Context, bucket string) (string, error) {
 	return be.Policy, error2.BucketPolicyNotFound{Bucket: bucket}
 }
 
+// BucketAccessGetter looks up the owner, policy and acl of the buckets for the policy evaluation
+type BucketAccessGetter struct{}
+
+func (BucketAccessGetter) GetBucketAccess(ctx context.Context, bucket string) (policy.BucketAccess, error) {
+	return GetBucketAccess(ctx, bucket)
+}
+
+func (BucketAccessGetter) GetObjectAcl(ctx context.Context, bucket, object string) (string, error) {
+	return GetObjectAcl(ctx, bucket, object)
+}
+
+// GetBucketAccess returns the owner, policy and acl of the bucket, error2.BucketNotFound is returned if the bucket does not exist
+func GetBucketAccess(ctx context.Context, bucket string) (policy.BucketAccess, error) {
+	ctx, span := trace.StartSpan(ctx, "GetBucketAccess")
+	defer span.End()
+
+	bi, err := QueryBucketInfo(ctx, bucket)
+	if err != nil {
+		return policy.BucketAccess{}, err
+	}
+	var be BucketExternal
+	res, err := cache.Read(ctx, fmt.Sprintf("ns:extral:%s", bucket), &BucketExternal{}, func() (interface{}, error) {
+		return queryBucketExternalInfo(bucket)
+	}, 0)
+	if res != nil {
+		be = *res.(*BucketExternal)
+	}
+	if err != nil && !strings.Contains(err.Error(), "record not found") {
+		return policy.BucketAccess{}, error2.BucketPolicyNotFound{Bucket: bucket}
+	}
+	return policy.BucketAccess{Bucket: bucket, Owner: bi.Owner, Policy: be.Policy, Acl: be.Acl}, nil
+}
+
+// GetObjectAcl returns the acl of the current version of the object, empty if the object does not exist
+func GetObjectAcl(ctx context.Context, bucket, object string) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "GetObjectAcl")
+	defer span.End()
+
+	// 与chunker保存对象时的目录格式一致
+	oi, err := QueryObjectInfo(ctx, bucket, path.Dir("/"+object), path.Base(object), "")
+	if _, ok := err.(error2.ObjectNotFound); ok {
+		return "", nil
+	}
+	return oi.Acl, err
+}
+
 func PutBucketPolicy(ctx context.Context, bucket, policy string) error {
 	_, span := trace.StartSpan(ctx, "PutBucketPolicy")
 	defer span.End()
@@ -0,0 +1,159 @@
+package metadata
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/pkg/logger"
+)
+
+// 相同内容在不同桶或版本中共享同一个cid, 引用数由对象表和历史表重新统计,
+// 当前版本同时存在于两张表时只计一次, 删除标记和文件夹不计入引用.
+const (
+	countCidRefSQL  = "SELECT COUNT(*) AS count FROM ( SELECT bucket,dirname,name,version FROM " + ObjectTable + " WHERE cid=? AND ismarker=false AND isdir=false UNION SELECT bucket,dirname,name,version FROM " + ObjectHistoryTable + " WHERE cid=? AND ismarker=false AND isdir=false) AS c"
+	upsertCidRefSQL = "INSERT INTO " + CidRefTable + " (cid, ref_count, zero_at, created_at, updated_at) VALUES(?,?,?,?,?) " +
+		"ON DUPLICATE KEY UPDATE zero_at=IF(VALUES(ref_count)=0, IFNULL(zero_at, VALUES(zero_at)), NULL), ref_count=VALUES(ref_count), updated_at=VALUES(updated_at)"
+
+	// 未完成的分片上传也引用分片的数据, 只在删除前检查, 不计入cid_ref
+	countPartCidRefSQL = "SELECT COUNT(*) AS count FROM " + MultipartPartTable + " WHERE cid=?"
+
+	backfillCidRefSQL = "INSERT IGNORE INTO " + CidRefTable + " (cid, ref_count, created_at, updated_at) SELECT cid, COUNT(*), ?, ? FROM ( SELECT bucket,dirname,name,version,cid FROM " + ObjectTable + " WHERE ismarker=false AND isdir=false AND cid<>'" + DefaultCid + "' UNION SELECT bucket,dirname,name,version,cid FROM " + ObjectHistoryTable + " WHERE ismarker=false AND isdir=false AND cid<>'" + DefaultCid + "') AS c GROUP BY cid"
+
+	queryCidObjectsSQL = "SELECT * FROM " + ObjectTable + " WHERE cid=? AND ismarker=false AND isdir=false LIMIT ?"
+
+	queryObjectCidsSQL = "SELECT cid FROM " + ObjectTable + " WHERE bucket=? AND dirname=? AND name=? UNION SELECT cid FROM " + ObjectHistoryTable + " WHERE bucket=? AND dirname=? AND name=?"
+	queryDirCidsSQL    = "SELECT cid FROM " + ObjectTable + " WHERE ( dirname LIKE ? OR ( dirname=? AND  name=?)) AND bucket=? UNION SELECT cid FROM " + ObjectHistoryTable + " WHERE ( dirname LIKE ? OR ( dirname=? AND  name=?)) AND bucket=?"
+)
+
+type cidRow struct {
+	Cid string
+}
+
+// queryObjectCids returns the cids referenced by all versions of the object
+func queryObjectCids(db *gorm.DB, bucket, dir, name string) ([]string, error) {
+	var rows []cidRow
+	if err := db.Raw(queryObjectCidsSQL, bucket, dir, name, bucket, dir, name).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	cids := make([]string, 0, len(rows))
+	for _, r := range rows {
+		cids = append(cids, r.Cid)
+	}
+	return cids, nil
+}
+
+// queryDirCids returns the cids referenced by all objects under the directory
+func queryDirCids(db *gorm.DB, bucket, dir, name string) ([]string, error) {
+	object := path.Join(dir, name)
+	var rows []cidRow
+	if err := db.Raw(queryDirCidsSQL, object+"%", dir, name, bucket, object+"%", dir, name, bucket).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	cids := make([]string, 0, len(rows))
+	for _, r := range rows {
+		cids = append(cids, r.Cid)
+	}
+	return cids, nil
+}
+
+// refreshCidRefs recounts the references of cids in the same transaction that changed them,
+// the first time a count drops to zero is kept as zero_at.
+func refreshCidRefs(tx *gorm.DB, cids []string) error {
+	seen := make(map[string]bool, len(cids))
+	for _, cid := range cids {
+		if cid == "" || cid == DefaultCid || seen[cid] {
+			continue
+		}
+		seen[cid] = true
+
+		var c struct {
+			Count int
+		}
+		if err := tx.Raw(countCidRefSQL, cid, cid).Scan(&c).Error; err != nil {
+			logger.Errorf("count references of %s failed: %s", cid, err)
+			return err
+		}
+		var zeroAt *time.Time
+		if c.Count == 0 {
+			t := now()
+			zeroAt = &t
+		}
+		if err := tx.Exec(upsertCidRefSQL, cid, c.Count, zeroAt, now(), now()).Error; err != nil {
+			logger.Errorf("update references of %s failed: %s", cid, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillCidRefs counts the references of objects written before the table existed
+func backfillCidRefs(db *gorm.DB) error {
+	return db.Exec(backfillCidRefSQL, now(), now()).Error
+}
+
+// GetReferencedCids returns the referenced cids with id greater than marker, ordered by id
+func GetReferencedCids(ctx context.Context, marker uint, limit int) ([]CidRefInfo, error) {
+	_, span := trace.StartSpan(ctx, "GetReferencedCids")
+	defer span.End()
+
+	refs := make([]CidRefInfo, 0)
+	err := mtMetadata.db.DB.
+		Raw("SELECT * FROM "+CidRefTable+" WHERE ref_count>0 AND id>? ORDER BY id LIMIT ?", marker, limit).
+		Scan(&refs).Error
+	return refs, err
+}
+
+// GetUnreferencedCids returns cids which have no reference for longer than grace
+func GetUnreferencedCids(ctx context.Context, grace time.Duration, limit int) ([]CidRefInfo, error) {
+	_, span := trace.StartSpan(ctx, "GetUnreferencedCids")
+	defer span.End()
+
+	refs := make([]CidRefInfo, 0)
+	err := mtMetadata.db.DB.
+		Raw("SELECT * FROM "+CidRefTable+" WHERE ref_count=0 AND zero_at<? ORDER BY zero_at LIMIT ?", now().Add(-grace), limit).
+		Scan(&refs).Error
+	return refs, err
+}
+
+// CountCidRef counts the objects, versions and multipart parts referencing cid at the moment
+func CountCidRef(ctx context.Context, cid string) (int, error) {
+	_, span := trace.StartSpan(ctx, "CountCidRef")
+	defer span.End()
+
+	var c, p struct {
+		Count int
+	}
+	if err := mtMetadata.db.DB.Raw(countCidRefSQL, cid, cid).Scan(&c).Error; err != nil {
+		return 0, err
+	}
+	if err := mtMetadata.db.DB.Raw(countPartCidRefSQL, cid).Scan(&p).Error; err != nil {
+		return 0, err
+	}
+	return c.Count + p.Count, nil
+}
+
+// QueryObjectsByCid returns at most limit current objects whose content is cid
+func QueryObjectsByCid(ctx context.Context, cid string, limit int) ([]ObjectInfo, error) {
+	_, span := trace.StartSpan(ctx, "QueryObjectsByCid")
+	defer span.End()
+
+	objects := make([]ObjectInfo, 0)
+	err := mtMetadata.db.DB.Raw(queryCidObjectsSQL, cid, limit).Scan(&objects).Error
+	return objects, err
+}
+
+// RemoveCidRef removes the record after the cid is unpinned,
+// false means the cid is referenced again and should be kept.
+func RemoveCidRef(ctx context.Context, cid string) (bool, error) {
+	_, span := trace.StartSpan(ctx, "RemoveCidRef")
+	defer span.End()
+
+	db := mtMetadata.db.DB.Exec("DELETE FROM "+CidRefTable+" WHERE cid=? AND ref_count=0", cid)
+	if db.Error != nil {
+		return false, db.Error
+	}
+	return db.RowsAffected == 1, nil
+}
@@ -152,12 +152,12 @@ func insertBucketTag(bucket, tag string) error {
 // object methods
 
 const (
-	insertHistoryObjectSQL     = "INSERT INTO " + ObjectHistoryTable + " (bucket, dirname, name, cid, etag, isdir, content_length,ciphertext_size, content_type, version, storageclass, acl, ismarker, created_at, updated_at) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
+	insertHistoryObjectSQL     = "INSERT INTO " + ObjectHistoryTable + " (bucket, dirname, name, cid, etag, isdir, content_length,ciphertext_size, compression, compressed_size, content_type, version, storageclass, acl, ismarker, created_at, updated_at) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
 	insertObjectSQL            = "INSERT INTO " + ObjectTable + " (bucket, dirname, name, cid, etag, isdir, content_length, content_type, version, storageclass, ismarker, created_at, updated_at) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)"
 	deleteObjectSQL            = "DELETE FROM " + ObjectTable + " WHERE  bucket=? and  dirname=? and  name=?"
 	deleteObjectWithVersionSQL = "DELETE FROM " + ObjectTable + " WHERE  bucket=? and  dirname=? and  name=? and version=?"
 	deletehistoryObjectSQL     = "DELETE FROM " + ObjectHistoryTable + " WHERE  bucket=? and  dirname=? and  name=? and version=?"
-	updateObjectSQL            = "UPDATE " + ObjectTable + " SET cid=?, etag=?, content_length=?, ciphertext_size = ?, content_type=?, version=?, storageclass=?, acl=?, ismarker=?, updated_at=? WHERE  bucket=? and  dirname=? and  name=?"
+	updateObjectSQL            = "UPDATE " + ObjectTable + " SET cid=?, etag=?, content_length=?, ciphertext_size = ?, compression=?, compressed_size=?, content_type=?, version=?, storageclass=?, acl=?, ismarker=?, updated_at=? WHERE  bucket=? and  dirname=? and  name=?"
 	updateObjectHistorySQL     = "UPDATE " + ObjectHistoryTable + " SET cid=?, etag=?, content_length=?, content_type=?, version=?, storageclass=?, ismarker=?, updated_at=? WHERE  bucket=? and  dirname=? and  name=?"
 
 	updateBucketByIDSQL      = "UPDATE " + BucketTable + " SET name=?, bucketid=?, count=count+?, size=size+?, owner=?, tenant=?, profile=?, policy=?, versioning=?, storageclass=?, location=?, updated_at=? WHERE id=?"
@@ -423,12 +423,12 @@ func makeInsertSql(dirs []string, bucket string) (string, []interface{}) {
 	}
 	now := time.Now()
 	sqlBuffer := strings.Builder{}
-	// 这里不需要管加密后的文件大小，因为这个构建出的是文件夹的sql，文件夹大小为0
-	sqlBuffer.WriteString("INSERT INTO " + ObjectTable + " (bucket, dirname, name, cid, etag, isdir, content_length, content_type, version, storageclass, acl, ismarker, created_at, updated_at) VALUES")
+	// 文件夹大小为0, 列与putObjectInfo中追加的对象行一致
+	sqlBuffer.WriteString("INSERT INTO " + ObjectTable + " (bucket, dirname, name, cid, etag, isdir, content_length,ciphertext_size, compression, compressed_size, content_type, version, storageclass, acl, ismarker, created_at, updated_at) VALUES")
 	param := make([]interface{}, 0)
 	for i := range dirs {
-		sqlBuffer.WriteString("(?,?,?,?,?,?,?,?,?,?,?,?,?,?),")
-		param = append(param, []interface{}{bucket, path.Dir(dirs[i]), path.Base(dirs[i]), "-", "-", 1, 0, DirContentType, Defaultversionid, "STANDARD", "", 0, now, now}...)
+		sqlBuffer.WriteString("(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?),")
+		param = append(param, []interface{}{bucket, path.Dir(dirs[i]), path.Base(dirs[i]), "-", "-", 1, 0, 0, "", 0, DirContentType, Defaultversionid, "STANDARD", "", 0, now, now}...)
 	}
 	sql := sqlBuffer.String()
 	return sql[:len(sql)-1], param
@@ -454,11 +454,11 @@ func putObjectInfo(ctx context.Context, tx *gorm.DB, bi *BucketInfo, obj *Object
 	}
 	sql, insertParam := makeInsertSql(insterOrUpdateDir[inster], bi.Name)
 	if sql == "" {
-		sql = "INSERT INTO " + ObjectTable + " (bucket, dirname, name, cid, etag, isdir, content_length,ciphertext_size, content_type, version, storageclass, acl, ismarker, created_at, updated_at) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
+		sql = "INSERT INTO " + ObjectTable + " (bucket, dirname, name, cid, etag, isdir, content_length,ciphertext_size, compression, compressed_size, content_type, version, storageclass, acl, ismarker, created_at, updated_at) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
 	} else {
-		sql += ",(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
+		sql += ",(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
 	}
-	insertParam = append(insertParam, obj.Bucket, obj.Dirname, obj.Name, obj.Cid, obj.Etag, obj.Isdir, obj.Content_length, obj.CipherTextSize, obj.Content_type, obj.Version, obj.StorageClass, obj.Acl, obj.IsMarker, now(), now())
+	insertParam = append(insertParam, obj.Bucket, obj.Dirname, obj.Name, obj.Cid, obj.Etag, obj.Isdir, obj.Content_length, obj.CipherTextSize, obj.Compression, obj.CompressedSize, obj.Content_type, obj.Version, obj.StorageClass, obj.Acl, obj.IsMarker, now(), now())
 	if bi.Versioning == VersioningEnabled && ohi.Name != "" {
 		hsql := strings.Replace(sql, ObjectTable, ObjectHistoryTable, 1)
 		if err := tx.Exec(hsql, insertParam...).Error; err != nil {
@@ -492,7 +492,7 @@ func updateObjectInfo(ctx context.Context, tx *gorm.DB, obj *ObjectInfo) error {
 	_, span := trace.StartSpan(ctx, "PutObjectInfo")
 	defer span.End()
 	if err := tx.Exec(updateObjectSQL,
-		obj.Cid, obj.Etag, obj.Content_length, obj.CipherTextSize, obj.Content_type, obj.Version, obj.StorageClass, obj.Acl, obj.IsMarker, now(), obj.Bucket, obj.Dirname, obj.Name).Error; err != nil {
+		obj.Cid, obj.Etag, obj.Content_length, obj.CipherTextSize, obj.Compression, obj.CompressedSize, obj.Content_type, obj.Version, obj.StorageClass, obj.Acl, obj.IsMarker, now(), obj.Bucket, obj.Dirname, obj.Name).Error; err != nil {
 		logger.Errorf("update object info storageerror:%s", err)
 		return err
 	}
@@ -505,7 +505,7 @@ func putObjectHistoryInfo(ctx context.Context, tx *gorm.DB, ohi *ObjectHistoryIn
 	if !(ohi.Isdir && isObjectHistoryExist(ohi.Bucket, ohi.Dirname, ohi.Name, ohi.Version)) {
 		if err := tx.Exec(insertHistoryObjectSQL,
 			ohi.Bucket, ohi.Dirname, ohi.Name, ohi.Cid, ohi.Etag, ohi.Isdir,
-			ohi.Content_length, ohi.CipherTextSize, ohi.Content_type, ohi.Version, ohi.StorageClass,
+			ohi.Content_length, ohi.CipherTextSize, ohi.Compression, ohi.CompressedSize, ohi.Content_type, ohi.Version, ohi.StorageClass,
 			ohi.Acl, ohi.IsMarker, now(), now()).Error; err != nil {
 			logger.Errorf("insert object history info storageerror:%s", err)
 			return err
@@ -579,6 +579,14 @@ func deleteDirPipeline(ctx context.Context, bi *BucketInfo, dir, name string) (D
 			}
 		}
 	}
+	cids := make([]string, 0, len(objets))
+	for i := range objets {
+		cids = append(cids, objets[i].Cid)
+	}
+	if err := refreshCidRefs(tx, cids); err != nil {
+		tx.Rollback()
+		return total, err
+	}
 	// 不管是不是多版本，删除文件夹。文件夹的记录都会被删除
 	// todo 这里还是有待讨论，如果之后删除文件夹就只是删除文件夹辣条记录和文件夹里的文件没有关系，那么这里就不符合业务了
 	//_, err = DelHistoryDirs(ctx, tx, bi, dir, name)
@@ -682,10 +690,19 @@ func deleteObjectPipeline(ctx context.Context, bi *BucketInfo, info *ObjectInfo,
 	// 恢复的文件夹个数
 	recoverDir := 0
 	tx := mtMetadata.db.DB.Begin()
+	cids, err := queryObjectCids(tx, bi.Name, info.Dirname, info.Name)
+	if err != nil {
+		tx.Rollback()
+		return total, err
+	}
 	if err := deleteObject(ctx, tx, bi, info, reqVerrsion, &recoverDir); err != nil {
 		tx.Rollback()
 		return total, err
 	}
+	if err := refreshCidRefs(tx, cids); err != nil {
+		tx.Rollback()
+		return total, err
+	}
 	if bi.Versioning == VersioningEnabled {
 		if !info.IsMarker && reqVerrsion != "" {
 			total.Size = info.Content_length
@@ -1078,6 +1095,16 @@ func deleteObjectInfo(ctx context.Context, bi *BucketInfo, dir, name, version st
 		logger.Info("query deleted object size:", total)
 	}
 
+	var cids []string
+	if isdir {
+		cids, err = queryDirCids(mtMetadata.db.DB, bucket, dir, name)
+	} else {
+		cids, err = queryObjectCids(mtMetadata.db.DB, bucket, dir, name)
+	}
+	if err != nil {
+		return DeletedObjects{}, error2.WriteDataBaseFailed{Err: err}
+	}
+
 	err = mtMetadata.db.DB.Transaction(
 		func(tx *gorm.DB) error {
 			if isdir {
@@ -1109,6 +1136,9 @@ func deleteObjectInfo(ctx context.Context, bi *BucketInfo, dir, name, version st
 					}
 				}
 			}
+			if err := refreshCidRefs(tx, cids); err != nil {
+				return error2.WriteDataBaseFailed{Err: err}
+			}
 			err := tx.Exec(updateBucketCapSQL,
 				bi.Count-total.Count, bi.Size-total.Size, now(), bi.Name).Error
 			if err != nil {
@@ -1145,7 +1175,7 @@ func putObjDelMarker(ctx context.Context, bi BucketInfo, dir, name string) error
 					continue
 				}
 				vid := genVersionId(oi.Isdir)
-				err := tx.Exec(updateObjectSQL, DefaultCid, DefaultEtag, 0,
+				err := tx.Exec(updateObjectSQL, DefaultCid, DefaultEtag, 0, 0, "", 0,
 					oi.Content_type, vid, oi.StorageClass, oi.Acl,
 					true, ts, oi.Bucket, oi.Dirname, oi.Name).Error
 				if err != nil {
@@ -1154,7 +1184,7 @@ func putObjDelMarker(ctx context.Context, bi BucketInfo, dir, name string) error
 				}
 				err = tx.Exec(insertHistoryObjectSQL,
 					oi.Bucket, oi.Dirname, oi.Name, DefaultCid, DefaultEtag,
-					oi.Isdir, 0, oi.Content_type, vid, oi.StorageClass, oi.Acl,
+					oi.Isdir, 0, 0, "", 0, oi.Content_type, vid, oi.StorageClass, oi.Acl,
 					true, ts, ts).Error
 				if err != nil {
 					logger.Errorf("insert object marker storageerror:%s", err)
@@ -1164,7 +1194,7 @@ func putObjDelMarker(ctx context.Context, bi BucketInfo, dir, name string) error
 			if dir != "/" {
 				err = tx.Exec(insertHistoryObjectSQL,
 					bi.Name, path.Dir(dir), path.Base(dir), DefaultCid, DefaultEtag,
-					true, 0, 0, Defaultversionid, bi.StorageClass, DefaultOjbectACL,
+					true, 0, 0, "", 0, DirContentType, Defaultversionid, bi.StorageClass, DefaultOjbectACL,
 					true, ts, ts).Error
 				if err != nil {
 					logger.Errorf("insert object dir marker storageerror:%s", err)
@@ -0,0 +1,40 @@
+package metadata
+
+import (
+	"context"
+
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/pkg/logger"
+)
+
+const upsertEndpointDrainSQL = "INSERT INTO " + EndpointDrainTable + " (endpoint, drain, updated_at) VALUES(?,?,?) " +
+	"ON DUPLICATE KEY UPDATE drain=VALUES(drain), updated_at=VALUES(updated_at)"
+
+// PutEndpointDrain records the drain state of the storage endpoint set by the admin
+func PutEndpointDrain(ctx context.Context, endpoint string, drain bool) error {
+	_, span := trace.StartSpan(ctx, "PutEndpointDrain")
+	defer span.End()
+
+	err := mtMetadata.db.DB.Exec(upsertEndpointDrainSQL, endpoint, drain, now()).Error
+	if err != nil {
+		logger.Errorf("put drain state of %s failed: %s", endpoint, err)
+	}
+	return err
+}
+
+// QueryEndpointDrains returns the drain states of all endpoints set by the admin
+func QueryEndpointDrains(ctx context.Context) (map[string]bool, error) {
+	_, span := trace.StartSpan(ctx, "QueryEndpointDrains")
+	defer span.End()
+
+	var list []EndpointDrainInfo
+	if err := mtMetadata.db.DB.Find(&list).Error; err != nil {
+		logger.Error("query endpoint drain states failed: ", err)
+		return nil, err
+	}
+	states := make(map[string]bool, len(list))
+	for _, e := range list {
+		states[e.Endpoint] = e.Drain
+	}
+	return states, nil
+}
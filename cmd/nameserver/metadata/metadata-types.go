@@ -38,10 +38,12 @@ type ObjectInfo struct {
 	Name           string `gorm:"column:name;type:varchar(512);not null;index:o_n_index;primary_key" json:"name"`
 	Dirname        string `gorm:"column:dirname;type:varchar(1024);not null;default:'/'" json:"dirname"`
 	Bucket         string `gorm:"column:bucket;type:varchar(64);not null" json:"bucket"`
-	Cid            string `gorm:"column:cid;type:varchar(160)" json:"cid,omitempty"`
+	Cid            string `gorm:"column:cid;type:varchar(160);index:o_cid_index" json:"cid,omitempty"`
 	Etag           string `gorm:"column:etag;type:varchar(32)" json:"etag,omitempty"`
 	Content_length uint64 `gorm:"column:content_length;type:bigint" json:"content_length"`
 	CipherTextSize uint64 `gorm:"column:ciphertext_size;type:bigint" json:"ciphertext_size"`
+	Compression    string `gorm:"column:compression;type:varchar(16)" json:"compression,omitempty"`
+	CompressedSize uint64 `gorm:"column:compressed_size;type:bigint" json:"compressed_size,omitempty"`
 	Content_type   string `gorm:"column:content_type;type:varchar(128)" json:"content_type"`
 	Version        string `gorm:"column:version;type:varchar(32)" json:"version"`
 	Tags           string `gorm:"column:tags;type:varchar(1024)" json:"tags,omitempty"`
@@ -49,6 +51,7 @@ type ObjectInfo struct {
 	IsMarker       bool   `gorm:"column:ismarker;type:bool;default:false" json:"ismarker"`
 	StorageClass   string `gorm:"column:storageclass;type:varchar(32)" json:"storageclass"`
 	Acl            string `gorm:"column:acl;type:varchar(1024)" json:"acl"`
+	Appendable     bool   `gorm:"column:appendable;type:bool;default:false" json:"appendable,omitempty"` //由AppendObject创建, 可以继续追加
 }
 
 type ObjectHistoryInfo struct {
@@ -60,6 +63,8 @@ type ObjectHistoryInfo struct {
 	Etag           string `gorm:"column:etag;type:varchar(32)"`
 	Content_length uint64 `gorm:"column:content_length;type:bigint"`
 	CipherTextSize uint64 `gorm:"column:ciphertext_size;type:bigint" json:"ciphertext_size"`
+	Compression    string `gorm:"column:compression;type:varchar(16)" json:"compression,omitempty"`
+	CompressedSize uint64 `gorm:"column:compressed_size;type:bigint" json:"compressed_size,omitempty"`
 	Content_type   string `gorm:"column:content_type;type:varchar(128)"`
 	Version        string `gorm:"column:version;type:varchar(32)"`
 	Tags           string `gorm:"column:tags;type:varchar(1024)"`
@@ -67,6 +72,7 @@ type ObjectHistoryInfo struct {
 	IsMarker       bool   `gorm:"column:ismarker;type:bool;default:false" json:"ismarker"`
 	StorageClass   string `gorm:"column:storageclass;type:varchar(32)" json:"storageclass,omitempty"`
 	Acl            string `gorm:"column:acl;type:varchar(1024)" json:"acl"`
+	Appendable     bool   `gorm:"column:appendable;type:bool;default:false" json:"appendable,omitempty"`
 }
 
 type ObjectChunkInfo struct {
@@ -79,6 +85,71 @@ type ObjectChunkInfo struct {
 	// Status   int    `gorm:"column:status;type:int;default:0"`
 }
 
+// CidRefInfo 记录cid被对象和历史版本引用的次数, 引用数归零的时间用于gc宽限期
+type CidRefInfo struct {
+	ID        uint       `gorm:"primary_key" json:"-"`
+	Cid       string     `gorm:"column:cid;type:varchar(160);not null;unique_index" json:"cid"`
+	RefCount  int        `gorm:"column:ref_count;type:int;default:0" json:"ref_count"`
+	ZeroAt    *time.Time `gorm:"column:zero_at" json:"zero_at,omitempty"`
+	CreatedAt time.Time  `json:"-"`
+	UpdatedAt time.Time  `json:"-"`
+}
+
+// MultipartUploadInfo 进行中的分片上传, 完成或取消后删除
+type MultipartUploadInfo struct {
+	ID           uint                `gorm:"primary_key" json:"-"`
+	UploadId     string              `gorm:"column:upload_id;type:varchar(128);not null;unique_index" json:"uploadId"`
+	Bucket       string              `gorm:"column:bucket;type:varchar(64);not null;index:mu_b_index" json:"bucket"`
+	Object       string              `gorm:"column:object;type:varchar(1024);not null" json:"object"`
+	Owner        uint32              `gorm:"column:owner;type:int;default:null" json:"owner"`
+	StorageClass string              `gorm:"column:storageclass;type:varchar(32)" json:"storageclass"`
+	Chunker      string              `gorm:"column:chunker;type:varchar(256);index:mu_c_index" json:"chunker"` //发起上传的chunker节点
+	Initiated    time.Time           `gorm:"column:initiated" json:"initiated"`
+	UpdatedAt    time.Time           `json:"updatedAt"`
+	Parts        []MultipartPartInfo `gorm:"-" json:"parts,omitempty"`
+}
+
+// MultipartPartInfo 已上传的分片
+type MultipartPartInfo struct {
+	ID           uint      `gorm:"primary_key" json:"-"`
+	UploadId     string    `gorm:"column:upload_id;type:varchar(128);not null;unique_index:mp_u_p_index" json:"uploadId"`
+	PartNumber   int       `gorm:"column:part_number;type:int;not null;unique_index:mp_u_p_index" json:"partNumber"`
+	ETag         string    `gorm:"column:etag;type:varchar(64)" json:"etag"`
+	Cid          string    `gorm:"column:cid;type:varchar(160);index:mp_cid_index" json:"cid"` //分片在存储引擎中的cid
+	Size         int64     `gorm:"column:size;type:bigint" json:"size"`
+	ActualSize   int64     `gorm:"column:actual_size;type:bigint" json:"actualSize"`
+	LastModified time.Time `gorm:"column:last_modified" json:"lastModified"`
+}
+
+// UserInfo 用户, 桶的Owner为用户的ID
+type UserInfo struct {
+	ID        uint32    `gorm:"primary_key" json:"id"`
+	Name      string    `gorm:"column:name;type:varchar(64);not null;unique_index" json:"name"`
+	Tenant    string    `gorm:"column:tenant;type:varchar(32);default:null" json:"tenant,omitempty"`
+	Status    string    `gorm:"column:status;type:varchar(16);not null" json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// AccessKeyInfo 用户的访问秘钥
+type AccessKeyInfo struct {
+	ID        uint      `gorm:"primary_key" json:"-"`
+	AccessKey string    `gorm:"column:access_key;type:varchar(32);not null;unique_index" json:"accessKey"`
+	SecretKey string    `gorm:"column:secret_key;type:varchar(256);not null" json:"-"` //crypto.EncryptLocalPassword加密后保存
+	UserId    uint32    `gorm:"column:user_id;type:int;not null;index:ak_u_index" json:"userId"`
+	Status    string    `gorm:"column:status;type:varchar(16);not null" json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// EndpointDrainInfo 通过管理接口设置的存储节点下线状态, 所有chunker据此同步, 优先于chunker的配置
+type EndpointDrainInfo struct {
+	ID        uint      `gorm:"primary_key" json:"-"`
+	Endpoint  string    `gorm:"column:endpoint;type:varchar(256);not null;unique_index" json:"endpoint"`
+	Drain     bool      `gorm:"column:drain" json:"drain"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
 // bucket info for api
 type StorageInfo struct {
 	BucketsNum int    `json:"bucketnum"`
@@ -97,11 +168,23 @@ type DeletedObjects struct {
 }
 
 const (
-	BucketTable        = "t_ns_bucket"
-	BucketExtTable     = "t_ns_bucket_ext"
-	ObjectTable        = "t_ns_object"
-	ObjectHistoryTable = "t_ns_object_history"
-	ObjectCidTable     = "t_ns_object_chunk"
+	BucketTable          = "t_ns_bucket"
+	BucketExtTable       = "t_ns_bucket_ext"
+	ObjectTable          = "t_ns_object"
+	ObjectHistoryTable   = "t_ns_object_history"
+	ObjectCidTable       = "t_ns_object_chunk"
+	CidRefTable          = "t_ns_cid_ref"
+	MultipartUploadTable = "t_ns_multipart_upload"
+	MultipartPartTable   = "t_ns_multipart_part"
+	UserTable            = "t_ns_user"
+	AccessKeyTable       = "t_ns_access_key"
+	EndpointDrainTable   = "t_ns_endpoint_drain"
+)
+
+// user and access key status
+const (
+	StatusEnabled  = "enabled"
+	StatusDisabled = "disabled"
 )
 
 // bucket versionning status
@@ -33,6 +33,30 @@ func (ObjectChunkInfo) TableName() string {
 	return ObjectCidTable
 }
 
+func (CidRefInfo) TableName() string {
+	return CidRefTable
+}
+
+func (MultipartUploadInfo) TableName() string {
+	return MultipartUploadTable
+}
+
+func (MultipartPartInfo) TableName() string {
+	return MultipartPartTable
+}
+
+func (UserInfo) TableName() string {
+	return UserTable
+}
+
+func (AccessKeyInfo) TableName() string {
+	return AccessKeyTable
+}
+
+func (EndpointDrainInfo) TableName() string {
+	return EndpointDrainTable
+}
+
 var mtMetadata = &MetaData{}
 
 func InitMetadata(c db.DBconfig) {
@@ -77,6 +101,51 @@ func InitMetadata(c db.DBconfig) {
 			return
 		}
 	}
+
+	if !db.DB.HasTable(&CidRefInfo{}) {
+		if err := db.DB.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").CreateTable(&CidRefInfo{}).Error; err != nil {
+			logger.Error("create cid ref table failed:", err)
+			return
+		}
+		if err := backfillCidRefs(db.DB); err != nil {
+			logger.Error("backfill cid ref table failed:", err)
+		}
+	}
+
+	if !db.DB.HasTable(&MultipartUploadInfo{}) {
+		if err := db.DB.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").CreateTable(&MultipartUploadInfo{}).Error; err != nil {
+			logger.Error("create multipart upload table failed:", err)
+			return
+		}
+	}
+
+	if !db.DB.HasTable(&MultipartPartInfo{}) {
+		if err := db.DB.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").CreateTable(&MultipartPartInfo{}).Error; err != nil {
+			logger.Error("create multipart part table failed:", err)
+			return
+		}
+	}
+
+	if !db.DB.HasTable(&UserInfo{}) {
+		if err := db.DB.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").CreateTable(&UserInfo{}).Error; err != nil {
+			logger.Error("create user table failed:", err)
+			return
+		}
+	}
+
+	if !db.DB.HasTable(&AccessKeyInfo{}) {
+		if err := db.DB.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").CreateTable(&AccessKeyInfo{}).Error; err != nil {
+			logger.Error("create access key table failed:", err)
+			return
+		}
+	}
+
+	if !db.DB.HasTable(&EndpointDrainInfo{}) {
+		if err := db.DB.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").CreateTable(&EndpointDrainInfo{}).Error; err != nil {
+			logger.Error("create endpoint drain table failed:", err)
+			return
+		}
+	}
 	//auto migrate
 	/*
 		gorm.DefaultTableNameHandler= func(db *gorm.DB, defaultTableName string) string {
@@ -92,6 +161,12 @@ func InitMetadata(c db.DBconfig) {
 	db.DB.AutoMigrate(&ObjectInfo{})
 	db.DB.AutoMigrate(&ObjectHistoryInfo{})
 	db.DB.AutoMigrate(&ObjectChunkInfo{})
+	db.DB.AutoMigrate(&CidRefInfo{})
+	db.DB.AutoMigrate(&MultipartUploadInfo{})
+	db.DB.AutoMigrate(&MultipartPartInfo{})
+	db.DB.AutoMigrate(&UserInfo{})
+	db.DB.AutoMigrate(&AccessKeyInfo{})
+	db.DB.AutoMigrate(&EndpointDrainInfo{})
 
 	mtMetadata.db = db
 }
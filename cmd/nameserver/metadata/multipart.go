@@ -0,0 +1,168 @@
+package metadata
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/pkg/logger"
+)
+
+// 分片上传的默认列举数量
+const defaultMaxUploads = 1000
+
+const (
+	upsertMultipartUploadSQL = "INSERT INTO " + MultipartUploadTable + " (upload_id, bucket, object, owner, storageclass, chunker, initiated, updated_at) VALUES(?,?,?,?,?,?,?,?) " +
+		"ON DUPLICATE KEY UPDATE chunker=VALUES(chunker), updated_at=VALUES(updated_at)"
+	upsertMultipartPartSQL = "INSERT INTO " + MultipartPartTable + " (upload_id, part_number, etag, cid, size, actual_size, last_modified) VALUES(?,?,?,?,?,?,?) " +
+		"ON DUPLICATE KEY UPDATE etag=VALUES(etag), cid=VALUES(cid), size=VALUES(size), actual_size=VALUES(actual_size), last_modified=VALUES(last_modified)"
+	touchMultipartUploadSQL = "UPDATE " + MultipartUploadTable + " SET updated_at=? WHERE upload_id=?"
+)
+
+// ListMultipartsInfo 分片上传列表
+type ListMultipartsInfo struct {
+	Uploads            []MultipartUploadInfo `json:"uploads"`
+	IsTruncated        bool                  `json:"isTruncated"`
+	NextKeyMarker      string                `json:"nextKeyMarker,omitempty"`
+	NextUploadIdMarker string                `json:"nextUploadIdMarker,omitempty"`
+}
+
+// PutMultipartUpload registers a new multipart upload, registering it again only updates the chunker and the time
+func PutMultipartUpload(ctx context.Context, u *MultipartUploadInfo) error {
+	_, span := trace.StartSpan(ctx, "PutMultipartUpload")
+	defer span.End()
+
+	if u.Initiated.IsZero() {
+		u.Initiated = now()
+	}
+	err := mtMetadata.db.DB.Exec(upsertMultipartUploadSQL,
+		u.UploadId, u.Bucket, u.Object, u.Owner, u.StorageClass, u.Chunker, u.Initiated, now()).Error
+	if err != nil {
+		logger.Errorf("put multipart upload %s failed: %s", u.UploadId, err)
+	}
+	return err
+}
+
+// PutMultipartPart records an uploaded part, a part uploaded again replaces the old one.
+// replaced is the cid of the old part, the caller should remove its data.
+func PutMultipartPart(ctx context.Context, p *MultipartPartInfo) (replaced string, err error) {
+	_, span := trace.StartSpan(ctx, "PutMultipartPart")
+	defer span.End()
+
+	if p.LastModified.IsZero() {
+		p.LastModified = now()
+	}
+	err = mtMetadata.db.DB.Transaction(func(tx *gorm.DB) error {
+		db := tx.Exec(touchMultipartUploadSQL, now(), p.UploadId)
+		if db.Error != nil {
+			return db.Error
+		}
+		if db.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		var old MultipartPartInfo
+		// 上传记录已被更新锁定, 同一上传的分片记录依次写入
+		err := tx.Where("upload_id = ? AND part_number = ?", p.UploadId, p.PartNumber).First(&old).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		if old.Cid != p.Cid {
+			replaced = old.Cid
+		}
+		return tx.Exec(upsertMultipartPartSQL,
+			p.UploadId, p.PartNumber, p.ETag, p.Cid, p.Size, p.ActualSize, p.LastModified).Error
+	})
+	return replaced, err
+}
+
+// QueryMultipartUpload returns the upload with its parts ordered by part number
+func QueryMultipartUpload(ctx context.Context, uploadId string) (MultipartUploadInfo, error) {
+	_, span := trace.StartSpan(ctx, "QueryMultipartUpload")
+	defer span.End()
+
+	var u MultipartUploadInfo
+	if err := mtMetadata.db.DB.Where("upload_id = ?", uploadId).First(&u).Error; err != nil {
+		return u, err
+	}
+	err := mtMetadata.db.DB.Where("upload_id = ?", uploadId).Order("part_number").Find(&u.Parts).Error
+	return u, err
+}
+
+// ListMultipartUploads lists the uploads of bucket ordered by object and upload id,
+// starting after keyMarker and uploadIdMarker like the s3 api.
+func ListMultipartUploads(ctx context.Context, bucket, prefix, keyMarker, uploadIdMarker string, maxUploads int) (ListMultipartsInfo, error) {
+	_, span := trace.StartSpan(ctx, "ListMultipartUploads")
+	defer span.End()
+
+	if maxUploads <= 0 || maxUploads > defaultMaxUploads {
+		maxUploads = defaultMaxUploads
+	}
+	db := mtMetadata.db.DB.Where("bucket = ?", bucket)
+	if prefix != "" {
+		db = db.Where("object LIKE ?", prefix+"%")
+	}
+	if keyMarker != "" {
+		if uploadIdMarker != "" {
+			db = db.Where("object > ? OR (object = ? AND upload_id > ?)", keyMarker, keyMarker, uploadIdMarker)
+		} else {
+			db = db.Where("object > ?", keyMarker)
+		}
+	}
+	uploads := make([]MultipartUploadInfo, 0)
+	if err := db.Order("object").Order("upload_id").Limit(maxUploads + 1).Find(&uploads).Error; err != nil {
+		return ListMultipartsInfo{}, err
+	}
+	result := ListMultipartsInfo{Uploads: uploads}
+	if len(uploads) > maxUploads {
+		result.Uploads = uploads[:maxUploads]
+		result.IsTruncated = true
+		last := result.Uploads[maxUploads-1]
+		result.NextKeyMarker, result.NextUploadIdMarker = last.Object, last.UploadId
+	}
+	return result, nil
+}
+
+// ListStaleMultipartUploads lists the uploads initiated before the time ordered by upload id, starting after marker
+func ListStaleMultipartUploads(ctx context.Context, before time.Time, marker string, maxUploads int) ([]MultipartUploadInfo, error) {
+	_, span := trace.StartSpan(ctx, "ListStaleMultipartUploads")
+	defer span.End()
+
+	if maxUploads <= 0 || maxUploads > defaultMaxUploads {
+		maxUploads = defaultMaxUploads
+	}
+	uploads := make([]MultipartUploadInfo, 0)
+	err := mtMetadata.db.DB.Where("initiated < ? AND upload_id > ?", before, marker).
+		Order("upload_id").Limit(maxUploads).Find(&uploads).Error
+	return uploads, err
+}
+
+// DeleteMultipartUpload removes the upload and its parts after it is completed or aborted,
+// returns the removed upload with its parts, UploadId is empty if it was already removed.
+func DeleteMultipartUpload(ctx context.Context, uploadId string) (MultipartUploadInfo, error) {
+	_, span := trace.StartSpan(ctx, "DeleteMultipartUpload")
+	defer span.End()
+
+	var u MultipartUploadInfo
+	err := mtMetadata.db.DB.Transaction(func(tx *gorm.DB) error {
+		// 加锁后读取, 同时删除时只有一方得到分片
+		err := tx.Set("gorm:query_option", "FOR UPDATE").Where("upload_id = ?", uploadId).First(&u).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = tx.Where("upload_id = ?", uploadId).Order("part_number").Find(&u.Parts).Error; err != nil {
+			return err
+		}
+		if err = tx.Exec("DELETE FROM "+MultipartPartTable+" WHERE upload_id=?", uploadId).Error; err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM "+MultipartUploadTable+" WHERE upload_id=?", uploadId).Error
+	})
+	if err != nil {
+		return MultipartUploadInfo{}, err
+	}
+	return u, nil
+}
@@ -152,6 +152,12 @@ func PutObjectInfo(ctx context.Context, obj *ObjectInfo) error {
 		logger.Warnf("find object [%s,%s,%s] failed: %s", obj.Bucket, obj.Dirname, obj.Name, err)
 		update = false
 	}
+	// 覆盖或清理旧版本后需要重新统计这些cid的引用
+	cids, err := queryObjectCids(tx, obj.Bucket, obj.Dirname, obj.Name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	// if obj is dir or bucket not enable version, version id is 'null'
 	obj.Version = genVersionId(obj.Isdir || (bi.Versioning != VersioningEnabled))
@@ -173,6 +179,9 @@ func PutObjectInfo(ctx context.Context, obj *ObjectInfo) error {
 		IsMarker:       false,
 		Acl:            obj.Acl,
 		CipherTextSize: obj.CipherTextSize,
+		Compression:    obj.Compression,
+		CompressedSize: obj.CompressedSize,
+		Appendable:     obj.Appendable,
 	}
 
 	freshCache := func() {
@@ -246,6 +255,14 @@ func PutObjectInfo(ctx context.Context, obj *ObjectInfo) error {
 			return error2.WriteDataBaseFailed{Err: err}
 		}
 	}
+	// 覆盖追加对象或创建追加对象时更新标记
+	if obj.Appendable != oi.Appendable {
+		if err := tx.Table(ObjectTable).Where("bucket = ? and dirname = ? and name = ?", obj.Bucket, obj.Dirname, obj.Name).
+			Update("appendable", obj.Appendable).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 	//  历史表中最多只能存在一条非多版本的对象信息
 	if bi.Versioning == VersioningSuspended && !ohi.Isdir {
 		if err := tx.Unscoped().Table(ObjectHistoryTable).
@@ -254,6 +271,10 @@ func PutObjectInfo(ctx context.Context, obj *ObjectInfo) error {
 			return err
 		}
 	}
+	if err := refreshCidRefs(tx, append(cids, obj.Cid)); err != nil {
+		tx.Rollback()
+		return err
+	}
 	tx.Commit()
 	lock.Unlock(ctx, bi.Name+strings.Split(obj.Dirname, "/")[1], bi.Name)
 	return nil
@@ -379,6 +400,15 @@ func DeleteObjectFetchDelete(ctx context.Context, opt ObjectOptions, fetchDelete
 		}
 	}
 
+	cids := make([]string, 0, len(objectH))
+	for i := range objectH {
+		cids = append(cids, objectH[i].Cid)
+	}
+	if err := refreshCidRefs(tx, cids); err != nil {
+		tx.Rollback()
+		return total, err
+	}
+
 	// todo 更新桶的对象和容量大小
 	if err := UpdateBucketCount(ctx, tx, opt.Bucket, int64(total.Count), int64(total.Size)); err != nil {
 		tx.Rollback()
@@ -620,3 +650,122 @@ func GetObjectCidInfos(ctx context.Context) ([]ObjectChunkInfo, error) {
 
 	return getObjectCidInfos()
 }
+
+// CopyObjectInfo creates dst pointing at the data of the source object, the version of dst follows its bucket.
+// 加密对象的密钥按对象生成, 需要由chunker重新加密后写入
+func CopyObjectInfo(ctx context.Context, src ObjectOptions, dst *ObjectInfo) error {
+	ctx, span := trace.StartSpan(ctx, "CopyObjectInfo")
+	defer span.End()
+
+	logger.Infof("copy object: [%s,%s,%s,%s] to [%s,%s,%s]", src.Bucket, src.Prefix, src.Object, src.VersionID, dst.Bucket, dst.Dirname, dst.Name)
+	oi, err := QueryObjectInfo(ctx, src.Bucket, src.Prefix, src.Object, src.VersionID)
+	if err != nil {
+		return err
+	}
+	if len(oi.Name) == 0 || oi.IsMarker || oi.Isdir {
+		return error2.ObjectNotFound{Bucket: src.Bucket, Object: path.Join(src.Prefix, src.Object), VersionID: src.VersionID}
+	}
+	if oi.CipherTextSize > 0 {
+		return error2.InvalidArgument{Bucket: src.Bucket, Object: path.Join(src.Prefix, src.Object),
+			Err: errors.New("encrypted object should be copied by chunker")}
+	}
+	if dst.StorageClass == "" {
+		dst.StorageClass = oi.StorageClass
+	}
+	if src.Bucket == dst.Bucket && src.Prefix == dst.Dirname && src.Object == dst.Name &&
+		(src.VersionID == "" || src.VersionID == oi.Version) && dst.StorageClass == oi.StorageClass {
+		return error2.InvalidCopyDest{Bucket: src.Bucket, Object: path.Join(src.Prefix, src.Object)}
+	}
+
+	dst.Cid = oi.Cid
+	dst.Etag = oi.Etag
+	dst.Content_length = oi.Content_length
+	dst.Content_type = oi.Content_type
+	dst.Compression = oi.Compression
+	dst.CompressedSize = oi.CompressedSize
+	dst.Isdir = false
+	if dst.Acl == "" {
+		dst.Acl = DefaultOjbectACL
+	}
+	return PutObjectInfo(ctx, dst)
+}
+
+// AppendObjectInfo updates the cid and the length of the appendable object after data is appended at position.
+// 追加不产生新版本, 对象不存在时position为0则创建
+func AppendObjectInfo(ctx context.Context, obj *ObjectInfo, position uint64) error {
+	ctx, span := trace.StartSpan(ctx, "AppendObjectInfo")
+	defer span.End()
+
+	logger.Infof("append object:[%s, %s, %s] at %d", obj.Bucket, obj.Dirname, obj.Name, position)
+	obj.Appendable = true
+	object := path.Join(obj.Dirname, obj.Name)
+
+	tx := mtMetadata.db.DB.Begin()
+	oi := new(ObjectInfo)
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Table(ObjectTable).
+		Where("bucket = ? and dirname = ? and name = ?", obj.Bucket, obj.Dirname, obj.Name).First(oi).Error
+	if err == gorm.ErrRecordNotFound || (err == nil && oi.IsMarker) {
+		tx.Rollback()
+		if position != 0 {
+			return error2.PositionNotEqualToLength{Bucket: obj.Bucket, Object: object, Position: position}
+		}
+		return PutObjectInfo(ctx, obj)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !oi.Appendable {
+		tx.Rollback()
+		return error2.ObjectNotAppendable{Bucket: obj.Bucket, Object: object}
+	}
+	if oi.Content_length != position {
+		tx.Rollback()
+		return error2.PositionNotEqualToLength{Bucket: obj.Bucket, Object: object, Position: position, Length: oi.Content_length}
+	}
+
+	bi := new(BucketInfo)
+	if err := tx.Raw("SELECT * FROM "+BucketTable+" WHERE  name=? LIMIT 1", obj.Bucket).Scan(bi).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	freshCache := func() {
+		freshBucketCache(ctx, bi.Name, bi.Owner)
+		for _, vid := range []string{oi.Version, Defaultversionid} {
+			if err := cache.Delete(ctx, genObjectCacheKey(obj.Bucket, obj.Dirname, obj.Name, vid)); err != nil {
+				logger.Errorf("delete object in cache: %s", err)
+			}
+		}
+	}
+	freshCache()
+	defer freshCache()
+
+	values := map[string]interface{}{
+		"cid":            obj.Cid,
+		"etag":           obj.Etag,
+		"content_length": obj.Content_length,
+		"updated_at":     now(),
+	}
+	if err := tx.Table(ObjectTable).Where("id = ?", oi.ID).Updates(values).Error; err != nil {
+		tx.Rollback()
+		return error2.WriteDataBaseFailed{Err: err}
+	}
+	// 开启多版本时当前版本也记录在历史表中
+	if oi.Version != Defaultversionid {
+		if err := tx.Table(ObjectHistoryTable).
+			Where("bucket = ? and dirname = ? and name = ? and version = ?", obj.Bucket, obj.Dirname, obj.Name, oi.Version).
+			Updates(values).Error; err != nil {
+			tx.Rollback()
+			return error2.WriteDataBaseFailed{Err: err}
+		}
+	}
+	if err := updateBucketDate(tx, bi, 0, int64(obj.Content_length)-int64(oi.Content_length)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := refreshCidRefs(tx, []string{oi.Cid, obj.Cid}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,309 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"go.opencensus.io/trace"
+	"mtcloud.com/mtstorage/pkg/auth"
+	"mtcloud.com/mtstorage/pkg/cache"
+	"mtcloud.com/mtstorage/pkg/crypto"
+	"mtcloud.com/mtstorage/pkg/logger"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+)
+
+// 每个用户最多的秘钥数量
+const maxAccessKeysPerUser = 2
+
+const queryCredentialSQL = "SELECT k.access_key, k.secret_key, k.status AS key_status, u.id AS user_id, u.name AS user_name, u.status AS user_status FROM " +
+	AccessKeyTable + " k JOIN " + UserTable + " u ON u.id = k.user_id WHERE k.access_key = ? LIMIT 1"
+
+// credentialRecord 秘钥及其用户, 秘钥仍为加密后的内容
+type credentialRecord struct {
+	AccessKey  string
+	SecretKey  string
+	KeyStatus  string
+	UserId     uint32
+	UserName   string
+	UserStatus string
+}
+
+// CredentialStore looks up the access keys of the users for the request authentication
+type CredentialStore struct{}
+
+func (CredentialStore) GetCredentials(ctx context.Context, accessKey string) (auth.Credentials, error) {
+	return GetCredentials(ctx, accessKey)
+}
+
+// GetCredentials returns the decrypted credentials of the access key,
+// auth.ErrNoSuchAccessKey is returned if the access key or its user does not exist or is disabled.
+func GetCredentials(ctx context.Context, accessKey string) (auth.Credentials, error) {
+	ctx, span := trace.StartSpan(ctx, "GetCredentials")
+	defer span.End()
+
+	var rec credentialRecord
+	res, err := cache.Read(ctx, accessKeyCacheKey(accessKey), &credentialRecord{}, func() (interface{}, error) {
+		r := new(credentialRecord)
+		err := mtMetadata.db.DB.Raw(queryCredentialSQL, accessKey).Scan(r).Error
+		if err == nil && r.AccessKey == "" {
+			err = gorm.ErrRecordNotFound
+		}
+		return r, err
+	}, 0)
+	if res != nil {
+		rec = *res.(*credentialRecord)
+	}
+	if gorm.IsRecordNotFoundError(err) || (err == nil && rec.AccessKey == "") {
+		return auth.Credentials{}, auth.ErrNoSuchAccessKey
+	}
+	if err != nil {
+		return auth.Credentials{}, err
+	}
+	if rec.KeyStatus != StatusEnabled || rec.UserStatus != StatusEnabled {
+		return auth.Credentials{}, auth.ErrNoSuchAccessKey
+	}
+	secret := crypto.DecryptLocalPassword(rec.SecretKey)
+	if secret == "" {
+		return auth.Credentials{}, fmt.Errorf("decrypt secret key of %s failed", accessKey)
+	}
+	return auth.Credentials{
+		AccessKey: rec.AccessKey,
+		SecretKey: secret,
+		Owner:     rec.UserId,
+		User:      rec.UserName,
+	}, nil
+}
+
+// CreateUser creates the user with an access key, the secret key can only be got from the returned credentials
+func CreateUser(ctx context.Context, u *UserInfo) (auth.Credentials, error) {
+	ctx, span := trace.StartSpan(ctx, "CreateUser")
+	defer span.End()
+
+	var cred auth.Credentials
+	err := mtMetadata.db.DB.Transaction(func(tx *gorm.DB) error {
+		var count int
+		if err := tx.Model(&UserInfo{}).Where("name = ?", u.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return error2.UserAlreadyExists{User: u.Name}
+		}
+		u.Status = StatusEnabled
+		if err := tx.Create(u).Error; err != nil {
+			return err
+		}
+		var err error
+		cred, err = createAccessKey(tx, u)
+		return err
+	})
+	if err != nil {
+		logger.Errorf("create user %s failed: %s", u.Name, err)
+	}
+	return cred, err
+}
+
+// QueryUser returns the user with its access keys
+func QueryUser(ctx context.Context, name string) (UserInfo, []AccessKeyInfo, error) {
+	_, span := trace.StartSpan(ctx, "QueryUser")
+	defer span.End()
+
+	u, err := queryUser(mtMetadata.db.DB, name)
+	if err != nil {
+		return u, nil, err
+	}
+	keys := make([]AccessKeyInfo, 0)
+	err = mtMetadata.db.DB.Where("user_id = ?", u.ID).Order("id").Find(&keys).Error
+	return u, keys, err
+}
+
+// ListUsers lists all the users ordered by name
+func ListUsers(ctx context.Context) ([]UserInfo, error) {
+	_, span := trace.StartSpan(ctx, "ListUsers")
+	defer span.End()
+
+	users := make([]UserInfo, 0)
+	err := mtMetadata.db.DB.Order("name").Find(&users).Error
+	return users, err
+}
+
+// SetUserStatus enables or disables the user, the access keys of a disabled user are rejected
+func SetUserStatus(ctx context.Context, name, status string) error {
+	ctx, span := trace.StartSpan(ctx, "SetUserStatus")
+	defer span.End()
+
+	u, err := queryUser(mtMetadata.db.DB, name)
+	if err != nil {
+		return err
+	}
+	if err = mtMetadata.db.DB.Model(&UserInfo{}).Where("id = ?", u.ID).
+		Updates(map[string]interface{}{"status": status, "updated_at": now()}).Error; err != nil {
+		return err
+	}
+	freshUserKeysCache(ctx, u.ID)
+	return nil
+}
+
+// DeleteUser deletes the user and its access keys, the user owning buckets can not be deleted
+func DeleteUser(ctx context.Context, name string) error {
+	ctx, span := trace.StartSpan(ctx, "DeleteUser")
+	defer span.End()
+
+	u, err := queryUser(mtMetadata.db.DB, name)
+	if err != nil {
+		return err
+	}
+	bis, err := queryBucketInfoByOwner(u.ID)
+	if err != nil {
+		return err
+	}
+	if len(*bis) > 0 {
+		return error2.InvalidArgument{Err: fmt.Errorf("user %s still owns %d buckets", name, len(*bis))}
+	}
+	// 先清理缓存中的秘钥
+	freshUserKeysCache(ctx, u.ID)
+	return mtMetadata.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", u.ID).Delete(&AccessKeyInfo{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", u.ID).Delete(&UserInfo{}).Error
+	})
+}
+
+// CreateAccessKey creates a new access key of the user
+func CreateAccessKey(ctx context.Context, name string) (auth.Credentials, error) {
+	_, span := trace.StartSpan(ctx, "CreateAccessKey")
+	defer span.End()
+
+	var cred auth.Credentials
+	err := mtMetadata.db.DB.Transaction(func(tx *gorm.DB) error {
+		u, err := queryUser(tx.Set("gorm:query_option", "FOR UPDATE"), name)
+		if err != nil {
+			return err
+		}
+		var count int
+		if err = tx.Model(&AccessKeyInfo{}).Where("user_id = ?", u.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= maxAccessKeysPerUser {
+			return error2.InvalidArgument{Err: fmt.Errorf("user %s already has %d access keys", name, count)}
+		}
+		cred, err = createAccessKey(tx, &u)
+		return err
+	})
+	return cred, err
+}
+
+// RotateAccessKey replaces the access key of the user with a new one, the old key is deleted at once
+func RotateAccessKey(ctx context.Context, name, accessKey string) (auth.Credentials, error) {
+	ctx, span := trace.StartSpan(ctx, "RotateAccessKey")
+	defer span.End()
+
+	var cred auth.Credentials
+	err := mtMetadata.db.DB.Transaction(func(tx *gorm.DB) error {
+		u, err := queryUser(tx, name)
+		if err != nil {
+			return err
+		}
+		db := tx.Where("user_id = ? AND access_key = ?", u.ID, accessKey).Delete(&AccessKeyInfo{})
+		if db.Error != nil {
+			return db.Error
+		}
+		if db.RowsAffected == 0 {
+			return error2.AccessKeyNotFound{AccessKey: accessKey}
+		}
+		cred, err = createAccessKey(tx, &u)
+		return err
+	})
+	if err == nil {
+		freshAccessKeyCache(ctx, accessKey)
+	}
+	return cred, err
+}
+
+// SetAccessKeyStatus enables or disables the access key of the user
+func SetAccessKeyStatus(ctx context.Context, name, accessKey, status string) error {
+	ctx, span := trace.StartSpan(ctx, "SetAccessKeyStatus")
+	defer span.End()
+
+	u, err := queryUser(mtMetadata.db.DB, name)
+	if err != nil {
+		return err
+	}
+	db := mtMetadata.db.DB.Model(&AccessKeyInfo{}).Where("user_id = ? AND access_key = ?", u.ID, accessKey).
+		Updates(map[string]interface{}{"status": status, "updated_at": now()})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return error2.AccessKeyNotFound{AccessKey: accessKey}
+	}
+	freshAccessKeyCache(ctx, accessKey)
+	return nil
+}
+
+// DeleteAccessKey deletes the access key of the user
+func DeleteAccessKey(ctx context.Context, name, accessKey string) error {
+	ctx, span := trace.StartSpan(ctx, "DeleteAccessKey")
+	defer span.End()
+
+	u, err := queryUser(mtMetadata.db.DB, name)
+	if err != nil {
+		return err
+	}
+	db := mtMetadata.db.DB.Where("user_id = ? AND access_key = ?", u.ID, accessKey).Delete(&AccessKeyInfo{})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return error2.AccessKeyNotFound{AccessKey: accessKey}
+	}
+	freshAccessKeyCache(ctx, accessKey)
+	return nil
+}
+
+func queryUser(db *gorm.DB, name string) (UserInfo, error) {
+	var u UserInfo
+	err := db.Where("name = ?", name).First(&u).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return u, error2.UserNotFound{User: name}
+	}
+	return u, err
+}
+
+// createAccessKey 生成秘钥, 加密后保存
+func createAccessKey(tx *gorm.DB, u *UserInfo) (auth.Credentials, error) {
+	cred, err := auth.GenerateCredentials()
+	if err != nil {
+		return cred, err
+	}
+	err = tx.Create(&AccessKeyInfo{
+		AccessKey: cred.AccessKey,
+		SecretKey: crypto.EncryptLocalPassword(cred.SecretKey),
+		UserId:    u.ID,
+		Status:    StatusEnabled,
+	}).Error
+	cred.Owner, cred.User = u.ID, u.Name
+	return cred, err
+}
+
+func accessKeyCacheKey(accessKey string) string {
+	return "ns:ak:" + accessKey
+}
+
+func freshAccessKeyCache(ctx context.Context, accessKey string) {
+	if err := cache.Delete(ctx, accessKeyCacheKey(accessKey)); err != nil {
+		logger.Errorf("delete access key in cache: %s", err)
+	}
+}
+
+func freshUserKeysCache(ctx context.Context, userId uint32) {
+	var keys []AccessKeyInfo
+	if err := mtMetadata.db.DB.Select("access_key").Where("user_id = ?", userId).Find(&keys).Error; err != nil {
+		logger.Errorf("query access keys of user %d failed: %s", userId, err)
+		return
+	}
+	for _, k := range keys {
+		freshAccessKeyCache(ctx, k.AccessKey)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"mtcloud.com/mtstorage/cmd/nameserver/backend"
+	"time"
 
 	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
 	"mtcloud.com/mtstorage/node/api"
@@ -48,3 +49,19 @@ func (n *ControlNodeImpl) PutObjectCidInfo(ctx context.Context, obj metadata.Obj
 func (n *ControlNodeImpl) GetObjectCidInfos(ctx context.Context) ([]metadata.ObjectChunkInfo, error) {
 	return metadata.GetObjectCidInfos(ctx)
 }
+
+func (n *ControlNodeImpl) GetUnreferencedCids(ctx context.Context, grace time.Duration, limit int) ([]metadata.CidRefInfo, error) {
+	return metadata.GetUnreferencedCids(ctx, grace, limit)
+}
+
+func (n *ControlNodeImpl) RemoveCidRef(ctx context.Context, cid string) (bool, error) {
+	return metadata.RemoveCidRef(ctx, cid)
+}
+
+func (n *ControlNodeImpl) CountCidRef(ctx context.Context, cid string) (int, error) {
+	return metadata.CountCidRef(ctx, cid)
+}
+
+func (n *ControlNodeImpl) GetReferencedCids(ctx context.Context, marker uint, limit int) ([]metadata.CidRefInfo, error) {
+	return metadata.GetReferencedCids(ctx, marker, limit)
+}
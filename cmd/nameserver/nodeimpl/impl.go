@@ -2,14 +2,21 @@ package nodeimpl
 
 import (
 	"context"
+	"path"
+	"time"
 
 	"mtcloud.com/mtstorage/cmd/nameserver/backend"
 
+	"github.com/jinzhu/gorm"
 	"go.opencensus.io/trace"
 	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
 	"mtcloud.com/mtstorage/node/api"
 	"mtcloud.com/mtstorage/node/util"
+	"mtcloud.com/mtstorage/pkg/auth"
+	"mtcloud.com/mtstorage/pkg/crypto"
 	"mtcloud.com/mtstorage/pkg/logger"
+	"mtcloud.com/mtstorage/pkg/policy"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
 )
 
 type NodeImpl struct {
@@ -54,7 +61,12 @@ func (n *NodeImpl) SaveObjectMeta(ctx context.Context, d util.ReWriteObjectInfo)
 	o.Content_type = d.ContentType
 	o.Acl = d.ACL
 	o.CipherTextSize = d.CipherTextSize
+	o.Compression = d.Compression
+	o.CompressedSize = d.CompressedSize
 
+	if d.Appendable {
+		return metadata.AppendObjectInfo(ctx, o, d.AppendPosition)
+	}
 	err := metadata.PutObjectInfo(ctx, o)
 	if err != nil {
 		return err
@@ -68,3 +80,128 @@ func (n *NodeImpl) SaveObjectMeta(ctx context.Context, d util.ReWriteObjectInfo)
 
 	return nil
 }
+
+// GetBucketProfile 返回桶的配置, chunker据此决定是否压缩
+func (n *NodeImpl) GetBucketProfile(ctx context.Context, bucket string) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "GetBucketProfile")
+	defer span.End()
+
+	bi, err := metadata.QueryBucketInfo(ctx, bucket)
+	if err != nil {
+		return "", err
+	}
+	return bi.Profile, nil
+}
+
+// PutMultipartUpload 记录新的分片上传
+func (n *NodeImpl) PutMultipartUpload(ctx context.Context, upload metadata.MultipartUploadInfo) error {
+	ctx, span := trace.StartSpan(ctx, "PutMultipartUpload")
+	defer span.End()
+	return metadata.PutMultipartUpload(ctx, &upload)
+}
+
+// PutMultipartPart 记录已上传的分片, 返回被替换的旧分片的cid
+func (n *NodeImpl) PutMultipartPart(ctx context.Context, part metadata.MultipartPartInfo) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "PutMultipartPart")
+	defer span.End()
+	return metadata.PutMultipartPart(ctx, &part)
+}
+
+// RemoveMultipartUpload 分片上传完成或取消后删除记录, 返回删除的上传及其分片
+func (n *NodeImpl) RemoveMultipartUpload(ctx context.Context, uploadId string) (metadata.MultipartUploadInfo, error) {
+	ctx, span := trace.StartSpan(ctx, "RemoveMultipartUpload")
+	defer span.End()
+	return metadata.DeleteMultipartUpload(ctx, uploadId)
+}
+
+// GetStaleMultipartUploads 返回在before之前发起的分片上传, 按uploadId分页
+func (n *NodeImpl) GetStaleMultipartUploads(ctx context.Context, before time.Time, marker string, limit int) ([]metadata.MultipartUploadInfo, error) {
+	ctx, span := trace.StartSpan(ctx, "GetStaleMultipartUploads")
+	defer span.End()
+	return metadata.ListStaleMultipartUploads(ctx, before, marker, limit)
+}
+
+// GetBucketLifecycle 返回桶的生命周期配置, chunker据此清理未完成的分片上传
+func (n *NodeImpl) GetBucketLifecycle(ctx context.Context, bucket string) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "GetBucketLifecycle")
+	defer span.End()
+	return metadata.QueryBucketLifecycle(ctx, bucket)
+}
+
+// GetMultipartUpload 返回分片上传及其分片, 不存在时UploadId为空
+func (n *NodeImpl) GetMultipartUpload(ctx context.Context, uploadId string) (metadata.MultipartUploadInfo, error) {
+	ctx, span := trace.StartSpan(ctx, "GetMultipartUpload")
+	defer span.End()
+	upload, err := metadata.QueryMultipartUpload(ctx, uploadId)
+	if err == gorm.ErrRecordNotFound {
+		return metadata.MultipartUploadInfo{}, nil
+	}
+	return upload, err
+}
+
+// GetObjectInfo 返回对象的当前版本, 不存在时Name为空
+func (n *NodeImpl) GetObjectInfo(ctx context.Context, bucket, object string) (metadata.ObjectInfo, error) {
+	ctx, span := trace.StartSpan(ctx, "GetObjectInfo")
+	defer span.End()
+
+	// 与chunker保存对象时的目录格式一致
+	dir, name := path.Dir("/"+object), path.Base(object)
+	oi, err := metadata.QueryObjectInfo(ctx, bucket, dir, name, "")
+	if _, ok := err.(error2.ObjectNotFound); ok {
+		return metadata.ObjectInfo{}, nil
+	}
+	return oi, err
+}
+
+// GetCredentials 返回用户的秘钥, 不存在或已禁用时AccessKey为空. SecretKey加密后传输
+func (n *NodeImpl) GetCredentials(ctx context.Context, accessKey string) (auth.Credentials, error) {
+	ctx, span := trace.StartSpan(ctx, "GetCredentials")
+	defer span.End()
+
+	cred, err := metadata.GetCredentials(ctx, accessKey)
+	if err == auth.ErrNoSuchAccessKey {
+		return auth.Credentials{}, nil
+	}
+	cred.SecretKey = crypto.EncryptLocalPassword(cred.SecretKey)
+	return cred, err
+}
+
+// GetBucketAccess 返回桶的所有者, 访问策略及ACL, 桶不存在时Bucket为空
+func (n *NodeImpl) GetBucketAccess(ctx context.Context, bucket string) (policy.BucketAccess, error) {
+	ctx, span := trace.StartSpan(ctx, "GetBucketAccess")
+	defer span.End()
+
+	access, err := metadata.GetBucketAccess(ctx, bucket)
+	if _, ok := err.(error2.BucketNotFound); ok {
+		return policy.BucketAccess{}, nil
+	}
+	return access, err
+}
+
+// PutEndpointDrain 保存管理接口设置的存储节点下线状态
+func (n *NodeImpl) PutEndpointDrain(ctx context.Context, endpoint string, drain bool) error {
+	ctx, span := trace.StartSpan(ctx, "PutEndpointDrain")
+	defer span.End()
+	return metadata.PutEndpointDrain(ctx, endpoint, drain)
+}
+
+// GetEndpointDrains 返回所有设置过下线状态的存储节点, chunker定时同步
+func (n *NodeImpl) GetEndpointDrains(ctx context.Context) (map[string]bool, error) {
+	ctx, span := trace.StartSpan(ctx, "GetEndpointDrains")
+	defer span.End()
+	return metadata.QueryEndpointDrains(ctx)
+}
+
+// CountCidRef 统计引用cid的对象和分片, chunker删除分片数据前检查
+func (n *NodeImpl) CountCidRef(ctx context.Context, cid string) (int, error) {
+	ctx, span := trace.StartSpan(ctx, "CountCidRef")
+	defer span.End()
+	return metadata.CountCidRef(ctx, cid)
+}
+
+// GetObjectsByCid 查找内容为cid的对象, 用于未指定对象按cid访问的旧客户端
+func (n *NodeImpl) GetObjectsByCid(ctx context.Context, cid string, limit int) ([]metadata.ObjectInfo, error) {
+	ctx, span := trace.StartSpan(ctx, "GetObjectsByCid")
+	defer span.End()
+	return metadata.QueryObjectsByCid(ctx, cid, limit)
+}
@@ -25,6 +25,7 @@ require (
 	github.com/jinzhu/now v1.1.1 // indirect
 	github.com/klauspost/compress v1.13.6
 	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
+	github.com/klauspost/reedsolomon v1.9.13
 	github.com/lestrrat-go/strftime v1.0.3
 	github.com/lib/pq v1.9.0 // indirect
 	github.com/mattn/go-sqlite3 v2.0.1+incompatible // indirect
@@ -33,6 +34,7 @@ require (
 	github.com/minio/sha256-simd v1.0.0
 	github.com/minio/sio v0.3.0
 	github.com/mitchellh/mapstructure v1.4.1 // indirect
+	github.com/multiformats/go-multihash v0.0.14
 	github.com/olivere/elastic/v7 v7.0.31
 	github.com/pelletier/go-toml v1.8.0 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
@@ -2,7 +2,12 @@ package api
 
 import (
 	"context"
+	"time"
+
+	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
 	"mtcloud.com/mtstorage/node/util"
+	"mtcloud.com/mtstorage/pkg/auth"
+	"mtcloud.com/mtstorage/pkg/policy"
 )
 
 // ServerNode API is a low-level interface to the distribute network call
@@ -11,4 +16,18 @@ type ServerNode interface {
 	Version(context.Context, string) (string, error)
 	Heartbeat(context.Context, util.ChunkerNodeInfo) error
 	SaveObjectMeta(ctx context.Context, info util.ReWriteObjectInfo) error
+	GetBucketProfile(ctx context.Context, bucket string) (string, error)
+	PutMultipartUpload(ctx context.Context, upload metadata.MultipartUploadInfo) error
+	PutMultipartPart(ctx context.Context, part metadata.MultipartPartInfo) (string, error)
+	RemoveMultipartUpload(ctx context.Context, uploadId string) (metadata.MultipartUploadInfo, error)
+	GetMultipartUpload(ctx context.Context, uploadId string) (metadata.MultipartUploadInfo, error)
+	GetStaleMultipartUploads(ctx context.Context, before time.Time, marker string, limit int) ([]metadata.MultipartUploadInfo, error)
+	GetBucketLifecycle(ctx context.Context, bucket string) (string, error)
+	GetObjectInfo(ctx context.Context, bucket, object string) (metadata.ObjectInfo, error)
+	GetCredentials(ctx context.Context, accessKey string) (auth.Credentials, error)
+	GetBucketAccess(ctx context.Context, bucket string) (policy.BucketAccess, error)
+	PutEndpointDrain(ctx context.Context, endpoint string, drain bool) error
+	GetEndpointDrains(ctx context.Context) (map[string]bool, error)
+	CountCidRef(ctx context.Context, cid string) (int, error)
+	GetObjectsByCid(ctx context.Context, cid string, limit int) ([]metadata.ObjectInfo, error)
 }
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"time"
 
 	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
 	"mtcloud.com/mtstorage/node/util"
@@ -18,4 +19,12 @@ type ServerControlNode interface {
 	PutObjectCidInfo(context.Context, metadata.ObjectChunkInfo) error
 
 	GetObjectCidInfos(context.Context) ([]metadata.ObjectChunkInfo, error)
+
+	GetUnreferencedCids(context.Context, time.Duration, int) ([]metadata.CidRefInfo, error)
+
+	RemoveCidRef(context.Context, string) (bool, error)
+
+	CountCidRef(context.Context, string) (int, error)
+
+	GetReferencedCids(context.Context, uint, int) ([]metadata.CidRefInfo, error)
 }
@@ -2,15 +2,34 @@ package client
 
 import (
 	"context"
+	"time"
+
+	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
 	"mtcloud.com/mtstorage/node/util"
+	"mtcloud.com/mtstorage/pkg/auth"
+	"mtcloud.com/mtstorage/pkg/policy"
 )
 
 type ServerClient struct {
 	Internal struct {
-		TestNetwork    func(ctx context.Context) (bool, error)
-		Version        func(ctx context.Context, v string) (string, error)
-		Heartbeat      func(ctx context.Context, info util.ChunkerNodeInfo) error
-		SaveObjectMeta func(ctx context.Context, d util.ReWriteObjectInfo) error
+		TestNetwork              func(ctx context.Context) (bool, error)
+		Version                  func(ctx context.Context, v string) (string, error)
+		Heartbeat                func(ctx context.Context, info util.ChunkerNodeInfo) error
+		SaveObjectMeta           func(ctx context.Context, d util.ReWriteObjectInfo) error
+		GetBucketProfile         func(ctx context.Context, bucket string) (string, error)
+		PutMultipartUpload       func(ctx context.Context, upload metadata.MultipartUploadInfo) error
+		PutMultipartPart         func(ctx context.Context, part metadata.MultipartPartInfo) (string, error)
+		RemoveMultipartUpload    func(ctx context.Context, uploadId string) (metadata.MultipartUploadInfo, error)
+		GetMultipartUpload       func(ctx context.Context, uploadId string) (metadata.MultipartUploadInfo, error)
+		GetStaleMultipartUploads func(ctx context.Context, before time.Time, marker string, limit int) ([]metadata.MultipartUploadInfo, error)
+		GetBucketLifecycle       func(ctx context.Context, bucket string) (string, error)
+		GetObjectInfo            func(ctx context.Context, bucket, object string) (metadata.ObjectInfo, error)
+		GetCredentials           func(ctx context.Context, accessKey string) (auth.Credentials, error)
+		GetBucketAccess          func(ctx context.Context, bucket string) (policy.BucketAccess, error)
+		PutEndpointDrain         func(ctx context.Context, endpoint string, drain bool) error
+		GetEndpointDrains        func(ctx context.Context) (map[string]bool, error)
+		CountCidRef              func(ctx context.Context, cid string) (int, error)
+		GetObjectsByCid          func(ctx context.Context, cid string, limit int) ([]metadata.ObjectInfo, error)
 	}
 }
 
@@ -30,3 +49,59 @@ func (c *ServerClient) Heartbeat(ctx context.Context, info util.ChunkerNodeInfo)
 func (c *ServerClient) SaveObjectMeta(ctx context.Context, d util.ReWriteObjectInfo) error {
 	return c.Internal.SaveObjectMeta(ctx, d)
 }
+
+func (c *ServerClient) GetBucketProfile(ctx context.Context, bucket string) (string, error) {
+	return c.Internal.GetBucketProfile(ctx, bucket)
+}
+
+func (c *ServerClient) PutMultipartUpload(ctx context.Context, upload metadata.MultipartUploadInfo) error {
+	return c.Internal.PutMultipartUpload(ctx, upload)
+}
+
+func (c *ServerClient) PutMultipartPart(ctx context.Context, part metadata.MultipartPartInfo) (string, error) {
+	return c.Internal.PutMultipartPart(ctx, part)
+}
+
+func (c *ServerClient) RemoveMultipartUpload(ctx context.Context, uploadId string) (metadata.MultipartUploadInfo, error) {
+	return c.Internal.RemoveMultipartUpload(ctx, uploadId)
+}
+
+func (c *ServerClient) GetMultipartUpload(ctx context.Context, uploadId string) (metadata.MultipartUploadInfo, error) {
+	return c.Internal.GetMultipartUpload(ctx, uploadId)
+}
+
+func (c *ServerClient) GetStaleMultipartUploads(ctx context.Context, before time.Time, marker string, limit int) ([]metadata.MultipartUploadInfo, error) {
+	return c.Internal.GetStaleMultipartUploads(ctx, before, marker, limit)
+}
+
+func (c *ServerClient) GetBucketLifecycle(ctx context.Context, bucket string) (string, error) {
+	return c.Internal.GetBucketLifecycle(ctx, bucket)
+}
+
+func (c *ServerClient) GetObjectInfo(ctx context.Context, bucket, object string) (metadata.ObjectInfo, error) {
+	return c.Internal.GetObjectInfo(ctx, bucket, object)
+}
+
+func (c *ServerClient) GetCredentials(ctx context.Context, accessKey string) (auth.Credentials, error) {
+	return c.Internal.GetCredentials(ctx, accessKey)
+}
+
+func (c *ServerClient) GetBucketAccess(ctx context.Context, bucket string) (policy.BucketAccess, error) {
+	return c.Internal.GetBucketAccess(ctx, bucket)
+}
+
+func (c *ServerClient) PutEndpointDrain(ctx context.Context, endpoint string, drain bool) error {
+	return c.Internal.PutEndpointDrain(ctx, endpoint, drain)
+}
+
+func (c *ServerClient) GetEndpointDrains(ctx context.Context) (map[string]bool, error) {
+	return c.Internal.GetEndpointDrains(ctx)
+}
+
+func (c *ServerClient) CountCidRef(ctx context.Context, cid string) (int, error) {
+	return c.Internal.CountCidRef(ctx, cid)
+}
+
+func (c *ServerClient) GetObjectsByCid(ctx context.Context, cid string, limit int) ([]metadata.ObjectInfo, error) {
+	return c.Internal.GetObjectsByCid(ctx, cid, limit)
+}
@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"time"
 
 	"mtcloud.com/mtstorage/cmd/nameserver/metadata"
 	"mtcloud.com/mtstorage/node/util"
@@ -9,11 +10,15 @@ import (
 
 type ServerControlNodeClient struct {
 	Internal struct {
-		GetChunkerNode    func(context.Context) (util.ChunkerNodeInfo, error)
-		GetBucketsLogging func(ctx context.Context) ([]metadata.BucketExternal, error)
-		GetChunkerNodes   func(ctx context.Context) ([]util.ChunkerNodeInfo, error)
-		PutObjectCidInfo  func(context.Context, metadata.ObjectChunkInfo) error
-		GetObjectCidInfos func(context.Context) ([]metadata.ObjectChunkInfo, error)
+		GetChunkerNode      func(context.Context) (util.ChunkerNodeInfo, error)
+		GetBucketsLogging   func(ctx context.Context) ([]metadata.BucketExternal, error)
+		GetChunkerNodes     func(ctx context.Context) ([]util.ChunkerNodeInfo, error)
+		PutObjectCidInfo    func(context.Context, metadata.ObjectChunkInfo) error
+		GetObjectCidInfos   func(context.Context) ([]metadata.ObjectChunkInfo, error)
+		GetUnreferencedCids func(context.Context, time.Duration, int) ([]metadata.CidRefInfo, error)
+		RemoveCidRef        func(context.Context, string) (bool, error)
+		CountCidRef         func(context.Context, string) (int, error)
+		GetReferencedCids   func(context.Context, uint, int) ([]metadata.CidRefInfo, error)
 	}
 }
 
@@ -36,3 +41,19 @@ func (c *ServerControlNodeClient) PutObjectCidInfo(ctx context.Context, obj meta
 func (c *ServerControlNodeClient) GetObjectCidInfos(ctx context.Context) ([]metadata.ObjectChunkInfo, error) {
 	return c.Internal.GetObjectCidInfos(ctx)
 }
+
+func (c *ServerControlNodeClient) GetUnreferencedCids(ctx context.Context, grace time.Duration, limit int) ([]metadata.CidRefInfo, error) {
+	return c.Internal.GetUnreferencedCids(ctx, grace, limit)
+}
+
+func (c *ServerControlNodeClient) RemoveCidRef(ctx context.Context, cid string) (bool, error) {
+	return c.Internal.RemoveCidRef(ctx, cid)
+}
+
+func (c *ServerControlNodeClient) CountCidRef(ctx context.Context, cid string) (int, error) {
+	return c.Internal.CountCidRef(ctx, cid)
+}
+
+func (c *ServerControlNodeClient) GetReferencedCids(ctx context.Context, marker uint, limit int) ([]metadata.CidRefInfo, error) {
+	return c.Internal.GetReferencedCids(ctx, marker, limit)
+}
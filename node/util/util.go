@@ -6,6 +6,8 @@ const (
 	State_Health  = "ok"
 	State_keep    = "keep"
 	State_Offline = "offline"
+	// 存储节点下线中, 仍可读取
+	State_Draining = "draining"
 )
 
 type Region struct {
@@ -27,6 +29,10 @@ type ReWriteObjectInfo struct {
 	ContentType    string
 	ActualCid      string
 	ACL            string
+	Compression    string //压缩算法, 未压缩为空
+	CompressedSize uint64 //压缩后的大小
+	Appendable     bool   //追加写入, 按AppendPosition更新对象
+	AppendPosition uint64 //追加的位置, 与对象当前的长度相同
 }
 
 type ChunkerNodeInfo struct {
@@ -38,6 +44,8 @@ type ChunkerNodeInfo struct {
 	TotalSpace     uint64    `json:"totalspace,omitempty"`
 	UsedSpace      uint64    `json:"usedspace,omitempty"`
 	AvailableSpace uint64    `json:"availspace,omitempty"`
+	NumObjects     uint64    `json:"numobjects,omitempty"`
+	Disks          []Disk    `json:"disks,omitempty"` //各存储节点的容量和状态
 	State          string    `json:"state,omitempty"`
 	LatestNetSpeed float64   `json:"latestNetSpeed"` //最新网速
 	Time           time.Time `json:"time"`
@@ -54,6 +62,7 @@ type Disk struct {
 	TotalSpace      uint64  `json:"totalspace,omitempty"`
 	UsedSpace       uint64  `json:"usedspace,omitempty"`
 	AvailableSpace  uint64  `json:"availspace,omitempty"`
+	NumObjects      uint64  `json:"numobjects,omitempty"`
 	ReadThroughput  float64 `json:"readthroughput,omitempty"`
 	WriteThroughPut float64 `json:"writethroughput,omitempty"`
 	ReadLatency     float64 `json:"readlatency,omitempty"`
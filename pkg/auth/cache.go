@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// 缓存的秘钥数量
+const cachedCredentials = 1024
+
+type cachedEntry struct {
+	cred    Credentials
+	found   bool
+	expires time.Time
+}
+
+// CachedStore caches the credentials of the store for ttl, including the access keys not found.
+// 禁用或删除的秘钥最多ttl后失效
+type CachedStore struct {
+	store Store
+	ttl   time.Duration
+	cache *lru.Cache
+}
+
+func NewCachedStore(store Store, ttl time.Duration) *CachedStore {
+	c, _ := lru.New(cachedCredentials)
+	return &CachedStore{store: store, ttl: ttl, cache: c}
+}
+
+func (s *CachedStore) GetCredentials(ctx context.Context, accessKey string) (Credentials, error) {
+	if v, ok := s.cache.Get(accessKey); ok {
+		e := v.(cachedEntry)
+		if time.Now().Before(e.expires) {
+			if !e.found {
+				return Credentials{}, ErrNoSuchAccessKey
+			}
+			return e.cred, nil
+		}
+	}
+	c, err := s.store.GetCredentials(ctx, accessKey)
+	if err != nil && err != ErrNoSuchAccessKey {
+		return c, err
+	}
+	s.cache.Add(accessKey, cachedEntry{cred: c, found: err == nil, expires: time.Now().Add(s.ttl)})
+	return c, err
+}
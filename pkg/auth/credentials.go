@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+
+	"mtcloud.com/mtstorage/pkg/config"
+	"mtcloud.com/mtstorage/pkg/crypto"
+	"mtcloud.com/mtstorage/pkg/logger"
+)
+
+// ErrNoSuchAccessKey the access key does not exist or has been disabled
+var ErrNoSuchAccessKey = errors.New("access key not found")
+
+// Credentials the key pair used to sign the requests
+type Credentials struct {
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey,omitempty"`
+	Owner     uint32 `json:"owner,omitempty"` //所属用户的ID, 与桶的Owner对应
+	User      string `json:"user,omitempty"`
+	Admin     bool   `json:"admin,omitempty"` //配置文件中的秘钥, 用于内部服务和管理接口
+}
+
+// Store looks up the credentials of the access key, ErrNoSuchAccessKey is returned when not found
+type Store interface {
+	GetCredentials(ctx context.Context, accessKey string) (Credentials, error)
+}
+
+// StaticStore the credentials loaded from the config file
+type StaticStore map[string]Credentials
+
+// NewStaticStore decrypts the configured secret keys, the invalid ones are skipped
+func NewStaticStore(cs []config.CredentialConfig) StaticStore {
+	s := make(StaticStore, len(cs))
+	for _, c := range cs {
+		secret := crypto.DecryptLocalPassword(c.SecretKey)
+		if c.AccessKey == "" || secret == "" {
+			logger.Warnf("skip invalid credential of access key: %q", c.AccessKey)
+			continue
+		}
+		s[c.AccessKey] = Credentials{AccessKey: c.AccessKey, SecretKey: secret, Admin: true}
+	}
+	return s
+}
+
+func (s StaticStore) GetCredentials(ctx context.Context, accessKey string) (Credentials, error) {
+	c, ok := s[accessKey]
+	if !ok {
+		return Credentials{}, ErrNoSuchAccessKey
+	}
+	return c, nil
+}
+
+// Stores looks up the access key in the stores in order
+type Stores []Store
+
+func (s Stores) GetCredentials(ctx context.Context, accessKey string) (Credentials, error) {
+	for _, store := range s {
+		c, err := store.GetCredentials(ctx, accessKey)
+		if err != ErrNoSuchAccessKey {
+			return c, err
+		}
+	}
+	return Credentials{}, ErrNoSuchAccessKey
+}
+
+// GenerateCredentials generates a random key pair
+func GenerateCredentials() (Credentials, error) {
+	ak, err := randomString(accessKeyAlphabet, accessKeyLen)
+	if err != nil {
+		return Credentials{}, err
+	}
+	sk, err := randomString(secretKeyAlphabet, secretKeyLen)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{AccessKey: ak, SecretKey: sk}, nil
+}
+
+const (
+	accessKeyLen      = 20
+	secretKeyLen      = 40
+	accessKeyAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	secretKeyAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+)
+
+func randomString(alphabet string, n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b), nil
+}
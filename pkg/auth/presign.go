@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/signer"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+)
+
+// 预签名url中上传数据的长度限制, 与其余参数一同签名
+const (
+	MinContentLength = "minContentLength"
+	MaxContentLength = "maxContentLength"
+)
+
+// PresignURL signs the url in the query string with the credentials, the url is valid for expires.
+// The method and the query of the url are signed as well, the bucket, object and content-length limits
+// in the query can not be changed.
+func PresignURL(method, rawURL string, cred Credentials, region string, expires time.Duration) (string, error) {
+	if expires < time.Second || expires > maxPresignExpires {
+		return "", fmt.Errorf("expires must be between 1s and %s", maxPresignExpires)
+	}
+	r, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	r = signer.PreSignV4(*r, cred.AccessKey, cred.SecretKey, "", region, int64(expires/time.Second))
+	return r.URL.String(), nil
+}
+
+// CheckContentLength checks the content-length of the presigned request against the limits in its query
+func CheckContentLength(r *http.Request) error2.APIErrorCode {
+	query := r.URL.Query()
+	for _, k := range []string{MinContentLength, MaxContentLength} {
+		v := query.Get(k)
+		if v == "" {
+			continue
+		}
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 0 {
+			return error2.ErrMalformedPresignedQuery
+		}
+		// 分块传输时长度未知
+		if r.ContentLength < 0 {
+			return error2.ErrMissingContentLength
+		}
+		if k == MinContentLength && r.ContentLength < limit {
+			return error2.ErrEntityTooSmall
+		}
+		if k == MaxContentLength && r.ContentLength > limit {
+			return error2.ErrEntityTooLarge
+		}
+	}
+	return error2.ErrNone
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+)
+
+func TestPresignURL(t *testing.T) {
+	cred := testStore["AKIDTEST"]
+	u, err := PresignURL(http.MethodPost, "http://127.0.0.1:8521/cs/v1/postObject?bucket=b&object=a&maxContentLength=5", cred, "cd", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		want   error2.APIErrorCode
+	}{
+		{name: "valid", method: http.MethodPost, url: u, body: "hello", want: error2.ErrNone},
+		{name: "method", method: http.MethodPut, url: u, body: "hello", want: error2.ErrSignatureDoesNotMatch},
+		{name: "object", method: http.MethodPost, url: strings.Replace(u, "object=a", "object=b", 1), body: "hello", want: error2.ErrSignatureDoesNotMatch},
+		{name: "limit", method: http.MethodPost, url: strings.Replace(u, "maxContentLength=5", "maxContentLength=50", 1), body: "hello", want: error2.ErrSignatureDoesNotMatch},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r, err := http.NewRequest(c.method, c.url, strings.NewReader(c.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, code := VerifyRequest(context.Background(), r, testStore, "cd", time.Now().UTC()); code != c.want {
+				t.Fatalf("want %v, got %v", c.want, code)
+			}
+		})
+	}
+
+	if _, err = PresignURL(http.MethodGet, "http://127.0.0.1:8521/cs/v1/object/QmHash", cred, "cd", 8*24*time.Hour); err == nil {
+		t.Fatal("expires longer than 7 days should fail")
+	}
+}
+
+func TestCheckContentLength(t *testing.T) {
+	cases := []struct {
+		query  string
+		length int64
+		want   error2.APIErrorCode
+	}{
+		{query: "", length: -1, want: error2.ErrNone},
+		{query: "minContentLength=1&maxContentLength=5", length: 5, want: error2.ErrNone},
+		{query: "maxContentLength=5", length: 6, want: error2.ErrEntityTooLarge},
+		{query: "minContentLength=2", length: 1, want: error2.ErrEntityTooSmall},
+		{query: "maxContentLength=5", length: -1, want: error2.ErrMissingContentLength},
+		{query: "maxContentLength=five", length: 1, want: error2.ErrMalformedPresignedQuery},
+	}
+	for i, c := range cases {
+		r, err := http.NewRequest(http.MethodPost, "http://127.0.0.1:8521/cs/v1/postObject?"+c.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.ContentLength = c.length
+		if code := CheckContentLength(r); code != c.want {
+			t.Fatalf("Test %d: want %v, got %v", i, c.want, code)
+		}
+	}
+}
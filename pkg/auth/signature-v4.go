@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+	xhttp "mtcloud.com/mtstorage/pkg/http"
+	"mtcloud.com/mtstorage/pkg/logger"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+)
+
+const (
+	SignV4Algorithm = "AWS4-HMAC-SHA256"
+	ServiceS3       = "s3"
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+	iso8601Format = "20060102T150405Z"
+	yyyymmdd      = "20060102"
+	// 预签名请求的最长有效期
+	maxPresignExpires = 7 * 24 * time.Hour
+)
+
+// signValues 请求中携带的签名信息
+type signValues struct {
+	accessKey     string
+	date          time.Time // credential scope 中的日期
+	region        string
+	signedHeaders []string
+	signature     string
+}
+
+func (v signValues) scope() string {
+	return strings.Join([]string{v.date.Format(yyyymmdd), v.region, ServiceS3, "aws4_request"}, "/")
+}
+
+// IsRequestSignedV4 the request is signed in the authorization header
+func IsRequestSignedV4(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get(xhttp.Authorization), SignV4Algorithm)
+}
+
+// IsRequestPresignedV4 the request is signed in the query string
+func IsRequestPresignedV4(r *http.Request) bool {
+	_, ok := r.URL.Query()[xhttp.AmzCredential]
+	return ok
+}
+
+// IsRequestAnonymous the request carries no authentication at all
+func IsRequestAnonymous(r *http.Request) bool {
+	return r.Header.Get(xhttp.Authorization) == "" && !IsRequestPresignedV4(r)
+}
+
+// VerifyRequest verifies the signature V4 in the authorization header or the query string of the request,
+// returns the credentials of the signer.
+// 预签名请求同时校验有效期, 头部签名请求的时间偏差由调用方检查
+func VerifyRequest(ctx context.Context, r *http.Request, store Store, region string, now time.Time) (Credentials, error2.APIErrorCode) {
+	var (
+		v       signValues
+		t       time.Time
+		payload string
+		code    error2.APIErrorCode
+	)
+	query := r.URL.Query()
+	switch {
+	case IsRequestSignedV4(r):
+		if v, code = parseSignV4(r.Header.Get(xhttp.Authorization)); code != error2.ErrNone {
+			return Credentials{}, code
+		}
+		if t, code = RequestTime(r); code != error2.ErrNone {
+			return Credentials{}, code
+		}
+		payload = r.Header.Get(xhttp.AmzContentSha256)
+	case IsRequestPresignedV4(r):
+		if v, t, code = parsePresignV4(query, now); code != error2.ErrNone {
+			return Credentials{}, code
+		}
+		payload = query.Get(xhttp.AmzContentSha256)
+		query.Del(xhttp.AmzSignature)
+	case r.Header.Get(xhttp.Authorization) != "":
+		return Credentials{}, error2.ErrSignatureVersionNotSupported
+	default:
+		return Credentials{}, error2.ErrAccessDenied
+	}
+	if payload == "" {
+		payload = UnsignedPayload
+	}
+	// 不支持分块签名上传
+	if strings.HasPrefix(payload, "STREAMING-") {
+		return Credentials{}, error2.ErrSignatureVersionNotSupported
+	}
+	if region != "" && v.region != region {
+		return Credentials{}, error2.ErrAuthorizationHeaderMalformed
+	}
+	// credential scope中的日期必须与请求时间是同一天
+	if v.date.Format(yyyymmdd) != t.UTC().Format(yyyymmdd) {
+		return Credentials{}, error2.ErrAuthorizationHeaderMalformed
+	}
+	if code = checkSignedHeaders(r, v.signedHeaders); code != error2.ErrNone {
+		return Credentials{}, code
+	}
+
+	cred, err := store.GetCredentials(ctx, v.accessKey)
+	if err == ErrNoSuchAccessKey {
+		return Credentials{}, error2.ErrInvalidAccessKeyID
+	}
+	if err != nil {
+		logger.Errorf("get credentials of %s failed: %s", v.accessKey, err)
+		return Credentials{}, error2.ErrInternalError
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		s3utils.EncodePath(r.URL.Path),
+		strings.ReplaceAll(query.Encode(), "+", "%20"),
+		canonicalHeaders(r, v.signedHeaders),
+		strings.Join(v.signedHeaders, ";"),
+		payload,
+	}, "\n")
+	signature := signString(cred.SecretKey, v, stringToSign(t, v.scope(), canonicalRequest))
+	if !hmac.Equal([]byte(signature), []byte(v.signature)) {
+		return Credentials{}, error2.ErrSignatureDoesNotMatch
+	}
+	return cred, error2.ErrNone
+}
+
+// RequestTime parses the time of the request from the x-amz-date or date header
+func RequestTime(r *http.Request) (time.Time, error2.APIErrorCode) {
+	if d := r.Header.Get(xhttp.AmzDate); d != "" {
+		t, err := time.Parse(iso8601Format, d)
+		if err != nil {
+			return time.Time{}, error2.ErrMalformedDate
+		}
+		return t, error2.ErrNone
+	}
+	if d := r.Header.Get(xhttp.Date); d != "" {
+		t, err := http.ParseTime(d)
+		if err != nil {
+			return time.Time{}, error2.ErrMalformedDate
+		}
+		return t.UTC(), error2.ErrNone
+	}
+	return time.Time{}, error2.ErrMissingDateHeader
+}
+
+// parseSignV4 parses the authorization header like:
+// AWS4-HMAC-SHA256 Credential=AKID/20130524/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-date, Signature=xxx
+func parseSignV4(header string) (signValues, error2.APIErrorCode) {
+	fields := make(map[string]string, 3)
+	for _, f := range strings.Split(strings.TrimPrefix(header, SignV4Algorithm), ",") {
+		kv := strings.SplitN(strings.TrimSpace(f), "=", 2)
+		if len(kv) != 2 {
+			return signValues{}, error2.ErrAuthorizationHeaderMalformed
+		}
+		fields[kv[0]] = kv[1]
+	}
+	return newSignValues(fields["Credential"], fields["SignedHeaders"], fields["Signature"], error2.ErrAuthorizationHeaderMalformed)
+}
+
+// parsePresignV4 parses the signature in the query string, and checks the expiry of the presigned request
+func parsePresignV4(query map[string][]string, now time.Time) (signValues, time.Time, error2.APIErrorCode) {
+	get := func(k string) string {
+		if vs := query[k]; len(vs) > 0 {
+			return vs[0]
+		}
+		return ""
+	}
+	if get(xhttp.AmzAlgorithm) != SignV4Algorithm {
+		return signValues{}, time.Time{}, error2.ErrSignatureVersionNotSupported
+	}
+	v, code := newSignValues(get(xhttp.AmzCredential), get(xhttp.AmzSignedHeaders), get(xhttp.AmzSignature), error2.ErrMalformedPresignedQuery)
+	if code != error2.ErrNone {
+		return v, time.Time{}, code
+	}
+	t, err := time.Parse(iso8601Format, get(xhttp.AmzDate))
+	if err != nil {
+		return v, t, error2.ErrMalformedPresignedQuery
+	}
+	expires, err := strconv.ParseInt(get(xhttp.AmzExpires), 10, 64)
+	if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignExpires {
+		return v, t, error2.ErrMalformedPresignedQuery
+	}
+	if t.After(now.Add(time.Minute)) {
+		return v, t, error2.ErrRequestNotReadyYet
+	}
+	if now.After(t.Add(time.Duration(expires) * time.Second)) {
+		return v, t, error2.ErrExpiredPresignRequest
+	}
+	return v, t, error2.ErrNone
+}
+
+func newSignValues(credential, signedHeaders, signature string, malformed error2.APIErrorCode) (signValues, error2.APIErrorCode) {
+	// AKID/20130524/us-east-1/s3/aws4_request
+	cs := strings.Split(credential, "/")
+	if len(cs) != 5 || cs[0] == "" || cs[3] != ServiceS3 || cs[4] != "aws4_request" || signedHeaders == "" || signature == "" {
+		return signValues{}, malformed
+	}
+	date, err := time.Parse(yyyymmdd, cs[1])
+	if err != nil {
+		return signValues{}, malformed
+	}
+	return signValues{
+		accessKey:     cs[0],
+		date:          date,
+		region:        cs[2],
+		signedHeaders: strings.Split(signedHeaders, ";"),
+		signature:     signature,
+	}, error2.ErrNone
+}
+
+// checkSignedHeaders host and all the x-amz-* headers must be signed
+func checkSignedHeaders(r *http.Request, signed []string) error2.APIErrorCode {
+	set := make(map[string]bool, len(signed))
+	for _, h := range signed {
+		set[h] = true
+	}
+	if !set["host"] {
+		return error2.ErrUnsignedHeaders
+	}
+	for k := range r.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") && !set[k] {
+			return error2.ErrUnsignedHeaders
+		}
+	}
+	return error2.ErrNone
+}
+
+func canonicalHeaders(r *http.Request, signed []string) string {
+	var b strings.Builder
+	for _, k := range signed {
+		b.WriteString(k)
+		b.WriteByte(':')
+		switch k {
+		case "host":
+			b.WriteString(r.Host)
+		case "content-length":
+			b.WriteString(strconv.FormatInt(r.ContentLength, 10))
+		default:
+			for i, v := range r.Header.Values(k) {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				b.WriteString(strings.Join(strings.Fields(v), " "))
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func stringToSign(t time.Time, scope, canonicalRequest string) string {
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	return SignV4Algorithm + "\n" + t.Format(iso8601Format) + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+}
+
+func signString(secret string, v signValues, s string) string {
+	key := sumHMAC([]byte("AWS4"+secret), []byte(v.date.Format(yyyymmdd)))
+	for _, p := range []string{v.region, ServiceS3, "aws4_request"} {
+		key = sumHMAC(key, []byte(p))
+	}
+	return hex.EncodeToString(sumHMAC(key, []byte(s)))
+}
+
+func sumHMAC(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
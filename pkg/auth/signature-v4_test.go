@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/signer"
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+)
+
+var testStore = StaticStore{
+	"AKIDTEST": {AccessKey: "AKIDTEST", SecretKey: "secret/key+123"},
+}
+
+func newTestRequest(t *testing.T, method, url string) *http.Request {
+	r, err := http.NewRequest(method, url, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "text/plain")
+	r.Header.Set("X-Amz-Meta-Color", "  red   and  blue ")
+	return r
+}
+
+func TestVerifyRequestHeader(t *testing.T) {
+	cases := []struct {
+		name   string
+		secret string
+		region string
+		modify func(r *http.Request)
+		want   error2.APIErrorCode
+	}{
+		{name: "valid", secret: "secret/key+123", region: "cd", want: error2.ErrNone},
+		{name: "wrong secret", secret: "other", region: "cd", want: error2.ErrSignatureDoesNotMatch},
+		{name: "wrong region", secret: "secret/key+123", region: "us-east-1", want: error2.ErrAuthorizationHeaderMalformed},
+		{name: "query modified", secret: "secret/key+123", region: "cd", modify: func(r *http.Request) {
+			r.URL.RawQuery = "bucket=b&object=other"
+		}, want: error2.ErrSignatureDoesNotMatch},
+		{name: "unsigned amz header", secret: "secret/key+123", region: "cd", modify: func(r *http.Request) {
+			r.Header.Set("X-Amz-Meta-Size", "1")
+		}, want: error2.ErrUnsignedHeaders},
+		{name: "unknown access key", secret: "secret/key+123", region: "cd", modify: func(r *http.Request) {
+			r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), "AKIDTEST", "AKIDNONE", 1))
+		}, want: error2.ErrInvalidAccessKeyID},
+		{name: "scope date mismatch", secret: "secret/key+123", region: "cd", modify: func(r *http.Request) {
+			r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), "AKIDTEST/"+r.Header.Get("X-Amz-Date")[:8], "AKIDTEST/19990101", 1))
+		}, want: error2.ErrAuthorizationHeaderMalformed},
+		{name: "signature v2", secret: "secret/key+123", region: "cd", modify: func(r *http.Request) {
+			r.Header.Set("Authorization", "AWS AKIDTEST:c2lnbmF0dXJl")
+		}, want: error2.ErrSignatureVersionNotSupported},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := newTestRequest(t, http.MethodPost, "http://127.0.0.1:8521/cs/v1/object?bucket=b&object=a%20b/c.txt")
+			r = signer.SignV4(*r, "AKIDTEST", c.secret, "", c.region)
+			if c.modify != nil {
+				c.modify(r)
+			}
+			_, code := VerifyRequest(context.Background(), r, testStore, "cd", time.Now().UTC())
+			if code != c.want {
+				t.Fatalf("want %v, got %v", c.want, code)
+			}
+		})
+	}
+}
+
+func TestVerifyRequestPresigned(t *testing.T) {
+	url := "http://127.0.0.1:8521/cs/v1/object/QmHash?crypto-key=abc"
+	cases := []struct {
+		name string
+		now  time.Time
+		want error2.APIErrorCode
+	}{
+		{name: "valid", now: time.Now().UTC().Add(time.Minute), want: error2.ErrNone},
+		{name: "expired", now: time.Now().UTC().Add(time.Hour), want: error2.ErrExpiredPresignRequest},
+		{name: "not ready", now: time.Now().UTC().Add(-time.Hour), want: error2.ErrRequestNotReadyYet},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := newTestRequest(t, http.MethodGet, url)
+			r.Header.Del("X-Amz-Meta-Color")
+			r = signer.PreSignV4(*r, "AKIDTEST", "secret/key+123", "", "cd", 600)
+			cred, code := VerifyRequest(context.Background(), r, testStore, "", c.now)
+			if code != c.want {
+				t.Fatalf("want %v, got %v", c.want, code)
+			}
+			if code == error2.ErrNone && cred.AccessKey != "AKIDTEST" {
+				t.Fatalf("unexpected access key %s", cred.AccessKey)
+			}
+		})
+	}
+
+	// 修改签名后的参数
+	r := newTestRequest(t, http.MethodGet, url)
+	r = signer.PreSignV4(*r, "AKIDTEST", "secret/key+123", "", "cd", 600)
+	q := r.URL.Query()
+	q.Set("crypto-key", "xyz")
+	r.URL.RawQuery = q.Encode()
+	if _, code := VerifyRequest(context.Background(), r, testStore, "", time.Now().UTC()); code != error2.ErrSignatureDoesNotMatch {
+		t.Fatalf("want signature mismatch, got %v", code)
+	}
+}
+
+func TestVerifyRequestAnonymous(t *testing.T) {
+	r := newTestRequest(t, http.MethodGet, "http://127.0.0.1:8521/cs/v1/object/QmHash")
+	if !IsRequestAnonymous(r) {
+		t.Fatal("request should be anonymous")
+	}
+	if _, code := VerifyRequest(context.Background(), r, testStore, "", time.Now().UTC()); code != error2.ErrAccessDenied {
+		t.Fatalf("want access denied, got %v", code)
+	}
+}
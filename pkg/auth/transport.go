@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/minio/minio-go/v7/pkg/signer"
+	xhttp "mtcloud.com/mtstorage/pkg/http"
+)
+
+// Transport signs the requests to the chunker and nameserver api with the credentials,
+// 已携带认证信息的请求(如elasticsearch的basic auth)不再签名
+type Transport struct {
+	Base        http.RoundTripper
+	Credentials Credentials
+	Region      string
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if r.Header.Get(xhttp.Authorization) != "" {
+		return base.RoundTrip(r)
+	}
+	region := t.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	req := signer.SignV4(*r.Clone(r.Context()), t.Credentials.AccessKey, t.Credentials.SecretKey, "", region)
+	return base.RoundTrip(req)
+}
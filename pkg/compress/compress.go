@@ -0,0 +1,229 @@
+// Package compress implements the transparent object compression of the chunker.
+// Compressed data starts with a header recording the codec and the original size.
+// Whether an object is compressed is only known from its metadata, the header is checked
+// against it on read but never used to detect compression, plain data may start with the same bytes.
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// 支持的压缩算法
+const (
+	None = ""
+	Zstd = "zstd"
+	S2   = "s2"
+)
+
+const (
+	// HeaderSize 压缩数据前的头部大小
+	HeaderSize = 24
+	// MinSize 小于该大小的对象不压缩
+	MinSize = 4 << 10
+	// profileKey 桶配置中压缩算法的配置项, 如 "compression=zstd"
+	profileKey = "compression"
+)
+
+// 头部: magic(8) + 算法(1) + 保留(3) + 原始大小(8) + crc32(4)
+var magic = [8]byte{0x89, 'M', 'T', 'C', 'M', 'P', '\r', '\n'}
+
+var codecIds = map[string]byte{Zstd: 1, S2: 2}
+
+var (
+	// ErrUnknownCodec 不支持的压缩算法
+	ErrUnknownCodec = errors.New("unknown compression codec")
+	// ErrInvalidHeader 数据的头部与元数据中的压缩算法不符
+	ErrInvalidHeader = errors.New("invalid compression header")
+)
+
+// Valid reports whether codec is a supported compression codec
+func Valid(codec string) bool {
+	_, ok := codecIds[codec]
+	return ok
+}
+
+// ParseProfile returns the compression codec enabled in the bucket profile,
+// the profile is a comma separated list, e.g. "Chengdu,compression=zstd".
+func ParseProfile(profile string) string {
+	for _, item := range strings.Split(profile, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 || !strings.EqualFold(strings.TrimSpace(kv[0]), profileKey) {
+			continue
+		}
+		codec := strings.ToLower(strings.TrimSpace(kv[1]))
+		if Valid(codec) {
+			return codec
+		}
+	}
+	return None
+}
+
+// 已压缩的内容类型, 再压缩没有收益
+var incompressibleTypes = []string{
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/x-zip-compressed",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/vnd.rar",
+	"application/zstd",
+	"application/x-compress",
+	"application/x-lz4",
+	"application/java-archive",
+	"application/pdf",
+	"application/vnd.openxmlformats-officedocument",
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+}
+
+// Compressible reports whether data of contentType is worth compressing
+func Compressible(contentType string) bool {
+	ct := strings.ToLower(strings.TrimSpace(contentType))
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = strings.TrimSpace(ct[:i])
+	}
+	if ct == "image/svg+xml" || ct == "image/bmp" {
+		return true
+	}
+	for _, t := range incompressibleTypes {
+		if strings.HasPrefix(ct, t) {
+			return false
+		}
+	}
+	return true
+}
+
+func encodeHeader(id byte, size int64) []byte {
+	h := make([]byte, HeaderSize)
+	copy(h, magic[:])
+	h[8] = id
+	binary.BigEndian.PutUint64(h[12:20], uint64(size))
+	binary.BigEndian.PutUint32(h[20:], crc32.ChecksumIEEE(h[:20]))
+	return h
+}
+
+// ParseHeader returns the codec and the original size recorded in the header,
+// ok is false if b does not start with a compression header.
+func ParseHeader(b []byte) (codec string, size int64, ok bool) {
+	if len(b) < HeaderSize || !bytes.Equal(b[:8], magic[:]) {
+		return None, 0, false
+	}
+	if crc32.ChecksumIEEE(b[:20]) != binary.BigEndian.Uint32(b[20:HeaderSize]) {
+		return None, 0, false
+	}
+	for c, id := range codecIds {
+		if id == b[8] {
+			return c, int64(binary.BigEndian.Uint64(b[12:20])), true
+		}
+	}
+	return None, 0, false
+}
+
+// Reader compresses the data read from the source
+type Reader struct {
+	pr      *io.PipeReader
+	written int64
+}
+
+// NewReader returns a reader of the header followed by the data of r compressed by codec,
+// size is the original size of the data.
+func NewReader(r io.Reader, codec string, size int64) (*Reader, error) {
+	id, ok := codecIds[codec]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		if _, err := pw.Write(encodeHeader(id, size)); err != nil {
+			return
+		}
+		var enc io.WriteCloser
+		switch codec {
+		case Zstd:
+			w, err := zstd.NewWriter(pw)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			enc = w
+		case S2:
+			enc = s2.NewWriter(pw)
+		}
+		n, err := io.Copy(enc, r)
+		if err == nil && n != size {
+			err = io.ErrUnexpectedEOF
+		}
+		if cerr := enc.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	return &Reader{pr: pr}, nil
+}
+
+func (c *Reader) Read(p []byte) (int, error) {
+	n, err := c.pr.Read(p)
+	c.written += int64(n)
+	return n, err
+}
+
+// Close stops compressing
+func (c *Reader) Close() error {
+	return c.pr.Close()
+}
+
+// Size returns the number of bytes read so far, including the header
+func (c *Reader) Size() int64 {
+	return c.written
+}
+
+// NewDecodeReader returns a reader of the data of r decompressed by codec, which comes from the object metadata.
+// The data must start with the header of codec. size is the original size recorded in the header.
+func NewDecodeReader(r io.Reader, codec string) (rd io.ReadCloser, size int64, err error) {
+	if !Valid(codec) {
+		return nil, 0, ErrUnknownCodec
+	}
+	head := make([]byte, HeaderSize)
+	if _, err = io.ReadFull(r, head); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrInvalidHeader
+		}
+		return nil, 0, err
+	}
+	c, size, ok := ParseHeader(head)
+	if !ok || c != codec {
+		return nil, 0, ErrInvalidHeader
+	}
+	switch codec {
+	case Zstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		return zstdReader{dec}, size, nil
+	default:
+		return io.NopCloser(s2.NewReader(r)), size, nil
+	}
+}
+
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+func (z zstdReader) Close() error {
+	z.Decoder.Close()
+	return nil
+}
@@ -0,0 +1,130 @@
+package compress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var parseProfileTests = []struct {
+	Profile string
+	Codec   string
+}{
+	{Profile: "", Codec: None},                                 // 0
+	{Profile: "Chengdu,Beijing,New York", Codec: None},         // 1
+	{Profile: "compression=zstd", Codec: Zstd},                 // 2
+	{Profile: "Chengdu, Compression = S2 ,Beijing", Codec: S2}, // 3
+	{Profile: "compression=gzip", Codec: None},                 // 4
+	{Profile: "compression=,compression=zstd", Codec: Zstd},    // 5
+}
+
+func TestParseProfile(t *testing.T) {
+	for i, test := range parseProfileTests {
+		if codec := ParseProfile(test.Profile); codec != test.Codec {
+			t.Fatalf("Test %d: codec mismatch: got %q - want %q", i, codec, test.Codec)
+		}
+	}
+}
+
+var compressibleTests = []struct {
+	ContentType  string
+	Compressible bool
+}{
+	{ContentType: "", Compressible: true},                          // 0
+	{ContentType: "text/plain; charset=utf-8", Compressible: true}, // 1
+	{ContentType: "application/json", Compressible: true},          // 2
+	{ContentType: "application/gzip", Compressible: false},         // 3
+	{ContentType: "Image/JPEG", Compressible: false},               // 4
+	{ContentType: "image/svg+xml", Compressible: true},             // 5
+	{ContentType: "video/mp4", Compressible: false},                // 6
+	{ContentType: "application/zip; foo=bar", Compressible: false}, // 7
+}
+
+func TestCompressible(t *testing.T) {
+	for i, test := range compressibleTests {
+		if ok := Compressible(test.ContentType); ok != test.Compressible {
+			t.Fatalf("Test %d: got %v - want %v", i, ok, test.Compressible)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("multi-storage compression ", 10000))
+	for _, codec := range []string{Zstd, S2} {
+		r, err := NewReader(bytes.NewReader(data), codec, int64(len(data)))
+		if err != nil {
+			t.Fatalf("%s: %v", codec, err)
+		}
+		compressed, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", codec, err)
+		}
+		if r.Size() != int64(len(compressed)) || r.Size() >= int64(len(data)) {
+			t.Fatalf("%s: size mismatch: got %d, compressed %d", codec, r.Size(), len(compressed))
+		}
+
+		rd, size, err := NewDecodeReader(bytes.NewReader(compressed), codec)
+		if err != nil {
+			t.Fatalf("%s: %v", codec, err)
+		}
+		if size != int64(len(data)) {
+			t.Fatalf("%s: header mismatch: got %d", codec, size)
+		}
+		plain, err := ioutil.ReadAll(rd)
+		rd.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", codec, err)
+		}
+		if !bytes.Equal(plain, data) {
+			t.Fatalf("%s: data mismatch", codec)
+		}
+	}
+}
+
+func TestDecodeHeader(t *testing.T) {
+	r, err := NewReader(strings.NewReader(strings.Repeat("a", MinSize)), Zstd, MinSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zstdData, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 未压缩的数据恰好以压缩头开头时, 只有元数据能区分
+	if _, _, ok := ParseHeader(zstdData); !ok {
+		t.Fatal("header not parsed")
+	}
+
+	cases := []struct {
+		data  []byte
+		codec string
+		want  error
+	}{
+		{data: zstdData, codec: Zstd, want: nil},                                     // 0
+		{data: zstdData, codec: S2, want: ErrInvalidHeader},                          // 1
+		{data: nil, codec: Zstd, want: ErrInvalidHeader},                             // 2
+		{data: []byte("short"), codec: S2, want: ErrInvalidHeader},                   // 3
+		{data: bytes.Repeat([]byte{0x89}, 100), codec: Zstd, want: ErrInvalidHeader}, // 4
+		{data: zstdData, codec: None, want: ErrUnknownCodec},                         // 5
+	}
+	for i, c := range cases {
+		rd, _, err := NewDecodeReader(bytes.NewReader(c.data), c.codec)
+		if err != c.want {
+			t.Fatalf("Test %d: want %v, got %v", i, c.want, err)
+		}
+		if rd != nil {
+			rd.Close()
+		}
+	}
+}
+
+func TestShortSource(t *testing.T) {
+	r, err := NewReader(strings.NewReader("abc"), Zstd, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(r); err == nil {
+		t.Fatal("short source should fail")
+	}
+}
@@ -35,3 +35,16 @@ type RedisConfig struct {
 	Url      string
 	Password string
 }
+
+// AuthConfig 请求的签名认证(AWS Signature V4)
+type AuthConfig struct {
+	Enable      bool
+	Region      string //签名的区域, 为空时不校验
+	MaxSkew     int    //允许的请求时间偏差(秒)
+	Credentials []CredentialConfig
+}
+
+type CredentialConfig struct {
+	AccessKey string
+	SecretKey string //加密后的秘钥, 与redis密码的加密方式相同
+}
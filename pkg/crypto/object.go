@@ -25,3 +25,37 @@ func GetEncryptReader(read io.Reader, objectEncryptionKey hash.ObjectKey, encMd5
 	hashReader.WithEncryption(objectEncryptionKey)
 	return hashReader, nil
 }
+
+// DARE 2.0 加密包大小, 每个包 16字节头 + 64KB数据 + 16字节tag
+const (
+	DarePayloadSize = 64 * 1024
+	DarePackageSize = DarePayloadSize + 32
+)
+
+// DareRange returns the range of the encrypted packages which cover the plain range
+// [offset, offset+length) of a DARE stream with encSize bytes, and the sequence number
+// of the first package.
+func DareRange(offset, length, encSize int64) (encOffset, encLength int64, seq uint32) {
+	first := offset / DarePayloadSize
+	last := (offset + length - 1) / DarePayloadSize
+	encOffset = first * DarePackageSize
+	encLength = (last - first + 1) * DarePackageSize
+	if encOffset+encLength > encSize {
+		encLength = encSize - encOffset
+	}
+	return encOffset, encLength, uint32(first)
+}
+
+// DecryptRangeReader decrypts the packages read from DareRange, and skips the
+// leading bytes so the returned reader starts at offset.
+func DecryptRangeReader(rd io.Reader, key []byte, offset, length int64) (io.Reader, error) {
+	first := offset / DarePayloadSize
+	deRead, err := sio.DecryptReader(rd, sio.Config{Key: key, MinVersion: sio.Version20, SequenceNumber: uint32(first), CipherSuites: fips.CipherSuitesDARE()})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.CopyN(io.Discard, deRead, offset-first*DarePayloadSize); err != nil {
+		return nil, err
+	}
+	return io.LimitReader(deRead, length), nil
+}
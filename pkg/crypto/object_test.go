@@ -0,0 +1,48 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/minio/sio"
+	"mtcloud.com/mtstorage/pkg/fips"
+)
+
+func TestDecryptRangeReader(t *testing.T) {
+	key := make([]byte, 32)
+	plain := make([]byte, 3*DarePayloadSize+100)
+	rand.Read(key)
+	rand.Read(plain)
+
+	var enc bytes.Buffer
+	if _, err := sio.Encrypt(&enc, bytes.NewReader(plain), sio.Config{Key: key, MinVersion: sio.Version20, CipherSuites: fips.CipherSuitesDARE()}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct{ offset, length int64 }{
+		{0, 1},
+		{0, int64(len(plain))},
+		{10, DarePayloadSize},
+		{DarePayloadSize - 1, 2},
+		{2 * DarePayloadSize, DarePayloadSize},
+		{3 * DarePayloadSize, 100},
+		{int64(len(plain)) - 1, 1},
+	}
+	for _, c := range cases {
+		encOffset, encLength, _ := DareRange(c.offset, c.length, int64(enc.Len()))
+		rd := bytes.NewReader(enc.Bytes()[encOffset : encOffset+encLength])
+		deRead, err := DecryptRangeReader(rd, key, c.offset, c.length)
+		if err != nil {
+			t.Fatalf("offset %d length %d: %v", c.offset, c.length, err)
+		}
+		got, err := io.ReadAll(deRead)
+		if err != nil {
+			t.Fatalf("offset %d length %d: %v", c.offset, c.length, err)
+		}
+		if !bytes.Equal(got, plain[c.offset:c.offset+c.length]) {
+			t.Fatalf("offset %d length %d: content mismatch", c.offset, c.length)
+		}
+	}
+}
@@ -30,6 +30,18 @@ const (
 	IfUnmodifiedSince = "If-Unmodified-Since"
 	IfNoneMatch       = "If-None-Match"
 
+	// Signature V4 related contants.
+	AmzContentSha256 = "X-Amz-Content-Sha256"
+	AmzDate          = "X-Amz-Date"
+	AmzAlgorithm     = "X-Amz-Algorithm"
+	AmzExpires       = "X-Amz-Expires"
+	AmzSignedHeaders = "X-Amz-SignedHeaders"
+	AmzSignature     = "X-Amz-Signature"
+	AmzCredential    = "X-Amz-Credential"
+
+	// Reserved metadata prefix, headers with the prefix are for internal use only.
+	AmzMetaInternal = "X-Amz-Meta-Internal"
+
 	// Server-Status
 	MinIOServerStatus = "x-minio-server-status"
 
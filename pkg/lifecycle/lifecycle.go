@@ -0,0 +1,93 @@
+// Package lifecycle parses the bucket lifecycle configuration saved in t_ns_bucket_ext.lifecycle.
+// The configuration is the s3 xml document, the json form with the same element names is also accepted.
+package lifecycle
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+)
+
+// 规则状态
+const (
+	Enabled  = "Enabled"
+	Disabled = "Disabled"
+)
+
+// Configuration 桶的生命周期配置
+type Configuration struct {
+	Rules []Rule `xml:"Rule" json:"Rules"`
+}
+
+// Rule 生命周期规则, 只解析需要用到的部分
+type Rule struct {
+	ID     string `xml:"ID,omitempty" json:"ID,omitempty"`
+	Status string `xml:"Status" json:"Status"`
+	// Prefix 旧格式的前缀, 新格式在Filter中
+	Prefix                         string                          `xml:"Prefix,omitempty" json:"Prefix,omitempty"`
+	Filter                         Filter                          `xml:"Filter" json:"Filter"`
+	AbortIncompleteMultipartUpload *AbortIncompleteMultipartUpload `xml:"AbortIncompleteMultipartUpload,omitempty" json:"AbortIncompleteMultipartUpload,omitempty"`
+}
+
+// Filter 规则适用的对象
+type Filter struct {
+	Prefix string `xml:"Prefix,omitempty" json:"Prefix,omitempty"`
+	And    struct {
+		Prefix string `xml:"Prefix,omitempty" json:"Prefix,omitempty"`
+	} `xml:"And" json:"And"`
+}
+
+// AbortIncompleteMultipartUpload 分片上传发起后超过天数仍未完成时取消
+type AbortIncompleteMultipartUpload struct {
+	DaysAfterInitiation int `xml:"DaysAfterInitiation" json:"DaysAfterInitiation"`
+}
+
+// Parse parses the lifecycle configuration, an empty one has no rules
+func Parse(s string) (*Configuration, error) {
+	s = strings.TrimSpace(s)
+	c := &Configuration{}
+	if s == "" {
+		return c, nil
+	}
+	var err error
+	if strings.HasPrefix(s, "<") {
+		err = xml.Unmarshal([]byte(s), c)
+	} else {
+		err = json.Unmarshal([]byte(s), c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// prefix returns the object prefix the rule applies to
+func (r Rule) prefix() string {
+	if r.Filter.Prefix != "" {
+		return r.Filter.Prefix
+	}
+	if r.Filter.And.Prefix != "" {
+		return r.Filter.And.Prefix
+	}
+	return r.Prefix
+}
+
+// AbortIncompleteMultipartUpload returns the days after which the incomplete upload of object is aborted,
+// the shortest one is used if several enabled rules match. ok is false if no rule applies.
+func (c *Configuration) AbortIncompleteMultipartUpload(object string) (days int, ok bool) {
+	if c == nil {
+		return 0, false
+	}
+	for _, r := range c.Rules {
+		if r.Status != Enabled || r.AbortIncompleteMultipartUpload == nil || r.AbortIncompleteMultipartUpload.DaysAfterInitiation <= 0 {
+			continue
+		}
+		if !strings.HasPrefix(object, r.prefix()) {
+			continue
+		}
+		if d := r.AbortIncompleteMultipartUpload.DaysAfterInitiation; !ok || d < days {
+			days, ok = d, true
+		}
+	}
+	return days, ok
+}
@@ -0,0 +1,67 @@
+package lifecycle
+
+import "testing"
+
+const xmlConfig = `<LifecycleConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Rule>
+    <ID>logs</ID>
+    <Status>Enabled</Status>
+    <Filter><Prefix>logs/</Prefix></Filter>
+    <AbortIncompleteMultipartUpload><DaysAfterInitiation>3</DaysAfterInitiation></AbortIncompleteMultipartUpload>
+  </Rule>
+  <Rule>
+    <ID>all</ID>
+    <Status>Enabled</Status>
+    <Filter></Filter>
+    <AbortIncompleteMultipartUpload><DaysAfterInitiation>7</DaysAfterInitiation></AbortIncompleteMultipartUpload>
+  </Rule>
+  <Rule>
+    <ID>disabled</ID>
+    <Status>Disabled</Status>
+    <Filter><And><Prefix>logs/tmp/</Prefix></And></Filter>
+    <AbortIncompleteMultipartUpload><DaysAfterInitiation>1</DaysAfterInitiation></AbortIncompleteMultipartUpload>
+  </Rule>
+  <Rule>
+    <ID>expiration</ID>
+    <Status>Enabled</Status>
+    <Prefix>tmp/</Prefix>
+    <Expiration><Days>1</Days></Expiration>
+  </Rule>
+</LifecycleConfiguration>`
+
+const jsonConfig = `{"Rules":[{"ID":"old","Status":"Enabled","Prefix":"backup/","AbortIncompleteMultipartUpload":{"DaysAfterInitiation":2}}]}`
+
+var abortTests = []struct {
+	Config string
+	Object string
+	Days   int
+	OK     bool
+}{
+	{Config: xmlConfig, Object: "logs/a.log", Days: 3, OK: true},       // 0
+	{Config: xmlConfig, Object: "data/a.bin", Days: 7, OK: true},       // 1
+	{Config: xmlConfig, Object: "logs/tmp/a.log", Days: 3, OK: true},   // 2
+	{Config: jsonConfig, Object: "backup/db.tar", Days: 2, OK: true},   // 3
+	{Config: jsonConfig, Object: "data/backup/db", Days: 0, OK: false}, // 4
+	{Config: "", Object: "a", Days: 0, OK: false},                      // 5
+}
+
+func TestAbortIncompleteMultipartUpload(t *testing.T) {
+	for i, test := range abortTests {
+		c, err := Parse(test.Config)
+		if err != nil {
+			t.Fatalf("Test %d: %v", i, err)
+		}
+		days, ok := c.AbortIncompleteMultipartUpload(test.Object)
+		if days != test.Days || ok != test.OK {
+			t.Fatalf("Test %d: got %d, %v - want %d, %v", i, days, ok, test.Days, test.OK)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{"<LifecycleConfiguration><Rule>", "{\"Rules\":"} {
+		if _, err := Parse(s); err == nil {
+			t.Fatalf("%q should fail", s)
+		}
+	}
+}
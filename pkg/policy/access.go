@@ -0,0 +1,63 @@
+package policy
+
+import (
+	"context"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// 缓存的桶数量
+const cachedBuckets = 1024
+
+// BucketAccess 桶的所有者, 访问策略及ACL
+type BucketAccess struct {
+	Bucket string `json:"bucket"`
+	Owner  uint32 `json:"owner"`
+	Policy string `json:"policy"`
+	Acl    string `json:"acl"`
+}
+
+// Getter 查询桶的访问控制, 桶不存在时返回 storageerror.BucketNotFound.
+// 对象不存在时其ACL为空
+type Getter interface {
+	GetBucketAccess(ctx context.Context, bucket string) (BucketAccess, error)
+	GetObjectAcl(ctx context.Context, bucket, object string) (string, error)
+}
+
+type cachedAccess struct {
+	access  BucketAccess
+	expires time.Time
+}
+
+// CachedGetter caches the bucket access of the getter for ttl, the buckets not found are not cached
+// so that a bucket created later is evaluated at once. 修改的策略及桶的ACL最多ttl后生效, 对象的ACL不缓存
+type CachedGetter struct {
+	getter Getter
+	ttl    time.Duration
+	cache  *lru.Cache
+}
+
+func NewCachedGetter(getter Getter, ttl time.Duration) *CachedGetter {
+	c, _ := lru.New(cachedBuckets)
+	return &CachedGetter{getter: getter, ttl: ttl, cache: c}
+}
+
+func (g *CachedGetter) GetBucketAccess(ctx context.Context, bucket string) (BucketAccess, error) {
+	if v, ok := g.cache.Get(bucket); ok {
+		e := v.(cachedAccess)
+		if time.Now().Before(e.expires) {
+			return e.access, nil
+		}
+	}
+	a, err := g.getter.GetBucketAccess(ctx, bucket)
+	if err != nil {
+		return a, err
+	}
+	g.cache.Add(bucket, cachedAccess{access: a, expires: time.Now().Add(g.ttl)})
+	return a, nil
+}
+
+func (g *CachedGetter) GetObjectAcl(ctx context.Context, bucket, object string) (string, error) {
+	return g.getter.GetObjectAcl(ctx, bucket, object)
+}
@@ -0,0 +1,68 @@
+package policy
+
+// 预设的ACL
+const (
+	ACLPrivate           = "private"
+	ACLPublicRead        = "public-read"
+	ACLPublicReadWrite   = "public-read-write"
+	ACLAuthenticatedRead = "authenticated-read"
+	// ACLBucket 对象使用桶的ACL, 为对象的默认值
+	ACLBucket = "bucket"
+)
+
+// IsValidACL checks the canned acl, ACLBucket is only valid for objects
+func IsValidACL(acl string, isObject bool) bool {
+	switch acl {
+	case ACLPrivate, ACLPublicRead, ACLPublicReadWrite, ACLAuthenticatedRead:
+		return true
+	case ACLBucket:
+		return isObject
+	}
+	return false
+}
+
+// EffectiveACL returns the acl applied to the object, the bucket acl is used if the object acl is empty or ACLBucket.
+// 未设置或无法识别的ACL按private处理
+func EffectiveACL(objectACL, bucketACL string) string {
+	acl := objectACL
+	if acl == "" || acl == ACLBucket {
+		acl = bucketACL
+	}
+	if !IsValidACL(acl, false) {
+		return ACLPrivate
+	}
+	return acl
+}
+
+// aclGrant 返回适用于请求的ACL及其是否允许请求. 读对象使用对象的ACL, 列举及写对象使用桶的ACL,
+// 其余操作只允许所有者
+func aclGrant(args Args) (string, bool) {
+	var (
+		acl   string
+		write bool
+	)
+	switch args.Action {
+	case GetObjectAction:
+		if args.Object == "" {
+			return "", false
+		}
+		acl = EffectiveACL(args.ObjectACL, args.BucketACL)
+	case ListBucketAction, ListBucketVersionsAction:
+		acl = EffectiveACL("", args.BucketACL)
+	case PutObjectAction, DeleteObjectAction, AbortMultipartUploadAction, ListMultipartUploadPartsAction:
+		acl, write = EffectiveACL("", args.BucketACL), true
+	default:
+		return "", false
+	}
+
+	anonymous := args.AccessKey == "" && args.User == ""
+	switch acl {
+	case ACLPublicReadWrite:
+		return acl, true
+	case ACLPublicRead:
+		return acl, !write
+	case ACLAuthenticatedRead:
+		return acl, !write && !anonymous
+	}
+	return acl, false
+}
@@ -0,0 +1,153 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/pkg/wildcard"
+)
+
+// 支持的条件运算符
+const (
+	IpAddress       = "IpAddress"
+	NotIpAddress    = "NotIpAddress"
+	StringLike      = "StringLike"
+	StringNotLike   = "StringNotLike"
+	DateLessThan    = "DateLessThan"
+	DateGreaterThan = "DateGreaterThan"
+)
+
+// 常用的条件键, 比较时不区分大小写
+const (
+	KeySourceIp    = "aws:SourceIp"
+	KeyCurrentTime = "aws:CurrentTime"
+	KeyUserAgent   = "aws:UserAgent"
+	KeyReferer     = "aws:Referer"
+	KeyUsername    = "aws:username"
+	KeyPrefix      = "s3:prefix"
+)
+
+// Conditions 条件运算符 -> 条件键 -> 值, 所有条件都满足时语句才适用
+type Conditions map[string]map[string]StringSet
+
+func (c Conditions) validate() error {
+	for op, kvs := range c {
+		for k, vs := range kvs {
+			if k == "" || len(vs) == 0 {
+				return fmt.Errorf("condition %s: empty key or value", op)
+			}
+			for _, v := range vs {
+				var err error
+				switch op {
+				case IpAddress, NotIpAddress:
+					_, err = parseCIDR(v)
+				case StringLike, StringNotLike:
+				case DateLessThan, DateGreaterThan:
+					_, err = parseDate(v)
+				default:
+					return fmt.Errorf("unsupported condition %s", op)
+				}
+				if err != nil {
+					return fmt.Errorf("condition %s %s: %s", op, k, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// mismatch 返回第一个不满足的条件, 请求中没有的键只满足否定的运算符
+func (c Conditions) mismatch(values map[string]string) string {
+	lower := make(map[string]string, len(values))
+	for k, v := range values {
+		lower[strings.ToLower(k)] = v
+	}
+	ops := make([]string, 0, len(c))
+	for op := range c {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		keys := make([]string, 0, len(c[op]))
+		for k := range c[op] {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v, ok := lower[strings.ToLower(k)]
+			if !test(op, c[op][k], v, ok) {
+				return fmt.Sprintf("condition %s %s is not satisfied", op, k)
+			}
+		}
+	}
+	return ""
+}
+
+func test(op string, patterns StringSet, v string, ok bool) bool {
+	switch op {
+	case IpAddress, NotIpAddress:
+		matched := false
+		if ip := net.ParseIP(v); ok && ip != nil {
+			for _, p := range patterns {
+				if n, err := parseCIDR(p); err == nil && n.Contains(ip) {
+					matched = true
+					break
+				}
+			}
+		}
+		return matched == (op == IpAddress)
+	case StringLike, StringNotLike:
+		matched := false
+		if ok {
+			for _, p := range patterns {
+				if wildcard.Match(p, v) {
+					matched = true
+					break
+				}
+			}
+		}
+		return matched == (op == StringLike)
+	case DateLessThan, DateGreaterThan:
+		t, err := parseDate(v)
+		if !ok || err != nil {
+			return false
+		}
+		for _, p := range patterns {
+			d, err := parseDate(p)
+			if err != nil {
+				continue
+			}
+			if (op == DateLessThan && t.Before(d)) || (op == DateGreaterThan && t.After(d)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseCIDR 也接受单个IP
+func parseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address %q", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
@@ -0,0 +1,326 @@
+// Package policy parses and evaluates the bucket policy saved in t_ns_bucket_ext.policy.
+// The policy is the s3 json document, the supported elements are Effect, Principal, Action, Resource and Condition.
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/minio/pkg/wildcard"
+)
+
+// Effect 语句的效果
+type Effect string
+
+const (
+	Allow Effect = "Allow"
+	Deny  Effect = "Deny"
+)
+
+// 接口对应的操作
+const (
+	GetObjectAction                  = "s3:GetObject"
+	PutObjectAction                  = "s3:PutObject"
+	DeleteObjectAction               = "s3:DeleteObject"
+	AbortMultipartUploadAction       = "s3:AbortMultipartUpload"
+	ListMultipartUploadPartsAction   = "s3:ListMultipartUploadParts"
+	GetObjectTaggingAction           = "s3:GetObjectTagging"
+	PutObjectTaggingAction           = "s3:PutObjectTagging"
+	DeleteObjectTaggingAction        = "s3:DeleteObjectTagging"
+	GetObjectAclAction               = "s3:GetObjectAcl"
+	PutObjectAclAction               = "s3:PutObjectAcl"
+	ListBucketAction                 = "s3:ListBucket"
+	ListBucketVersionsAction         = "s3:ListBucketVersions"
+	ListBucketMultipartUploadsAction = "s3:ListBucketMultipartUploads"
+	CreateBucketAction               = "s3:CreateBucket"
+	DeleteBucketAction               = "s3:DeleteBucket"
+	GetBucketVersioningAction        = "s3:GetBucketVersioning"
+	PutBucketVersioningAction        = "s3:PutBucketVersioning"
+	GetBucketLoggingAction           = "s3:GetBucketLogging"
+	PutBucketLoggingAction           = "s3:PutBucketLogging"
+	GetBucketPolicyAction            = "s3:GetBucketPolicy"
+	PutBucketPolicyAction            = "s3:PutBucketPolicy"
+	DeleteBucketPolicyAction         = "s3:DeleteBucketPolicy"
+	GetLifecycleConfigurationAction  = "s3:GetLifecycleConfiguration"
+	PutLifecycleConfigurationAction  = "s3:PutLifecycleConfiguration"
+	GetBucketAclAction               = "s3:GetBucketAcl"
+	PutBucketAclAction               = "s3:PutBucketAcl"
+	GetBucketTaggingAction           = "s3:GetBucketTagging"
+	PutBucketTaggingAction           = "s3:PutBucketTagging"
+	GetEncryptionConfigurationAction = "s3:GetEncryptionConfiguration"
+	PutEncryptionConfigurationAction = "s3:PutEncryptionConfiguration"
+)
+
+// 资源的前缀, 之后为 bucket 或 bucket/object, 支持通配符
+const resourceARNPrefix = "arn:aws:s3:::"
+
+// StringSet 单个字符串或字符串数组
+type StringSet []string
+
+func (s *StringSet) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*s = StringSet{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*s = many
+	return nil
+}
+
+// Principal 语句适用的用户, "*"表示所有人, 否则为用户名, 秘钥或 arn:aws:iam::xxx:user/用户名
+type Principal struct {
+	AWS StringSet `json:"AWS"`
+}
+
+func (p *Principal) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		if s != "*" {
+			return fmt.Errorf("invalid principal %q", s)
+		}
+		p.AWS = StringSet{"*"}
+		return nil
+	}
+	type principal Principal
+	var v principal
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*p = Principal(v)
+	return nil
+}
+
+func (p Principal) match(args Args) bool {
+	for _, v := range p.AWS {
+		if v == "*" {
+			return true
+		}
+		if strings.HasPrefix(v, "arn:aws:iam:") {
+			if i := strings.LastIndex(v, ":user/"); i >= 0 {
+				v = v[i+len(":user/"):]
+			}
+		}
+		if (args.User != "" && v == args.User) || (args.AccessKey != "" && v == args.AccessKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Statement 策略语句
+type Statement struct {
+	Sid       string     `json:"Sid,omitempty"`
+	Effect    Effect     `json:"Effect"`
+	Principal Principal  `json:"Principal"`
+	Action    StringSet  `json:"Action"`
+	Resource  StringSet  `json:"Resource"`
+	Condition Conditions `json:"Condition,omitempty"`
+}
+
+// name 语句的Sid, 没有Sid时为序号
+func (st Statement) name(i int) string {
+	if st.Sid != "" {
+		return st.Sid
+	}
+	return "#" + strconv.Itoa(i)
+}
+
+func (st Statement) validate(bucket string) error {
+	if st.Effect != Allow && st.Effect != Deny {
+		return fmt.Errorf("invalid effect %q", st.Effect)
+	}
+	if len(st.Principal.AWS) == 0 {
+		return errors.New("principal empty")
+	}
+	if len(st.Action) == 0 {
+		return errors.New("action empty")
+	}
+	if len(st.Resource) == 0 {
+		return errors.New("resource empty")
+	}
+	for _, r := range st.Resource {
+		if !strings.HasPrefix(r, resourceARNPrefix) {
+			return fmt.Errorf("invalid resource %q", r)
+		}
+		b := strings.SplitN(strings.TrimPrefix(r, resourceARNPrefix), "/", 2)[0]
+		if bucket != "" && !wildcard.Match(b, bucket) {
+			return fmt.Errorf("resource %q does not belong to bucket %s", r, bucket)
+		}
+	}
+	return st.Condition.validate()
+}
+
+// mismatch 返回语句不适用于请求的原因, 适用时为空
+func (st Statement) mismatch(args Args) string {
+	if !st.Principal.match(args) {
+		return "principal does not match"
+	}
+	action := strings.ToLower(args.Action)
+	matched := false
+	for _, a := range st.Action {
+		if wildcard.Match(strings.ToLower(a), action) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return "action does not match"
+	}
+	resource := args.Bucket
+	if args.Object != "" {
+		resource += "/" + args.Object
+	}
+	matched = false
+	for _, r := range st.Resource {
+		if wildcard.Match(strings.TrimPrefix(r, resourceARNPrefix), resource) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return "resource does not match"
+	}
+	return st.Condition.mismatch(args.Conditions)
+}
+
+// Policy 桶的访问策略
+type Policy struct {
+	Version   string      `json:"Version,omitempty"`
+	Statement []Statement `json:"Statement"`
+}
+
+// Parse parses the bucket policy and checks its statements refer to the bucket,
+// nil is returned for an empty policy. The bucket is not checked if it is empty.
+func Parse(s, bucket string) (*Policy, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	p := &Policy{}
+	if err := json.Unmarshal([]byte(s), p); err != nil {
+		return nil, err
+	}
+	if len(p.Statement) == 0 {
+		return nil, errors.New("policy has no statement")
+	}
+	for i, st := range p.Statement {
+		if err := st.validate(bucket); err != nil {
+			return nil, fmt.Errorf("statement %s: %s", st.name(i), err)
+		}
+	}
+	return p, nil
+}
+
+// Args 需要评估的请求
+type Args struct {
+	// AccessKey, User 签名请求的秘钥及其用户, 匿名请求时为空
+	AccessKey string
+	User      string
+	// Admin 配置文件中的秘钥, 不受桶策略限制
+	Admin bool
+	// IsOwner 请求者为桶的所有者, 没有语句拒绝时允许
+	IsOwner bool
+	// BucketACL, ObjectACL 桶及对象的ACL, 没有语句允许时检查
+	BucketACL string
+	ObjectACL string
+	Action    string
+	Bucket    string
+	Object    string
+	// Conditions 条件键的值, 如 aws:SourceIp, aws:CurrentTime, aws:UserAgent, s3:prefix
+	Conditions map[string]string
+}
+
+// Result 评估的结果
+type Result struct {
+	Allowed bool `json:"allowed"`
+	// Effect, Statement 决定结果的语句, 由所有者, ACL或管理秘钥决定时为空
+	Effect    Effect `json:"effect,omitempty"`
+	Statement string `json:"statement,omitempty"`
+	// ACL 由ACL决定时适用的ACL
+	ACL    string `json:"acl,omitempty"`
+	Reason string `json:"reason"`
+	// Statements 每条语句的匹配情况, 只在Explain时返回
+	Statements []StatementResult `json:"statements,omitempty"`
+}
+
+// StatementResult 语句对请求的匹配情况
+type StatementResult struct {
+	Statement string `json:"statement"`
+	Effect    Effect `json:"effect"`
+	Matched   bool   `json:"matched"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Evaluate decides whether the request is allowed, p may be nil if the bucket has no policy.
+// 管理秘钥总是允许, 其次任一语句拒绝时拒绝, 语句允许, 请求者为桶的所有者或ACL允许时允许, 其余拒绝
+func (p *Policy) Evaluate(args Args) Result {
+	return p.evaluate(args, false)
+}
+
+// Explain is the same as Evaluate, the result also reports how each statement matches the request.
+func (p *Policy) Explain(args Args) Result {
+	return p.evaluate(args, true)
+}
+
+func (p *Policy) evaluate(args Args, explain bool) Result {
+	var (
+		res         Result
+		allow, deny = -1, -1
+		statements  []Statement
+	)
+	if p != nil {
+		statements = p.Statement
+	}
+	for i, st := range statements {
+		reason := st.mismatch(args)
+		if explain {
+			res.Statements = append(res.Statements, StatementResult{
+				Statement: st.name(i),
+				Effect:    st.Effect,
+				Matched:   reason == "",
+				Reason:    reason,
+			})
+		}
+		if reason != "" {
+			continue
+		}
+		if st.Effect == Deny && deny < 0 {
+			deny = i
+			if !explain {
+				break
+			}
+		}
+		if st.Effect == Allow && allow < 0 {
+			allow = i
+		}
+	}
+
+	switch {
+	case args.Admin:
+		res.Allowed, res.Reason = true, "admin credentials are not restricted by the bucket policy"
+	case deny >= 0:
+		res.Effect, res.Statement = Deny, statements[deny].name(deny)
+		res.Reason = "explicitly denied by statement " + res.Statement
+	case allow >= 0:
+		res.Allowed, res.Effect, res.Statement = true, Allow, statements[allow].name(allow)
+		res.Reason = "allowed by statement " + res.Statement
+	case args.IsOwner:
+		res.Allowed, res.Reason = true, "requester is the bucket owner"
+	default:
+		acl, ok := aclGrant(args)
+		res.ACL, res.Allowed = acl, ok
+		if ok {
+			res.Reason = "granted by acl " + acl
+		} else {
+			res.Reason = "no statement or acl allows the request"
+		}
+	}
+	return res
+}
@@ -0,0 +1,206 @@
+package policy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	error2 "mtcloud.com/mtstorage/pkg/storageerror"
+)
+
+const testPolicy = `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Sid": "PublicRead",
+      "Effect": "Allow",
+      "Principal": "*",
+      "Action": "s3:GetObject",
+      "Resource": "arn:aws:s3:::photos/public/*"
+    },
+    {
+      "Sid": "OfficeUpload",
+      "Effect": "Allow",
+      "Principal": {"AWS": ["alice", "arn:aws:iam::1:user/bob"]},
+      "Action": ["s3:PutObject", "s3:List*"],
+      "Resource": ["arn:aws:s3:::photos", "arn:aws:s3:::photos/*"],
+      "Condition": {
+        "IpAddress": {"aws:SourceIp": ["10.0.0.0/8", "192.168.1.1"]},
+        "DateLessThan": {"aws:CurrentTime": "2030-01-01T00:00:00Z"}
+      }
+    },
+    {
+      "Effect": "Deny",
+      "Principal": "*",
+      "Action": "s3:*",
+      "Resource": "arn:aws:s3:::photos/*",
+      "Condition": {"StringLike": {"aws:UserAgent": "*crawler*"}}
+    }
+  ]
+}`
+
+var evaluateTests = []struct {
+	Args      Args
+	Allowed   bool
+	Statement string
+}{
+	{Args: Args{AccessKey: "AK", Action: "s3:GetObject", Bucket: "photos", Object: "public/a.jpg"}, Allowed: true, Statement: "PublicRead"}, // 0
+	{Args: Args{Action: "s3:GetObject", Bucket: "photos", Object: "private/a.jpg"}, Allowed: false},                                         // 1
+	{Args: Args{User: "alice", Action: "s3:PutObject", Bucket: "photos", Object: "a.jpg",
+		Conditions: map[string]string{KeySourceIp: "10.1.2.3", KeyCurrentTime: "2026-10-17T00:00:00Z"}}, Allowed: true, Statement: "OfficeUpload"}, // 2
+	{Args: Args{User: "alice", Action: "s3:PutObject", Bucket: "photos", Object: "a.jpg",
+		Conditions: map[string]string{KeySourceIp: "172.16.0.1", KeyCurrentTime: "2026-10-17T00:00:00Z"}}, Allowed: false}, // 3
+	{Args: Args{User: "bob", Action: "s3:ListBucket", Bucket: "photos",
+		Conditions: map[string]string{"AWS:SOURCEIP": "192.168.1.1", KeyCurrentTime: "2026-10-17T00:00:00Z"}}, Allowed: true, Statement: "OfficeUpload"}, // 4
+	{Args: Args{User: "bob", Action: "s3:PutObject", Bucket: "photos", Object: "a.jpg",
+		Conditions: map[string]string{KeySourceIp: "10.1.2.3", KeyCurrentTime: "2031-01-01T00:00:00Z"}}, Allowed: false}, // 5
+	{Args: Args{User: "carol", Action: "s3:PutObject", Bucket: "photos", Object: "a.jpg", IsOwner: true}, Allowed: true}, // 6
+	{Args: Args{Action: "s3:GetObject", Bucket: "photos", Object: "public/a.jpg", IsOwner: true,
+		Conditions: map[string]string{KeyUserAgent: "my-crawler/1.0"}}, Allowed: false, Statement: "#2"}, // 7
+	{Args: Args{Action: "s3:DeleteObject", Bucket: "photos", Object: "a.jpg", Admin: true,
+		Conditions: map[string]string{KeyUserAgent: "my-crawler/1.0"}}, Allowed: true}, // 8
+}
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse(testPolicy, "photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, test := range evaluateTests {
+		res := p.Evaluate(test.Args)
+		if res.Allowed != test.Allowed || res.Statement != test.Statement {
+			t.Fatalf("Test %d: got %v, %q - want %v, %q (%s)", i, res.Allowed, res.Statement, test.Allowed, test.Statement, res.Reason)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	p, err := Parse(testPolicy, "photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := p.Explain(Args{User: "alice", Action: "s3:GetObject", Bucket: "photos", Object: "public/a.jpg",
+		Conditions: map[string]string{KeyUserAgent: "a-crawler"}})
+	if res.Allowed || res.Statement != "#2" || len(res.Statements) != 3 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	want := []bool{true, false, true}
+	for i, s := range res.Statements {
+		if s.Matched != want[i] {
+			t.Fatalf("statement %d: got matched %v (%s)", i, s.Matched, s.Reason)
+		}
+	}
+	if r := res.Statements[1].Reason; r != "action does not match" {
+		t.Fatalf("unexpected reason %q", r)
+	}
+
+	// 没有策略时只允许所有者
+	var empty *Policy
+	if res = empty.Explain(Args{Action: "s3:GetObject", Bucket: "photos"}); res.Allowed {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+var parseTests = []struct {
+	Policy string
+	OK     bool
+}{
+	{Policy: "", OK: true}, // 0
+	{Policy: `{"Statement":[{"Effect":"Allow","Principal":{"AWS":"u"},"Action":"s3:GetObject","Resource":"arn:aws:s3:::photos/*"}]}`, OK: true}, // 1
+	{Policy: `{"Statement":[]}`, OK: false}, // 2
+	{Policy: `{"Statement":[{"Effect":"Permit","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::photos/*"}]}`, OK: false}, // 3
+	{Policy: `{"Statement":[{"Effect":"Allow","Principal":"u","Action":"s3:GetObject","Resource":"arn:aws:s3:::photos/*"}]}`, OK: false},  // 4
+	{Policy: `{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::other/*"}]}`, OK: false},   // 5
+	{Policy: `{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"photos/*"}]}`, OK: false},               // 6
+	{Policy: `{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::photos/*",` +
+		`"Condition":{"NumericLessThan":{"s3:max-keys":"10"}}}]}`, OK: false}, // 7
+	{Policy: `{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::photos/*",` +
+		`"Condition":{"IpAddress":{"aws:SourceIp":"10.0.0.0/33"}}}]}`, OK: false}, // 8
+	{Policy: `{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::photos/*",` +
+		`"Condition":{"DateLessThan":{"aws:CurrentTime":"tomorrow"}}}]}`, OK: false}, // 9
+	{Policy: `not json`, OK: false}, // 10
+}
+
+func TestParse(t *testing.T) {
+	for i, test := range parseTests {
+		_, err := Parse(test.Policy, "photos")
+		if (err == nil) != test.OK {
+			t.Fatalf("Test %d: got %v, want ok %v", i, err, test.OK)
+		}
+	}
+}
+
+var aclTests = []struct {
+	Args    Args
+	Allowed bool
+	ACL     string
+}{
+	{Args: Args{Action: GetObjectAction, Bucket: "b", Object: "a", ObjectACL: ACLPublicRead}, Allowed: true, ACL: ACLPublicRead},                                // 0
+	{Args: Args{Action: GetObjectAction, Bucket: "b", Object: "a", ObjectACL: ACLBucket, BucketACL: ACLPublicRead}, Allowed: true, ACL: ACLPublicRead},          // 1
+	{Args: Args{Action: GetObjectAction, Bucket: "b", Object: "a", ObjectACL: ACLPrivate, BucketACL: ACLPublicRead}, Allowed: false, ACL: ACLPrivate},           // 2
+	{Args: Args{Action: GetObjectAction, Bucket: "b", Object: "a", BucketACL: ACLAuthenticatedRead}, Allowed: false, ACL: ACLAuthenticatedRead},                 // 3
+	{Args: Args{AccessKey: "AK", Action: GetObjectAction, Bucket: "b", Object: "a", BucketACL: ACLAuthenticatedRead}, Allowed: true, ACL: ACLAuthenticatedRead}, // 4
+	{Args: Args{AccessKey: "AK", Action: PutObjectAction, Bucket: "b", Object: "a", BucketACL: ACLPublicRead}, Allowed: false, ACL: ACLPublicRead},              // 5
+	{Args: Args{AccessKey: "AK", Action: PutObjectAction, Bucket: "b", Object: "a", BucketACL: ACLPublicReadWrite}, Allowed: true, ACL: ACLPublicReadWrite},     // 6
+	{Args: Args{AccessKey: "AK", Action: PutObjectAction, Bucket: "b", Object: "a", ObjectACL: ACLPublicReadWrite}, Allowed: false, ACL: ACLPrivate},            // 7
+	{Args: Args{Action: ListBucketAction, Bucket: "b", BucketACL: ACLPublicRead}, Allowed: true, ACL: ACLPublicRead},                                            // 8
+	{Args: Args{Action: GetObjectAction, Bucket: "b", BucketACL: ACLPublicRead}, Allowed: false},                                                                // 9
+	{Args: Args{Action: PutBucketAclAction, Bucket: "b", BucketACL: ACLPublicReadWrite}, Allowed: false},                                                        // 10
+	{Args: Args{Action: GetObjectAction, Bucket: "b", Object: "a", ObjectACL: "unknown", BucketACL: ACLPublicRead}, Allowed: false, ACL: ACLPrivate},            // 11
+}
+
+func TestACL(t *testing.T) {
+	for i, test := range aclTests {
+		res := (*Policy)(nil).Evaluate(test.Args)
+		if res.Allowed != test.Allowed || res.ACL != test.ACL {
+			t.Fatalf("Test %d: got %v, %q - want %v, %q (%s)", i, res.Allowed, res.ACL, test.Allowed, test.ACL, res.Reason)
+		}
+	}
+
+	// 语句拒绝时ACL不生效
+	p, err := Parse(testPolicy, "photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := p.Evaluate(Args{Action: GetObjectAction, Bucket: "photos", Object: "a.jpg", ObjectACL: ACLPublicRead,
+		Conditions: map[string]string{KeyUserAgent: "crawler"}})
+	if res.Allowed {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+type countingGetter struct {
+	buckets map[string]BucketAccess
+	calls   int
+}
+
+func (g *countingGetter) GetBucketAccess(ctx context.Context, bucket string) (BucketAccess, error) {
+	g.calls++
+	a, ok := g.buckets[bucket]
+	if !ok {
+		return a, error2.BucketNotFound{Bucket: bucket}
+	}
+	return a, nil
+}
+
+func (g *countingGetter) GetObjectAcl(ctx context.Context, bucket, object string) (string, error) {
+	return "", nil
+}
+
+func TestCachedGetter(t *testing.T) {
+	g := &countingGetter{buckets: map[string]BucketAccess{}}
+	c := NewCachedGetter(g, time.Minute)
+	ctx := context.Background()
+
+	// 不存在的桶不缓存, 创建后立即按其所有者检查
+	if _, err := c.GetBucketAccess(ctx, "photos"); err == nil {
+		t.Fatal("bucket not found expected")
+	}
+	g.buckets["photos"] = BucketAccess{Bucket: "photos", Owner: 2}
+	if a, err := c.GetBucketAccess(ctx, "photos"); err != nil || a.Owner != 2 {
+		t.Fatalf("bucket created later not visible: %+v, %v", a, err)
+	}
+	if _, err := c.GetBucketAccess(ctx, "photos"); err != nil || g.calls != 2 {
+		t.Fatalf("existing bucket not cached: %d calls, %v", g.calls, err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/xml"
 	"fmt"
 	"net/http"
+
+	"mtcloud.com/mtstorage/pkg/hash"
 )
 
 // APIError structure
@@ -71,6 +73,31 @@ const (
 
 	ErrWriteDatabaseFailed
 	ErrInvalidRequest
+	ErrStorageFull
+	ErrNoSuchUpload
+	ErrInvalidPart
+	ErrInvalidRange
+	ErrObjectNotAppendable
+	ErrPositionNotEqualToLength
+
+	ErrSignatureDoesNotMatch
+	ErrInvalidAccessKeyID
+	ErrAuthorizationHeaderMalformed
+	ErrSignatureVersionNotSupported
+	ErrUnsignedHeaders
+	ErrMissingDateHeader
+	ErrMalformedDate
+	ErrRequestTimeTooSkewed
+	ErrMalformedPresignedQuery
+	ErrExpiredPresignRequest
+	ErrRequestNotReadyYet
+	ErrContentSHA256Mismatch
+	ErrMissingContentLength
+
+	ErrNoSuchUser
+	ErrUserAlreadyExists
+	ErrNoSuchAccessKey
+	ErrMalformedPolicy
 )
 
 type errorCodeMap map[APIErrorCode]APIError
@@ -201,6 +228,141 @@ var ErrorCodes = errorCodeMap{
 		Description:    "The TagSet does not exist",
 		HTTPStatusCode: http.StatusNotFound,
 	},
+	ErrStorageFull: {
+		Code:           "StorageFull",
+		Description:    "Storage backend has insufficient capacity to complete the request.",
+		HTTPStatusCode: http.StatusInsufficientStorage,
+	},
+	ErrNoSuchUpload: {
+		Code:           "NoSuchUpload",
+		Description:    "The specified multipart upload does not exist. The upload ID may be invalid, or the upload may have been aborted or completed.",
+		HTTPStatusCode: http.StatusNotFound,
+	},
+	ErrInvalidPart: {
+		Code:           "InvalidPart",
+		Description:    "One or more of the specified parts could not be found.  The part may not have been uploaded, or the specified entity tag may not match the part's entity tag.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidRange: {
+		Code:           "InvalidRange",
+		Description:    "The requested range is not satisfiable",
+		HTTPStatusCode: http.StatusRequestedRangeNotSatisfiable,
+	},
+	ErrObjectNotAppendable: {
+		Code:           "ObjectNotAppendable",
+		Description:    "The operation is not supported for this object.",
+		HTTPStatusCode: http.StatusConflict,
+	},
+	ErrPositionNotEqualToLength: {
+		Code:           "PositionNotEqualToLength",
+		Description:    "Position is not equal to file length.",
+		HTTPStatusCode: http.StatusConflict,
+	},
+	ErrAccessDenied: {
+		Code:           "AccessDenied",
+		Description:    "Access Denied.",
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrSignatureDoesNotMatch: {
+		Code:           "SignatureDoesNotMatch",
+		Description:    "The request signature we calculated does not match the signature you provided. Check your key and signing method.",
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrInvalidAccessKeyID: {
+		Code:           "InvalidAccessKeyId",
+		Description:    "The Access Key Id you provided does not exist in our records.",
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrAuthorizationHeaderMalformed: {
+		Code:           "AuthorizationHeaderMalformed",
+		Description:    "The authorization header is malformed.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrSignatureVersionNotSupported: {
+		Code:           "InvalidRequest",
+		Description:    "The authorization mechanism you have provided is not supported. Please use AWS4-HMAC-SHA256.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrUnsignedHeaders: {
+		Code:           "AccessDenied",
+		Description:    "There were headers present in the request which were not signed",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMissingDateHeader: {
+		Code:           "AccessDenied",
+		Description:    "AWS authentication requires a valid Date or x-amz-date header",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMalformedDate: {
+		Code:           "MalformedDate",
+		Description:    "Invalid date format header, expected to be in ISO8601, RFC1123 or RFC1123Z time format.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrRequestTimeTooSkewed: {
+		Code:           "RequestTimeTooSkewed",
+		Description:    "The difference between the request time and the server's time is too large.",
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrMalformedPresignedQuery: {
+		Code:           "AuthorizationQueryParametersError",
+		Description:    "Query-string authentication version 4 requires the X-Amz-Algorithm, X-Amz-Credential, X-Amz-Signature, X-Amz-Date, X-Amz-SignedHeaders, and X-Amz-Expires parameters.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrExpiredPresignRequest: {
+		Code:           "AccessDenied",
+		Description:    "Request has expired",
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrRequestNotReadyYet: {
+		Code:           "AccessDenied",
+		Description:    "Request is not valid yet",
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrContentSHA256Mismatch: {
+		Code:           "XAmzContentSHA256Mismatch",
+		Description:    "The provided 'x-amz-content-sha256' header does not match what was computed.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMissingContentLength: {
+		Code:           "MissingContentLength",
+		Description:    "You must provide the Content-Length HTTP header.",
+		HTTPStatusCode: http.StatusLengthRequired,
+	},
+	ErrEntityTooSmall: {
+		Code:           "EntityTooSmall",
+		Description:    "Your proposed upload is smaller than the minimum allowed object size.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrEntityTooLarge: {
+		Code:           "EntityTooLarge",
+		Description:    "Your proposed upload exceeds the maximum allowed object size.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrNoSuchUser: {
+		Code:           "NoSuchUser",
+		Description:    "The specified user does not exist.",
+		HTTPStatusCode: http.StatusNotFound,
+	},
+	ErrUserAlreadyExists: {
+		Code:           "UserAlreadyExists",
+		Description:    "The specified user already exists.",
+		HTTPStatusCode: http.StatusConflict,
+	},
+	ErrNoSuchAccessKey: {
+		Code:           "NoSuchAccessKey",
+		Description:    "The specified access key does not exist.",
+		HTTPStatusCode: http.StatusNotFound,
+	},
+	ErrPolicyTooLarge: {
+		Code:           "PolicyTooLarge",
+		Description:    "Policy exceeds the maximum allowed document size.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMalformedPolicy: {
+		Code:           "MalformedPolicy",
+		Description:    "The bucket policy is not valid.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
 	// Add your storageerror structure here.
 }
 
@@ -255,6 +417,32 @@ func toAPIErrorCode(ctx context.Context, err error) (apiErr APIErrorCode) {
 		apiErr = ErrNoSuchKey
 	case ObjectTaggingNotFound:
 		apiErr = ErrObjectTaggingNotFound
+	case StorageFull:
+		apiErr = ErrStorageFull
+	case InvalidUploadID:
+		apiErr = ErrNoSuchUpload
+	case InvalidPart:
+		apiErr = ErrInvalidPart
+	case InvalidRange:
+		apiErr = ErrInvalidRange
+	case InvalidCopyDest:
+		apiErr = ErrInvalidCopyDest
+	case ObjectNotAppendable:
+		apiErr = ErrObjectNotAppendable
+	case PositionNotEqualToLength:
+		apiErr = ErrPositionNotEqualToLength
+	case UserNotFound:
+		apiErr = ErrNoSuchUser
+	case UserAlreadyExists:
+		apiErr = ErrUserAlreadyExists
+	case AccessKeyNotFound:
+		apiErr = ErrNoSuchAccessKey
+	case BucketPolicyInvalid:
+		apiErr = ErrMalformedPolicy
+	case AccessDenied:
+		apiErr = ErrAccessDenied
+	case hash.SHA256Mismatch:
+		apiErr = ErrContentSHA256Mismatch
 	}
 	return apiErr
 }
@@ -401,6 +589,27 @@ func (e IncompleteBody) Error() string {
 	return e.Bucket + "/" + e.Object + "has incomplete body"
 }
 
+// StorageFull no storage endpoint has enough free space for the upload
+type StorageFull GenericError
+
+func (e StorageFull) Error() string {
+	if e.Err != nil {
+		return "storage full: " + e.Err.Error()
+	}
+	return "storage full"
+}
+
+// InvalidUploadID the multipart upload does not exist, or was already completed or aborted
+type InvalidUploadID struct {
+	Bucket   string
+	Object   string
+	UploadID string
+}
+
+func (e InvalidUploadID) Error() string {
+	return "Invalid upload id " + e.UploadID
+}
+
 // InvalidPart One or more of the specified parts could not be found
 type InvalidPart struct {
 	PartNumber int
@@ -413,6 +622,42 @@ func (e InvalidPart) Error() string {
 		e.PartNumber, e.ExpETag, e.GotETag)
 }
 
+// InvalidRange the range of the copy source is not satisfiable
+type InvalidRange struct {
+	Range string
+	Size  int64
+}
+
+func (e InvalidRange) Error() string {
+	return fmt.Sprintf("The requested range %q is not satisfiable, object size %d", e.Range, e.Size)
+}
+
+// InvalidCopyDest an object is copied to itself without changing anything
+type InvalidCopyDest GenericError
+
+func (e InvalidCopyDest) Error() string {
+	return "Copy " + e.Bucket + "/" + e.Object + " to itself without changes"
+}
+
+// ObjectNotAppendable the object was not created by AppendObject
+type ObjectNotAppendable GenericError
+
+func (e ObjectNotAppendable) Error() string {
+	return "Object " + e.Bucket + "/" + e.Object + " is not appendable"
+}
+
+// PositionNotEqualToLength the append position is not the length of the object
+type PositionNotEqualToLength struct {
+	Bucket   string
+	Object   string
+	Position uint64
+	Length   uint64
+}
+
+func (e PositionNotEqualToLength) Error() string {
+	return fmt.Sprintf("Position %d is not equal to length %d of %s/%s", e.Position, e.Length, e.Bucket, e.Object)
+}
+
 // BucketLifecycleNotFound - no bucket lifecycle found.
 type BucketLifecycleNotFound GenericError
 
@@ -468,3 +713,44 @@ type WriteDataBaseFailed GenericError
 func (e WriteDataBaseFailed) Error() string {
 	return "Write database failed : " + e.Err.Error()
 }
+
+// UserNotFound the user does not exist
+type UserNotFound struct {
+	User string
+}
+
+func (e UserNotFound) Error() string {
+	return "User not found: " + e.User
+}
+
+// UserAlreadyExists the user name is taken
+type UserAlreadyExists struct {
+	User string
+}
+
+func (e UserAlreadyExists) Error() string {
+	return "User already exists: " + e.User
+}
+
+// AccessKeyNotFound the access key does not exist
+type AccessKeyNotFound struct {
+	AccessKey string
+}
+
+func (e AccessKeyNotFound) Error() string {
+	return "Access key not found: " + e.AccessKey
+}
+
+// BucketPolicyInvalid the bucket policy can not be parsed
+type BucketPolicyInvalid GenericError
+
+func (e BucketPolicyInvalid) Error() string {
+	return "Invalid bucket policy of bucket " + e.Bucket + ": " + e.Err.Error()
+}
+
+// AccessDenied the request is not allowed on the bucket or object
+type AccessDenied GenericError
+
+func (e AccessDenied) Error() string {
+	return "Access denied: " + e.Bucket + "/" + e.Object
+}